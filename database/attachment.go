@@ -0,0 +1,211 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// attachmentURL is where the files of the attachments are downloaded, followed by their ID
+const attachmentURL = "/api/v1/attachments/"
+
+// attachmentColumns are the columns scanned by scanAttachment
+const attachmentColumns = `attachment_id, uploader_id, COALESCE(target_type, ''), COALESCE(target_id, ''),
+    blob_key, thumbnail_key, filename, mime_type, size, width, height, created_at
+    FROM attachment`
+
+// orphanAttachments matches the attachments whose content or uploader was deleted,
+// and the uploads never attached to a content before ?1
+const orphanAttachments = `
+    (target_type IS NULL AND created_at < ?1)
+    OR uploader_id NOT IN (SELECT user_id FROM User)
+    OR (target_type = 'post' AND target_id NOT IN (SELECT post_id FROM Post))
+    OR (target_type = 'comment' AND target_id NOT IN (SELECT comment_id FROM Comment))
+    OR (target_type = 'message' AND CAST(target_id AS INTEGER) NOT IN (SELECT id FROM messages))`
+
+// scanAttachment reads a row selected with attachmentColumns
+func scanAttachment(row interface{ Scan(...interface{}) error }) (*models.Attachment, error) {
+	var a models.Attachment
+	err := row.Scan(&a.Id, &a.UploaderId, &a.TargetType, &a.TargetId, &a.BlobKey, &a.ThumbnailKey,
+		&a.Filename, &a.MimeType, &a.Size, &a.Width, &a.Height, &a.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	a.URL = attachmentURL + a.Id
+	if a.ThumbnailKey != "" {
+		a.ThumbnailURL = a.URL + "/thumbnail"
+	}
+	return &a, nil
+}
+
+// CreateAttachment records an uploaded file, not attached to any content yet
+func CreateAttachment(a models.Attachment) (*models.Attachment, error) {
+	a.Id = shared.ParseUUID(shared.GenerateUUID())
+	_, err := DB.Exec(
+		`INSERT INTO attachment (attachment_id, uploader_id, blob_key, thumbnail_key, filename, mime_type,
+		size, width, height, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		a.Id, a.UploaderId, a.BlobKey, a.ThumbnailKey, a.Filename, a.MimeType, a.Size, a.Width, a.Height, time.Now(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save attachment: %w", err)
+	}
+	return GetAttachment(a.Id)
+}
+
+// GetAttachment retrieves an attachment by its ID
+func GetAttachment(attachmentID string) (*models.Attachment, error) {
+	return scanAttachment(DB.QueryRow("SELECT "+attachmentColumns+" WHERE attachment_id = ?", attachmentID))
+}
+
+// CountPendingAttachments counts the uploads among ids that userID made and did not attach yet
+func CountPendingAttachments(userID string, ids []string) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := []interface{}{userID}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	var count int
+	err := DB.QueryRow(`
+        SELECT COUNT(*) FROM attachment
+        WHERE uploader_id = ? AND target_type IS NULL
+          AND attachment_id IN (?`+strings.Repeat(", ?", len(ids)-1)+`)`,
+		args...).Scan(&count)
+	return count, err
+}
+
+// LinkAttachments attaches uploads of userID to a post, a comment or a message, and returns them
+// Uploads already attached elsewhere or made by someone else are left out
+func LinkAttachments(userID string, ids []string, targetType, targetID string) ([]models.Attachment, error) {
+	for _, id := range ids {
+		_, err := DB.Exec(
+			`UPDATE attachment SET target_type = ?, target_id = ?
+			WHERE attachment_id = ? AND uploader_id = ? AND target_type IS NULL`,
+			targetType, targetID, id, userID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to link attachment: %w", err)
+		}
+	}
+	attachments, err := GetAttachments(targetType, []string{targetID})
+	return attachments[targetID], err
+}
+
+// GetAttachments retrieves the attachments of a list of posts, comments or messages, by content ID
+func GetAttachments(targetType string, targetIDs []string) (map[string][]models.Attachment, error) {
+	attachments := make(map[string][]models.Attachment)
+	if len(targetIDs) == 0 {
+		return attachments, nil
+	}
+
+	args := []interface{}{targetType}
+	for _, id := range targetIDs {
+		args = append(args, id)
+	}
+	rows, err := DB.Query(`
+        SELECT `+attachmentColumns+`
+        WHERE target_type = ? AND target_id IN (?`+strings.Repeat(", ?", len(targetIDs)-1)+`)
+        ORDER BY created_at`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query attachments: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attachment row: %w", err)
+		}
+		attachments[a.TargetId] = append(attachments[a.TargetId], *a)
+	}
+	return attachments, rows.Err()
+}
+
+// DeleteOrphanAttachments deletes the attachments of deleted contents and users,
+// and the uploads never attached to a content before pendingBefore
+// It returns the keys of the files nothing else uses, to remove from the blob store
+func DeleteOrphanAttachments(pendingBefore time.Time) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`
+        SELECT blob_key FROM attachment WHERE `+orphanAttachments+`
+        UNION
+        SELECT thumbnail_key FROM attachment WHERE thumbnail_key != '' AND (`+orphanAttachments+`)`,
+		pendingBefore)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query orphan attachments: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	if _, err := tx.Exec("DELETE FROM attachment WHERE "+orphanAttachments, pendingBefore); err != nil {
+		return nil, fmt.Errorf("failed to delete orphan attachments: %w", err)
+	}
+
+	unused, err := unusedBlobs(tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return unused, tx.Commit()
+}
+
+// attachPostAttachments fills the attachments of a list of posts
+func attachPostAttachments(posts []models.Post) error {
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	attachments, err := GetAttachments("post", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Attachments = attachments[posts[i].Id]
+	}
+	return nil
+}
+
+// attachCommentAttachments fills the attachments of a list of comments
+func attachCommentAttachments(comments []models.Comment) error {
+	ids := make([]string, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.Id
+	}
+	attachments, err := GetAttachments("comment", ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Attachments = attachments[comments[i].Id]
+	}
+	return nil
+}
+
+// postAttachments returns the attachments of a single post
+func postAttachments(postID string) ([]models.Attachment, error) {
+	attachments, err := GetAttachments("post", []string{postID})
+	if err != nil {
+		return nil, err
+	}
+	return attachments[postID], nil
+}
@@ -0,0 +1,181 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// avatarURL is where the avatars are downloaded, followed by the ID of their user
+const avatarURL = "/api/v1/avatars/"
+
+// avatarVersion selects the version of the avatar of the user u, empty for the identicon
+// Queries using it join user_avatar as ua
+const avatarVersion = "COALESCE(substr(ua.large_key, 1, 12), '')"
+
+// AvatarURL returns the URL of the avatar of a user
+// The version changes with the picture, so that browsers can keep each version in cache
+func AvatarURL(userID, version string) string {
+	if version == "" {
+		return avatarURL + userID
+	}
+	return avatarURL + userID + "?v=" + version
+}
+
+// AvatarVersion returns the version of a user's avatar, empty when they have none
+func AvatarVersion(avatar *models.Avatar) string {
+	if avatar == nil {
+		return ""
+	}
+	return avatar.LargeKey[:12]
+}
+
+// GetAvatar retrieves the avatar of a user who was not deleted, nil when they have none
+// It returns sql.ErrNoRows when the user does not exist
+func GetAvatar(userID string) (*models.Avatar, error) {
+	var deleted bool
+	var smallKey, mediumKey, largeKey, mimeType sql.NullString
+	var updatedAt sql.NullTime
+	err := DB.QueryRow(`
+        SELECT u.deleted_at IS NOT NULL, ua.small_key, ua.medium_key, ua.large_key, ua.mime_type, ua.updated_at
+        FROM User u
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE u.user_id = ?`,
+		userID).Scan(&deleted, &smallKey, &mediumKey, &largeKey, &mimeType, &updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	// Deleted accounts keeping their content are shown with the identicon
+	if deleted || !largeKey.Valid {
+		return nil, nil
+	}
+	return &models.Avatar{
+		UserId:    userID,
+		SmallKey:  smallKey.String,
+		MediumKey: mediumKey.String,
+		LargeKey:  largeKey.String,
+		MimeType:  mimeType.String,
+		UpdatedAt: updatedAt.Time,
+	}, nil
+}
+
+// GetAvatarURL returns the URL of the current avatar of a user
+func GetAvatarURL(userID string) (string, error) {
+	avatar, err := GetAvatar(userID)
+	if err != nil {
+		return "", err
+	}
+	return AvatarURL(userID, AvatarVersion(avatar)), nil
+}
+
+// SetAvatar saves the avatar of a user, replacing the previous one
+// It returns the keys of the replaced images nothing else uses, to remove from the blob store
+func SetAvatar(avatar models.Avatar) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	previous, err := avatarKeys(tx, "user_id = ?", avatar.UserId)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.Exec(`
+        INSERT INTO user_avatar (user_id, small_key, medium_key, large_key, mime_type, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(user_id) DO UPDATE SET small_key = excluded.small_key, medium_key = excluded.medium_key,
+            large_key = excluded.large_key, mime_type = excluded.mime_type, updated_at = excluded.updated_at`,
+		avatar.UserId, avatar.SmallKey, avatar.MediumKey, avatar.LargeKey, avatar.MimeType, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to save avatar: %w", err)
+	}
+
+	unused, err := unusedBlobs(tx, previous)
+	if err != nil {
+		return nil, err
+	}
+	return unused, tx.Commit()
+}
+
+// DeleteAvatar removes the avatar of a user, who is then shown with the identicon
+// It returns the keys of the images nothing else uses, to remove from the blob store
+func DeleteAvatar(userID string) ([]string, error) {
+	return deleteAvatars("user_id = ?", userID)
+}
+
+// DeleteOrphanAvatars removes the avatars of deleted accounts
+// It returns the keys of the images nothing else uses, to remove from the blob store
+func DeleteOrphanAvatars() ([]string, error) {
+	return deleteAvatars(`user_id NOT IN (SELECT user_id FROM User WHERE deleted_at IS NULL)`)
+}
+
+// deleteAvatars removes the avatars matching a condition on user_avatar
+func deleteAvatars(condition string, args ...interface{}) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	keys, err := avatarKeys(tx, condition, args...)
+	if err != nil || len(keys) == 0 {
+		return nil, err
+	}
+	if _, err := tx.Exec("DELETE FROM user_avatar WHERE "+condition, args...); err != nil {
+		return nil, fmt.Errorf("failed to delete avatar: %w", err)
+	}
+
+	unused, err := unusedBlobs(tx, keys)
+	if err != nil {
+		return nil, err
+	}
+	return unused, tx.Commit()
+}
+
+// avatarKeys returns the keys of the images of the avatars matching a condition on user_avatar
+func avatarKeys(tx *sql.Tx, condition string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query("SELECT small_key, medium_key, large_key FROM user_avatar WHERE "+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query avatars: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var small, medium, large string
+		if err := rows.Scan(&small, &medium, &large); err != nil {
+			return nil, err
+		}
+		keys = append(keys, small, medium, large)
+	}
+	return keys, rows.Err()
+}
+
+// unusedBlobs keeps the keys no attachment, avatar or preview image refers to anymore
+// Identical files share their blob, which is only removed with its last user
+func unusedBlobs(tx *sql.Tx, keys []string) ([]string, error) {
+	var unused []string
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var used bool
+		err := tx.QueryRow(`
+            SELECT EXISTS(SELECT 1 FROM attachment WHERE blob_key = ?1 OR thumbnail_key = ?1)
+                OR EXISTS(SELECT 1 FROM user_avatar WHERE small_key = ?1 OR medium_key = ?1 OR large_key = ?1)
+                OR EXISTS(SELECT 1 FROM link_preview WHERE image_key = ?1)`,
+			key).Scan(&used)
+		if err != nil {
+			return nil, err
+		}
+		if !used {
+			unused = append(unused, key)
+		}
+	}
+	return unused, nil
+}
@@ -1,35 +1,174 @@
-package database
-
-import (
-	"database/sql"
-	"fmt"
-	"io/ioutil"
-	"log"
-
-	_ "github.com/mattn/go-sqlite3"
-)
-
-var DB *sql.DB
-
-// InitDB reads the query.sql file and executes its content
-func InitDB() {
-	var err error
-	DB, err = sql.Open("sqlite3", "database.db")
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	// Read the query.sql file
-	sqlFile, err := ioutil.ReadFile("query.sql")
-	if err != nil {
-		log.Fatal("Error reading the SQL file:", err)
-	}
-
-	// Execute the SQL queries
-	_, err = DB.Exec(string(sqlFile))
-	if err != nil {
-		log.Fatal("Error executing the SQL queries:", err)
-	}
-
-	fmt.Println("Database initialized successfully!")
-}
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"log"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var DB *sql.DB
+
+// column describes a column added to a table after its creation
+// backfill is an optional query run once, right after the column is added
+type column struct {
+	table      string
+	name       string
+	definition string
+	backfill   string
+}
+
+// Columns added to existing tables over time
+// CREATE TABLE IF NOT EXISTS leaves tables of an existing database untouched,
+// so these are added with ALTER TABLE when they are missing
+var migrations = []column{
+	{table: "session", name: "last_activity", definition: "DATETIME"},
+	{table: "session", name: "user_agent", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "session", name: "ip_address", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "session", name: "remember_me", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "session", name: "idle_timeout", definition: "INTEGER NOT NULL DEFAULT 3600"}, // in seconds
+	{table: "session", name: "public_id", definition: "TEXT",
+		backfill: "UPDATE session SET public_id = lower(hex(randomblob(16))) WHERE public_id IS NULL"},
+	{table: "session", name: "csrf_token", definition: "TEXT",
+		backfill: "UPDATE session SET csrf_token = lower(hex(randomblob(32))) WHERE csrf_token IS NULL"},
+	// Accounts created before email verification existed are trusted
+	{table: "User", name: "email_verified", definition: "INTEGER NOT NULL DEFAULT 0",
+		backfill: "UPDATE User SET email_verified = 1"},
+	{table: "User", name: "deleted_at", definition: "DATETIME"},
+	{table: "User", name: "last_seen", definition: "DATETIME"},
+	{table: "user_privacy", name: "show_in_directory", definition: "INTEGER NOT NULL DEFAULT 1"},
+	{table: "user_privacy", name: "allow_stranger_messages", definition: "INTEGER NOT NULL DEFAULT 1"},
+	{table: "User", name: "role", definition: "TEXT NOT NULL DEFAULT 'user'"},
+	{table: "User", name: "suspended_until", definition: "DATETIME"},
+	{table: "Post", name: "pinned", definition: "INTEGER NOT NULL DEFAULT 0"},
+	{table: "Post", name: "locked", definition: "INTEGER NOT NULL DEFAULT 0"},
+	// Previews used to link the image on the site of the page, they get a copy when fetched again
+	{table: "link_preview", name: "image_key", definition: "TEXT NOT NULL DEFAULT ''"},
+	{table: "link_preview", name: "image_type", definition: "TEXT NOT NULL DEFAULT ''"},
+}
+
+// dataMigration is a query run once on a database, recorded in schema_migration by its name
+type dataMigration struct {
+	name  string
+	query string
+}
+
+// Changes of the data of existing databases, run in order after the columns are added
+var dataMigrations = []dataMigration{
+	// Comments only notify the subscribers of a post, the authors and commenters
+	// of the posts written before subscriptions existed are subscribed to them
+	{name: "subscribe_to_own_posts", query: `
+        INSERT OR IGNORE INTO subscription (user_id, target_type, target_id, created_at)
+        SELECT p.user_id, 'post', p.post_id, p.creation_date
+        FROM Post p JOIN User u ON u.user_id = p.user_id
+        WHERE u.deleted_at IS NULL
+        UNION
+        SELECT c.user_id, 'post', c.post_id, MIN(c.creation_date)
+        FROM Comment c JOIN User u ON u.user_id = c.user_id
+        WHERE u.deleted_at IS NULL
+        GROUP BY c.user_id, c.post_id`},
+}
+
+// InitDB reads the query.sql file and executes its content
+func InitDB() {
+	var err error
+	DB, err = sql.Open("sqlite3", "database.db")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Read the query.sql file
+	sqlFile, err := ioutil.ReadFile("query.sql")
+	if err != nil {
+		log.Fatal("Error reading the SQL file:", err)
+	}
+
+	// Execute the SQL queries
+	_, err = DB.Exec(string(sqlFile))
+	if err != nil {
+		log.Fatal("Error executing the SQL queries:", err)
+	}
+
+	// Bring tables of an existing database up to date
+	if err = migrate(); err != nil {
+		log.Fatal("Error migrating the database:", err)
+	}
+
+	fmt.Println("Database initialized successfully!")
+}
+
+// migrate adds the missing columns listed in migrations, then runs the data migrations not run yet
+func migrate() error {
+	for _, col := range migrations {
+		exists, err := columnExists(col.table, col.name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+
+		_, err = DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition))
+		if err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", col.table, col.name, err)
+		}
+
+		if col.backfill != "" {
+			if _, err = DB.Exec(col.backfill); err != nil {
+				return fmt.Errorf("failed to backfill column %s.%s: %w", col.table, col.name, err)
+			}
+		}
+	}
+
+	for _, m := range dataMigrations {
+		if err := runDataMigration(m); err != nil {
+			return fmt.Errorf("failed to run migration %s: %w", m.name, err)
+		}
+	}
+	return nil
+}
+
+// runDataMigration runs a data migration unless it was already run, and records it in the same transaction
+func runDataMigration(m dataMigration) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT OR IGNORE INTO schema_migration (name, applied_at) VALUES (?, datetime('now'))", m.name)
+	if err != nil {
+		return err
+	}
+	if applied, err := result.RowsAffected(); err != nil || applied == 0 {
+		return err
+	}
+	if _, err := tx.Exec(m.query); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// columnExists checks if a table already has a given column
+func columnExists(table, name string) (bool, error) {
+	rows, err := DB.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var colName, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return false, err
+		}
+		if colName == name {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetDigestSettings retrieves the email digest preference of a user
+// Users who never changed it get a weekly digest
+func GetDigestSettings(userID string) (*models.DigestSettings, error) {
+	settings := models.DigestSettings{UserId: userID, Frequency: models.DigestWeekly}
+
+	err := DB.QueryRow("SELECT frequency, last_sent_at FROM email_digest WHERE user_id = ?", userID).
+		Scan(&settings.Frequency, &settings.LastSentAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetDigestFrequency changes how often a user gets the email digest
+func SetDigestFrequency(userID, frequency string) error {
+	_, err := DB.Exec(
+		`INSERT INTO email_digest (user_id, frequency) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET frequency = excluded.frequency`,
+		userID, frequency,
+	)
+	return err
+}
+
+// GetDueDigests lists the users whose daily or weekly digest period ended by now
+// The first period of a user starts when they registered, and only verified addresses get digests
+func GetDueDigests(now time.Time) ([]models.Digest, error) {
+	rows, err := DB.Query(`
+        SELECT u.user_id, u.username, u.email, COALESCE(d.frequency, 'weekly'), d.last_sent_at, u.creation_date
+        FROM User u
+        LEFT JOIN email_digest d ON d.user_id = u.user_id
+        WHERE u.email_verified = 1 AND u.deleted_at IS NULL AND u.email != ''
+          AND ((COALESCE(d.frequency, 'weekly') = 'daily' AND COALESCE(d.last_sent_at, u.creation_date) <= ?)
+            OR (COALESCE(d.frequency, 'weekly') = 'weekly' AND COALESCE(d.last_sent_at, u.creation_date) <= ?))`,
+		now.Add(-24*time.Hour), now.Add(-7*24*time.Hour))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due digests: %w", err)
+	}
+	defer rows.Close()
+
+	digests := []models.Digest{}
+	for rows.Next() {
+		var d models.Digest
+		var lastSent sql.NullTime
+		if err := rows.Scan(&d.UserId, &d.Username, &d.Email, &d.Frequency, &lastSent, &d.Since); err != nil {
+			return nil, err
+		}
+		if lastSent.Valid {
+			d.Since = lastSent.Time
+		}
+		digests = append(digests, d)
+	}
+	return digests, rows.Err()
+}
+
+// GetDigestNotifications lists the unread messages, replies and mentions received by a user
+// between two dates, oldest first
+func GetDigestNotifications(userID string, since, until time.Time) ([]models.Notification, error) {
+	rows, err := DB.Query(`
+        SELECT `+notificationColumns+`
+        WHERE n.user_id = ? AND n.read = 0 AND n.created_at > ? AND n.created_at <= ?
+          AND n.type IN ('message', 'post_reply', 'comment_reply', 'mention')
+        ORDER BY n.created_at`,
+		userID, since, until)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query digest notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, *n)
+	}
+	return notifications, rows.Err()
+}
+
+// MarkDigestSent records the end of the period covered by the digest of a user,
+// the next one starts from there
+func MarkDigestSent(userID string, at time.Time) error {
+	_, err := DB.Exec(
+		`INSERT INTO email_digest (user_id, last_sent_at) VALUES (?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET last_sent_at = excluded.last_sent_at`,
+		userID, at,
+	)
+	return err
+}
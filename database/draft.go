@@ -0,0 +1,76 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"fmt"
+	"time"
+)
+
+// What a draft is written for
+const (
+	DraftPost    = "post"    // the form of a new post
+	DraftComment = "comment" // the comment box of a post
+	DraftMessage = "message" // a conversation with another user
+)
+
+// GetDrafts lists the drafts of a user, most recently changed first
+func GetDrafts(userID string) ([]models.Draft, error) {
+	rows, err := DB.Query(`
+        SELECT target_type, target_id, title, content, updated_at
+        FROM draft WHERE user_id = ?
+        ORDER BY updated_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query drafts: %w", err)
+	}
+	defer rows.Close()
+
+	drafts := []models.Draft{}
+	for rows.Next() {
+		var d models.Draft
+		if err := rows.Scan(&d.TargetType, &d.TargetId, &d.Title, &d.Content, &d.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan draft row: %w", err)
+		}
+		drafts = append(drafts, d)
+	}
+	return drafts, rows.Err()
+}
+
+// SaveDraft saves a draft of a user, replacing the previous one of the same target
+func SaveDraft(userID string, d models.Draft) error {
+	_, err := DB.Exec(`
+        INSERT INTO draft (user_id, target_type, target_id, title, content, updated_at)
+        VALUES (?, ?, ?, ?, ?, ?)
+        ON CONFLICT(user_id, target_type, target_id) DO UPDATE SET
+            title = excluded.title, content = excluded.content, updated_at = excluded.updated_at`,
+		userID, d.TargetType, d.TargetId, d.Title, d.Content, d.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to save draft: %w", err)
+	}
+	return nil
+}
+
+// DeleteDraft removes a draft of a user
+// It reports whether there was one
+func DeleteDraft(userID, targetType, targetID string) (bool, error) {
+	result, err := DB.Exec(
+		"DELETE FROM draft WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete draft: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// DeleteOldDrafts removes the drafts left untouched since before, and those of deleted posts
+func DeleteOldDrafts(before time.Time) (int64, error) {
+	result, err := DB.Exec(`
+        DELETE FROM draft
+        WHERE updated_at < ? OR (target_type = 'comment' AND target_id NOT IN (SELECT post_id FROM Post))`,
+		before)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete drafts: %w", err)
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"fmt"
+	"time"
+)
+
+// RecordFilteredContent keeps a content the filter masked, flagged or blocked
+func RecordFilteredContent(entry models.FilteredContent) (*models.FilteredContent, error) {
+	entry.Id = shared.ParseUUID(shared.GenerateUUID())
+	entry.CreatedAt = time.Now()
+
+	_, err := DB.Exec(
+		`INSERT INTO filtered_content (filter_id, author_id, kind, target_id, action, rules, original, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Id, entry.AuthorId, entry.Kind, entry.TargetId, entry.Action, entry.Rules, entry.Original, entry.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record filtered content: %w", err)
+	}
+	return &entry, nil
+}
+
+// GetFilteredContent retrieves a page of the filtered contents, most recent first
+// An empty action matches every content
+func GetFilteredContent(action string, limit, offset int) ([]models.FilteredContent, error) {
+	rows, err := DB.Query(`
+        SELECT f.filter_id, f.author_id, u.username, f.kind, f.target_id, f.action, f.rules, f.original, f.created_at
+        FROM filtered_content f
+        JOIN User u ON f.author_id = u.user_id
+        WHERE ? = '' OR f.action = ?
+        ORDER BY f.created_at DESC
+        LIMIT ? OFFSET ?`,
+		action, action, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query filtered content: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.FilteredContent{}
+	for rows.Next() {
+		var entry models.FilteredContent
+		err := rows.Scan(&entry.Id, &entry.AuthorId, &entry.Author, &entry.Kind, &entry.TargetId,
+			&entry.Action, &entry.Rules, &entry.Original, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan filtered content row: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// GetRecentContent returns the posts, comments and private messages a user wrote since a date
+func GetRecentContent(authorID string, since time.Time) ([]string, error) {
+	rows, err := DB.Query(`
+        SELECT content FROM Post WHERE user_id = ?1 AND creation_date > ?2
+        UNION ALL
+        SELECT content FROM Comment WHERE user_id = ?1 AND creation_date > ?2
+        UNION ALL
+        SELECT content FROM messages WHERE sender_id = ?1 AND sent_at > ?3`,
+		authorID, since, since.UTC().Format("2006-01-02 15:04:05"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query recent content: %w", err)
+	}
+	defer rows.Close()
+
+	var contents []string
+	for rows.Next() {
+		var content string
+		if err := rows.Scan(&content); err != nil {
+			return nil, fmt.Errorf("failed to scan content row: %w", err)
+		}
+		contents = append(contents, content)
+	}
+	return contents, rows.Err()
+}
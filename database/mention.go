@@ -0,0 +1,238 @@
+package database
+
+import (
+	"Real-Time-Forum/markdown"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// mentionPattern matches @username, unless the @ follows a word character as in an email address
+var mentionPattern = regexp.MustCompile(`(?:^|[^A-Za-z0-9_.@-])(@[A-Za-z0-9_.-]+)`)
+
+// deleteOrphanMentions removes the mentions of contents that were deleted
+const deleteOrphanMentions = `DELETE FROM mention WHERE
+    (source_type = 'post' AND source_id NOT IN (SELECT post_id FROM Post))
+    OR (source_type = 'comment' AND source_id NOT IN (SELECT comment_id FROM Comment))
+    OR (source_type = 'message' AND CAST(source_id AS INTEGER) NOT IN (SELECT id FROM messages))`
+
+// maxMentions is the number of different names a content can mention, the others are left as plain text
+const maxMentions = 20
+
+// saveMentions finds the users mentioned in a content and records the mentions,
+// in the transaction that inserts the content
+// Names that match no user are left as plain text
+func saveMentions(tx *sql.Tx, sourceType, sourceID, content string) ([]models.Mention, error) {
+	mentions, err := parseMentions(tx, content)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, m := range mentions {
+		_, err := tx.Exec(
+			`INSERT INTO mention (source_type, source_id, user_id, start_offset, end_offset) VALUES (?, ?, ?, ?, ?)`,
+			sourceType, sourceID, m.UserId, m.Start, m.End,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to save mention: %w", err)
+		}
+	}
+	return mentions, nil
+}
+
+// parseMentions returns the mentions of existing users in a content
+// Offsets are counted in characters, not bytes
+func parseMentions(tx *sql.Tx, content string) ([]models.Mention, error) {
+	type token struct {
+		start int
+		name  string
+	}
+	var tokens []token
+	names := make(map[string]bool)
+	for _, loc := range mentionPattern.FindAllStringSubmatchIndex(content, -1) {
+		name := content[loc[2]+1 : loc[3]]
+		if !names[strings.ToLower(name)] {
+			if len(names) == maxMentions {
+				continue
+			}
+			names[strings.ToLower(name)] = true
+		}
+		tokens = append(tokens, token{loc[2], name})
+	}
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	users, err := findMentionedUsers(tx, names)
+	if err != nil {
+		return nil, err
+	}
+
+	var mentions []models.Mention
+	for _, t := range tokens {
+		user := resolveMention(users, t.name)
+		if user == nil {
+			continue
+		}
+
+		offset := utf8.RuneCountInString(content[:t.start])
+		mentions = append(mentions, models.Mention{
+			UserId:   user.UserId,
+			Username: user.Username,
+			Start:    offset,
+			End:      offset + 1 + utf8.RuneCountInString(user.Username),
+		})
+	}
+	return mentions, nil
+}
+
+// findMentionedUsers returns the users whose name is one of names, ignoring its case,
+// or one of names without the dots and dashes ending it, by lowercase username
+func findMentionedUsers(tx *sql.Tx, names map[string]bool) (map[string][]models.PublicUser, error) {
+	var args []interface{}
+	for name := range names {
+		args = append(args, name)
+		if trimmed := strings.TrimRight(name, ".-"); trimmed != name && trimmed != "" {
+			args = append(args, trimmed)
+		}
+	}
+
+	rows, err := tx.Query(`
+        SELECT user_id, username FROM User
+        WHERE username COLLATE NOCASE IN (?`+strings.Repeat(", ?", len(args)-1)+`) AND deleted_at IS NULL`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve mentions: %w", err)
+	}
+	defer rows.Close()
+
+	users := make(map[string][]models.PublicUser)
+	for rows.Next() {
+		var user models.PublicUser
+		if err := rows.Scan(&user.UserId, &user.Username); err != nil {
+			return nil, fmt.Errorf("failed to scan mentioned user: %w", err)
+		}
+		key := strings.ToLower(user.Username)
+		users[key] = append(users[key], user)
+	}
+	return users, rows.Err()
+}
+
+// resolveMention picks the user a name following an @ refers to, preferring the one written with the same case
+// The dots and dashes ending a sentence are dropped when no user has them in their name
+// A name matching several users with other cases, and none with the same case, mentions nobody
+func resolveMention(users map[string][]models.PublicUser, name string) *models.PublicUser {
+	for {
+		if matches := users[strings.ToLower(name)]; len(matches) > 0 {
+			for i := range matches {
+				if matches[i].Username == name {
+					return &matches[i]
+				}
+			}
+			// Accounts created before usernames were unique whatever their case can't be told apart
+			if len(matches) > 1 {
+				return nil
+			}
+			return &matches[0]
+		}
+
+		trimmed := strings.TrimRight(name, ".-")
+		if trimmed == name || trimmed == "" {
+			return nil
+		}
+		name = trimmed
+	}
+}
+
+// GetMentions returns the mentions of contents of a type, by content ID
+func GetMentions(sourceType string, sourceIDs []string) (map[string][]models.Mention, error) {
+	mentions := make(map[string][]models.Mention)
+	if len(sourceIDs) == 0 {
+		return mentions, nil
+	}
+
+	args := []interface{}{sourceType}
+	for _, id := range sourceIDs {
+		args = append(args, id)
+	}
+	rows, err := DB.Query(`
+        SELECT m.source_id, m.user_id, u.username, m.start_offset, m.end_offset
+        FROM mention m
+        JOIN User u ON m.user_id = u.user_id
+        WHERE m.source_type = ? AND m.source_id IN (?`+strings.Repeat(", ?", len(sourceIDs)-1)+`)
+        ORDER BY m.start_offset`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query mentions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceID string
+		var m models.Mention
+		if err := rows.Scan(&sourceID, &m.UserId, &m.Username, &m.Start, &m.End); err != nil {
+			return nil, fmt.Errorf("failed to scan mention row: %w", err)
+		}
+		mentions[sourceID] = append(mentions[sourceID], m)
+	}
+	return mentions, rows.Err()
+}
+
+// RenderContent returns the sanitized HTML of a Markdown content, with its mentions linked to the profiles
+func RenderContent(content string, mentions []models.Mention) string {
+	chars := []rune(content)
+	names := make(map[string]string, len(mentions))
+	for _, m := range mentions {
+		// The text of a mention is the name written after the @, which may be an older username
+		if m.Start < m.End && m.End <= len(chars) {
+			names[strings.ToLower(string(chars[m.Start+1:m.End]))] = m.Username
+		}
+	}
+	return markdown.Render(content, names)
+}
+
+// attachPostMentions fills the mentions of a list of posts, and their content rendered with them
+func attachPostMentions(posts []models.Post) error {
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	mentions, err := GetMentions("post", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Mentions = mentions[posts[i].Id]
+		posts[i].ContentHTML = RenderContent(posts[i].Content, posts[i].Mentions)
+	}
+	return nil
+}
+
+// attachCommentMentions fills the mentions of a list of comments, and their content rendered with them
+func attachCommentMentions(comments []models.Comment) error {
+	ids := make([]string, len(comments))
+	for i, comment := range comments {
+		ids[i] = comment.Id
+	}
+	mentions, err := GetMentions("comment", ids)
+	if err != nil {
+		return err
+	}
+	for i := range comments {
+		comments[i].Mentions = mentions[comments[i].Id]
+		comments[i].ContentHTML = RenderContent(comments[i].Content, comments[i].Mentions)
+	}
+	return nil
+}
+
+// postMentions returns the mentions of a single post
+func postMentions(postID string) ([]models.Mention, error) {
+	mentions, err := GetMentions("post", []string{postID})
+	if err != nil {
+		return nil, err
+	}
+	return mentions[postID], nil
+}
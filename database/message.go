@@ -1,63 +1,125 @@
-package database
-
-import (
-	"fmt"
-)
-
-// Manage saving and retrieving private messages in the database
-
-// SavePrivateMessage saves a private message to the database
-func SavePrivateMessage(senderID, receiverID, content string) error {
-	_, err := DB.Exec(
-		"INSERT INTO messages (sender_id, receiver_id, content, sent_at) VALUES (?, ?, ?, datetime('now'))",
-		senderID, receiverID, content,
-	)
-	return err
-}
-
-// GetPrivateMessages retrieves paginated conversation history between two users
-func GetPrivateMessages(user1ID, user2ID string, page, limit int) ([]map[string]interface{}, error) {
-	offset := (page - 1) * limit
-
-	// SQL query to get messages between two users
-	// Takes into account both directions of the conversation & pagination (LIMIT and OFFSET)
-	query := fmt.Sprintf(`
-        SELECT id, sender_id, receiver_id, content, sent_at
-        FROM messages
-        WHERE (sender_id = '%s' AND receiver_id = '%s')
-           OR (sender_id = '%s' AND receiver_id = '%s')
-        ORDER BY sent_at DESC
-        LIMIT %d OFFSET %d`,
-		user1ID, user2ID, user2ID, user1ID, limit, offset)
-
-	rows, err := DB.Query(query)
-	if err != nil {
-		return nil, fmt.Errorf("query error: %v", err)
-	}
-	defer rows.Close()
-
-	// Messages are stored in a slice of maps
-	var messages []map[string]interface{}
-	for rows.Next() {
-		var id int
-		var senderID, receiverID, content, sentAt string
-
-		if err := rows.Scan(&id, &senderID, &receiverID, &content, &sentAt); err != nil {
-			return nil, fmt.Errorf("scan error: %v", err)
-		}
-
-		messages = append(messages, map[string]interface{}{
-			"id":          id,
-			"sender_id":   senderID,
-			"receiver_id": receiverID,
-			"content":     content,
-			"sent_at":     sentAt,
-		})
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("rows error: %v", err)
-	}
-
-	return messages, nil
-}
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// Manage saving and retrieving private messages in the database
+
+// SavePrivateMessage saves a private message to the database
+// It returns the ID of the message and the users it mentions
+func SavePrivateMessage(senderID, receiverID, content string) (int64, []models.Mention, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		"INSERT INTO messages (sender_id, receiver_id, content, sent_at) VALUES (?, ?, ?, datetime('now'))",
+		senderID, receiverID, content,
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	mentions, err := saveMentions(tx, "message", strconv.FormatInt(id, 10), content)
+	if err != nil {
+		return 0, nil, err
+	}
+	return id, mentions, tx.Commit()
+}
+
+// DeleteMessage deletes a private message with its mentions and the notifications it caused
+func DeleteMessage(tx *sql.Tx, messageID string) error {
+	if _, err := tx.Exec("DELETE FROM notification WHERE type IN (?, ?) AND target_id = ?",
+		models.NotifyMessage, models.NotifyMention, messageID); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM mention WHERE source_type = 'message' AND source_id = ?", messageID); err != nil {
+		return fmt.Errorf("failed to delete mentions: %w", err)
+	}
+	return execOne(tx, "DELETE FROM messages WHERE id = ?", messageID)
+}
+
+// GetPrivateMessages retrieves paginated conversation history between two users
+func GetPrivateMessages(user1ID, user2ID string, page, limit int) ([]map[string]interface{}, error) {
+	offset := (page - 1) * limit
+
+	// SQL query to get messages between two users
+	// Takes into account both directions of the conversation & pagination (LIMIT and OFFSET)
+	query := `
+        SELECT id, sender_id, receiver_id, content, sent_at
+        FROM messages
+        WHERE (sender_id = ? AND receiver_id = ?)
+           OR (sender_id = ? AND receiver_id = ?)
+        ORDER BY sent_at DESC
+        LIMIT ? OFFSET ?`
+
+	rows, err := DB.Query(query, user1ID, user2ID, user2ID, user1ID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("query error: %v", err)
+	}
+	defer rows.Close()
+
+	// Messages are stored in a slice of maps
+	var messages []map[string]interface{}
+	var ids []string
+	for rows.Next() {
+		var id int
+		var senderID, receiverID, content, sentAt string
+
+		if err := rows.Scan(&id, &senderID, &receiverID, &content, &sentAt); err != nil {
+			return nil, fmt.Errorf("scan error: %v", err)
+		}
+
+		messages = append(messages, map[string]interface{}{
+			"id":          id,
+			"sender_id":   senderID,
+			"receiver_id": receiverID,
+			"content":     content,
+			"sent_at":     sentAt,
+		})
+		ids = append(ids, strconv.Itoa(id))
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows error: %v", err)
+	}
+
+	mentions, err := GetMentions("message", ids)
+	if err != nil {
+		return nil, err
+	}
+	attachments, err := GetAttachments("message", ids)
+	if err != nil {
+		return nil, err
+	}
+	previews, err := GetPreviews("message", ids)
+	if err != nil {
+		return nil, err
+	}
+	for i, id := range ids {
+		if m, ok := mentions[id]; ok {
+			messages[i]["mentions"] = m
+		}
+		if content, ok := messages[i]["content"].(string); ok {
+			messages[i]["content_html"] = RenderContent(content, mentions[id])
+		}
+		if a, ok := attachments[id]; ok {
+			messages[i]["attachments"] = a
+		}
+		if p, ok := previews[id]; ok {
+			messages[i]["previews"] = p
+		}
+	}
+
+	return messages, nil
+}
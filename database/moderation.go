@@ -0,0 +1,178 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// GetUserRole returns the role of a user
+func GetUserRole(userID string) (string, error) {
+	var role string
+	err := DB.QueryRow("SELECT role FROM User WHERE user_id = ? AND deleted_at IS NULL", userID).Scan(&role)
+	return role, err
+}
+
+// SetUserRole changes the role of a user
+func SetUserRole(tx *sql.Tx, userID, role string) error {
+	return execOne(tx, "UPDATE User SET role = ? WHERE user_id = ? AND deleted_at IS NULL", role, userID)
+}
+
+// SetSuspension suspends a user until a date, or lifts the suspension when until is nil
+func SetSuspension(tx *sql.Tx, userID string, until *time.Time) error {
+	return execOne(tx, "UPDATE User SET suspended_until = ? WHERE user_id = ? AND deleted_at IS NULL", until, userID)
+}
+
+// GetSuspension returns the end of the suspension of a user, or nil when they are not suspended
+func GetSuspension(userID string) (*time.Time, error) {
+	var until sql.NullTime
+	err := DB.QueryRow("SELECT suspended_until FROM User WHERE user_id = ?", userID).Scan(&until)
+	if err != nil {
+		return nil, err
+	}
+	if !until.Valid || until.Time.Before(time.Now()) {
+		return nil, nil
+	}
+	return &until.Time, nil
+}
+
+// DeletePost deletes a post along with its comments, their mentions and the subscriptions to it
+func DeletePost(tx *sql.Tx, postID string) error {
+	if _, err := tx.Exec("DELETE FROM Comment WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("failed to delete comments: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM notification WHERE post_id = ?", postID); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	result, err := tx.Exec("DELETE FROM Post WHERE post_id = ?", postID)
+	if err != nil {
+		return fmt.Errorf("failed to delete post: %w", err)
+	}
+	if removed, _ := result.RowsAffected(); removed == 0 {
+		return sql.ErrNoRows
+	}
+	if _, err := tx.Exec(deleteOrphanMentions); err != nil {
+		return fmt.Errorf("failed to delete mentions: %w", err)
+	}
+	if _, err := tx.Exec(deleteOrphanSubscriptions); err != nil {
+		return fmt.Errorf("failed to delete subscriptions: %w", err)
+	}
+	return nil
+}
+
+// DeleteComment deletes a comment with its mentions and the notifications it caused
+func DeleteComment(tx *sql.Tx, commentID string) error {
+	if _, err := tx.Exec("DELETE FROM notification WHERE target_id = ?", commentID); err != nil {
+		return fmt.Errorf("failed to delete notifications: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM mention WHERE source_type = 'comment' AND source_id = ?", commentID); err != nil {
+		return fmt.Errorf("failed to delete mentions: %w", err)
+	}
+	return execOne(tx, "DELETE FROM Comment WHERE comment_id = ?", commentID)
+}
+
+// SetPostLocked locks or unlocks the comments of a post
+func SetPostLocked(tx *sql.Tx, postID string, locked bool) error {
+	return execOne(tx, "UPDATE Post SET locked = ? WHERE post_id = ?", locked, postID)
+}
+
+// SetPostPinned pins a post at the top of its category, or unpins it
+func SetPostPinned(tx *sql.Tx, postID string, pinned bool) error {
+	return execOne(tx, "UPDATE Post SET pinned = ? WHERE post_id = ?", pinned, postID)
+}
+
+// GetCommentPostID returns the post a comment belongs to
+func GetCommentPostID(commentID string) (string, error) {
+	var postID string
+	err := DB.QueryRow("SELECT post_id FROM Comment WHERE comment_id = ?", commentID).Scan(&postID)
+	return postID, err
+}
+
+// Transaction runs statements in a transaction, which is rolled back when run fails
+func Transaction(run func(tx *sql.Tx) error) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := run(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Moderate runs an action of a moderator and writes it to the audit log in the same transaction,
+// so that nothing is changed when the log can't be written
+func Moderate(entry models.ModerationEntry, action func(tx *sql.Tx) error) error {
+	return Transaction(func(tx *sql.Tx) error {
+		if err := action(tx); err != nil {
+			return err
+		}
+		return logModeration(tx, entry)
+	})
+}
+
+// logModeration writes an action of a moderator to the audit log
+func logModeration(tx *sql.Tx, entry models.ModerationEntry) error {
+	entry.Id = shared.ParseUUID(shared.GenerateUUID())
+	entry.CreatedAt = time.Now()
+
+	_, err := tx.Exec(
+		`INSERT INTO moderation_log (log_id, moderator_id, action, target_type, target_id, details, reason, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		entry.Id, entry.ModeratorId, entry.Action, entry.TargetType, entry.TargetId,
+		entry.Details, entry.Reason, entry.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to write moderation log: %w", err)
+	}
+	return nil
+}
+
+// GetModerationLog retrieves a page of the audit log, most recent actions first
+func GetModerationLog(limit, offset int) ([]models.ModerationEntry, error) {
+	rows, err := DB.Query(`
+        SELECT l.log_id, l.moderator_id, COALESCE(u.username, ''), l.action, l.target_type, l.target_id,
+            l.details, l.reason, l.created_at
+        FROM moderation_log l
+        LEFT JOIN User u ON l.moderator_id = u.user_id -- entries outlive the accounts of moderators
+        ORDER BY l.created_at DESC
+        LIMIT ? OFFSET ?`,
+		limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query moderation log: %w", err)
+	}
+	defer rows.Close()
+
+	entries := []models.ModerationEntry{}
+	for rows.Next() {
+		var entry models.ModerationEntry
+		err := rows.Scan(&entry.Id, &entry.ModeratorId, &entry.Moderator, &entry.Action,
+			&entry.TargetType, &entry.TargetId, &entry.Details, &entry.Reason, &entry.CreatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan moderation log row: %w", err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// execer runs statements, on the database or in a transaction
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// execOne runs a statement meant to change a single row, returning sql.ErrNoRows when none matched
+func execOne(db execer, query string, args ...interface{}) error {
+	result, err := db.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	if changed, _ := result.RowsAffected(); changed == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
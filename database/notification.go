@@ -0,0 +1,86 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"fmt"
+	"time"
+)
+
+// notificationColumns are the columns scanned by scanNotification
+const notificationColumns = `
+    n.notification_id, n.user_id, n.actor_id, u.username, n.type, n.post_id, n.target_id,
+    n.excerpt, n.read, n.created_at
+    FROM notification n
+    JOIN User u ON n.actor_id = u.user_id`
+
+// scanNotification reads a row selected with notificationColumns
+func scanNotification(row interface{ Scan(...interface{}) error }) (*models.Notification, error) {
+	var n models.Notification
+	err := row.Scan(&n.Id, &n.UserId, &n.ActorId, &n.Actor, &n.Type, &n.PostId, &n.TargetId,
+		&n.Excerpt, &n.Read, &n.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &n, nil
+}
+
+// CreateNotification saves a new unread notification and returns it with the name of its actor
+func CreateNotification(n models.Notification) (*models.Notification, error) {
+	n.Id = shared.ParseUUID(shared.GenerateUUID())
+	n.CreatedAt = time.Now()
+
+	_, err := DB.Exec(
+		`INSERT INTO notification (notification_id, user_id, actor_id, type, post_id, target_id, excerpt, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		n.Id, n.UserId, n.ActorId, n.Type, n.PostId, n.TargetId, n.Excerpt, n.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save notification: %w", err)
+	}
+
+	return scanNotification(DB.QueryRow("SELECT "+notificationColumns+" WHERE n.notification_id = ?", n.Id))
+}
+
+// GetNotifications retrieves a page of the notifications of a user, most recent first
+func GetNotifications(userID string, unreadOnly bool, limit, offset int) ([]models.Notification, error) {
+	rows, err := DB.Query(`
+        SELECT `+notificationColumns+`
+        WHERE n.user_id = ? AND (n.read = 0 OR NOT ?)
+        ORDER BY n.created_at DESC
+        LIMIT ? OFFSET ?`,
+		userID, unreadOnly, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notifications: %w", err)
+	}
+	defer rows.Close()
+
+	notifications := []models.Notification{}
+	for rows.Next() {
+		n, err := scanNotification(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan notification row: %w", err)
+		}
+		notifications = append(notifications, *n)
+	}
+	return notifications, rows.Err()
+}
+
+// CountUnreadNotifications returns the number of unread notifications of a user
+func CountUnreadNotifications(userID string) (int, error) {
+	var count int
+	err := DB.QueryRow("SELECT COUNT(*) FROM notification WHERE user_id = ? AND read = 0", userID).Scan(&count)
+	return count, err
+}
+
+// MarkNotificationRead marks a notification of a user as read
+// It returns sql.ErrNoRows if the user has no such notification
+func MarkNotificationRead(userID, notificationID string) error {
+	return execOne(DB, "UPDATE notification SET read = 1 WHERE notification_id = ? AND user_id = ?", notificationID, userID)
+}
+
+// MarkAllNotificationsRead marks every notification of a user as read
+func MarkAllNotificationsRead(userID string) error {
+	_, err := DB.Exec("UPDATE notification SET read = 1 WHERE user_id = ? AND read = 0", userID)
+	return err
+}
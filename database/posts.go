@@ -1,220 +1,375 @@
-package database
-
-import (
-	"Real-Time-Forum/models"
-	"Real-Time-Forum/shared"
-	"database/sql"
-	"fmt"
-	"time"
-)
-
-// CreatePost inserts a new post into the database
-func CreatePost(post models.Post) (*models.Post, error) {
-	uuidObj := shared.GenerateUUID()
-	post.Id = shared.ParseUUID(uuidObj) // Convert to string format
-	post.CreationDate = time.Now()
-
-	// Insert the post into the database
-	result, err := DB.Exec(
-		`INSERT INTO Post (post_id, title, content, category, user_id, creation_date) 
-		VALUES (?, ?, ?, ?, ?, ?)`,
-		post.Id, post.Title, post.Content, post.Category, post.UserId, post.CreationDate,
-	)
-	if err != nil {
-		return nil, fmt.Errorf("failed to insert post into database: %w", err)
-	}
-
-	_, _ = result.RowsAffected()
-
-	return &post, nil
-}
-
-// GetAllPosts retrieves all posts from the database
-func GetAllPosts(db *sql.DB) ([]models.Post, error) {
-
-	query := `
-	SELECT p.post_id, p.user_id, p.title, p.content, p.category, p.creation_date, u.username
-	FROM Post p
-	JOIN User u ON p.user_id = u.user_id
-	ORDER BY p.creation_date DESC
-	`
-
-	rows, err := db.Query(query)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []models.Post
-
-	for rows.Next() {
-		var post models.Post
-		var username string
-		err := rows.Scan(
-			&post.Id,
-			&post.UserId,
-			&post.Title,
-			&post.Content,
-			&post.Category,
-			&post.CreationDate,
-			&username,
-		)
-		if err != nil {
-			return nil, err
-		}
-		post.Username = username // Assign the username to the post
-		posts = append(posts, post)
-	}
-
-	return posts, nil
-}
-
-// GetPostByID retrieves a certain post by its ID
-func GetPostByID(db *sql.DB, id string) (*models.Post, error) {
-	query := `
-	SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username
-	FROM Post p
-	JOIN User u ON p.user_id = u.user_id
-	WHERE p.post_id = ?
-	`
-
-	row := db.QueryRow(query, id)
-
-	var post models.Post
-	var username string
-	err := row.Scan(
-		&post.Id,
-		&post.Title,
-		&post.Content,
-		&post.UserId,
-		&post.Category,
-		&post.CreationDate,
-		&username,
-	)
-	if err != nil {
-		return nil, err
-	}
-	return &post, nil
-}
-
-// GetPostsByUser retrieves all posts by a specific user
-func GetPostsByUser(db *sql.DB, userID string) ([]models.Post, error) {
-	query := `
-	SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date
-	FROM Post p
-	WHERE user_id = ?
-	ORDER BY creation_date DESC
-	`
-
-	rows, err := db.Query(query, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var posts []models.Post
-	for rows.Next() {
-		var post models.Post
-		err := rows.Scan(
-			&post.Id,
-			&post.Title,
-			&post.Content,
-			&post.UserId,
-			&post.Category,
-			&post.CreationDate,
-		)
-		if err != nil {
-			return nil, err
-		}
-		posts = append(posts, post)
-	}
-	return posts, nil
-}
-
-// GetPostWithComments retrieves a post and all its comments
-func GetPostWithComments(postID string) (*models.PostWithComments, error) {
-	var post models.Post
-	var postUsername string
-
-	postQuery := `
-		SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username
-		FROM Post p
-		JOIN User u ON p.user_id = u.user_id
-		WHERE p.post_id = ?
-	`
-
-	err := DB.QueryRow(postQuery, postID).Scan(
-		&post.Id,
-		&post.Title,
-		&post.Content,
-		&post.UserId,
-		&post.Category,
-		&post.CreationDate,
-		&postUsername,
-	)
-
-	if err != nil {
-		return nil, err
-	}
-
-	post.Username = postUsername
-
-	commentsQuery := `
-		SELECT c.comment_id, c.content, c.user_id, c.creation_date, u.username
-		FROM Comment c
-		JOIN User u ON c.user_id = u.user_id
-		WHERE c.post_id = ?
-		ORDER BY c.creation_date ASC
-	`
-
-	rows, err := DB.Query(commentsQuery, postID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-
-	var comments []models.Comment
-	for rows.Next() {
-		var comment models.Comment
-		var commentUsername string
-		err := rows.Scan(
-			&comment.Id,
-			&comment.Content,
-			&comment.UserId,
-			&comment.CreationDate,
-			&commentUsername,
-		)
-		if err != nil {
-			continue
-		}
-		comment.PostId = postID
-		comment.Username = commentUsername // Assign the username to the comment
-		comments = append(comments, comment)
-	}
-	result := &models.PostWithComments{
-		Post:     post,
-		Comments: comments,
-	}
-	return result, nil
-}
-
-// CreateComment adds a new comment to a post
-func CreateComment(comment models.Comment) error {
-
-	comment.Id = shared.ParseUUID(shared.GenerateUUID())
-	comment.CreationDate = time.Now()
-
-	result, err := DB.Exec(
-		`INSERT INTO Comment (comment_id, post_id, user_id, content, creation_date) 
-		 VALUES (?, ?, ?, ?, ?)`,
-		comment.Id, comment.PostId, comment.UserId, comment.Content, comment.CreationDate,
-	)
-
-	if err != nil {
-		return err
-	}
-
-	_, _ = result.RowsAffected()
-
-	return nil
-}
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// CreatePost inserts a new post into the database
+func CreatePost(post models.Post) (*models.Post, error) {
+	uuidObj := shared.GenerateUUID()
+	post.Id = shared.ParseUUID(uuidObj) // Convert to string format
+	post.CreationDate = time.Now()
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Insert the post into the database
+	result, err := tx.Exec(
+		`INSERT INTO Post (post_id, title, content, category, user_id, creation_date) 
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		post.Id, post.Title, post.Content, post.Category, post.UserId, post.CreationDate,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert post into database: %w", err)
+	}
+
+	_, _ = result.RowsAffected()
+
+	if post.Mentions, err = saveMentions(tx, "post", post.Id, post.Content); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	post.ContentHTML = RenderContent(post.Content, post.Mentions)
+
+	return &post, nil
+}
+
+// GetAllPosts retrieves all posts from the database, or the posts of a category when it is not empty
+// Pinned posts come first in the list of their category, the list of every category is only sorted by date
+// The posts of the authors viewerID blocked or muted are left out
+func GetAllPosts(db *sql.DB, viewerID, category string) ([]models.Post, error) {
+	where, order := fmt.Sprintf(hiddenAuthor, "p.user_id"), "p.creation_date DESC"
+	args := []interface{}{viewerID}
+	if category != "" {
+		where += " AND p.category = ?"
+		order = "p.pinned DESC, " + order
+		args = append(args, category)
+	}
+
+	query := `
+	SELECT p.post_id, p.user_id, p.title, p.content, p.category, p.creation_date, u.username, p.pinned, p.locked
+	FROM Post p
+	JOIN User u ON p.user_id = u.user_id
+	WHERE ` + where + `
+	ORDER BY ` + order
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []models.Post
+
+	for rows.Next() {
+		var post models.Post
+		var username string
+		err := rows.Scan(
+			&post.Id,
+			&post.UserId,
+			&post.Title,
+			&post.Content,
+			&post.Category,
+			&post.CreationDate,
+			&username,
+			&post.Pinned,
+			&post.Locked,
+		)
+		if err != nil {
+			return nil, err
+		}
+		post.Username = username // Assign the username to the post
+		posts = append(posts, post)
+	}
+
+	if err := attachPostMentions(posts); err != nil {
+		return nil, err
+	}
+	if err := attachPostAttachments(posts); err != nil {
+		return nil, err
+	}
+	if err := attachPostPreviews(posts); err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+// GetPostByID retrieves a certain post by its ID
+func GetPostByID(db *sql.DB, id string) (*models.Post, error) {
+	query := `
+	SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username, p.pinned, p.locked
+	FROM Post p
+	JOIN User u ON p.user_id = u.user_id
+	WHERE p.post_id = ?
+	`
+
+	row := db.QueryRow(query, id)
+
+	var post models.Post
+	var username string
+	err := row.Scan(
+		&post.Id,
+		&post.Title,
+		&post.Content,
+		&post.UserId,
+		&post.Category,
+		&post.CreationDate,
+		&username,
+		&post.Pinned,
+		&post.Locked,
+	)
+	if err != nil {
+		return nil, err
+	}
+	post.Username = username
+	post.Mentions, err = postMentions(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.ContentHTML = RenderContent(post.Content, post.Mentions)
+	post.Attachments, err = postAttachments(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.Previews, err = postPreviews(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+// GetPostsByUser retrieves a page of the posts of a specific user, newest first
+func GetPostsByUser(db *sql.DB, userID string, limit, offset int) ([]models.Post, error) {
+	query := `
+	SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username, p.pinned, p.locked
+	FROM Post p
+	JOIN User u ON p.user_id = u.user_id
+	WHERE p.user_id = ?
+	ORDER BY p.creation_date DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		err := rows.Scan(
+			&post.Id,
+			&post.Title,
+			&post.Content,
+			&post.UserId,
+			&post.Category,
+			&post.CreationDate,
+			&post.Username,
+			&post.Pinned,
+			&post.Locked,
+		)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachPostMentions(posts); err != nil {
+		return nil, err
+	}
+	if err := attachPostAttachments(posts); err != nil {
+		return nil, err
+	}
+	return posts, attachPostPreviews(posts)
+}
+
+// GetCommentsByUser retrieves a page of the comments of a specific user with
+// the title of the commented post, newest first
+func GetCommentsByUser(db *sql.DB, userID string, limit, offset int) ([]models.Comment, error) {
+	query := `
+	SELECT c.comment_id, c.post_id, c.user_id, c.content, c.creation_date, u.username, p.title
+	FROM Comment c
+	JOIN User u ON c.user_id = u.user_id
+	JOIN Post p ON c.post_id = p.post_id
+	WHERE c.user_id = ?
+	ORDER BY c.creation_date DESC
+	LIMIT ? OFFSET ?
+	`
+
+	rows, err := db.Query(query, userID, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []models.Comment{}
+	for rows.Next() {
+		var comment models.Comment
+		err := rows.Scan(
+			&comment.Id,
+			&comment.PostId,
+			&comment.UserId,
+			&comment.Content,
+			&comment.CreationDate,
+			&comment.Username,
+			&comment.PostTitle,
+		)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachCommentMentions(comments); err != nil {
+		return nil, err
+	}
+	return comments, attachCommentAttachments(comments)
+}
+
+// CountUserActivity returns how many posts and comments a user wrote
+func CountUserActivity(userID string) (posts, comments int, err error) {
+	err = DB.QueryRow(`
+	SELECT
+		(SELECT COUNT(*) FROM Post WHERE user_id = ?),
+		(SELECT COUNT(*) FROM Comment WHERE user_id = ?)`,
+		userID, userID).Scan(&posts, &comments)
+	return posts, comments, err
+}
+
+// GetPostWithComments retrieves a post and all its comments
+// The comments of the authors viewerID blocked or muted are left out
+func GetPostWithComments(postID, viewerID string) (*models.PostWithComments, error) {
+	var post models.Post
+	var postUsername string
+
+	postQuery := `
+		SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username, p.pinned, p.locked
+		FROM Post p
+		JOIN User u ON p.user_id = u.user_id
+		WHERE p.post_id = ?
+	`
+
+	err := DB.QueryRow(postQuery, postID).Scan(
+		&post.Id,
+		&post.Title,
+		&post.Content,
+		&post.UserId,
+		&post.Category,
+		&post.CreationDate,
+		&postUsername,
+		&post.Pinned,
+		&post.Locked,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	post.Username = postUsername
+	post.Mentions, err = postMentions(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.ContentHTML = RenderContent(post.Content, post.Mentions)
+	post.Attachments, err = postAttachments(post.Id)
+	if err != nil {
+		return nil, err
+	}
+	post.Previews, err = postPreviews(post.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	commentsQuery := `
+		SELECT c.comment_id, c.content, c.user_id, c.creation_date, u.username
+		FROM Comment c
+		JOIN User u ON c.user_id = u.user_id
+		WHERE c.post_id = ? AND ` + fmt.Sprintf(hiddenAuthor, "c.user_id") + `
+		ORDER BY c.creation_date ASC
+	`
+
+	rows, err := DB.Query(commentsQuery, postID, viewerID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var comments []models.Comment
+	for rows.Next() {
+		var comment models.Comment
+		var commentUsername string
+		err := rows.Scan(
+			&comment.Id,
+			&comment.Content,
+			&comment.UserId,
+			&comment.CreationDate,
+			&commentUsername,
+		)
+		if err != nil {
+			continue
+		}
+		comment.PostId = postID
+		comment.Username = commentUsername // Assign the username to the comment
+		comments = append(comments, comment)
+	}
+	if err := attachCommentMentions(comments); err != nil {
+		return nil, err
+	}
+	if err := attachCommentAttachments(comments); err != nil {
+		return nil, err
+	}
+	result := &models.PostWithComments{
+		Post:     post,
+		Comments: comments,
+	}
+	return result, nil
+}
+
+// CreateComment adds a new comment to a post
+func CreateComment(comment models.Comment) (*models.Comment, error) {
+
+	comment.Id = shared.ParseUUID(shared.GenerateUUID())
+	comment.CreationDate = time.Now()
+
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO Comment (comment_id, post_id, user_id, content, creation_date) 
+		 VALUES (?, ?, ?, ?, ?)`,
+		comment.Id, comment.PostId, comment.UserId, comment.Content, comment.CreationDate,
+	)
+
+	if err != nil {
+		return nil, err
+	}
+
+	_, _ = result.RowsAffected()
+
+	if comment.Mentions, err = saveMentions(tx, "comment", comment.Id, comment.Content); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	comment.ContentHTML = RenderContent(comment.Content, comment.Mentions)
+
+	return &comment, nil
+}
@@ -0,0 +1,205 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// previewImageURL is where the copies of the preview images are downloaded, followed by their key
+const previewImageURL = "/api/v1/previews/"
+
+// PreviewImageURL returns the URL of the copy of a preview image, empty when there is none
+func PreviewImageURL(key string) string {
+	if key == "" {
+		return ""
+	}
+	return previewImageURL + key
+}
+
+// SaveContentLinks records the URLs linked in a post or a message, in their order
+func SaveContentLinks(sourceType, sourceID string, urls []string) error {
+	for i, url := range urls {
+		_, err := DB.Exec(
+			"INSERT OR IGNORE INTO content_link (source_type, source_id, url, position) VALUES (?, ?, ?, ?)",
+			sourceType, sourceID, url, i,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to save link: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetLinkPreview retrieves the cached preview of a URL, sql.ErrNoRows when it was never fetched
+func GetLinkPreview(url string) (*models.LinkPreview, error) {
+	var p models.LinkPreview
+	err := DB.QueryRow(`
+        SELECT url, failed, title, description, image_key, image_type, site_name, fetched_at
+        FROM link_preview WHERE url = ?`, url).
+		Scan(&p.URL, &p.Failed, &p.Title, &p.Description, &p.ImageKey, &p.ImageType, &p.SiteName, &p.FetchedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.ImageURL = PreviewImageURL(p.ImageKey)
+	return &p, nil
+}
+
+// SaveLinkPreview caches the preview of a URL, replacing the previous one
+// It returns the key of the replaced image when nothing else uses it, to remove from the blob store
+func SaveLinkPreview(p models.LinkPreview) ([]string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous []string
+	var key string
+	err = tx.QueryRow("SELECT image_key FROM link_preview WHERE url = ?", p.URL).Scan(&key)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if key != "" && key != p.ImageKey {
+		previous = append(previous, key)
+	}
+
+	_, err = tx.Exec(`
+        INSERT INTO link_preview (url, failed, title, description, image_key, image_type, site_name, fetched_at)
+        VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+        ON CONFLICT(url) DO UPDATE SET failed = excluded.failed, title = excluded.title,
+            description = excluded.description, image_key = excluded.image_key, image_type = excluded.image_type,
+            site_name = excluded.site_name, fetched_at = excluded.fetched_at`,
+		p.URL, p.Failed, p.Title, p.Description, p.ImageKey, p.ImageType, p.SiteName, time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to save link preview: %w", err)
+	}
+
+	unused, err := unusedBlobs(tx, previous)
+	if err != nil {
+		return nil, err
+	}
+	return unused, tx.Commit()
+}
+
+// GetPreviewImageType returns the MIME type of the copy of a preview image, sql.ErrNoRows when no preview has it
+func GetPreviewImageType(key string) (string, error) {
+	var imageType string
+	err := DB.QueryRow("SELECT image_type FROM link_preview WHERE image_key = ? LIMIT 1", key).Scan(&imageType)
+	return imageType, err
+}
+
+// GetPreviews returns the previews of the links of contents of a type, by content ID
+// The links whose page had no preview, or was not fetched yet, are left out
+func GetPreviews(sourceType string, sourceIDs []string) (map[string][]models.LinkPreview, error) {
+	previews := make(map[string][]models.LinkPreview)
+	if len(sourceIDs) == 0 {
+		return previews, nil
+	}
+
+	args := []interface{}{sourceType}
+	for _, id := range sourceIDs {
+		args = append(args, id)
+	}
+	rows, err := DB.Query(`
+        SELECT l.source_id, p.url, p.title, p.description, p.image_key, p.site_name
+        FROM content_link l
+        JOIN link_preview p ON p.url = l.url
+        WHERE p.failed = 0 AND l.source_type = ? AND l.source_id IN (?`+strings.Repeat(", ?", len(sourceIDs)-1)+`)
+        ORDER BY l.position`,
+		args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query link previews: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var sourceID string
+		var p models.LinkPreview
+		if err := rows.Scan(&sourceID, &p.URL, &p.Title, &p.Description, &p.ImageKey, &p.SiteName); err != nil {
+			return nil, fmt.Errorf("failed to scan link preview row: %w", err)
+		}
+		p.ImageURL = PreviewImageURL(p.ImageKey)
+		previews[sourceID] = append(previews[sourceID], p)
+	}
+	return previews, rows.Err()
+}
+
+// DeleteOrphanLinks forgets the links of deleted contents, and the previews
+// no content links to that were fetched before fetchedBefore
+// It returns the number of previews deleted, and the keys of their images nothing else uses
+func DeleteOrphanLinks(fetchedBefore time.Time) (int64, []string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM content_link WHERE
+        (source_type = 'post' AND source_id NOT IN (SELECT post_id FROM Post))
+        OR (source_type = 'message' AND CAST(source_id AS INTEGER) NOT IN (SELECT id FROM messages))`)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to delete links: %w", err)
+	}
+
+	const orphans = "fetched_at < ? AND url NOT IN (SELECT url FROM content_link)"
+	rows, err := tx.Query("SELECT image_key FROM link_preview WHERE image_key != '' AND "+orphans, fetchedBefore)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to query link previews: %w", err)
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, nil, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	result, err := tx.Exec("DELETE FROM link_preview WHERE "+orphans, fetchedBefore)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to delete link previews: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	unused, err := unusedBlobs(tx, keys)
+	if err != nil {
+		return 0, nil, err
+	}
+	return removed, unused, tx.Commit()
+}
+
+// attachPostPreviews fills the link previews of a list of posts
+func attachPostPreviews(posts []models.Post) error {
+	ids := make([]string, len(posts))
+	for i, post := range posts {
+		ids[i] = post.Id
+	}
+	previews, err := GetPreviews("post", ids)
+	if err != nil {
+		return err
+	}
+	for i := range posts {
+		posts[i].Previews = previews[posts[i].Id]
+	}
+	return nil
+}
+
+// postPreviews returns the link previews of a single post
+func postPreviews(postID string) ([]models.LinkPreview, error) {
+	previews, err := GetPreviews("post", []string{postID})
+	if err != nil {
+		return nil, err
+	}
+	return previews[postID], nil
+}
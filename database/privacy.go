@@ -0,0 +1,46 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+)
+
+// GetPrivacySettings retrieves the privacy settings of a user
+// Users who never changed them get the defaults: every optional profile field is hidden,
+// and the user is listed in the directory and can be messaged by anyone
+func GetPrivacySettings(userID string) (*models.PrivacySettings, error) {
+	settings := models.PrivacySettings{
+		UserId:                userID,
+		ShowInDirectory:       true,
+		AllowStrangerMessages: true,
+	}
+
+	err := DB.QueryRow(`
+		SELECT show_full_name, show_age, show_gender, show_in_directory, allow_stranger_messages
+		FROM user_privacy
+		WHERE user_id = ?`,
+		userID).Scan(&settings.ShowFullName, &settings.ShowAge, &settings.ShowGender,
+		&settings.ShowInDirectory, &settings.AllowStrangerMessages)
+
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// SetPrivacySettings creates or replaces the privacy settings of a user
+func SetPrivacySettings(settings models.PrivacySettings) error {
+	_, err := DB.Exec(
+		`INSERT INTO user_privacy (user_id, show_full_name, show_age, show_gender, show_in_directory, allow_stranger_messages)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			show_full_name = excluded.show_full_name,
+			show_age = excluded.show_age,
+			show_gender = excluded.show_gender,
+			show_in_directory = excluded.show_in_directory,
+			allow_stranger_messages = excluded.allow_stranger_messages`,
+		settings.UserId, settings.ShowFullName, settings.ShowAge, settings.ShowGender,
+		settings.ShowInDirectory, settings.AllowStrangerMessages,
+	)
+	return err
+}
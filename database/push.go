@@ -0,0 +1,83 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrPushEndpointTaken is returned when a browser subscription is registered by another user
+var ErrPushEndpointTaken = errors.New("push endpoint registered by another user")
+
+// SavePushSubscription registers a browser to receive the pushes of a user, keeping at most
+// maxPerUser browsers for each user: the oldest subscriptions are dropped to make room
+// A browser only has one subscription, registering it again updates its keys, but only for
+// the user who registered it, ErrPushEndpointTaken is returned to the others
+func SavePushSubscription(sub models.PushSubscription, maxPerUser int) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO push_subscription (endpoint, user_id, p256dh, auth, user_agent, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(endpoint) DO UPDATE SET p256dh = excluded.p256dh, auth = excluded.auth,
+		user_agent = excluded.user_agent, created_at = excluded.created_at
+		WHERE push_subscription.user_id = excluded.user_id`,
+		sub.Endpoint, sub.UserId, sub.P256dh, sub.Auth, sub.UserAgent, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save push subscription: %w", err)
+	}
+	if saved, _ := result.RowsAffected(); saved == 0 {
+		return ErrPushEndpointTaken
+	}
+
+	_, err = tx.Exec(`
+		DELETE FROM push_subscription
+		WHERE user_id = ? AND endpoint NOT IN (
+			SELECT endpoint FROM push_subscription WHERE user_id = ? ORDER BY created_at DESC LIMIT ?
+		)`, sub.UserId, sub.UserId, maxPerUser)
+	if err != nil {
+		return fmt.Errorf("failed to drop old push subscriptions: %w", err)
+	}
+	return tx.Commit()
+}
+
+// GetPushSubscriptions lists the browsers receiving the pushes of a user, most recent first
+func GetPushSubscriptions(userID string) ([]models.PushSubscription, error) {
+	rows, err := DB.Query(`
+        SELECT endpoint, user_id, p256dh, auth, user_agent, created_at
+        FROM push_subscription
+        WHERE user_id = ?
+        ORDER BY created_at DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query push subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []models.PushSubscription{}
+	for rows.Next() {
+		var s models.PushSubscription
+		if err := rows.Scan(&s.Endpoint, &s.UserId, &s.P256dh, &s.Auth, &s.UserAgent, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// DeletePushSubscription stops the pushes of a user to a browser
+// It returns sql.ErrNoRows when the user has no such subscription
+func DeletePushSubscription(userID, endpoint string) error {
+	return execOne(DB, "DELETE FROM push_subscription WHERE user_id = ? AND endpoint = ?", userID, endpoint)
+}
+
+// DeleteExpiredPushSubscription forgets a subscription the push service no longer knows
+func DeleteExpiredPushSubscription(endpoint string) error {
+	_, err := DB.Exec("DELETE FROM push_subscription WHERE endpoint = ?", endpoint)
+	return err
+}
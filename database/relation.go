@@ -0,0 +1,112 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"fmt"
+	"time"
+)
+
+// Kinds of relations a user can have with another one
+const (
+	RelationBlock = "block" // no private messages or typing notifications either way, posts and comments hidden
+	RelationMute  = "mute"  // posts and comments hidden
+)
+
+// hiddenAuthor is the condition leaving out the content of the authors viewer blocked or muted
+// It takes the viewer's ID as parameter, and the author column as argument for fmt.Sprintf
+const hiddenAuthor = `%s NOT IN (SELECT target_id FROM user_relation WHERE user_id = ?)`
+
+// AddUserRelation blocks or mutes targetID for userID, doing it twice has no effect
+func AddUserRelation(userID, targetID, kind string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO user_relation (user_id, target_id, kind, created_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, targetID, kind, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save relation: %w", err)
+	}
+	return nil
+}
+
+// RemoveUserRelation unblocks or unmutes targetID for userID
+// It reports whether the relation existed
+func RemoveUserRelation(userID, targetID, kind string) (bool, error) {
+	result, err := DB.Exec(
+		"DELETE FROM user_relation WHERE user_id = ? AND target_id = ? AND kind = ?",
+		userID, targetID, kind,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete relation: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// GetUserRelations lists the users that userID blocked or muted, most recent first
+func GetUserRelations(userID, kind string) ([]models.PublicUser, error) {
+	rows, err := DB.Query(`
+        SELECT u.user_id, u.username, u.creation_date, `+avatarVersion+`
+        FROM user_relation r
+        JOIN User u ON r.target_id = u.user_id
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE r.user_id = ? AND r.kind = ?
+        ORDER BY r.created_at DESC`,
+		userID, kind)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query relations: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.PublicUser{}
+	for rows.Next() {
+		var user models.PublicUser
+		var avatar string
+		if err := rows.Scan(&user.UserId, &user.Username, &user.JoinedAt, &avatar); err != nil {
+			return nil, fmt.Errorf("failed to scan relation row: %w", err)
+		}
+		user.AvatarURL = AvatarURL(user.UserId, avatar)
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+// HasUserRelation reports whether userID blocked or muted targetID
+func HasUserRelation(userID, targetID, kind string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM user_relation WHERE user_id = ? AND target_id = ? AND kind = ?)",
+		userID, targetID, kind).Scan(&exists)
+	return exists, err
+}
+
+// IsBlockedBetween reports whether either user blocked the other
+func IsBlockedBetween(userID, otherUserID string) (bool, error) {
+	var blocked bool
+	err := DB.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM user_relation
+            WHERE kind = ? AND ((user_id = ? AND target_id = ?) OR (user_id = ? AND target_id = ?))
+        )`,
+		RelationBlock, userID, otherUserID, otherUserID, userID).Scan(&blocked)
+	return blocked, err
+}
+
+// GetUsersHiding returns the set of users who blocked or muted authorID
+func GetUsersHiding(authorID string) (map[string]bool, error) {
+	rows, err := DB.Query("SELECT DISTINCT user_id FROM user_relation WHERE target_id = ?", authorID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query relations: %w", err)
+	}
+	defer rows.Close()
+
+	users := make(map[string]bool)
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan relation row: %w", err)
+		}
+		users[userID] = true
+	}
+	return users, rows.Err()
+}
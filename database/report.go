@@ -0,0 +1,132 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDuplicateReport is returned when a user reports again content they already reported
+var ErrDuplicateReport = errors.New("content already reported")
+
+// GetReportTarget returns the author and text of reportable content
+// The receiver is only set for private messages
+func GetReportTarget(targetType, targetID string) (authorID, receiverID, content string, err error) {
+	switch targetType {
+	case "post":
+		var title, body string
+		err = DB.QueryRow("SELECT user_id, title, content FROM Post WHERE post_id = ?", targetID).
+			Scan(&authorID, &title, &body)
+		content = title + "\n\n" + body
+	case "comment":
+		err = DB.QueryRow("SELECT user_id, content FROM Comment WHERE comment_id = ?", targetID).
+			Scan(&authorID, &content)
+	case "message":
+		err = DB.QueryRow("SELECT sender_id, receiver_id, content FROM messages WHERE id = ?", targetID).
+			Scan(&authorID, &receiverID, &content)
+	default:
+		err = sql.ErrNoRows
+	}
+	return authorID, receiverID, content, err
+}
+
+// CreateReport saves a new open report
+// It returns ErrDuplicateReport if the reporter already has an open report on the same content
+func CreateReport(report models.Report) (*models.Report, error) {
+	var exists bool
+	err := DB.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM report
+            WHERE reporter_id = ? AND target_type = ? AND target_id = ? AND status = ?
+        )`,
+		report.ReporterId, report.TargetType, report.TargetId, models.ReportOpen).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrDuplicateReport
+	}
+
+	report.Id = shared.ParseUUID(shared.GenerateUUID())
+	report.Status = models.ReportOpen
+	report.CreatedAt = time.Now()
+
+	_, err = DB.Exec(
+		`INSERT INTO report (report_id, reporter_id, target_type, target_id, target_author_id, content, reason, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		report.Id, report.ReporterId, report.TargetType, report.TargetId, report.TargetAuthorId,
+		report.Content, report.Reason, report.Status, report.CreatedAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save report: %w", err)
+	}
+	return &report, nil
+}
+
+// reportColumns are the columns scanned by scanReport
+const reportColumns = `
+    r.report_id, r.reporter_id, reporter.username, r.target_type, r.target_id,
+    r.target_author_id, author.username, r.content, r.reason, r.status, r.created_at,
+    COALESCE(r.resolved_by, ''), r.resolved_at, r.resolution_note
+    FROM report r
+    JOIN User reporter ON r.reporter_id = reporter.user_id
+    JOIN User author ON r.target_author_id = author.user_id`
+
+// scanReport reads a row selected with reportColumns
+func scanReport(row interface{ Scan(...interface{}) error }) (*models.Report, error) {
+	var report models.Report
+	var resolvedAt sql.NullTime
+	err := row.Scan(&report.Id, &report.ReporterId, &report.Reporter, &report.TargetType, &report.TargetId,
+		&report.TargetAuthorId, &report.TargetAuthor, &report.Content, &report.Reason, &report.Status,
+		&report.CreatedAt, &report.ResolvedBy, &resolvedAt, &report.ResolutionNote)
+	if err != nil {
+		return nil, err
+	}
+	if resolvedAt.Valid {
+		report.ResolvedAt = &resolvedAt.Time
+	}
+	return &report, nil
+}
+
+// GetReport retrieves a report by its ID
+func GetReport(reportID string) (*models.Report, error) {
+	return scanReport(DB.QueryRow("SELECT "+reportColumns+" WHERE r.report_id = ?", reportID))
+}
+
+// GetReports retrieves a page of the reports, oldest first so that the queue is handled in order
+// Empty status or targetType filters match every report
+func GetReports(status, targetType string, limit, offset int) ([]models.Report, error) {
+	rows, err := DB.Query(`
+        SELECT `+reportColumns+`
+        WHERE (? = '' OR r.status = ?) AND (? = '' OR r.target_type = ?)
+        ORDER BY r.created_at ASC
+        LIMIT ? OFFSET ?`,
+		status, status, targetType, targetType, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query reports: %w", err)
+	}
+	defer rows.Close()
+
+	reports := []models.Report{}
+	for rows.Next() {
+		report, err := scanReport(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan report row: %w", err)
+		}
+		reports = append(reports, *report)
+	}
+	return reports, rows.Err()
+}
+
+// ResolveReport closes an open report with the given status
+// It returns sql.ErrNoRows when the report does not exist or was already resolved
+func ResolveReport(tx *sql.Tx, reportID, moderatorID, status, note string) error {
+	return execOne(tx,
+		`UPDATE report SET status = ?, resolved_by = ?, resolved_at = ?, resolution_note = ?
+		WHERE report_id = ? AND status = ?`,
+		status, moderatorID, time.Now(), note, reportID, models.ReportOpen,
+	)
+}
@@ -1,33 +1,190 @@
-package database
-
-import (
-	"time"
-)
-
-// saves a session in the database
-func SaveSession(sessionID, userID string, duration time.Duration) error {
-	_, err := DB.Exec(
-		"INSERT INTO session(session_id, user_id, created_at, expires_at) VALUES(?, ?, ?, ?)",
-		sessionID, userID, time.Now(), time.Now().Add(duration))
-	return err
-}
-
-// retrieves a session from the database
-func GetUserIDFromSession(sessionID string) (string, error) {
-	var userID string
-	err := DB.QueryRow("SELECT user_id FROM session WHERE session_id = ? AND expires_at > ?",
-		sessionID, time.Now()).Scan(&userID)
-	return userID, err
-}
-
-// deletes a session from the database
-func DeleteSession(sessionID string) error {
-	_, err := DB.Exec("DELETE FROM session WHERE session_id = ?", sessionID)
-	return err
-}
-
-// UpdateSessionStatus updates the status of a session
-func UpdateSessionStatus(sessionID, status string) error {
-	_, err := DB.Exec("UPDATE session SET status = ? WHERE session_id = ?", status, sessionID)
-	return err
-}
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+	"database/sql"
+	"time"
+)
+
+// Minimum delay between two refreshes of the same session, to avoid a write on every request
+const sessionRefreshInterval = time.Minute
+
+// saves a session in the database
+// idleTimeout is how long the session stays valid without any activity
+func SaveSession(session models.Session, idleTimeout time.Duration) error {
+	now := time.Now()
+	_, err := DB.Exec(
+		`INSERT INTO session(session_id, public_id, csrf_token, user_id, created_at, expires_at, last_activity, user_agent, ip_address, remember_me, idle_timeout)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		session.Id, shared.ParseUUID(shared.GenerateUUID()), session.CSRFToken, session.UserId, now, now.Add(idleTimeout), now,
+		session.UserAgent, session.IPAddress, session.RememberMe, int(idleTimeout.Seconds()))
+	if err != nil {
+		return err
+	}
+	return UpdateLastSeen(session.UserId)
+}
+
+// retrieves a session from the database
+// Every successful lookup counts as activity and slides the session expiry
+func GetUserIDFromSession(sessionID string) (string, error) {
+	var userID string
+	var idleTimeout int
+	var lastActivity sql.NullTime
+	err := DB.QueryRow("SELECT user_id, idle_timeout, last_activity FROM session WHERE session_id = ? AND expires_at > ?",
+		sessionID, time.Now()).Scan(&userID, &idleTimeout, &lastActivity)
+	if err != nil {
+		return userID, err
+	}
+
+	if !lastActivity.Valid || time.Since(lastActivity.Time) > sessionRefreshInterval {
+		err = touchSession(sessionID, time.Duration(idleTimeout)*time.Second)
+	}
+	return userID, err
+}
+
+// RefreshSession slides the expiry of a valid session right away and returns it
+func RefreshSession(sessionID string) (*models.Session, error) {
+	var idleTimeout int
+	err := DB.QueryRow("SELECT idle_timeout FROM session WHERE session_id = ? AND expires_at > ?",
+		sessionID, time.Now()).Scan(&idleTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := touchSession(sessionID, time.Duration(idleTimeout)*time.Second); err != nil {
+		return nil, err
+	}
+	return GetSession(sessionID)
+}
+
+// touchSession records activity on a session and pushes back its expiry
+// The activity also counts as the last time the user was seen
+func touchSession(sessionID string, idleTimeout time.Duration) error {
+	now := time.Now()
+	_, err := DB.Exec("UPDATE session SET last_activity = ?, expires_at = ? WHERE session_id = ?",
+		now, now.Add(idleTimeout), sessionID)
+	if err != nil {
+		return err
+	}
+
+	_, err = DB.Exec("UPDATE User SET last_seen = ? WHERE user_id = (SELECT user_id FROM session WHERE session_id = ?)",
+		now, sessionID)
+	return err
+}
+
+// GetSession retrieves a valid session by its ID
+func GetSession(sessionID string) (*models.Session, error) {
+	row := DB.QueryRow(`
+		SELECT session_id, public_id, csrf_token, user_id, created_at, expires_at, last_activity, user_agent, ip_address, remember_me
+		FROM session
+		WHERE session_id = ? AND expires_at > ?`,
+		sessionID, time.Now())
+	return scanSession(row)
+}
+
+// GetUserSessions retrieves all the valid sessions of a user, most recently active first
+func GetUserSessions(userID string) ([]models.Session, error) {
+	rows, err := DB.Query(`
+		SELECT session_id, public_id, csrf_token, user_id, created_at, expires_at, last_activity, user_agent, ip_address, remember_me
+		FROM session
+		WHERE user_id = ? AND expires_at > ?
+		ORDER BY last_activity DESC`,
+		userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, rows.Err()
+}
+
+// scanSession reads a session row selected by GetSession or GetUserSessions
+func scanSession(row interface{ Scan(...interface{}) error }) (*models.Session, error) {
+	var session models.Session
+	var lastActivity sql.NullTime
+	err := row.Scan(
+		&session.Id,
+		&session.PublicId,
+		&session.CSRFToken,
+		&session.UserId,
+		&session.CreatedAt,
+		&session.ExpiresAt,
+		&lastActivity,
+		&session.UserAgent,
+		&session.IPAddress,
+		&session.RememberMe,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	session.LastActivity = session.CreatedAt
+	if lastActivity.Valid {
+		session.LastActivity = lastActivity.Time
+	}
+	return &session, nil
+}
+
+// deletes a session from the database
+func DeleteSession(sessionID string) error {
+	_, err := DB.Exec("DELETE FROM session WHERE session_id = ?", sessionID)
+	return err
+}
+
+// DeleteUserSessions deletes all the sessions of a user and returns their IDs
+func DeleteUserSessions(userID string) ([]string, error) {
+	return deleteUserSessions(userID, "")
+}
+
+// DeleteOtherUserSessions deletes the sessions of a user except keepSessionID and returns their IDs
+func DeleteOtherUserSessions(userID, keepSessionID string) ([]string, error) {
+	return deleteUserSessions(userID, keepSessionID)
+}
+
+// deleteUserSessions deletes the sessions of a user other than keepSessionID
+func deleteUserSessions(userID, keepSessionID string) ([]string, error) {
+	rows, err := DB.Query("SELECT session_id FROM session WHERE user_id = ? AND session_id != ?", userID, keepSessionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var sessionIDs []string
+	for rows.Next() {
+		var sessionID string
+		if err := rows.Scan(&sessionID); err != nil {
+			return nil, err
+		}
+		sessionIDs = append(sessionIDs, sessionID)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	_, err = DB.Exec("DELETE FROM session WHERE user_id = ? AND session_id != ?", userID, keepSessionID)
+	return sessionIDs, err
+}
+
+// DeleteExpiredSessions purges the expired sessions and returns how many were removed
+func DeleteExpiredSessions() (int64, error) {
+	result, err := DB.Exec("DELETE FROM session WHERE expires_at <= ?", time.Now())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// UpdateSessionStatus updates the status of a session
+func UpdateSessionStatus(sessionID, status string) error {
+	_, err := DB.Exec("UPDATE session SET status = ? WHERE session_id = ?", status, sessionID)
+	return err
+}
@@ -0,0 +1,70 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"time"
+)
+
+// SetUserStatus creates or replaces the custom status of a user
+func SetUserStatus(status models.UserStatus) error {
+	status.UpdatedAt = time.Now()
+
+	_, err := DB.Exec(
+		`INSERT INTO user_status (user_id, status_text, status_emoji, availability, expires_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(user_id) DO UPDATE SET
+			status_text = excluded.status_text,
+			status_emoji = excluded.status_emoji,
+			availability = excluded.availability,
+			expires_at = excluded.expires_at,
+			updated_at = excluded.updated_at`,
+		status.UserId, status.Text, status.Emoji, status.Availability, status.ExpiresAt, status.UpdatedAt,
+	)
+	return err
+}
+
+// GetUserStatus retrieves the custom status of a user
+// A missing or expired status is returned as the default "available" status
+func GetUserStatus(userID string) (*models.UserStatus, error) {
+	status := models.UserStatus{UserId: userID}
+	var expiresAt sql.NullTime
+
+	err := DB.QueryRow(`
+		SELECT status_text, status_emoji, availability, expires_at, updated_at
+		FROM user_status
+		WHERE user_id = ?`,
+		userID).Scan(&status.Text, &status.Emoji, &status.Availability, &expiresAt, &status.UpdatedAt)
+
+	if err == sql.ErrNoRows {
+		return defaultUserStatus(userID), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if expiresAt.Valid {
+		if expiresAt.Time.Before(time.Now()) {
+			return defaultUserStatus(userID), nil
+		}
+		status.ExpiresAt = &expiresAt.Time
+	}
+
+	return &status, nil
+}
+
+// IsUserDoNotDisturb reports whether a user currently has do-not-disturb enabled
+func IsUserDoNotDisturb(userID string) bool {
+	status, err := GetUserStatus(userID)
+	if err != nil {
+		return false
+	}
+	return status.Availability == models.AvailabilityDoNotDisturb
+}
+
+func defaultUserStatus(userID string) *models.UserStatus {
+	return &models.UserStatus{
+		UserId:       userID,
+		Availability: models.AvailabilityAvailable,
+	}
+}
@@ -0,0 +1,161 @@
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"fmt"
+	"time"
+)
+
+// What a user can subscribe to
+const (
+	SubscriptionPost     = "post"     // new comments on a post
+	SubscriptionCategory = "category" // new posts in a category
+	SubscriptionUser     = "user"     // new posts of an author
+)
+
+// deleteOrphanSubscriptions removes the subscriptions to posts that were deleted
+const deleteOrphanSubscriptions = `DELETE FROM subscription
+    WHERE target_type = 'post' AND target_id NOT IN (SELECT post_id FROM Post)`
+
+// Subscribe subscribes userID to a post, a category or a user, doing it twice has no effect
+func Subscribe(userID, targetType, targetID string) error {
+	_, err := DB.Exec(
+		`INSERT OR IGNORE INTO subscription (user_id, target_type, target_id, created_at)
+		VALUES (?, ?, ?, ?)`,
+		userID, targetType, targetID, time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save subscription: %w", err)
+	}
+	return nil
+}
+
+// Unsubscribe removes a subscription of userID
+// It reports whether the subscription existed
+func Unsubscribe(userID, targetType, targetID string) (bool, error) {
+	result, err := DB.Exec(
+		"DELETE FROM subscription WHERE user_id = ? AND target_type = ? AND target_id = ?",
+		userID, targetType, targetID,
+	)
+	if err != nil {
+		return false, fmt.Errorf("failed to remove subscription: %w", err)
+	}
+	removed, err := result.RowsAffected()
+	return removed > 0, err
+}
+
+// IsSubscribed reports whether userID is subscribed to a post, a category or a user
+func IsSubscribed(userID, targetType, targetID string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM subscription WHERE user_id = ? AND target_type = ? AND target_id = ?)",
+		userID, targetType, targetID).Scan(&exists)
+	return exists, err
+}
+
+// GetSubscriptions lists the subscriptions of a user, most recent first
+// Each one is labelled with the title of the post, the name of the category or the username
+func GetSubscriptions(userID string) ([]models.Subscription, error) {
+	rows, err := DB.Query(`
+        SELECT s.target_type, s.target_id, COALESCE(p.title, u.username, s.target_id), s.created_at
+        FROM subscription s
+        LEFT JOIN Post p ON s.target_type = 'post' AND s.target_id = p.post_id
+        LEFT JOIN User u ON s.target_type = 'user' AND s.target_id = u.user_id
+        WHERE s.user_id = ?
+        ORDER BY s.created_at DESC`,
+		userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscriptions: %w", err)
+	}
+	defer rows.Close()
+
+	subscriptions := []models.Subscription{}
+	for rows.Next() {
+		var s models.Subscription
+		if err := rows.Scan(&s.TargetType, &s.TargetId, &s.Label, &s.CreatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan subscription row: %w", err)
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// GetPostSubscribers returns the users subscribed to a post
+func GetPostSubscribers(postID string) ([]string, error) {
+	return querySubscribers(
+		"SELECT user_id FROM subscription WHERE target_type = ? AND target_id = ?",
+		SubscriptionPost, postID)
+}
+
+// GetNewPostSubscribers returns the users subscribed to the category or the author of a new post
+func GetNewPostSubscribers(post models.Post) ([]string, error) {
+	return querySubscribers(`
+        SELECT DISTINCT user_id FROM subscription
+        WHERE (target_type = ? AND target_id = ?) OR (target_type = ? AND target_id = ?)`,
+		SubscriptionCategory, post.Category, SubscriptionUser, post.UserId)
+}
+
+// querySubscribers runs a query selecting user IDs
+func querySubscribers(query string, args ...interface{}) ([]string, error) {
+	rows, err := DB.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query subscribers: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("failed to scan subscriber row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	return userIDs, rows.Err()
+}
+
+// GetFollowingFeed retrieves a page of the posts a user follows, newest first: the posts they
+// subscribed to and the posts of the categories and authors they follow, except their own
+// The posts of the authors they blocked or muted are left out
+func GetFollowingFeed(userID string, limit, offset int) ([]models.Post, error) {
+	rows, err := DB.Query(`
+        SELECT p.post_id, p.title, p.content, p.user_id, p.category, p.creation_date, u.username, p.pinned, p.locked
+        FROM Post p
+        JOIN User u ON p.user_id = u.user_id
+        WHERE p.user_id != ?1 AND `+fmt.Sprintf(hiddenAuthor, "p.user_id")+` AND EXISTS (
+            SELECT 1 FROM subscription s
+            WHERE s.user_id = ?1 AND (
+                (s.target_type = 'post' AND s.target_id = p.post_id)
+                OR (s.target_type = 'category' AND s.target_id = p.category)
+                OR (s.target_type = 'user' AND s.target_id = p.user_id)
+            )
+        )
+        ORDER BY p.creation_date DESC
+        LIMIT ?2 OFFSET ?3`,
+		userID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query feed: %w", err)
+	}
+	defer rows.Close()
+
+	posts := []models.Post{}
+	for rows.Next() {
+		var post models.Post
+		err := rows.Scan(&post.Id, &post.Title, &post.Content, &post.UserId, &post.Category,
+			&post.CreationDate, &post.Username, &post.Pinned, &post.Locked)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan feed row: %w", err)
+		}
+		posts = append(posts, post)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := attachPostMentions(posts); err != nil {
+		return nil, err
+	}
+	if err := attachPostAttachments(posts); err != nil {
+		return nil, err
+	}
+	return posts, attachPostPreviews(posts)
+}
@@ -0,0 +1,90 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Purposes of the tokens sent by email
+const (
+	TokenEmailVerification = "email_verification"
+	TokenPasswordReset     = "password_reset"
+)
+
+// ErrInvalidToken is returned for unknown, expired or already used tokens
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// SaveUserToken stores the hash of a token sent to a user
+// Issuing a token invalidates the unused tokens of the same purpose
+func SaveUserToken(tokenHash, userID, purpose string, expiresAt time.Time) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
+		"DELETE FROM user_token WHERE user_id = ? AND purpose = ? AND used_at IS NULL",
+		userID, purpose,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to invalidate previous tokens: %w", err)
+	}
+
+	_, err = tx.Exec(
+		`INSERT INTO user_token (token_hash, user_id, purpose, created_at, expires_at)
+		VALUES (?, ?, ?, ?, ?)`,
+		tokenHash, userID, purpose, time.Now(), expiresAt,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to save token: %w", err)
+	}
+	return tx.Commit()
+}
+
+// ConsumeUserToken marks a token as used and returns the user it was issued to
+// A token can only be consumed once
+func ConsumeUserToken(tokenHash, purpose string) (string, error) {
+	tx, err := DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	var userID string
+	err = tx.QueryRow(
+		`SELECT user_id FROM user_token
+		WHERE token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?`,
+		tokenHash, purpose, now,
+	).Scan(&userID)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	result, err := tx.Exec(
+		"UPDATE user_token SET used_at = ? WHERE token_hash = ? AND used_at IS NULL",
+		now, tokenHash,
+	)
+	if err != nil {
+		return "", err
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		return "", ErrInvalidToken
+	}
+
+	return userID, tx.Commit()
+}
+
+// DeleteExpiredUserTokens removes the tokens that can no longer be used
+func DeleteExpiredUserTokens() (int64, error) {
+	result, err := DB.Exec(
+		"DELETE FROM user_token WHERE expires_at <= ? OR used_at IS NOT NULL",
+		time.Now(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
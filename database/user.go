@@ -1,209 +1,364 @@
-package database
-
-import (
-	"Real-Time-Forum/models"
-	"fmt"
-)
-
-// GetUserByID retrieves a user by their ID
-func GetUserByID(userID string) (*models.User, error) {
-	var user models.User
-
-	// Query the database for the user with the given ID
-	err := DB.QueryRow(`
-        SELECT user_id, username, email, first_name, last_name, age, gender, creation_date 
-        FROM User 
-        WHERE user_id = ?`,
-		userID).Scan(
-		&user.Id,
-		&user.Username,
-		&user.Email,
-		&user.FirstName,
-		&user.LastName,
-		&user.Age,
-		&user.Gender,
-		&user.CreationDate,
-	)
-
-	if err != nil {
-		fmt.Println("Error getting user:", err)
-		return nil, err
-	}
-
-	// Don't return the password (security)
-	user.Password = ""
-
-	return &user, nil
-}
-
-// GetAllUsers retrieves all registered users from the database
-func GetAllUsers() ([]models.User, error) {
-	// Create a slice to hold the users
-	var users []models.User
-
-	// Query the database for all users
-	rows, err := DB.Query(`
-        SELECT user_id, username, email, first_name, last_name, age, gender, creation_date 
-        FROM user 
-        ORDER BY username ASC`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query users: %w", err)
-	}
-	defer rows.Close()
-
-	// Iterate through the result set
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.Id,
-			&user.Username,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Age,
-			&user.Gender,
-			&user.CreationDate,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user row: %w", err)
-		}
-		users = append(users, user)
-	}
-
-	// Check for errors from iterating over rows
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after iterating rows: %w", err)
-	}
-
-	return users, nil
-}
-
-// Retrieves all users who are currently online
-func GetOnlineUsers() ([]models.User, error) {
-	var onlineUsers []models.User
-
-	// Requête pour récupérer les utilisateurs avec des sessions actives
-	rows, err := DB.Query(`
-    SELECT DISTINCT u.user_id, u.username, u.email, u.first_name, u.last_name, u.age, u.gender, u.creation_date
-    FROM user u
-    INNER JOIN session s ON u.user_id = s.user_id
-    WHERE s.expires_at > CURRENT_TIMESTAMP
-    ORDER BY u.username ASC`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query online users: %w", err)
-	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var user models.User
-		err := rows.Scan(
-			&user.Id,
-			&user.Username,
-			&user.Email,
-			&user.FirstName,
-			&user.LastName,
-			&user.Age,
-			&user.Gender,
-			&user.CreationDate,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan user row: %w", err)
-		}
-		onlineUsers = append(onlineUsers, user)
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after iterating rows: %w", err)
-	}
-
-	return onlineUsers, nil
-}
-
-// retrieves all users ordered by the last message sent or received (sort like discord)
-func GetUsersOrderedByLastMessage(currentUserID string) ([]map[string]interface{}, error) {
-	// retrieves a list of users + last message infos
-	// LEFT JOIN to include users even if no messages have been exchanged
-	// Results are grouped by user ID and ordered by the most recent message timestamp (descending), then by username (ascending)
-	query := `
-        SELECT 
-            u.user_id,
-            u.username,
-            u.email,
-            u.first_name,
-            u.last_name,
-            u.age,
-            u.gender,
-            u.creation_date,
-            COALESCE(m.content, '') AS last_message_content,
-            COALESCE(m.sender_id, '') AS last_message_sender,
-            COALESCE(strftime('%Y-%m-%d %H:%M:%S', m.sent_at), '') AS last_message_time
-        FROM user u
-        LEFT JOIN (
-            SELECT 
-                CASE 
-                    WHEN sender_id = ? THEN receiver_id
-                    ELSE sender_id
-                END AS other_user_id,
-                content,
-                sender_id,
-                sent_at
-            FROM messages
-            WHERE sender_id = ? OR receiver_id = ?
-            ORDER BY sent_at DESC
-        ) m ON u.user_id = m.other_user_id
-        WHERE u.user_id != ?
-        GROUP BY u.user_id
-        ORDER BY MAX(m.sent_at) DESC, u.username ASC
-    `
-
-	rows, err := DB.Query(query, currentUserID, currentUserID, currentUserID, currentUserID)
-	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
-	}
-	defer rows.Close()
-
-	var users []map[string]interface{}
-
-	for rows.Next() {
-		var userID, username, email, firstName, lastName, gender, creationDate string
-		var age int
-		var lastMessageContent, lastMessageSender, lastMessageTime string
-
-		err := rows.Scan(
-			&userID,
-			&username,
-			&email,
-			&firstName,
-			&lastName,
-			&age,
-			&gender,
-			&creationDate,
-			&lastMessageContent,
-			&lastMessageSender,
-			&lastMessageTime,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("Scan failed: %w", err)
-		}
-
-		users = append(users, map[string]interface{}{
-			"user_id":             userID,
-			"username":            username,
-			"email":               email,
-			"first_name":          firstName,
-			"last_name":           lastName,
-			"age":                 age,
-			"gender":              gender,
-			"creation_date":       creationDate,
-			"last_message":        lastMessageContent,
-			"last_message_sender": lastMessageSender,
-			"last_message_time":   lastMessageTime,
-		})
-	}
-
-	if err = rows.Err(); err != nil {
-		return nil, fmt.Errorf("error after iterating rows: %w", err)
-	}
-
-	return users, nil
-}
+package database
+
+import (
+	"Real-Time-Forum/models"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// GetUserByID retrieves a user by their ID
+func GetUserByID(userID string) (*models.User, error) {
+	var user models.User
+	var avatar string
+
+	// Query the database for the user with the given ID
+	err := DB.QueryRow(`
+        SELECT u.user_id, u.username, u.email, u.first_name, u.last_name, u.age, u.gender, u.creation_date,
+            u.email_verified, u.role, `+avatarVersion+`
+        FROM User u
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE u.user_id = ?`,
+		userID).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Age,
+		&user.Gender,
+		&user.CreationDate,
+		&user.EmailVerified,
+		&user.Role,
+		&avatar,
+	)
+
+	if err != nil {
+		fmt.Println("Error getting user:", err)
+		return nil, err
+	}
+	user.AvatarURL = AvatarURL(user.Id, avatar)
+
+	// Don't return the password (security)
+	user.Password = ""
+
+	return &user, nil
+}
+
+// visibleToViewer is the condition selecting the users u that viewer can see listed
+// Users who opted out of the directory are still listed to the people they talk with
+// It takes the viewer's ID three times as parameters
+const visibleToViewer = `u.deleted_at IS NULL AND (
+        COALESCE(up.show_in_directory, 1) = 1
+        OR u.user_id = ?
+        OR EXISTS (
+            SELECT 1 FROM messages dm
+            WHERE (dm.sender_id = u.user_id AND dm.receiver_id = ?)
+               OR (dm.sender_id = ? AND dm.receiver_id = u.user_id)
+        )
+    )`
+
+// SearchUsers retrieves a page of the directory as seen by viewerID,
+// optionally limited to the usernames starting with prefix
+func SearchUsers(viewerID, prefix string, limit, offset int) ([]models.PublicUser, error) {
+	// Escape the LIKE wildcards so that the prefix is matched literally
+	pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(prefix) + "%"
+
+	rows, err := DB.Query(`
+        SELECT u.user_id, u.username, u.creation_date, `+avatarVersion+`
+        FROM User u
+        LEFT JOIN user_privacy up ON u.user_id = up.user_id
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE u.username LIKE ? ESCAPE '\' AND `+visibleToViewer+`
+        ORDER BY u.username COLLATE NOCASE ASC
+        LIMIT ? OFFSET ?`,
+		pattern, viewerID, viewerID, viewerID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.PublicUser{}
+	for rows.Next() {
+		var user models.PublicUser
+		var avatar string
+		if err := rows.Scan(&user.UserId, &user.Username, &user.JoinedAt, &avatar); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		user.AvatarURL = AvatarURL(user.UserId, avatar)
+		users = append(users, user)
+	}
+
+	// Check for errors from iterating over rows
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// Retrieves the users currently online that viewerID can see
+func GetOnlineUsers(viewerID string) ([]models.PublicUser, error) {
+	onlineUsers := []models.PublicUser{}
+
+	// Requête pour récupérer les utilisateurs avec des sessions actives
+	// Invisible users are left out until their status expires
+	rows, err := DB.Query(`
+    SELECT DISTINCT u.user_id, u.username, u.creation_date, `+avatarVersion+`
+    FROM user u
+    INNER JOIN session s ON u.user_id = s.user_id
+    LEFT JOIN user_status us ON u.user_id = us.user_id
+    LEFT JOIN user_privacy up ON u.user_id = up.user_id
+    LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+    WHERE s.expires_at > ?
+      AND NOT (COALESCE(us.availability, '') = 'invisible' AND (us.expires_at IS NULL OR us.expires_at > ?))
+      AND `+visibleToViewer+`
+    ORDER BY u.username ASC`, time.Now(), time.Now(), viewerID, viewerID, viewerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query online users: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var user models.PublicUser
+		var avatar string
+		if err := rows.Scan(&user.UserId, &user.Username, &user.JoinedAt, &avatar); err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		user.AvatarURL = AvatarURL(user.UserId, avatar)
+		onlineUsers = append(onlineUsers, user)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	// Attach the custom status of each online user
+	for i := range onlineUsers {
+		status, err := GetUserStatus(onlineUsers[i].UserId)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get user status: %w", err)
+		}
+		onlineUsers[i].Status = status
+	}
+
+	return onlineUsers, nil
+}
+
+// HaveConversation reports whether two users already exchanged private messages
+func HaveConversation(userID, otherUserID string) (bool, error) {
+	var exists bool
+	err := DB.QueryRow(`
+        SELECT EXISTS(
+            SELECT 1 FROM messages
+            WHERE (sender_id = ? AND receiver_id = ?) OR (sender_id = ? AND receiver_id = ?)
+        )`,
+		userID, otherUserID, otherUserID, userID).Scan(&exists)
+	return exists, err
+}
+
+// retrieves all users ordered by the last message sent or received (sort like discord)
+// Only public fields are returned, and only the users the current user can see
+func GetUsersOrderedByLastMessage(currentUserID string) ([]map[string]interface{}, error) {
+	// retrieves a list of users + last message infos
+	// LEFT JOIN to include users even if no messages have been exchanged
+	// Results are grouped by user ID and ordered by the most recent message timestamp (descending), then by username (ascending)
+	query := `
+        SELECT 
+            u.user_id,
+            u.username,
+            u.creation_date,
+            ` + avatarVersion + ` AS avatar_version,
+            COALESCE(m.content, '') AS last_message_content,
+            COALESCE(m.sender_id, '') AS last_message_sender,
+            COALESCE(strftime('%Y-%m-%d %H:%M:%S', m.sent_at), '') AS last_message_time
+        FROM user u
+        LEFT JOIN (
+            SELECT 
+                CASE 
+                    WHEN sender_id = ? THEN receiver_id
+                    ELSE sender_id
+                END AS other_user_id,
+                content,
+                sender_id,
+                sent_at
+            FROM messages
+            WHERE sender_id = ? OR receiver_id = ?
+            ORDER BY sent_at DESC
+        ) m ON u.user_id = m.other_user_id
+        LEFT JOIN user_privacy up ON u.user_id = up.user_id
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE u.user_id != ? AND ` + visibleToViewer + `
+        GROUP BY u.user_id
+        ORDER BY MAX(m.sent_at) DESC, u.username ASC
+    `
+
+	rows, err := DB.Query(query, currentUserID, currentUserID, currentUserID, currentUserID,
+		currentUserID, currentUserID, currentUserID)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var users []map[string]interface{}
+
+	for rows.Next() {
+		var userID, username, creationDate, avatar string
+		var lastMessageContent, lastMessageSender, lastMessageTime string
+
+		err := rows.Scan(
+			&userID,
+			&username,
+			&creationDate,
+			&avatar,
+			&lastMessageContent,
+			&lastMessageSender,
+			&lastMessageTime,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("Scan failed: %w", err)
+		}
+
+		users = append(users, map[string]interface{}{
+			"user_id":             userID,
+			"username":            username,
+			"avatar_url":          AvatarURL(userID, avatar),
+			"creation_date":       creationDate,
+			"last_message":        lastMessageContent,
+			"last_message_sender": lastMessageSender,
+			"last_message_time":   lastMessageTime,
+		})
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error after iterating rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// GetUserByUsername retrieves an account that was not deleted by its username
+func GetUserByUsername(username string) (*models.User, error) {
+	var user models.User
+	var avatar string
+	err := DB.QueryRow(`
+        SELECT u.user_id, u.username, u.email, u.first_name, u.last_name, u.age, u.gender, u.creation_date,
+            u.email_verified, `+avatarVersion+`
+        FROM User u
+        LEFT JOIN user_avatar ua ON ua.user_id = u.user_id
+        WHERE u.username = ? AND u.deleted_at IS NULL`,
+		username).Scan(
+		&user.Id,
+		&user.Username,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Age,
+		&user.Gender,
+		&user.CreationDate,
+		&user.EmailVerified,
+		&avatar,
+	)
+	if err != nil {
+		return nil, err
+	}
+	user.AvatarURL = AvatarURL(user.Id, avatar)
+	return &user, nil
+}
+
+// GetUserLastSeen returns the last time a user was active, nil if never recorded
+func GetUserLastSeen(userID string) (*time.Time, error) {
+	var lastSeen sql.NullTime
+	err := DB.QueryRow("SELECT last_seen FROM User WHERE user_id = ?", userID).Scan(&lastSeen)
+	if err != nil || !lastSeen.Valid {
+		return nil, err
+	}
+	return &lastSeen.Time, nil
+}
+
+// UpdateLastSeen records that a user was active just now
+func UpdateLastSeen(userID string) error {
+	_, err := DB.Exec("UPDATE User SET last_seen = ? WHERE user_id = ?", time.Now(), userID)
+	return err
+}
+
+// Username shown in place of the author of the content of a deleted account
+const DeletedUsername = "[deleted]"
+
+// UpdateUserProfile saves the profile fields of a user
+// The email address and password have their own functions
+func UpdateUserProfile(user models.User) error {
+	_, err := DB.Exec(
+		`UPDATE User SET username = ?, first_name = ?, last_name = ?, age = ?, gender = ?
+		WHERE user_id = ?`,
+		user.Username, user.FirstName, user.LastName, user.Age, user.Gender, user.Id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+	return nil
+}
+
+// UpdateUserEmail changes the email address of a user, who has to verify it again
+func UpdateUserEmail(userID, email string) error {
+	_, err := DB.Exec("UPDATE User SET email = ?, email_verified = 0 WHERE user_id = ?", email, userID)
+	if err != nil {
+		return fmt.Errorf("failed to update email: %w", err)
+	}
+	return nil
+}
+
+// DeleteUser deletes an account
+// With keepContent its posts, comments and messages stay, credited to DeletedUsername,
+// otherwise they are removed along with the comments left on its posts
+// Its avatar is left to DeleteOrphanAvatars, which also returns the files to remove
+func DeleteUser(userID string, keepContent bool) error {
+	tx, err := DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var queries []string
+	if keepContent {
+		queries = []string{
+			`UPDATE User SET username = '` + DeletedUsername + `', email = '', first_name = '', last_name = '',
+			age = 0, gender = 3, password = '', email_verified = 0, role = 'user', deleted_at = datetime('now')
+			WHERE user_id = ?1`,
+		}
+	} else {
+		queries = []string{
+			"DELETE FROM notification WHERE actor_id = ?1 OR post_id IN (SELECT post_id FROM Post WHERE user_id = ?1)",
+			"DELETE FROM Comment WHERE user_id = ?1 OR post_id IN (SELECT post_id FROM Post WHERE user_id = ?1)",
+			"DELETE FROM Post WHERE user_id = ?1",
+			"DELETE FROM messages WHERE sender_id = ?1 OR receiver_id = ?1",
+			"DELETE FROM report WHERE reporter_id = ?1 OR target_author_id = ?1",
+			"DELETE FROM filtered_content WHERE author_id = ?1",
+			"DELETE FROM User WHERE user_id = ?1",
+			deleteOrphanMentions,
+			deleteOrphanSubscriptions,
+		}
+	}
+	// Nothing else is kept in either case
+	queries = append(queries,
+		"DELETE FROM session WHERE user_id = ?1",
+		"DELETE FROM user_status WHERE user_id = ?1",
+		"DELETE FROM user_token WHERE user_id = ?1",
+		"DELETE FROM user_privacy WHERE user_id = ?1",
+		"DELETE FROM user_relation WHERE user_id = ?1 OR target_id = ?1",
+		"DELETE FROM notification WHERE user_id = ?1",
+		"DELETE FROM mention WHERE user_id = ?1",
+		"DELETE FROM subscription WHERE user_id = ?1 OR (target_type = 'user' AND target_id = ?1)",
+		"DELETE FROM push_subscription WHERE user_id = ?1",
+		"DELETE FROM email_digest WHERE user_id = ?1",
+		"DELETE FROM draft WHERE user_id = ?1 OR (target_type = 'message' AND target_id = ?1)",
+	)
+
+	for _, query := range queries {
+		if _, err := tx.Exec(query, userID); err != nil {
+			return fmt.Errorf("failed to delete user: %w", err)
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,107 @@
+package filter
+
+import (
+	"strings"
+	"time"
+)
+
+// Action is what a filter does with the content it matched, from the mildest to the harshest
+type Action int
+
+const (
+	Allow Action = iota
+	Mask         // the matched words are replaced with asterisks
+	Flag         // the content is published but kept for the moderators to review
+	Block        // the content is refused
+)
+
+// String returns the name of the action, as stored in the database
+func (a Action) String() string {
+	switch a {
+	case Mask:
+		return "mask"
+	case Flag:
+		return "flag"
+	case Block:
+		return "block"
+	default:
+		return "allow"
+	}
+}
+
+// Content is a text written by a user, about to be saved
+type Content struct {
+	Kind       string // "post", "comment" or "message"
+	AuthorID   string
+	AccountAge time.Duration // how long ago the author registered
+	Text       string
+}
+
+// Match is a rule a content broke
+type Match struct {
+	Rule   string // name of the filter, like "banned_word"
+	Action Action
+	Detail string // what matched, like the word or the number of links
+}
+
+// Filter checks a content, and may rewrite its text when it masks parts of it
+type Filter interface {
+	Check(c *Content) []Match
+}
+
+// Result is the outcome of a pipeline on a content
+type Result struct {
+	Action  Action // harshest action of the matches
+	Text    string // text to save, with the masked words replaced
+	Matches []Match
+}
+
+// Rules returns the matched rules in a readable form, like "banned_word: spam"
+func (r Result) Rules() string {
+	rules := make([]string, 0, len(r.Matches))
+	for _, m := range r.Matches {
+		rules = append(rules, m.Rule+": "+m.Detail)
+	}
+	return strings.Join(rules, ", ")
+}
+
+// Merge combines the result of another field of the same content
+func (r Result) Merge(other Result) Result {
+	if other.Action > r.Action {
+		r.Action = other.Action
+	}
+	for _, m := range other.Matches {
+		if !r.has(m) {
+			r.Matches = append(r.Matches, m)
+		}
+	}
+	return r
+}
+
+// has reports whether the result already holds a match, so that merged fields don't repeat it
+func (r Result) has(match Match) bool {
+	for _, m := range r.Matches {
+		if m == match {
+			return true
+		}
+	}
+	return false
+}
+
+// Pipeline runs filters one after the other, each one seeing the text rewritten by the previous ones
+type Pipeline []Filter
+
+// Run checks a content with every filter of the pipeline
+func (p Pipeline) Run(c Content) Result {
+	var result Result
+	for _, f := range p {
+		for _, m := range f.Check(&c) {
+			if m.Action > result.Action {
+				result.Action = m.Action
+			}
+			result.Matches = append(result.Matches, m)
+		}
+	}
+	result.Text = c.Text
+	return result
+}
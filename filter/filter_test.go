@@ -0,0 +1,75 @@
+package filter
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPipeline(t *testing.T) {
+	recent := func(string, time.Time) ([]string, error) {
+		return []string{"Buy   FANCY watches now"}, nil
+	}
+	p := Pipeline{
+		NewWordFilter(nil, []string{"cheap"}, nil),
+		LinkFilter{MaxLinks: 1, NewAccountAge: time.Hour},
+		DuplicateFilter{Window: time.Hour, NewAccountAge: time.Hour, MinLength: 10, Recent: recent},
+	}
+
+	tests := []struct {
+		name       string
+		content    Content
+		wantAction Action
+		wantText   string
+		wantRules  string
+	}{
+		{
+			name:       "clean text",
+			content:    Content{Text: "hello there"},
+			wantAction: Allow,
+			wantText:   "hello there",
+		},
+		{
+			name:       "masked word",
+			content:    Content{Text: "cheap tricks", AccountAge: 2 * time.Hour},
+			wantAction: Mask,
+			wantText:   "***** tricks",
+			wantRules:  "banned_word: cheap",
+		},
+		{
+			name:       "short duplicate",
+			content:    Content{Text: "ok"},
+			wantAction: Allow,
+			wantText:   "ok",
+		},
+		{
+			name:       "links of a new account",
+			content:    Content{Text: "https://a.example and www.b.example"},
+			wantAction: Flag,
+			wantText:   "https://a.example and www.b.example",
+			wantRules:  "too_many_links: 2 links",
+		},
+		{
+			name:       "links of a trusted account",
+			content:    Content{Text: "https://a.example and www.b.example", AccountAge: 2 * time.Hour},
+			wantAction: Allow,
+			wantText:   "https://a.example and www.b.example",
+		},
+		{
+			name:       "duplicate of a new account",
+			content:    Content{Text: "buy fancy watches now"},
+			wantAction: Block,
+			wantText:   "buy fancy watches now",
+			wantRules:  "duplicate_content: already posted in the last 1h0m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := p.Run(tt.content)
+			if got.Action != tt.wantAction || got.Text != tt.wantText || got.Rules() != tt.wantRules {
+				t.Errorf("Run(%q) = %v %q %q, want %v %q %q", tt.content.Text,
+					got.Action, got.Text, got.Rules(), tt.wantAction, tt.wantText, tt.wantRules)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package filter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// linkPattern matches the web addresses in a text
+var linkPattern = regexp.MustCompile(`(?i)\b(https?://|www\.)\S+`)
+
+// LinkFilter flags the contents of new accounts with too many links
+type LinkFilter struct {
+	MaxLinks      int
+	NewAccountAge time.Duration // accounts older than this are trusted
+}
+
+// Check implements Filter
+func (f LinkFilter) Check(c *Content) []Match {
+	if c.AccountAge >= f.NewAccountAge {
+		return nil
+	}
+	links := len(linkPattern.FindAllStringIndex(c.Text, -1))
+	if links <= f.MaxLinks {
+		return nil
+	}
+	return []Match{{Rule: "too_many_links", Action: Flag, Detail: strconv.Itoa(links) + " links"}}
+}
+
+// DuplicateFilter blocks new accounts repeating the same text in a short time, a common spam pattern
+// Short texts like "thanks" or "ok" are often repeated by anyone, they are not checked
+type DuplicateFilter struct {
+	Window        time.Duration
+	NewAccountAge time.Duration // accounts older than this are trusted
+	MinLength     int           // texts with fewer characters, once normalized, are never duplicates
+	// Recent returns the texts the author wrote since a date
+	Recent func(authorID string, since time.Time) ([]string, error)
+}
+
+// Check implements Filter
+func (f DuplicateFilter) Check(c *Content) []Match {
+	text := normalize(c.Text)
+	if c.AccountAge >= f.NewAccountAge || f.Recent == nil || utf8.RuneCountInString(text) < f.MinLength {
+		return nil
+	}
+
+	recent, err := f.Recent(c.AuthorID, time.Now().Add(-f.Window))
+	if err != nil {
+		return nil
+	}
+
+	for _, previous := range recent {
+		if normalize(previous) == text {
+			return []Match{{Rule: "duplicate_content", Action: Block, Detail: "already posted in the last " + f.Window.String()}}
+		}
+	}
+	return nil
+}
+
+// normalize lowercases a text and collapses its whitespace, so that trivial changes don't hide a duplicate
+func normalize(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
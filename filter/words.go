@@ -0,0 +1,112 @@
+package filter
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// WordFilter matches banned words, whole words only and whatever their case
+type WordFilter struct {
+	rules []wordRule
+}
+
+type wordRule struct {
+	action  Action
+	pattern *regexp.Regexp
+}
+
+// NewWordFilter builds a filter from the lists of words to block, mask and flag
+func NewWordFilter(block, mask, flag []string) *WordFilter {
+	f := &WordFilter{}
+	for _, list := range []struct {
+		action Action
+		words  []string
+	}{{Block, block}, {Mask, mask}, {Flag, flag}} {
+		if pattern := wordsPattern(list.words); pattern != nil {
+			f.rules = append(f.rules, wordRule{action: list.action, pattern: pattern})
+		}
+	}
+	return f
+}
+
+// wordsPattern builds a case-insensitive regexp matching any of the words, or nil without words
+// The word is the first group, and the letters and digits of every script count as part of a word:
+// \b only knows ASCII ones, so it would never see the end of a word like "été"
+func wordsPattern(words []string) *regexp.Regexp {
+	quoted := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.TrimSpace(word); word != "" {
+			quoted = append(quoted, regexp.QuoteMeta(word))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`(?i)(?:^|[^\p{L}\p{N}_])(` + strings.Join(quoted, "|") + `)(?:$|[^\p{L}\p{N}_])`)
+}
+
+// find returns the start and end of every banned word in a text
+// The search goes on right after each word, as the character that ended it can start the next one
+func (r wordRule) find(text string) [][2]int {
+	var found [][2]int
+	for start := 0; start < len(text); {
+		loc := r.pattern.FindStringSubmatchIndex(text[start:])
+		if loc == nil {
+			break
+		}
+		found = append(found, [2]int{start + loc[2], start + loc[3]})
+		start += loc[3]
+	}
+	return found
+}
+
+// Check implements Filter
+func (f *WordFilter) Check(c *Content) []Match {
+	var matches []Match
+	for _, rule := range f.rules {
+		found := rule.find(c.Text)
+		if len(found) == 0 {
+			continue
+		}
+
+		words := make([]string, len(found))
+		for i, loc := range found {
+			words[i] = c.Text[loc[0]:loc[1]]
+		}
+		if rule.action == Mask {
+			c.Text = mask(c.Text, found)
+		}
+		for _, word := range unique(words) {
+			matches = append(matches, Match{Rule: "banned_word", Action: rule.action, Detail: word})
+		}
+	}
+	return matches
+}
+
+// mask replaces every character of the words found in a text with an asterisk
+func mask(text string, found [][2]int) string {
+	var masked strings.Builder
+	end := 0
+	for _, loc := range found {
+		masked.WriteString(text[end:loc[0]])
+		masked.WriteString(strings.Repeat("*", utf8.RuneCountInString(text[loc[0]:loc[1]])))
+		end = loc[1]
+	}
+	masked.WriteString(text[end:])
+	return masked.String()
+}
+
+// unique returns the words without repetitions, ignoring their case
+func unique(words []string) []string {
+	seen := make(map[string]bool, len(words))
+	var result []string
+	for _, word := range words {
+		key := strings.ToLower(word)
+		if !seen[key] {
+			seen[key] = true
+			result = append(result, key)
+		}
+	}
+	return result
+}
@@ -0,0 +1,100 @@
+package filter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWordFilter(t *testing.T) {
+	f := NewWordFilter([]string{"idiot", "été"}, []string{"zut", "ça"}, []string{"arnaque", "c++"})
+
+	tests := []struct {
+		name    string
+		text    string
+		want    []Match
+		wantOut string
+	}{
+		{
+			name:    "ASCII word",
+			text:    "quel idiot",
+			want:    []Match{{Rule: "banned_word", Action: Block, Detail: "idiot"}},
+			wantOut: "quel idiot",
+		},
+		{
+			name:    "accented word in the middle of a sentence",
+			text:    "bel été ici",
+			want:    []Match{{Rule: "banned_word", Action: Block, Detail: "été"}},
+			wantOut: "bel été ici",
+		},
+		{
+			name:    "case of accented letters is ignored",
+			text:    "ÉTÉ!",
+			want:    []Match{{Rule: "banned_word", Action: Block, Detail: "été"}},
+			wantOut: "ÉTÉ!",
+		},
+		{
+			name:    "part of a longer word",
+			text:    "idiots, étés, zutique, arnaqueur",
+			wantOut: "idiots, étés, zutique, arnaqueur",
+		},
+		{
+			name:    "accented letters extend the word",
+			text:    "idiotè étéà",
+			wantOut: "idiotè étéà",
+		},
+		{
+			name:    "digits and underscores extend the word",
+			text:    "idiot2 _été",
+			wantOut: "idiot2 _été",
+		},
+		{
+			name:    "masked words keep their length",
+			text:    "Zut, ça marche pas",
+			want:    []Match{{Rule: "banned_word", Action: Mask, Detail: "zut"}, {Rule: "banned_word", Action: Mask, Detail: "ça"}},
+			wantOut: "***, ** marche pas",
+		},
+		{
+			name:    "words next to each other",
+			text:    "zut zut ZUT",
+			want:    []Match{{Rule: "banned_word", Action: Mask, Detail: "zut"}},
+			wantOut: "*** *** ***",
+		},
+		{
+			name:    "flagged word",
+			text:    "une arnaque",
+			want:    []Match{{Rule: "banned_word", Action: Flag, Detail: "arnaque"}},
+			wantOut: "une arnaque",
+		},
+		{
+			name:    "word ending with punctuation",
+			text:    "j'aime le c++ et le c",
+			want:    []Match{{Rule: "banned_word", Action: Flag, Detail: "c++"}},
+			wantOut: "j'aime le c++ et le c",
+		},
+		{
+			name:    "clean text",
+			text:    "bonjour à tous",
+			wantOut: "bonjour à tous",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := Content{Text: tt.text}
+			got := f.Check(&c)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Check(%q) = %+v, want %+v", tt.text, got, tt.want)
+			}
+			if c.Text != tt.wantOut {
+				t.Errorf("Check(%q) left the text %q, want %q", tt.text, c.Text, tt.wantOut)
+			}
+		})
+	}
+}
+
+func TestWordFilterWithoutWords(t *testing.T) {
+	c := Content{Text: "anything"}
+	if got := NewWordFilter(nil, []string{" ", ""}, nil).Check(&c); got != nil {
+		t.Errorf("Check = %+v, want no match", got)
+	}
+}
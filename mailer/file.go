@@ -0,0 +1,48 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes emails to .eml files instead of sending them, for local development
+// Without a directory the emails are only printed to the log
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+// NewFileMailer creates a mailer writing emails to dir, or to the log when dir is empty
+func NewFileMailer(dir, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+// Send writes the message to a new file of the directory
+func (m *FileMailer) Send(msg Message) error {
+	if hasHeaderInjection(msg.To, msg.Subject) {
+		return errors.New("invalid characters in email headers")
+	}
+
+	email := format(m.From, msg)
+	if m.Dir == "" {
+		log.Printf("Email to %s:\n%s", msg.To, email)
+		return nil
+	}
+
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create mail directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s.eml", time.Now().Format("20060102-150405.000000000"), filepath.Base(msg.To))
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, email, 0o600); err != nil {
+		return fmt.Errorf("failed to write email: %w", err)
+	}
+
+	log.Printf("Email to %s written to %s", msg.To, path)
+	return nil
+}
@@ -0,0 +1,74 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email, with an optional HTML version
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+	HTML    string // sent as a multipart/alternative email along with Body when set
+}
+
+// Mailer sends emails
+// The SMTP mailer is used in production, the file mailer during local development
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// format renders a message as an RFC 5322 email
+func format(from string, msg Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n")
+		b.WriteString("\r\n")
+		b.WriteString(crlf(msg.Body))
+		return []byte(b.String())
+	}
+
+	// Mail clients show the last part they can display, the HTML one
+	boundary := "forum-" + randomBoundary()
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%q\r\n", boundary)
+	b.WriteString("\r\n")
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(crlf(msg.Body))
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	b.WriteString("Content-Type: text/html; charset=UTF-8\r\n\r\n")
+	b.WriteString(crlf(msg.HTML))
+	fmt.Fprintf(&b, "\r\n--%s--\r\n", boundary)
+	return []byte(b.String())
+}
+
+// crlf turns the line breaks of a text into the CRLF required by email
+func crlf(text string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\n", "\r\n")
+}
+
+// randomBoundary returns a multipart boundary that can't appear in the parts
+func randomBoundary() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
+
+// hasHeaderInjection reports whether a header value would start a new header line
+func hasHeaderInjection(values ...string) bool {
+	for _, value := range values {
+		if strings.ContainsAny(value, "\r\n") {
+			return true
+		}
+	}
+	return false
+}
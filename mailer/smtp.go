@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"errors"
+	"net"
+	"net/smtp"
+	"strconv"
+)
+
+// SMTPMailer sends emails through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     int
+	Username string // no authentication when empty
+	Password string
+	From     string
+}
+
+// NewSMTPMailer creates a mailer sending emails from the given address
+func NewSMTPMailer(host string, port int, username, password, from string) *SMTPMailer {
+	return &SMTPMailer{
+		Host:     host,
+		Port:     port,
+		Username: username,
+		Password: password,
+		From:     from,
+	}
+}
+
+// Send delivers the message, using STARTTLS when the server supports it
+func (m *SMTPMailer) Send(msg Message) error {
+	if hasHeaderInjection(msg.To, msg.Subject) {
+		return errors.New("invalid characters in email headers")
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	addr := net.JoinHostPort(m.Host, strconv.Itoa(m.Port))
+	return smtp.SendMail(addr, auth, m.From, []string{msg.To}, format(m.From, msg))
+}
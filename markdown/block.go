@@ -0,0 +1,153 @@
+package markdown
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// fencePattern matches the line opening or closing a code block, with the language of the code
+	fencePattern = regexp.MustCompile("^ {0,3}(```+|~~~+)[ \t]*([A-Za-z0-9_+-]*)")
+	// bulletPattern matches an item of a list, "- item", "* item" or "+ item"
+	bulletPattern = regexp.MustCompile(`^ {0,3}[-*+][ \t]+(.*)$`)
+	// orderedPattern matches an item of an ordered list, "1. item" or "1) item"
+	orderedPattern = regexp.MustCompile(`^ {0,3}([0-9]{1,9})[.)][ \t]+(.*)$`)
+	// quotePattern matches a line of a quote, "> text"
+	quotePattern = regexp.MustCompile(`^ {0,3}> ?(.*)$`)
+)
+
+// maxQuoteDepth is the most quotes nested in each other, the "> " of deeper lines are left as text
+// Every level reads the lines of the quote again, so deep nesting would cost as much as it is deep
+const maxQuoteDepth = 8
+
+// blocks writes the paragraphs, lists, quotes and code blocks of a list of lines
+func (w *writer) blocks(lines []string) {
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+		case fencePattern.MatchString(line):
+			i = w.codeBlock(lines, i)
+		case w.startsQuote(line):
+			i = w.quote(lines, i)
+		case bulletPattern.MatchString(line) || orderedPattern.MatchString(line):
+			i = w.list(lines, i)
+		default:
+			i = w.paragraph(lines, i)
+		}
+	}
+}
+
+// startsBlock reports whether a line ends the paragraph before it
+func (w *writer) startsBlock(line string) bool {
+	return strings.TrimSpace(line) == "" || fencePattern.MatchString(line) || w.startsQuote(line) ||
+		bulletPattern.MatchString(line) || orderedPattern.MatchString(line)
+}
+
+// startsQuote reports whether a line opens a quote, which it can't once maxQuoteDepth quotes are open
+func (w *writer) startsQuote(line string) bool {
+	return w.quotes < maxQuoteDepth && quotePattern.MatchString(line)
+}
+
+// paragraph writes the lines up to the next block, their line breaks are kept
+func (w *writer) paragraph(lines []string, i int) int {
+	end := i + 1
+	for end < len(lines) && !w.startsBlock(lines[end]) {
+		end++
+	}
+	w.open("p")
+	w.inline(strings.TrimSpace(strings.Join(lines[i:end], "\n")))
+	w.close("p")
+	return end
+}
+
+// codeBlock writes a fenced code block as it is, up to its closing fence or the end of the content
+func (w *writer) codeBlock(lines []string, i int) int {
+	match := fencePattern.FindStringSubmatch(lines[i])
+	fence, language := match[1], match[2]
+
+	end := i + 1
+	for end < len(lines) && !strings.HasPrefix(strings.TrimLeft(lines[end], " "), fence) {
+		end++
+	}
+
+	w.open("pre")
+	if language != "" {
+		w.open("code", "class", "language-"+strings.ToLower(language))
+	} else {
+		w.open("code")
+	}
+	w.text(strings.Join(lines[i+1:end], "\n"))
+	w.close("code")
+	w.close("pre")
+	return min(end+1, len(lines))
+}
+
+// quote writes the following "> " lines as a quote, which can hold any other block
+func (w *writer) quote(lines []string, i int) int {
+	var inner []string
+	for ; i < len(lines); i++ {
+		match := quotePattern.FindStringSubmatch(lines[i])
+		if match == nil {
+			break
+		}
+		inner = append(inner, match[1])
+	}
+	w.open("blockquote")
+	w.quotes++
+	w.blocks(inner)
+	w.quotes--
+	w.close("blockquote")
+	return i
+}
+
+// list writes the following items of the same kind of list
+// The indented lines under an item continue it, and a blank line only ends the list
+// when the next line is not an item
+func (w *writer) list(lines []string, i int) int {
+	ordered := orderedPattern.MatchString(lines[i])
+	pattern, tag := bulletPattern, "ul"
+	if ordered {
+		pattern, tag = orderedPattern, "ol"
+	}
+
+	if start := orderedPattern.FindStringSubmatch(lines[i]); ordered && start[1] != "1" {
+		number, _ := strconv.Atoi(start[1])
+		w.open(tag, "start", strconv.Itoa(number))
+	} else {
+		w.open(tag)
+	}
+
+	for i < len(lines) {
+		match := pattern.FindStringSubmatch(lines[i])
+		if match == nil {
+			break
+		}
+		item := []string{match[len(match)-1]}
+		i++
+		for i < len(lines) && strings.TrimSpace(lines[i]) != "" && !pattern.MatchString(lines[i]) &&
+			(strings.HasPrefix(lines[i], " ") || strings.HasPrefix(lines[i], "\t")) {
+			item = append(item, strings.TrimSpace(lines[i]))
+			i++
+		}
+
+		w.open("li")
+		w.inline(strings.TrimSpace(strings.Join(item, "\n")))
+		w.close("li")
+
+		// Skip the blank lines between two items
+		next := i
+		for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+			next++
+		}
+		if next >= len(lines) || !pattern.MatchString(lines[next]) {
+			break
+		}
+		i = next
+	}
+
+	w.close(tag)
+	return i
+}
@@ -0,0 +1,78 @@
+package markdown
+
+import (
+	"html"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// allowedTags lists the only elements the renderer writes, with the attributes each can have
+// Anything else is dropped, so that no content can add scripts, styles or event handlers
+var allowedTags = map[string][]string{
+	"p":          nil,
+	"br":         nil,
+	"strong":     nil,
+	"em":         nil,
+	"code":       {"class"},
+	"pre":        nil,
+	"blockquote": nil,
+	"ul":         nil,
+	"ol":         {"start"},
+	"li":         nil,
+	"a":          {"href", "rel", "class", "data-username"},
+}
+
+// Schemes links can point to
+var allowedSchemes = []string{"http", "https", "mailto"}
+
+// writer builds the HTML of a content, every tag goes through the allowlist and every text is escaped
+type writer struct {
+	strings.Builder
+	mentions map[string]string
+	inLink   bool // links can't be nested, the text of a link has no other link
+	quotes   int  // quotes open around the blocks being written
+}
+
+// open writes a start tag, attrs are name and value pairs
+// Attributes outside the allowlist are dropped, and so are links to other schemes
+func (w *writer) open(tag string, attrs ...string) {
+	allowed, ok := allowedTags[tag]
+	if !ok {
+		return
+	}
+	w.WriteString("<" + tag)
+	for i := 0; i+1 < len(attrs); i += 2 {
+		name, value := attrs[i], attrs[i+1]
+		if !slices.Contains(allowed, name) || (name == "href" && !safeURL(value)) {
+			continue
+		}
+		w.WriteString(" " + name + `="` + html.EscapeString(value) + `"`)
+	}
+	w.WriteString(">")
+}
+
+// close writes the end tag of an element opened with open
+func (w *writer) close(tag string) {
+	if _, ok := allowedTags[tag]; ok && tag != "br" {
+		w.WriteString("</" + tag + ">")
+	}
+}
+
+// text writes escaped text
+func (w *writer) text(s string) {
+	w.WriteString(html.EscapeString(s))
+}
+
+// safeURL reports whether a link can be followed safely: an absolute URL with an allowed
+// scheme, or the "#" of the links handled by the page like the mentions
+func safeURL(raw string) bool {
+	if raw == "#" {
+		return true
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	return slices.Contains(allowedSchemes, strings.ToLower(u.Scheme)) && (u.Host != "" || u.Scheme == "mailto")
+}
@@ -0,0 +1,241 @@
+package markdown
+
+import (
+	"strings"
+)
+
+// Characters that can be escaped with a backslash to be shown as they are
+const escapable = "\\`*_[]()#+-.!>~@"
+
+// Characters ending a bare URL when they are its last one, like the dot closing a sentence
+const urlTrailing = ".,;:!?'\")"
+
+// inline writes the text of a block with its emphasis, code, links and mentions
+func (w *writer) inline(s string) {
+	plain := 0 // start of the text not written yet
+	flush := func(end int) {
+		w.text(s[plain:end])
+	}
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s) && strings.IndexByte(escapable, s[i+1]) >= 0:
+			flush(i)
+			w.text(s[i+1 : i+2])
+			i += 2
+			plain = i
+
+		case c == '\n':
+			flush(i)
+			w.open("br")
+			w.WriteString("\n")
+			i++
+			plain = i
+
+		case c == '`':
+			n := runLength(s, i, '`')
+			end := strings.Index(s[i+n:], strings.Repeat("`", n))
+			if end < 0 {
+				i += n
+				continue
+			}
+			flush(i)
+			w.open("code")
+			w.text(strings.TrimSpace(s[i+n : i+n+end]))
+			w.close("code")
+			i += n + end + n
+			plain = i
+
+		case c == '*' || c == '_':
+			n := min(runLength(s, i, c), 2)
+			end := closingDelimiter(s, i, c, n)
+			if end < 0 {
+				i += runLength(s, i, c)
+				continue
+			}
+			flush(i)
+			tag := "em"
+			if n == 2 {
+				tag = "strong"
+			}
+			w.open(tag)
+			w.inline(s[i+n : end])
+			w.close(tag)
+			i = end + n
+			plain = i
+
+		case c == '[' && !w.inLink:
+			label, href, end := parseLink(s, i)
+			if end < 0 || !safeURL(href) {
+				i++
+				continue
+			}
+			flush(i)
+			w.link(href, label)
+			i = end
+			plain = i
+
+		case c == 'h' && !w.inLink && (i == 0 || !isWordByte(s[i-1])) &&
+			(strings.HasPrefix(s[i:], "http://") || strings.HasPrefix(s[i:], "https://")):
+			end := urlEnd(s, i)
+			if !safeURL(s[i:end]) {
+				i = end
+				continue
+			}
+			flush(i)
+			w.inLink = true
+			w.open("a", "href", s[i:end], "rel", "nofollow noopener noreferrer")
+			w.text(s[i:end])
+			w.close("a")
+			w.inLink = false
+			i = end
+			plain = i
+
+		case c == '@' && !w.inLink && (i == 0 || !isMentionPrefix(s[i-1])):
+			name, username := w.mention(s, i+1)
+			if username == "" {
+				i++
+				continue
+			}
+			flush(i)
+			w.open("a", "href", "#", "class", "profile-link mention", "data-username", username)
+			w.text("@" + name)
+			w.close("a")
+			i += 1 + len(name)
+			plain = i
+
+		default:
+			i++
+		}
+	}
+	flush(len(s))
+}
+
+// link writes a link whose label can hold emphasis and code, but no other link
+func (w *writer) link(href, label string) {
+	w.inLink = true
+	w.open("a", "href", href, "rel", "nofollow noopener noreferrer")
+	w.inline(label)
+	w.close("a")
+	w.inLink = false
+}
+
+// runLength counts the repetitions of c starting at i
+func runLength(s string, i int, c byte) int {
+	n := 0
+	for i+n < len(s) && s[i+n] == c {
+		n++
+	}
+	return n
+}
+
+// closingDelimiter finds the end of the emphasis opened by n times c at i, or returns -1
+// The text can't start or end with a space, and underscores only emphasize whole words
+// so that names like snake_case are left alone
+func closingDelimiter(s string, i int, c byte, n int) int {
+	start := i + n
+	if start >= len(s) || s[start] == ' ' || s[start] == '\n' {
+		return -1
+	}
+	if c == '_' && i > 0 && isWordByte(s[i-1]) {
+		return -1
+	}
+
+	for j := start + 1; j+n <= len(s); j++ {
+		if s[j] == '`' {
+			// Delimiters inside code don't count
+			if end := strings.IndexByte(s[j+1:], '`'); end >= 0 {
+				j += end + 1
+			}
+			continue
+		}
+		if s[j] != c {
+			continue
+		}
+		run := runLength(s, j, c)
+		if n == 1 && run == 2 {
+			// Bold text inside italics
+			j++
+			continue
+		}
+		if run >= n && s[j-1] != ' ' && s[j-1] != '\n' && (c != '_' || j+n >= len(s) || !isWordByte(s[j+n])) {
+			return j
+		}
+		j += run - 1
+	}
+	return -1
+}
+
+// parseLink reads [label](url) at i and returns the index following it, or -1
+func parseLink(s string, i int) (label, href string, end int) {
+	closeLabel := strings.Index(s[i:], "](")
+	if closeLabel < 0 {
+		return "", "", -1
+	}
+	label = s[i+1 : i+closeLabel]
+	if label == "" || strings.ContainsAny(label, "[]\n") {
+		return "", "", -1
+	}
+
+	rest := s[i+closeLabel+2:]
+	closeURL := strings.IndexByte(rest, ')')
+	if closeURL < 0 {
+		return "", "", -1
+	}
+	href = strings.TrimSpace(rest[:closeURL])
+	if href == "" || strings.ContainsAny(href, " \t\n") {
+		return "", "", -1
+	}
+	return label, href, i + closeLabel + 2 + closeURL + 1
+}
+
+// urlEnd returns the end of a bare URL starting at i
+// The punctuation following a URL is not part of it, except the parentheses it opened
+func urlEnd(s string, i int) int {
+	end := i
+	for end < len(s) && !strings.ContainsRune(" \t\n<>", rune(s[end])) {
+		end++
+	}
+	for end > i && strings.IndexByte(urlTrailing, s[end-1]) >= 0 {
+		if s[end-1] == ')' && strings.Count(s[i:end], "(") >= strings.Count(s[i:end], ")") {
+			break
+		}
+		end--
+	}
+	return end
+}
+
+// mention returns the name following an @ at i and the username it links to,
+// or an empty username when the name is not one of the mentions
+// The dots and dashes ending a sentence are dropped, as when the mentions were found
+func (w *writer) mention(s string, i int) (name, username string) {
+	end := i
+	for end < len(s) && isNameByte(s[end]) {
+		end++
+	}
+	for name = s[i:end]; name != ""; name = name[:len(name)-1] {
+		if username = w.mentions[strings.ToLower(name)]; username != "" {
+			return name, username
+		}
+		if last := name[len(name)-1]; last != '.' && last != '-' {
+			break
+		}
+	}
+	return "", ""
+}
+
+// isWordByte reports whether b is an ASCII letter or digit, or any byte of a non-ASCII character
+func isWordByte(b byte) bool {
+	return b >= 0x80 || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// isNameByte reports whether b can be part of a username
+func isNameByte(b byte) bool {
+	return b == '.' || b == '-' || b == '_' || (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// isMentionPrefix reports whether an @ following b is part of a word, as in an email address
+func isMentionPrefix(b byte) bool {
+	return isNameByte(b) || b == '@'
+}
@@ -0,0 +1,91 @@
+package markdown
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Render turns a content written in the Markdown subset of the forum into safe HTML:
+// paragraphs and line breaks, **bold**, *italics*, `code`, ``` code blocks, - and 1. lists,
+// > quotes, [links](https://...) and bare http(s) URLs
+// Raw HTML is shown as text. Mentions maps the lowercased names following an @ to the
+// username whose profile they link to, the other @names are left as text
+// The result of each content is cached, rendering it again is free
+func Render(source string, mentions map[string]string) string {
+	key := cacheKey(source, mentions)
+	if html, ok := cache.get(key); ok {
+		return html
+	}
+	html := render(source, mentions)
+	cache.put(key, html)
+	return html
+}
+
+// render converts a content to HTML without looking at the cache
+func render(source string, mentions map[string]string) string {
+	source = strings.ReplaceAll(source, "\r\n", "\n")
+	w := &writer{mentions: mentions}
+	w.blocks(strings.Split(source, "\n"))
+	return w.String()
+}
+
+// Number of rendered contents kept in memory
+const cacheSize = 4096
+
+var cache = newLRU(cacheSize)
+
+// cacheKey identifies a content with its mentions, which change the links of the result
+func cacheKey(source string, mentions map[string]string) [sha256.Size]byte {
+	names := make([]string, 0, len(mentions))
+	for name, username := range mentions {
+		names = append(names, name+"\x00"+username)
+	}
+	sort.Strings(names)
+	return sha256.Sum256([]byte(source + "\x00" + strings.Join(names, "\x00")))
+}
+
+// lru keeps the most recently used results, dropping the oldest one when full
+type lru struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recent first, of *lruEntry
+	entries map[[sha256.Size]byte]*list.Element
+}
+
+type lruEntry struct {
+	key  [sha256.Size]byte
+	html string
+}
+
+func newLRU(size int) *lru {
+	return &lru{size: size, order: list.New(), entries: make(map[[sha256.Size]byte]*list.Element)}
+}
+
+func (c *lru) get(key [sha256.Size]byte) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*lruEntry).html, true
+}
+
+func (c *lru) put(key [sha256.Size]byte, html string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, html: html})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry).key)
+	}
+}
@@ -0,0 +1,196 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRender(t *testing.T) {
+	mentions := map[string]string{"bob": "Bob", "jo.ann": "Jo.Ann"}
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name:   "HTML is escaped",
+			source: `<script>alert(1)</script> & "q" 'x'`,
+			want:   `<p>&lt;script&gt;alert(1)&lt;/script&gt; &amp; &#34;q&#34; &#39;x&#39;</p>`,
+		},
+		{
+			name:   "HTML in emphasis is escaped",
+			source: `**<img src=x onerror=alert(1)>**`,
+			want:   `<p><strong>&lt;img src=x onerror=alert(1)&gt;</strong></p>`,
+		},
+		{
+			name:   "paragraphs and line breaks",
+			source: "line1\r\nline2\n\n\npara2",
+			want:   "<p>line1<br>\nline2</p><p>para2</p>",
+		},
+		{
+			name:   "emphasis",
+			source: "**bold** *it* _it_ snake_case_name 2*3*4",
+			want:   "<p><strong>bold</strong> <em>it</em> <em>it</em> snake_case_name 2<em>3</em>4</p>",
+		},
+		{
+			name:   "unclosed emphasis",
+			source: "**bold *it _it",
+			want:   "<p>**bold *it _it</p>",
+		},
+		{
+			name:   "escaped characters",
+			source: `\*not\* \[x\](https://a.com) \<b>`,
+			want:   `<p>*not* [x](<a href="https://a.com" rel="nofollow noopener noreferrer">https://a.com</a>) \&lt;b&gt;</p>`,
+		},
+		{
+			name:   "inline code",
+			source: "`<i>` and ``a ` b`` and `*not emphasis*`",
+			want:   "<p><code>&lt;i&gt;</code> and <code>a ` b</code> and <code>*not emphasis*</code></p>",
+		},
+		{
+			name:   "link",
+			source: "[**bold** `code`](https://a.com/x?y=1&z=2)",
+			want:   `<p><a href="https://a.com/x?y=1&amp;z=2" rel="nofollow noopener noreferrer"><strong>bold</strong> <code>code</code></a></p>`,
+		},
+		{
+			name:   "mailto link",
+			source: "[mail](mailto:a@b.c)",
+			want:   `<p><a href="mailto:a@b.c" rel="nofollow noopener noreferrer">mail</a></p>`,
+		},
+		{
+			name:   "javascript link",
+			source: "[x](javascript:alert(1)) [y](JavaScript:alert(1)) [z](  javascript:alert(1))",
+			want:   "<p>[x](javascript:alert(1)) [y](JavaScript:alert(1)) [z](  javascript:alert(1))</p>",
+		},
+		{
+			name:   "other schemes and relative links",
+			source: "[a](data:text/html;base64,PHNjcmlwdD4=) [b](vbscript:msgbox) [c](//evil.com) [d](/admin) [e](file:///etc/passwd)",
+			want:   "<p>[a](data:text/html;base64,PHNjcmlwdD4=) [b](vbscript:msgbox) [c](//evil.com) [d](/admin) [e](file:///etc/passwd)</p>",
+		},
+		{
+			name:   "quotes in href",
+			source: `[x](https://a.com/"onmouseover="alert(1))`,
+			want:   `<p><a href="https://a.com/&#34;onmouseover=&#34;alert(1" rel="nofollow noopener noreferrer">x</a>)</p>`,
+		},
+		{
+			name:   "quotes after a bare URL",
+			source: `https://a.com/"><script>alert(1)</script>`,
+			want:   `<p><a href="https://a.com/" rel="nofollow noopener noreferrer">https://a.com/</a>&#34;&gt;&lt;script&gt;alert(1)&lt;/script&gt;</p>`,
+		},
+		{
+			name:   "bare URL with the punctuation of the sentence",
+			source: "see https://en.wikipedia.org/wiki/Go_(language). or (https://a.com)",
+			want: `<p>see <a href="https://en.wikipedia.org/wiki/Go_(language)" rel="nofollow noopener noreferrer">https://en.wikipedia.org/wiki/Go_(language)</a>.` +
+				` or (<a href="https://a.com" rel="nofollow noopener noreferrer">https://a.com</a>)</p>`,
+		},
+		{
+			name:   "links are not nested",
+			source: "[nested [x](https://b.com)](https://a.com) [https://c.com](https://a.com)",
+			want: `<p>[nested <a href="https://b.com" rel="nofollow noopener noreferrer">x</a>](<a href="https://a.com" rel="nofollow noopener noreferrer">https://a.com</a>)` +
+				` <a href="https://a.com" rel="nofollow noopener noreferrer">https://c.com</a></p>`,
+		},
+		{
+			name:   "lists",
+			source: "- a\n* b\n  continued\n\n- c\n\n1. one\n2. two\n\ntext\n\n3) three",
+			want:   "<ul><li>a</li><li>b<br>\ncontinued</li><li>c</li></ul><ol><li>one</li><li>two</li></ol><p>text</p><ol start=\"3\"><li>three</li></ol>",
+		},
+		{
+			name:   "indented items are items of the same list",
+			source: "- a\n  - b\n- c",
+			want:   "<ul><li>a</li><li>b</li><li>c</li></ul>",
+		},
+		{
+			name:   "lists inside quotes",
+			source: "> - a\n> - b\n\n- c",
+			want:   "<blockquote><ul><li>a</li><li>b</li></ul></blockquote><ul><li>c</li></ul>",
+		},
+		{
+			name:   "nested quotes",
+			source: "> a\n> > b\n> c",
+			want:   "<blockquote><p>a</p><blockquote><p>b</p></blockquote><p>c</p></blockquote>",
+		},
+		{
+			name:   "quotes deeper than the limit",
+			source: strings.Repeat("> ", maxQuoteDepth+2) + "deep",
+			want:   strings.Repeat("<blockquote>", maxQuoteDepth) + "<p>&gt; &gt; deep</p>" + strings.Repeat("</blockquote>", maxQuoteDepth),
+		},
+		{
+			name:   "code block",
+			source: "```go\n<b>\"x\"</b>\n**not bold**\n```\nafter",
+			want:   "<pre><code class=\"language-go\">&lt;b&gt;&#34;x&#34;&lt;/b&gt;\n**not bold**</code></pre><p>after</p>",
+		},
+		{
+			name:   "code block language with quotes",
+			source: "```\"onclick=alert(1)\nx\n```",
+			want:   "<pre><code>x</code></pre>",
+		},
+		{
+			name:   "unclosed code block",
+			source: "~~~\n<x>",
+			want:   "<pre><code>&lt;x&gt;</code></pre>",
+		},
+		{
+			name:   "mentions",
+			source: "hi @BOB. and @jo.ann. a@bob.com @nobody @@bob",
+			want: `<p>hi <a href="#" class="profile-link mention" data-username="Bob">@BOB</a>.` +
+				` and <a href="#" class="profile-link mention" data-username="Jo.Ann">@jo.ann</a>.` +
+				` a@bob.com @nobody @@bob</p>`,
+		},
+		{
+			name:   "mention in a link",
+			source: "[@bob](https://a.com)",
+			want:   `<p><a href="https://a.com" rel="nofollow noopener noreferrer">@bob</a></p>`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Render(tt.source, mentions); got != tt.want {
+				t.Errorf("Render(%q)\n got %q\nwant %q", tt.source, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderMentionsChangeTheCachedResult(t *testing.T) {
+	source := "hello @bob"
+	if got := Render(source, nil); got != "<p>hello @bob</p>" {
+		t.Errorf("Render without mentions = %q", got)
+	}
+	want := `<p>hello <a href="#" class="profile-link mention" data-username="Bob">@bob</a></p>`
+	if got := Render(source, map[string]string{"bob": "Bob"}); got != want {
+		t.Errorf("Render with mentions = %q, want %q", got, want)
+	}
+}
+
+func TestRenderPathologicalInput(t *testing.T) {
+	// Longest content a user can write, a post
+	const size = 10000
+
+	patterns := map[string]string{
+		"nested quotes":         "> ",
+		"quote lines":           ">\n> ",
+		"delimiters":            "*",
+		"unclosed emphasis":     "*a **b ",
+		"underscores":           "_a ",
+		"backticks":             "`a`` ",
+		"brackets":              "[",
+		"unclosed links":        "[a](",
+		"mixed":                 "*_`[a](",
+		"URLs with parenthesis": "http://a(",
+		"list items":            "- a\n",
+		"mentions":              "@bob",
+	}
+	for name, pattern := range patterns {
+		t.Run(name, func(t *testing.T) {
+			source := strings.Repeat(pattern, size/len(pattern))
+			start := time.Now()
+			render(source, map[string]string{"bob": "Bob"})
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("rendering %d characters took %v", len(source), elapsed)
+			}
+		})
+	}
+}
@@ -1,49 +1,333 @@
-package models
-
-import "time"
-
-// Struct for registering in users
-type User struct {
-	Id           string    `json:"user_id"`
-	Username     string    `json:"username"`
-	FirstName    string    `json:"first_name"`
-	LastName     string    `json:"last_name"`
-	Age          int       `json:"age"`
-	Gender       int       `json:"gender"` // 1 = male, 2 = female, 3 = other
-	Email        string    `json:"email"`
-	Password     string    `json:"password"`
-	CreationDate time.Time `json:"creation_date"`
-}
-
-type Post struct {
-	Id           string    `json:"post_id"`
-	UserId       string    `json:"user_id"`
-	Title        string    `json:"title"`
-	Content      string    `json:"content"`
-	Category     string    `json:"category"`
-	CreationDate time.Time `json:"creation_date"`
-	Username     string    `json:"username"`
-}
-
-type Comment struct {
-	Id           string    `json:"comment_id"`
-	PostId       string    `json:"post_id"`
-	UserId       string    `json:"user_id"`
-	Content      string    `json:"content"`
-	CreationDate time.Time `json:"creation_date"`
-	Username     string    `json:"username"`
-}
-
-type PostWithComments struct {
-	Post     Post      `json:"post"`
-	Comments []Comment `json:"comments"`
-	Username string    `json:"username"`
-}
-
-type Message struct {
-	Type       string    `json:"type"`
-	SenderID   string    `json:"sender_id"`
-	ReceiverID string    `json:"receiver_id"`
-	Content    string    `json:"content"`
-	SentAt     time.Time `json:"sent_at"`
-}
+package models
+
+import "time"
+
+// Struct for registering in users
+type User struct {
+	Id            string      `json:"user_id"`
+	Username      string      `json:"username"`
+	FirstName     string      `json:"first_name"`
+	LastName      string      `json:"last_name"`
+	Age           int         `json:"age"`
+	Gender        int         `json:"gender"` // 1 = male, 2 = female, 3 = other
+	Email         string      `json:"email"`
+	Password      string      `json:"password"`
+	CreationDate  time.Time   `json:"creation_date"`
+	EmailVerified bool        `json:"email_verified"`
+	Role          string      `json:"role"`
+	AvatarURL     string      `json:"avatar_url"`
+	Status        *UserStatus `json:"status,omitempty"`
+}
+
+// Roles of the users, from the least to the most privileged
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // can delete, lock and pin content and suspend users
+	RoleAdmin     = "admin"     // can also change the roles of the other users
+)
+
+// Availability values a user can choose for their presence
+const (
+	AvailabilityAvailable    = "available"
+	AvailabilityBusy         = "busy"
+	AvailabilityDoNotDisturb = "dnd"
+	AvailabilityInvisible    = "invisible"
+)
+
+// Custom status chosen by a user (text, emoji and availability)
+type UserStatus struct {
+	UserId       string     `json:"user_id"`
+	Text         string     `json:"status_text"`
+	Emoji        string     `json:"status_emoji"`
+	Availability string     `json:"availability"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"` // nil means the status never expires
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// Session of a logged in user, one per device/browser
+type Session struct {
+	Id           string    `json:"-"` // Secret value of the session cookie, never sent back
+	CSRFToken    string    `json:"-"`
+	PublicId     string    `json:"session_id"`
+	UserId       string    `json:"user_id"`
+	CreatedAt    time.Time `json:"created_at"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	LastActivity time.Time `json:"last_activity"`
+	UserAgent    string    `json:"user_agent"`
+	IPAddress    string    `json:"ip_address"`
+	RememberMe   bool      `json:"remember_me"`
+	Current      bool      `json:"current"`
+}
+
+type Post struct {
+	Id           string        `json:"post_id"`
+	UserId       string        `json:"user_id"`
+	Title        string        `json:"title"`
+	Content      string        `json:"content"`                // Markdown source, as written
+	ContentHTML  string        `json:"content_html,omitempty"` // sanitized HTML rendered from the source
+	Category     string        `json:"category"`
+	CreationDate time.Time     `json:"creation_date"`
+	Username     string        `json:"username"`
+	Pinned       bool          `json:"pinned"`
+	Locked       bool          `json:"locked"`
+	Mentions     []Mention     `json:"mentions,omitempty"`
+	Attachments  []Attachment  `json:"attachments,omitempty"`
+	Previews     []LinkPreview `json:"previews,omitempty"`
+	// Uploads to attach to a new post, only read when creating it
+	AttachmentIds []string `json:"attachment_ids,omitempty"`
+}
+
+type Comment struct {
+	Id           string       `json:"comment_id"`
+	PostId       string       `json:"post_id"`
+	UserId       string       `json:"user_id"`
+	Content      string       `json:"content"`
+	ContentHTML  string       `json:"content_html,omitempty"`
+	CreationDate time.Time    `json:"creation_date"`
+	Username     string       `json:"username"`
+	PostTitle    string       `json:"post_title,omitempty"` // only set when listing the comments of a user
+	Mentions     []Mention    `json:"mentions,omitempty"`
+	Attachments  []Attachment `json:"attachments,omitempty"`
+	// Uploads to attach to a new comment, only read when creating it
+	AttachmentIds []string `json:"attachment_ids,omitempty"`
+}
+
+// A user mentioned as @username in a content
+// Offsets are counted in characters, End is just after the username
+type Mention struct {
+	UserId   string `json:"user_id"`
+	Username string `json:"username"` // current name of the user, which may differ from the text
+	Start    int    `json:"start"`
+	End      int    `json:"end"`
+}
+
+type PostWithComments struct {
+	Post       Post      `json:"post"`
+	Comments   []Comment `json:"comments"`
+	Username   string    `json:"username"`
+	Subscribed bool      `json:"subscribed"` // whether the viewer follows the new comments of the post
+}
+
+type Message struct {
+	Type       string    `json:"type"`
+	SenderID   string    `json:"sender_id"`
+	ReceiverID string    `json:"receiver_id"`
+	Content    string    `json:"content"`
+	SentAt     time.Time `json:"sent_at"`
+	// Uploads to attach to the message
+	AttachmentIds []string `json:"attachment_ids,omitempty"`
+	// Chosen by the page that sends the message, and given back to the sender with the saved message
+	// so that the page can tell it from the copy it shows while sending
+	ClientID string `json:"client_id,omitempty"`
+}
+
+// Fields of their profile a user chooses to show to others
+type PrivacySettings struct {
+	UserId                string `json:"-"`
+	ShowFullName          bool   `json:"show_full_name"`
+	ShowAge               bool   `json:"show_age"`
+	ShowGender            bool   `json:"show_gender"`
+	ShowInDirectory       bool   `json:"show_in_directory"`       // listed in /users and the online users
+	AllowStrangerMessages bool   `json:"allow_stranger_messages"` // private messages from users never talked with
+}
+
+// User as listed in the directory, without any private field
+type PublicUser struct {
+	UserId    string      `json:"user_id"`
+	Username  string      `json:"username"`
+	AvatarURL string      `json:"avatar_url"`
+	JoinedAt  time.Time   `json:"joined_at"`
+	Status    *UserStatus `json:"status,omitempty"`
+}
+
+// Profile of a user as seen by others, private fields are left empty
+type PublicProfile struct {
+	UserId         string      `json:"user_id"`
+	Username       string      `json:"username"`
+	AvatarURL      string      `json:"avatar_url"`
+	FirstName      string      `json:"first_name,omitempty"`
+	LastName       string      `json:"last_name,omitempty"`
+	Age            int         `json:"age,omitempty"`
+	Gender         int         `json:"gender,omitempty"`
+	JoinedAt       time.Time   `json:"joined_at"`
+	PostCount      int         `json:"post_count"`
+	CommentCount   int         `json:"comment_count"`
+	Presence       string      `json:"presence"`            // "online" or "offline"
+	LastSeen       *time.Time  `json:"last_seen,omitempty"` // hidden while online or invisible
+	Status         *UserStatus `json:"status,omitempty"`
+	RecentPosts    []Post      `json:"recent_posts"`
+	RecentComments []Comment   `json:"recent_comments"`
+	Blocked        bool        `json:"blocked"`   // whether the viewer blocked the user
+	Muted          bool        `json:"muted"`     // whether the viewer muted the user
+	Following      bool        `json:"following"` // whether the viewer follows the posts of the user
+}
+
+// Action of a moderator, kept in the audit log
+type ModerationEntry struct {
+	Id          string    `json:"log_id"`
+	ModeratorId string    `json:"moderator_id"`
+	Moderator   string    `json:"moderator"` // username of the moderator
+	Action      string    `json:"action"`
+	TargetType  string    `json:"target_type"` // "post", "comment" or "user"
+	TargetId    string    `json:"target_id"`
+	Details     string    `json:"details,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Statuses of a report
+const (
+	ReportOpen      = "open"
+	ReportActioned  = "actioned"  // a moderator acted on the reported content
+	ReportDismissed = "dismissed" // a moderator found nothing wrong
+)
+
+// Content flagged by a user for the moderators
+type Report struct {
+	Id             string     `json:"report_id"`
+	ReporterId     string     `json:"reporter_id"`
+	Reporter       string     `json:"reporter"`    // username of the reporter
+	TargetType     string     `json:"target_type"` // "post", "comment" or "message"
+	TargetId       string     `json:"target_id"`
+	TargetAuthorId string     `json:"target_author_id"`
+	TargetAuthor   string     `json:"target_author"` // username of the author of the content
+	Content        string     `json:"content"`       // copy of the content when it was reported
+	Reason         string     `json:"reason"`
+	Status         string     `json:"status"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ResolvedBy     string     `json:"resolved_by,omitempty"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ResolutionNote string     `json:"resolution_note,omitempty"`
+}
+
+// Content changed or stopped by the content filter, kept for the moderators
+type FilteredContent struct {
+	Id        string    `json:"filter_id"`
+	AuthorId  string    `json:"author_id"`
+	Author    string    `json:"author"` // username of the author
+	Kind      string    `json:"kind"`   // "post", "comment" or "message"
+	TargetId  string    `json:"target_id,omitempty"`
+	Action    string    `json:"action"` // "mask", "flag" or "block"
+	Rules     string    `json:"rules"`
+	Original  string    `json:"original"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Kinds of notifications
+const (
+	NotifyPostReply    = "post_reply"    // someone commented the user's post
+	NotifyCommentReply = "comment_reply" // someone commented a post the user follows
+	NotifyMention      = "mention"       // someone mentioned the user
+	NotifyMessage      = "message"       // someone messaged the user while they were offline
+)
+
+// Event kept for a user in their notification center
+type Notification struct {
+	Id        string    `json:"notification_id"`
+	UserId    string    `json:"user_id"`
+	ActorId   string    `json:"actor_id"`
+	Actor     string    `json:"actor"` // username of the user who caused the event
+	Type      string    `json:"type"`
+	PostId    string    `json:"post_id,omitempty"`
+	TargetId  string    `json:"target_id,omitempty"`
+	Excerpt   string    `json:"excerpt"`
+	Read      bool      `json:"read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Post, category or user followed by a user
+type Subscription struct {
+	TargetType string    `json:"target_type"` // "post", "category" or "user"
+	TargetId   string    `json:"target_id"`
+	Label      string    `json:"label"` // title of the post, name of the category or username
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Browser of a user receiving Web Push notifications
+type PushSubscription struct {
+	Endpoint  string    `json:"endpoint"`
+	UserId    string    `json:"-"`
+	P256dh    string    `json:"-"`
+	Auth      string    `json:"-"`
+	UserAgent string    `json:"user_agent"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// How often a user gets the email digest of their unread activity
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// Email digest preference of a user
+type DigestSettings struct {
+	UserId     string     `json:"-"`
+	Frequency  string     `json:"frequency"`
+	LastSentAt *time.Time `json:"last_sent_at,omitempty"`
+}
+
+// User due for an email digest, with the unread activity since the previous one
+type Digest struct {
+	UserId        string
+	Username      string
+	Email         string
+	Frequency     string
+	Since         time.Time // end of the previous digest, or registration for the first one
+	Notifications []Notification
+}
+
+// File uploaded by a user, attached to a post, a comment or a private message
+// Uploads that are not attached to a content within a day are deleted
+type Attachment struct {
+	Id           string    `json:"attachment_id"`
+	UploaderId   string    `json:"uploader_id"`
+	TargetType   string    `json:"target_type,omitempty"` // "post", "comment" or "message", empty until attached
+	TargetId     string    `json:"target_id,omitempty"`
+	BlobKey      string    `json:"-"`
+	ThumbnailKey string    `json:"-"`
+	Filename     string    `json:"filename"`
+	MimeType     string    `json:"mime_type"`
+	Size         int64     `json:"size"`
+	Width        int       `json:"width,omitempty"` // images only
+	Height       int       `json:"height,omitempty"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Picture uploaded by a user, stored cropped to a square in three sizes
+// Users without one are shown an identicon generated from their ID
+type Avatar struct {
+	UserId    string
+	SmallKey  string // 32 x 32
+	MediumKey string // 64 x 64
+	LargeKey  string // 256 x 256
+	MimeType  string
+	UpdatedAt time.Time
+}
+
+// Preview of a web page linked in a post or a message, read from its OpenGraph and Twitter card tags
+// Previews are shared by every content linking the same URL
+// Their image is a copy kept by the server, so that the site of the page never sees who reads it
+type LinkPreview struct {
+	URL         string    `json:"url"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	ImageURL    string    `json:"image_url,omitempty"` // where the copy of the image is served
+	ImageKey    string    `json:"-"`                   // blob of the copy of the image, empty when there is none
+	ImageType   string    `json:"-"`
+	SiteName    string    `json:"site_name,omitempty"`
+	Failed      bool      `json:"-"` // the page could not be fetched or has no title, it is not shown
+	FetchedAt   time.Time `json:"-"`
+}
+
+// Text a user started writing and did not send yet, shared by all their devices
+// There is one draft for the new post form, one per comment box and one per conversation
+type Draft struct {
+	TargetType string    `json:"target_type"`     // "post", "comment" or "message"
+	TargetId   string    `json:"target_id"`       // empty for a new post, the post commented or the other user
+	Title      string    `json:"title,omitempty"` // posts only
+	Content    string    `json:"content"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
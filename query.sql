@@ -1,52 +1,266 @@
-CREATE TABLE IF NOT EXISTS User (
-   user_id CHAR(32) PRIMARY KEY,
-   email VARCHAR(100) NOT NULL,
-   age INTEGER NOT NULL,
-   gender INTEGER NOT NULL CHECK (gender IN (1, 2, 3)),
-   first_name TEXT NOT NULL,
-   last_name TEXT NOT NULL,
-   username VARCHAR(50) NOT NULL,
-   password VARCHAR(255) NOT NULL, -- Hashed password
-   creation_date DATETIME NOT NULL
- );
- 
- CREATE TABLE IF NOT EXISTS Post (
-   post_id CHAR(32) PRIMARY KEY,
-   title VARCHAR(50) NOT NULL,
-   content TEXT NOT NULL, -- Content of the post
-   category VARCHAR(50) NOT NULL,
-   user_id CHAR(32) NOT NULL,
-   creation_date DATETIME NOT NULL,
-   update_date DATETIME,
-   FOREIGN KEY (user_id) REFERENCES User(user_id)
- );
- 
- CREATE TABLE IF NOT EXISTS Comment (
-   comment_id CHAR(32) PRIMARY KEY,
-   content TEXT NOT NULL,
-   creation_date DATETIME NOT NULL,
-   update_date DATETIME,
-   user_id CHAR(32) NOT NULL,
-   post_id CHAR(32) NOT NULL,
-   FOREIGN KEY (user_id) REFERENCES User(user_id),
-   FOREIGN KEY (post_id) REFERENCES Post(post_id)
- );
-
-CREATE TABLE IF NOT EXISTS session (
-    session_id TEXT PRIMARY KEY,
-    user_id TEXT NOT NULL,
-    created_at DATETIME NOT NULL,
-    expires_at DATETIME NOT NULL,
-    status TEXT DEFAULT 'online',
-    FOREIGN KEY (user_id) REFERENCES user(user_id)
-);
-
-CREATE TABLE IF NOT EXISTS messages (
-    id INTEGER PRIMARY KEY,
-    sender_id TEXT NOT NULL,
-    receiver_id TEXT NOT NULL,
-    content TEXT NOT NULL,
-    sent_at DATETIME NOT NULL,
-    FOREIGN KEY (sender_id) REFERENCES User(user_id),
-    FOREIGN KEY (receiver_id) REFERENCES User(user_id)
-);
+CREATE TABLE IF NOT EXISTS User (
+   user_id CHAR(32) PRIMARY KEY,
+   email VARCHAR(100) NOT NULL,
+   age INTEGER NOT NULL,
+   gender INTEGER NOT NULL CHECK (gender IN (1, 2, 3)),
+   first_name TEXT NOT NULL,
+   last_name TEXT NOT NULL,
+   username VARCHAR(50) NOT NULL,
+   password VARCHAR(255) NOT NULL, -- Hashed password
+   creation_date DATETIME NOT NULL,
+   email_verified INTEGER NOT NULL DEFAULT 0,
+   deleted_at DATETIME, -- set when the account was deleted but its content kept anonymized
+   last_seen DATETIME,
+   role TEXT NOT NULL DEFAULT 'user', -- user, moderator or admin
+   suspended_until DATETIME -- set by moderators, the user can't log in before that date
+ );
+ 
+ CREATE TABLE IF NOT EXISTS Post (
+   post_id CHAR(32) PRIMARY KEY,
+   title VARCHAR(50) NOT NULL,
+   content TEXT NOT NULL, -- Content of the post
+   category VARCHAR(50) NOT NULL,
+   user_id CHAR(32) NOT NULL,
+   creation_date DATETIME NOT NULL,
+   update_date DATETIME,
+   pinned INTEGER NOT NULL DEFAULT 0, -- shown first in the list
+   locked INTEGER NOT NULL DEFAULT 0, -- no more comments can be added
+   FOREIGN KEY (user_id) REFERENCES User(user_id)
+ );
+ 
+ CREATE TABLE IF NOT EXISTS Comment (
+   comment_id CHAR(32) PRIMARY KEY,
+   content TEXT NOT NULL,
+   creation_date DATETIME NOT NULL,
+   update_date DATETIME,
+   user_id CHAR(32) NOT NULL,
+   post_id CHAR(32) NOT NULL,
+   FOREIGN KEY (user_id) REFERENCES User(user_id),
+   FOREIGN KEY (post_id) REFERENCES Post(post_id)
+ );
+
+CREATE TABLE IF NOT EXISTS session (
+    session_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL,
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    status TEXT DEFAULT 'online',
+    last_activity DATETIME,
+    user_agent TEXT NOT NULL DEFAULT '',
+    ip_address TEXT NOT NULL DEFAULT '',
+    remember_me INTEGER NOT NULL DEFAULT 0,
+    idle_timeout INTEGER NOT NULL DEFAULT 3600, -- in seconds, expiry slides by this much on activity
+    public_id TEXT, -- identifier shown to the user, the session_id itself is never exposed
+    csrf_token TEXT, -- token expected in the X-CSRF-Token header of state-changing requests
+    FOREIGN KEY (user_id) REFERENCES user(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+    id INTEGER PRIMARY KEY,
+    sender_id TEXT NOT NULL,
+    receiver_id TEXT NOT NULL,
+    content TEXT NOT NULL,
+    sent_at DATETIME NOT NULL,
+    FOREIGN KEY (sender_id) REFERENCES User(user_id),
+    FOREIGN KEY (receiver_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_status (
+    user_id TEXT PRIMARY KEY,
+    status_text TEXT NOT NULL DEFAULT '',
+    status_emoji TEXT NOT NULL DEFAULT '',
+    availability TEXT NOT NULL DEFAULT 'available' CHECK (availability IN ('available', 'busy', 'dnd', 'invisible')),
+    expires_at DATETIME, -- NULL means the status never expires
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_token (
+    token_hash TEXT PRIMARY KEY, -- SHA-256 of the token, the token itself is only sent by email
+    user_id TEXT NOT NULL,
+    purpose TEXT NOT NULL CHECK (purpose IN ('email_verification', 'password_reset')),
+    created_at DATETIME NOT NULL,
+    expires_at DATETIME NOT NULL,
+    used_at DATETIME, -- tokens are single-use
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_privacy (
+    user_id TEXT PRIMARY KEY,
+    show_full_name INTEGER NOT NULL DEFAULT 0,
+    show_age INTEGER NOT NULL DEFAULT 0,
+    show_gender INTEGER NOT NULL DEFAULT 0,
+    show_in_directory INTEGER NOT NULL DEFAULT 1,
+    allow_stranger_messages INTEGER NOT NULL DEFAULT 1,
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS user_relation (
+    user_id TEXT NOT NULL,
+    target_id TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('block', 'mute')),
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, target_id, kind),
+    FOREIGN KEY (user_id) REFERENCES User(user_id),
+    FOREIGN KEY (target_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS moderation_log (
+    log_id TEXT PRIMARY KEY,
+    moderator_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    target_type TEXT NOT NULL, -- post, comment or user
+    target_id TEXT NOT NULL,
+    details TEXT NOT NULL DEFAULT '',
+    reason TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (moderator_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS report (
+    report_id TEXT PRIMARY KEY,
+    reporter_id TEXT NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'message')),
+    target_id TEXT NOT NULL,
+    target_author_id TEXT NOT NULL,
+    content TEXT NOT NULL, -- copy of the reported content, which may be deleted since
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'actioned', 'dismissed')),
+    created_at DATETIME NOT NULL,
+    resolved_by TEXT,
+    resolved_at DATETIME,
+    resolution_note TEXT NOT NULL DEFAULT '',
+    FOREIGN KEY (reporter_id) REFERENCES User(user_id),
+    FOREIGN KEY (target_author_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS filtered_content (
+    filter_id TEXT PRIMARY KEY,
+    author_id TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('post', 'comment', 'message')),
+    target_id TEXT NOT NULL DEFAULT '', -- empty when the content was blocked
+    action TEXT NOT NULL CHECK (action IN ('mask', 'flag', 'block')),
+    rules TEXT NOT NULL,
+    original TEXT NOT NULL, -- text as written by the author, before masking
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (author_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS notification (
+    notification_id TEXT PRIMARY KEY,
+    user_id TEXT NOT NULL, -- user notified
+    actor_id TEXT NOT NULL, -- user who caused the event
+    type TEXT NOT NULL CHECK (type IN ('post_reply', 'comment_reply', 'mention', 'message')),
+    post_id TEXT NOT NULL DEFAULT '', -- post to open, empty for private messages
+    target_id TEXT NOT NULL DEFAULT '', -- comment, post or message that caused the event
+    excerpt TEXT NOT NULL DEFAULT '',
+    read INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES User(user_id),
+    FOREIGN KEY (actor_id) REFERENCES User(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_notification_user ON notification(user_id, read, created_at);
+
+CREATE TABLE IF NOT EXISTS mention (
+    source_type TEXT NOT NULL CHECK (source_type IN ('post', 'comment', 'message')),
+    source_id TEXT NOT NULL,
+    user_id TEXT NOT NULL, -- user mentioned
+    start_offset INTEGER NOT NULL, -- position of the @ in the content, in characters
+    end_offset INTEGER NOT NULL,
+    PRIMARY KEY (source_type, source_id, start_offset),
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS subscription (
+    user_id TEXT NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'category', 'user')),
+    target_id TEXT NOT NULL, -- post ID, category name or user ID
+    created_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, target_type, target_id),
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_subscription_target ON subscription(target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS push_subscription (
+    endpoint TEXT PRIMARY KEY, -- URL of the push service for one browser
+    user_id TEXT NOT NULL,
+    p256dh TEXT NOT NULL, -- public key of the browser
+    auth TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_push_subscription_user ON push_subscription(user_id);
+
+CREATE TABLE IF NOT EXISTS email_digest (
+    user_id TEXT PRIMARY KEY,
+    frequency TEXT NOT NULL DEFAULT 'weekly' CHECK (frequency IN ('off', 'daily', 'weekly')),
+    last_sent_at DATETIME, -- end of the period covered by the last digest
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS attachment (
+    attachment_id TEXT PRIMARY KEY,
+    uploader_id TEXT NOT NULL,
+    target_type TEXT CHECK (target_type IN ('post', 'comment', 'message')), -- NULL until the upload is attached to a content
+    target_id TEXT,
+    blob_key TEXT NOT NULL, -- SHA-256 of the file in the blob store
+    thumbnail_key TEXT NOT NULL DEFAULT '', -- empty when the file is not an image
+    filename TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    size INTEGER NOT NULL,
+    width INTEGER NOT NULL DEFAULT 0,
+    height INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME NOT NULL,
+    FOREIGN KEY (uploader_id) REFERENCES User(user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_attachment_target ON attachment(target_type, target_id);
+
+CREATE TABLE IF NOT EXISTS user_avatar (
+    user_id TEXT PRIMARY KEY,
+    small_key TEXT NOT NULL, -- keys of the resized images in the blob store
+    medium_key TEXT NOT NULL,
+    large_key TEXT NOT NULL,
+    mime_type TEXT NOT NULL,
+    updated_at DATETIME NOT NULL,
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS link_preview (
+    url TEXT PRIMARY KEY,
+    failed INTEGER NOT NULL DEFAULT 0, -- the page had no preview, it is tried again later
+    title TEXT NOT NULL DEFAULT '',
+    description TEXT NOT NULL DEFAULT '',
+    image_key TEXT NOT NULL DEFAULT '', -- copy of the image in the blob store, served by the server
+    image_type TEXT NOT NULL DEFAULT '',
+    site_name TEXT NOT NULL DEFAULT '',
+    fetched_at DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS content_link (
+    source_type TEXT NOT NULL CHECK (source_type IN ('post', 'message')),
+    source_id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    position INTEGER NOT NULL, -- order of the link in the content
+    PRIMARY KEY (source_type, source_id, url)
+);
+
+CREATE TABLE IF NOT EXISTS draft (
+    user_id TEXT NOT NULL,
+    target_type TEXT NOT NULL CHECK (target_type IN ('post', 'comment', 'message')),
+    target_id TEXT NOT NULL DEFAULT '', -- empty for a new post, the post commented or the other user of the conversation
+    title TEXT NOT NULL DEFAULT '', -- posts only
+    content TEXT NOT NULL DEFAULT '',
+    updated_at DATETIME NOT NULL,
+    PRIMARY KEY (user_id, target_type, target_id),
+    FOREIGN KEY (user_id) REFERENCES User(user_id)
+);
+
+CREATE TABLE IF NOT EXISTS schema_migration (
+    name TEXT PRIMARY KEY, -- data migrations already run, see database.dataMigrations
+    applied_at DATETIME NOT NULL
+);
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/mailer"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Ways to delete an account, chosen by the user
+const (
+	deleteModeAnonymize = "anonymize" // keep the posts, comments and messages under DeletedUsername
+	deleteModeRemove    = "remove"    // delete them with the account
+)
+
+// profileRequest holds the profile fields to change, missing fields are left as they are
+type profileRequest struct {
+	Username  *string `json:"username"`
+	FirstName *string `json:"first_name"`
+	LastName  *string `json:"last_name"`
+	Age       *int    `json:"age"`
+	Gender    *int    `json:"gender"`
+}
+
+// AccountHandler returns (GET) or updates (POST) the profile of the current user
+func AccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := database.GetUserByID(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req profileRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		previousUsername := user.Username
+		if req.Username != nil {
+			user.Username = *req.Username
+		}
+		if req.FirstName != nil {
+			user.FirstName = *req.FirstName
+		}
+		if req.LastName != nil {
+			user.LastName = *req.LastName
+		}
+		if req.Age != nil {
+			user.Age = *req.Age
+		}
+		if req.Gender != nil {
+			user.Gender = *req.Gender
+		}
+
+		var v validator
+		validateProfile(&v, user)
+		if len(v.errors) > 0 {
+			writeValidationError(w, r, v.errors)
+			return
+		}
+
+		if !strings.EqualFold(user.Username, previousUsername) {
+			available, err := database.FindUsername(user.Username)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "Failed to update profile")
+				return
+			}
+			if !available {
+				writeError(w, r, http.StatusConflict, "Username already in use")
+				return
+			}
+		}
+
+		if err := database.UpdateUserProfile(*user); err != nil {
+			log.Printf("Error updating profile: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update profile")
+			return
+		}
+
+		// Let the other users refresh their lists with the new name
+		if user.Username != previousUsername {
+			broadcastUserStatus(user.Id, user.Username, "online")
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// privacyRequest holds the privacy settings to change, missing fields are left as they are
+type privacyRequest struct {
+	ShowFullName          *bool `json:"show_full_name"`
+	ShowAge               *bool `json:"show_age"`
+	ShowGender            *bool `json:"show_gender"`
+	ShowInDirectory       *bool `json:"show_in_directory"`
+	AllowStrangerMessages *bool `json:"allow_stranger_messages"`
+}
+
+// PrivacyHandler returns (GET) or updates (POST) the privacy settings of the current user:
+// the fields of their profile shown to others, whether they are listed in the directory
+// and whether users they never talked with can message them
+func PrivacyHandler(w http.ResponseWriter, r *http.Request) {
+	settings, err := database.GetPrivacySettings(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get privacy settings")
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		var req privacyRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		if req.ShowFullName != nil {
+			settings.ShowFullName = *req.ShowFullName
+		}
+		if req.ShowAge != nil {
+			settings.ShowAge = *req.ShowAge
+		}
+		if req.ShowGender != nil {
+			settings.ShowGender = *req.ShowGender
+		}
+		if req.ShowInDirectory != nil {
+			settings.ShowInDirectory = *req.ShowInDirectory
+		}
+		if req.AllowStrangerMessages != nil {
+			settings.AllowStrangerMessages = *req.AllowStrangerMessages
+		}
+
+		if err := database.SetPrivacySettings(*settings); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to update privacy settings")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// ChangePasswordHandler changes the password of the current user
+// The current password is required, and every other session is logged out
+func ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	if req.CurrentPassword == "" {
+		v.add("current_password", ErrRequired)
+	}
+	validatePassword(&v, "new_password", req.NewPassword)
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	userID := currentUserID(r)
+	if !checkCurrentPassword(w, r, userID, req.CurrentPassword, "current_password") {
+		return
+	}
+
+	if err := database.UpdatePassword(userID, req.NewPassword); err != nil {
+		log.Printf("Error updating password: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to change password")
+		return
+	}
+
+	// Other devices have to log in again with the new password
+	sessionIDs, err := database.DeleteOtherUserSessions(userID, currentSessionID(r))
+	if err != nil {
+		log.Printf("Error deleting sessions: %v", err)
+	}
+	closeSessionConnections(sessionIDs...)
+
+	if user, err := database.GetUserByID(userID); err == nil {
+		sendMail(mailer.Message{
+			To:      user.Email,
+			Subject: "Your password was changed",
+			Body: "Hello " + user.Username + ",\n\n" +
+				"The password of your account was just changed and your other devices were logged out.\n" +
+				"If you did not do it, reset your password right away from the login page.\n",
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password changed",
+	})
+}
+
+// ChangeEmailHandler changes the email address of the current user
+// The new address has to be verified again, and the old one is told about the change
+func ChangeEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	req.Email = strings.TrimSpace(req.Email)
+	validateEmail(&v, "email", req.Email)
+	if req.Password == "" {
+		v.add("password", ErrRequired)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	user, err := database.GetUserByID(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "User not found")
+		return
+	}
+	if !checkCurrentPassword(w, r, user.Id, req.Password, "password") {
+		return
+	}
+
+	if strings.EqualFold(req.Email, user.Email) {
+		writeValidationError(w, r, []FieldError{{Field: "email", Error: ErrInvalid}})
+		return
+	}
+	available, err := database.FindEmailUser(req.Email)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+	if !available {
+		writeError(w, r, http.StatusConflict, "Email already in use")
+		return
+	}
+
+	if err := database.UpdateUserEmail(user.Id, req.Email); err != nil {
+		log.Printf("Error updating email: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to change email")
+		return
+	}
+
+	sendMail(mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address was changed",
+		Body: "Hello " + user.Username + ",\n\n" +
+			"The email address of your account was changed to " + req.Email + ".\n" +
+			"If you did not do it, contact the forum administrators.\n",
+	})
+	if err := sendVerificationEmail(user.Id, user.Username, req.Email); err != nil {
+		log.Printf("Error issuing verification token: %v", err)
+	}
+
+	user.Email = req.Email
+	user.EmailVerified = false
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// DeleteAccountHandler deletes the account of the current user, who chooses
+// whether their posts, comments and messages are anonymized or removed
+func DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Password string `json:"password"`
+		Mode     string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	if req.Password == "" {
+		v.add("password", ErrRequired)
+	}
+	if req.Mode == "" {
+		v.add("mode", ErrRequired)
+	} else if req.Mode != deleteModeAnonymize && req.Mode != deleteModeRemove {
+		v.add("mode", ErrInvalid)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	userID := currentUserID(r)
+	if !checkCurrentPassword(w, r, userID, req.Password, "password") {
+		return
+	}
+
+	// Collect the sessions before they are deleted, to close their sockets
+	sessions, err := database.GetUserSessions(userID)
+	if err != nil {
+		log.Printf("Error getting sessions: %v", err)
+	}
+
+	if err := database.DeleteUser(userID, req.Mode == deleteModeAnonymize); err != nil {
+		log.Printf("Error deleting account: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	sessionIDs := make([]string, 0, len(sessions))
+	for _, session := range sessions {
+		sessionIDs = append(sessionIDs, session.Id)
+	}
+	closeSessionConnections(sessionIDs...)
+
+	clearSessionCookie(w, r)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Account deleted",
+	})
+}
+
+// checkCurrentPassword answers the request with an error unless password is the
+// one of the user, and reports whether the request can go on
+// Wrong passwords count towards the login lockout of the user
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, userID, password, field string) bool {
+	ip := clientIP(r)
+	if wait := failedLogins.lockedFor(userID, ip); wait > 0 {
+		writeRateLimited(w, r, wait)
+		return false
+	}
+
+	err := database.CheckPassword(userID, password)
+	if errors.Is(err, database.ErrInvalidPassword) {
+		failedLogins.fail(userID, ip)
+		writeErrorCode(w, r, http.StatusForbidden, ErrCodeValidation, "Wrong password", []FieldError{{Field: field, Error: ErrInvalid}})
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check password")
+		return false
+	}
+
+	failedLogins.reset(userID, ip)
+	return true
+}
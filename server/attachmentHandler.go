@@ -0,0 +1,326 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/storage"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Error codes of refused uploads
+const (
+	ErrCodeFileTooLarge        = "file_too_large"
+	ErrCodeUnsupportedFileType = "unsupported_file_type"
+)
+
+// Types of the files accepted when FORUM_UPLOAD_TYPES is not set
+var defaultUploadTypes = []string{"image/jpeg", "image/png", "image/gif", "image/webp", "application/pdf", "text/plain"}
+
+const (
+	// Most attachments a post, a comment or a message can have
+	maxAttachments = 5
+	// Width and height of the square the thumbnails of images fit in
+	thumbnailSize = 320
+	// How long an upload can wait to be attached to a content before it is deleted
+	pendingUploadTTL = 24 * time.Hour
+	// Longest name kept for an uploaded file, in characters
+	maxFilenameLength = 255
+)
+
+// blobStore keeps the uploaded files and their thumbnails, it is opened by SetupRoutes
+var blobStore storage.BlobStore
+
+// openBlobStore creates the store of the uploaded files in the configured directory
+func openBlobStore() error {
+	store, err := storage.NewDiskStore(config.UploadDir)
+	if err != nil {
+		return fmt.Errorf("failed to open the upload directory: %w", err)
+	}
+	blobStore = store
+	return nil
+}
+
+// UploadAttachmentHandler stores a file sent as the "file" field of a multipart form
+// The upload is then attached to a post, a comment or a message by giving its ID
+// in the attachment_ids of the content
+func UploadAttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	filename, data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	// The type is detected from the content, the one claimed by the client is ignored
+	mimeType, _, _ := mime.ParseMediaType(http.DetectContentType(data))
+	allowed := config.UploadTypes
+	if len(allowed) == 0 {
+		allowed = defaultUploadTypes
+	}
+	if !slices.Contains(allowed, mimeType) {
+		writeErrorCode(w, r, http.StatusUnsupportedMediaType, ErrCodeUnsupportedFileType, "This type of file is not accepted",
+			map[string]interface{}{"mime_type": mimeType, "allowed": allowed})
+		return
+	}
+
+	var err error
+	attachment := models.Attachment{
+		UploaderId: currentUserID(r),
+		Filename:   cleanFilename(filename),
+		MimeType:   mimeType,
+		Size:       int64(len(data)),
+	}
+	attachment.BlobKey, err = blobStore.Put(bytes.NewReader(data))
+	if err != nil {
+		log.Printf("Error storing upload: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to store the file")
+		return
+	}
+
+	if strings.HasPrefix(mimeType, "image/") {
+		thumbnail, width, height, err := storage.Thumbnail(data, thumbnailSize)
+		attachment.Width, attachment.Height = width, height
+		if err == nil {
+			attachment.ThumbnailKey, err = blobStore.Put(bytes.NewReader(thumbnail))
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotImage) {
+			log.Printf("Error making thumbnail: %v", err)
+		}
+	}
+
+	created, err := database.CreateAttachment(attachment)
+	if err != nil {
+		log.Printf("Error saving attachment: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to save the file")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(created)
+}
+
+// readUpload reads the file sent as the "file" field of a multipart form, with its name
+// It answers the request itself and returns false when there is no file or it is too large
+func readUpload(w http.ResponseWriter, r *http.Request) (string, []byte, bool) {
+	maxSize := int64(config.UploadMaxSize)
+	// Leave room for the multipart headers around the file
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+64<<10)
+
+	reader, err := r.MultipartReader()
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Expected a multipart/form-data body")
+		return "", nil, false
+	}
+
+	var filename string
+	var data []byte
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeUploadReadError(w, r, err)
+			return "", nil, false
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		filename = part.FileName()
+		data, err = io.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			writeUploadReadError(w, r, err)
+			return "", nil, false
+		}
+		break
+	}
+
+	if len(data) == 0 {
+		writeValidationError(w, r, []FieldError{{Field: "file", Error: ErrRequired}})
+		return "", nil, false
+	}
+	if int64(len(data)) > maxSize {
+		writeErrorCode(w, r, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, "The file is too large", map[string]int64{
+			"max_size": maxSize,
+		})
+		return "", nil, false
+	}
+	return filename, data, true
+}
+
+// writeUploadReadError answers a request whose upload could not be read
+func writeUploadReadError(w http.ResponseWriter, r *http.Request, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeErrorCode(w, r, http.StatusRequestEntityTooLarge, ErrCodeFileTooLarge, "The file is too large", map[string]int{
+			"max_size": config.UploadMaxSize,
+		})
+		return
+	}
+	writeError(w, r, http.StatusBadRequest, "Invalid multipart body")
+}
+
+// AttachmentHandler serves the file of an attachment at /attachments/{id},
+// or the thumbnail of an image at /attachments/{id}/thumbnail
+// The files of private messages are only served to the two users of the conversation,
+// and uploads not attached yet only to their uploader
+func AttachmentHandler(w http.ResponseWriter, r *http.Request) {
+	id, variant, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/attachments/"), "/")
+	if variant != "" && variant != "thumbnail" {
+		writeError(w, r, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	attachment, err := database.GetAttachment(id)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve attachment")
+		return
+	}
+
+	allowed, err := canViewAttachment(currentUserID(r), attachment)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve attachment")
+		return
+	}
+	// Hidden attachments are answered as missing so that their existence is not revealed
+	if !allowed {
+		writeError(w, r, http.StatusNotFound, "Attachment not found")
+		return
+	}
+
+	key, contentType := attachment.BlobKey, attachment.MimeType
+	if variant == "thumbnail" {
+		if attachment.ThumbnailKey == "" {
+			writeError(w, r, http.StatusNotFound, "Attachment has no thumbnail")
+			return
+		}
+		// Thumbnails of photos are JPEG, the others PNG
+		key, contentType = attachment.ThumbnailKey, "image/png"
+		if attachment.MimeType == "image/jpeg" {
+			contentType = "image/jpeg"
+		}
+	}
+
+	file, err := blobStore.Open(key)
+	if err != nil {
+		log.Printf("Error opening blob %s: %v", key, err)
+		writeError(w, r, http.StatusNotFound, "Attachment not found")
+		return
+	}
+	defer file.Close()
+
+	// Images are shown in the page, other files downloaded, and nothing served can run scripts
+	disposition := "attachment"
+	if strings.HasPrefix(contentType, "image/") {
+		disposition = "inline"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": attachment.Filename}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(w, r, "", attachment.CreatedAt, file)
+}
+
+// canViewAttachment reports whether a user can download an attachment
+func canViewAttachment(userID string, a *models.Attachment) (bool, error) {
+	switch a.TargetType {
+	case "":
+		return a.UploaderId == userID, nil
+	case "message":
+		senderID, receiverID, _, err := database.GetReportTarget("message", a.TargetId)
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return userID == senderID || userID == receiverID, err
+	default:
+		// Posts and comments can be read by every logged in user
+		return true, nil
+	}
+}
+
+// validateAttachments checks the uploads a user wants to attach to a new content
+// They must have been uploaded by the user and not be attached to anything yet
+func validateAttachments(userID string, ids []string) ([]FieldError, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	var v validator
+	if len(ids) > maxAttachments {
+		v.add("attachment_ids", ErrTooLong)
+		return v.errors, nil
+	}
+
+	unique := slices.Compact(slices.Sorted(slices.Values(ids)))
+	pending, err := database.CountPendingAttachments(userID, unique)
+	if err != nil {
+		return nil, err
+	}
+	if pending != len(unique) {
+		v.add("attachment_ids", ErrNotFound)
+	}
+	return v.errors, nil
+}
+
+// linkAttachments attaches checked uploads to a content that was just created
+// Failures are only logged, the content is kept without them
+func linkAttachments(userID string, ids []string, targetType, targetID string) []models.Attachment {
+	if len(ids) == 0 {
+		return nil
+	}
+	attachments, err := database.LinkAttachments(userID, ids, targetType, targetID)
+	if err != nil {
+		log.Printf("Error linking attachments to %s %s: %v", targetType, targetID, err)
+	}
+	return attachments
+}
+
+// cleanFilename keeps the base name of an uploaded file, without control characters,
+// quotes or path separators, shortened to maxFilenameLength characters
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == '"' || r == '/' {
+			return -1
+		}
+		return r
+	}, name)
+	if runes := []rune(name); len(runes) > maxFilenameLength {
+		name = string(runes[:maxFilenameLength])
+	}
+	if name == "" || name == "." {
+		return "file"
+	}
+	return name
+}
+
+// purgeAttachments deletes the attachments of deleted contents and the uploads never attached,
+// with the files no other attachment uses
+func purgeAttachments() {
+	keys, err := database.DeleteOrphanAttachments(time.Now().Add(-pendingUploadTTL))
+	if err != nil {
+		log.Printf("Error purging attachments: %v", err)
+		return
+	}
+	deleteBlobs(keys)
+	if len(keys) > 0 {
+		log.Printf("Purged %d unused files", len(keys))
+	}
+}
@@ -1,145 +1,228 @@
-package server
-
-import (
-	"encoding/json"
-	"fmt"
-	"net/http"
-	"time"
-
-	"Real-Time-Forum/database"
-	"Real-Time-Forum/models"
-	"Real-Time-Forum/shared"
-)
-
-// registerHandler handles user registration requests
-func registerHandler(w http.ResponseWriter, r *http.Request) {
-	var creds models.User
-
-	// Decode the JSON request body into the creds variable
-	err := json.NewDecoder(r.Body).Decode(&creds)
-	if err != nil {
-		// If decoding fails, respond with an error 400
-		http.Error(w, "Invalid data", http.StatusBadRequest)
-		return
-	}
-
-	IsUnique, _ := database.FindEmailUser(creds.Email)
-	if !IsUnique {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Email already in use"})
-		return
-	}
-
-	IsUniqueUsername, _ := database.FindUsername(creds.Username)
-	if !IsUniqueUsername {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusConflict)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Username already in use"})
-		return
-	}
-
-	// Attempt to register the user in the database.
-	err = database.RegisterUser(creds)
-	if err != nil {
-		// If registration fails, respond with an error 500
-		http.Error(w, "Error during registration", http.StatusInternalServerError)
-		return
-	}
-
-	// If registration is successful, respond with a 201 Created status
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{
-		"message": "User successfully registered",
-	})
-
-}
-
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	// Parse the request body into a User struct (we only need identifier and password)
-	var loginData struct {
-		Identifier string `json:"identifier"`
-		Password   string `json:"password"`
-	}
-
-	err := json.NewDecoder(r.Body).Decode(&loginData)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	// Validate required fields
-	if loginData.Identifier == "" || loginData.Password == "" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Email/username and password are required",
-		})
-		fmt.Println("Email/username and password are required")
-		return
-	}
-
-	// Authenticate the user
-	user, err := database.LoginUser(loginData.Identifier, loginData.Password)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": err.Error(),
-		})
-		return
-	}
-
-	// Generate a session ID
-	sessionID := shared.ParseUUID(shared.GenerateUUID())
-
-	// Define session duration
-	sessionDuration := 1 * time.Hour
-
-	// Save the session in the database
-	err = database.SaveSession(sessionID, user.Id, sessionDuration)
-	if err != nil {
-		http.Error(w, "Error creating session", http.StatusInternalServerError)
-		fmt.Println("Error creating session:", err)
-		return
-	}
-
-	// Set the session ID in a cookie
-	cookie := &http.Cookie{
-		Name:     "session_id",
-		Value:    sessionID,
-		HttpOnly: true,
-		Path:     "/",
-		MaxAge:   int(sessionDuration.Seconds()), // Same duration as session
-	}
-	http.SetCookie(w, cookie)
-
-	// Respond with user data (excluding password)
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(user)
-}
-
-func LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch the session ID from the cookie
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-	// Delete the session from the database
-	err = database.DeleteSession(cookie.Value)
-	if err != nil {
-		http.Error(w, "Error deleting session", http.StatusInternalServerError)
-		fmt.Println("Error deleting session:", err)
-		return
-	}
-	// Remove the session ID cookie from the user's browser
-	cookie.MaxAge = -1 // Set MaxAge to -1 to delete the cookie
-	cookie.Value = ""
-	http.SetCookie(w, cookie)
-	// Respond with a success message
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
-}
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/shared"
+)
+
+// registerHandler handles user registration requests
+func registerHandler(w http.ResponseWriter, r *http.Request) {
+	var creds models.User
+
+	// Decode the JSON request body into the creds variable
+	err := json.NewDecoder(r.Body).Decode(&creds)
+	if err != nil {
+		// If decoding fails, respond with an error 400
+		writeError(w, r, http.StatusBadRequest, "Invalid data")
+		return
+	}
+
+	if errs := validateUser(&creds); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	IsUnique, err := database.FindEmailUser(creds.Email)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error during registration")
+		return
+	}
+	if !IsUnique {
+		writeError(w, r, http.StatusConflict, "Email already in use")
+		return
+	}
+
+	IsUniqueUsername, err := database.FindUsername(creds.Username)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error during registration")
+		return
+	}
+	if !IsUniqueUsername {
+		writeError(w, r, http.StatusConflict, "Username already in use")
+		return
+	}
+
+	// Attempt to register the user in the database.
+	userID, err := database.RegisterUser(creds)
+	if err != nil {
+		// If registration fails, respond with an error 500
+		writeError(w, r, http.StatusInternalServerError, "Error during registration")
+		return
+	}
+
+	// The account can log in right away, but can't post until the address is confirmed
+	if err := sendVerificationEmail(userID, creds.Username, creds.Email); err != nil {
+		log.Printf("Error issuing verification token: %v", err)
+	}
+
+	// If registration is successful, respond with a 201 Created status
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "User successfully registered, check your email to confirm your address",
+	})
+
+}
+
+// Sessions expire after this much inactivity
+const (
+	sessionIdleTimeout    = 1 * time.Hour
+	rememberMeIdleTimeout = 30 * 24 * time.Hour
+)
+
+// setSessionCookie sets the session cookie in the response
+// Without "remember me" the cookie only lives as long as the browser session
+func setSessionCookie(w http.ResponseWriter, r *http.Request, sessionID string, rememberMe bool) {
+	cookie := &http.Cookie{
+		Name:     "session_id",
+		Value:    sessionID,
+		HttpOnly: true,
+		Secure:   useSecureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+	}
+	if rememberMe {
+		cookie.MaxAge = int(rememberMeIdleTimeout.Seconds())
+	}
+	http.SetCookie(w, cookie)
+}
+
+// clearSessionCookie removes the session cookie from the user's browser
+func clearSessionCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     "session_id",
+		Value:    "",
+		HttpOnly: true,
+		Secure:   useSecureCookies(r),
+		SameSite: http.SameSiteLaxMode,
+		Path:     "/",
+		MaxAge:   -1,
+	})
+}
+
+// useSecureCookies reports whether cookies must only be sent over HTTPS
+func useSecureCookies(r *http.Request) bool {
+	return config.SecureCookies || r.TLS != nil
+}
+
+func LoginHandler(w http.ResponseWriter, r *http.Request) {
+	// Parse the request body into a User struct (we only need identifier and password)
+	var loginData struct {
+		Identifier string `json:"identifier"`
+		Password   string `json:"password"`
+		RememberMe bool   `json:"remember_me"`
+	}
+
+	err := json.NewDecoder(r.Body).Decode(&loginData)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Validate required fields
+	if loginData.Identifier == "" || loginData.Password == "" {
+		writeError(w, r, http.StatusBadRequest, "Email/username and password are required")
+		fmt.Println("Email/username and password are required")
+		return
+	}
+
+	// Refuse to check the password of an identifier locked for this IP after too many failures
+	ip := clientIP(r)
+	if wait := failedLogins.lockedFor(loginData.Identifier, ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+		writeErrorCode(w, r, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many failed logins, try again later", nil)
+		return
+	}
+
+	// Authenticate the user
+	user, err := database.LoginUser(loginData.Identifier, loginData.Password)
+	if errors.Is(err, database.ErrUnknownIdentifier) || errors.Is(err, database.ErrInvalidPassword) {
+		failedLogins.fail(loginData.Identifier, ip)
+		writeError(w, r, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error during login")
+		log.Printf("Error during login: %v", err)
+		return
+	}
+
+	failedLogins.reset(loginData.Identifier, ip)
+
+	// Suspended users can't log in until the end of their suspension
+	suspendedUntil, err := database.GetSuspension(user.Id)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error during login")
+		return
+	}
+	if suspendedUntil != nil {
+		writeErrorCode(w, r, http.StatusForbidden, ErrCodeAccountSuspended,
+			"Your account is suspended until "+suspendedUntil.Format("2006-01-02 15:04 MST"),
+			map[string]time.Time{"suspended_until": *suspendedUntil})
+		return
+	}
+
+	// Generate a session ID
+	sessionID := shared.ParseUUID(shared.GenerateUUID())
+
+	// Define how long the session survives without activity
+	idleTimeout := sessionIdleTimeout
+	if loginData.RememberMe {
+		idleTimeout = rememberMeIdleTimeout
+	}
+
+	// Save the session in the database, with the CSRF token tied to it
+	csrfToken := shared.RandomToken(32)
+	err = database.SaveSession(models.Session{
+		Id:         sessionID,
+		CSRFToken:  csrfToken,
+		UserId:     user.Id,
+		UserAgent:  r.UserAgent(),
+		IPAddress:  clientIP(r),
+		RememberMe: loginData.RememberMe,
+	}, idleTimeout)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error creating session")
+		fmt.Println("Error creating session:", err)
+		return
+	}
+
+	// Set the session ID in a cookie
+	setSessionCookie(w, r, sessionID, loginData.RememberMe)
+	w.Header().Set(csrfHeader, csrfToken)
+
+	// Respond with user data (excluding password)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(user)
+}
+
+func LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionID := currentSessionID(r)
+
+	// Delete the session from the database
+	err := database.DeleteSession(sessionID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Error deleting session")
+		fmt.Println("Error deleting session:", err)
+		return
+	}
+	// Close the WebSocket connections opened with this session
+	closeSessionConnections(sessionID)
+
+	// Remove the session ID cookie from the user's browser
+	clearSessionCookie(w, r)
+	// Respond with a success message
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out successfully"})
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"log"
+	"net/http"
+)
+
+// roleRanks orders the roles by privileges, unknown roles have none
+var roleRanks = map[string]int{
+	models.RoleUser:      1,
+	models.RoleModerator: 2,
+	models.RoleAdmin:     3,
+}
+
+// hasRole reports whether a role grants at least the privileges of required
+func hasRole(role, required string) bool {
+	return roleRanks[role] >= roleRanks[required]
+}
+
+// outranks reports whether a user with role can act against a user with target
+// Moderators can't suspend other moderators, only admins can
+func outranks(role, target string) bool {
+	return roleRanks[role] > roleRanks[target]
+}
+
+// requireRole rejects logged in users whose role is below the required one
+// It is meant to be used on authenticated routes
+func requireRole(required string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		role, err := database.GetUserRole(currentUserID(r))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+			return
+		}
+		if !hasRole(role, required) {
+			writeError(w, r, http.StatusForbidden, "You are not allowed to do this")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// PromoteAdmins gives the admin role to the users listed in the configuration
+// It is how the first admins are named, they can then name the others through the API
+func PromoteAdmins() {
+	for _, username := range config.Admins {
+		user, err := database.GetUserByUsername(username)
+		if err != nil {
+			log.Printf("Cannot make %s an admin: %v", username, err)
+			continue
+		}
+		err = database.Transaction(func(tx *sql.Tx) error {
+			return database.SetUserRole(tx, user.Id, models.RoleAdmin)
+		})
+		if err != nil {
+			log.Printf("Cannot make %s an admin: %v", username, err)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/storage"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Sizes, in pixels, the avatars are stored and served at, asked for with ?size=
+var avatarSizes = map[string]int{
+	"small":  32,
+	"medium": 64,
+	"large":  256,
+}
+
+// Size served when the request does not name one
+const defaultAvatarSize = "medium"
+
+// Types of the images accepted as avatars, the ones the standard library can decode
+var avatarTypes = []string{"image/jpeg", "image/png", "image/gif"}
+
+// AvatarUploadHandler replaces the avatar of the current user with the image sent
+// as the "file" field of a multipart form, cropped to a square and resized to every size
+func AvatarUploadHandler(w http.ResponseWriter, r *http.Request) {
+	_, data, ok := readUpload(w, r)
+	if !ok {
+		return
+	}
+
+	sizes := []int{avatarSizes["small"], avatarSizes["medium"], avatarSizes["large"]}
+	images, mimeType, err := storage.Avatar(data, sizes)
+	if errors.Is(err, storage.ErrNotImage) {
+		writeErrorCode(w, r, http.StatusUnsupportedMediaType, ErrCodeUnsupportedFileType, "Avatars must be JPEG, PNG or GIF images",
+			map[string]interface{}{"allowed": avatarTypes})
+		return
+	}
+	if errors.Is(err, storage.ErrImageTooLarge) {
+		writeValidationError(w, r, []FieldError{{Field: "file", Error: ErrTooLong}})
+		return
+	}
+	if err != nil {
+		log.Printf("Error resizing avatar: %v", err)
+		writeValidationError(w, r, []FieldError{{Field: "file", Error: ErrInvalid}})
+		return
+	}
+
+	userID := currentUserID(r)
+	keys := make([]string, len(images))
+	for i, image := range images {
+		if keys[i], err = blobStore.Put(bytes.NewReader(image)); err != nil {
+			log.Printf("Error storing avatar: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to save avatar")
+			return
+		}
+	}
+
+	unused, err := database.SetAvatar(models.Avatar{
+		UserId:    userID,
+		SmallKey:  keys[0],
+		MediumKey: keys[1],
+		LargeKey:  keys[2],
+		MimeType:  mimeType,
+	})
+	if err != nil {
+		log.Printf("Error saving avatar: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to save avatar")
+		return
+	}
+	deleteBlobs(unused)
+	avatarChanged(w, r, userID)
+}
+
+// RemoveAvatarHandler brings back the identicon of the current user
+func RemoveAvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+	unused, err := database.DeleteAvatar(userID)
+	if err != nil {
+		log.Printf("Error deleting avatar: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to remove avatar")
+		return
+	}
+	deleteBlobs(unused)
+	avatarChanged(w, r, userID)
+}
+
+// avatarChanged sends the new avatar URL of a user, and tells the other users
+// to refresh their lists when the user is online
+func avatarChanged(w http.ResponseWriter, r *http.Request, userID string) {
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve user")
+		return
+	}
+	if isUserConnected(userID) {
+		broadcastUserStatus(user.Id, user.Username, "online")
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"avatar_url": user.AvatarURL})
+}
+
+// AvatarHandler serves the avatar of a user at /avatars/{user_id}?size=small|medium|large,
+// or their identicon when they have none
+// Versioned URLs, as given in the avatar_url of the users, can be cached for good,
+// the others are checked again after a few minutes
+func AvatarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := strings.TrimPrefix(r.URL.Path, "/avatars/")
+	sizeName := r.URL.Query().Get("size")
+	if sizeName == "" {
+		sizeName = defaultAvatarSize
+	}
+	size, ok := avatarSizes[sizeName]
+	if !ok {
+		writeValidationError(w, r, []FieldError{{Field: "size", Error: ErrInvalid}})
+		return
+	}
+
+	avatar, err := database.GetAvatar(userID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve avatar")
+		return
+	}
+
+	if version := r.URL.Query().Get("v"); version != "" && version == database.AvatarVersion(avatar) {
+		w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age=300")
+	}
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+
+	if avatar == nil {
+		identicon, err := storage.Identicon(userID, size)
+		if err != nil {
+			log.Printf("Error drawing identicon: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to retrieve avatar")
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"identicon-`+sizeName+`"`)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(identicon))
+		return
+	}
+
+	key := map[string]string{"small": avatar.SmallKey, "medium": avatar.MediumKey, "large": avatar.LargeKey}[sizeName]
+	file, err := blobStore.Open(key)
+	if err != nil {
+		log.Printf("Error opening blob %s: %v", key, err)
+		writeError(w, r, http.StatusNotFound, "Avatar not found")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", avatar.MimeType)
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, "", avatar.UpdatedAt, file)
+}
+
+// deleteBlobs removes files nothing refers to anymore from the blob store
+func deleteBlobs(keys []string) {
+	for _, key := range keys {
+		if err := blobStore.Delete(key); err != nil {
+			log.Printf("Error deleting blob %s: %v", key, err)
+		}
+	}
+}
+
+// purgeAvatars deletes the avatars of deleted accounts with their files
+func purgeAvatars() {
+	keys, err := database.DeleteOrphanAvatars()
+	if err != nil {
+		log.Printf("Error purging avatars: %v", err)
+		return
+	}
+	deleteBlobs(keys)
+}
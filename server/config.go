@@ -0,0 +1,257 @@
+package server
+
+import (
+	"Real-Time-Forum/shared"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings that can be changed through environment variables
+type Config struct {
+	// Mark cookies as Secure, to enable when the forum is served over HTTPS (FORUM_SECURE_COOKIES)
+	SecureCookies bool
+	// Origins allowed to call the API and open WebSockets besides the server's own host,
+	// as a comma separated list like "https://forum.example.com" (FORUM_ALLOWED_ORIGINS)
+	AllowedOrigins []string
+
+	// Limits of each rate limited action, overridable with FORUM_RATE_LIMIT_<ACTION>
+	// using the "<count>/<s|m|h>[:burst]" format, like FORUM_RATE_LIMIT_POST=5/m:10
+	RateLimits map[string]RateLimit
+	// Failed logins allowed for an identifier from an IP before it gets locked for that IP (FORUM_LOGIN_MAX_FAILURES)
+	LoginMaxFailures int
+	// Failed logins allowed for an identifier from all IPs together before it gets locked for every IP
+	// (FORUM_LOGIN_ACCOUNT_MAX_FAILURES)
+	LoginAccountMaxFailures int
+	// How long an identifier stays locked after too many failures (FORUM_LOGIN_LOCKOUT)
+	LoginLockout time.Duration
+	// Rate limited WebSocket frames tolerated before the socket is closed (FORUM_WS_MAX_VIOLATIONS)
+	WSMaxViolations int
+
+	// Address of the forum as seen by users, used in the links sent by email (FORUM_PUBLIC_URL)
+	PublicURL string
+	// Key signing the tokens sent by email (FORUM_TOKEN_SECRET)
+	// A random key is generated when unset, tokens then stop working when the server restarts
+	TokenSecret []byte
+	// How emails are delivered: "file" writes them to MailDir or the log, "smtp" sends them (FORUM_MAIL_DRIVER)
+	MailDriver string
+	// Directory receiving the emails of the file driver, the log is used when empty (FORUM_MAIL_DIR)
+	MailDir string
+	// Sender address of the emails (FORUM_MAIL_FROM)
+	MailFrom string
+	// SMTP server of the smtp driver (FORUM_SMTP_HOST, FORUM_SMTP_PORT, FORUM_SMTP_USERNAME, FORUM_SMTP_PASSWORD)
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+
+	// Usernames given the admin role when the server starts, as a comma separated list (FORUM_ADMINS)
+	Admins []string
+
+	// Words refused, replaced with asterisks, or published but kept for the moderators to review,
+	// as comma separated lists (FORUM_FILTER_BLOCK_WORDS, FORUM_FILTER_MASK_WORDS, FORUM_FILTER_FLAG_WORDS)
+	FilterBlockWords []string
+	FilterMaskWords  []string
+	FilterFlagWords  []string
+	// Accounts younger than this get the spam checks below (FORUM_FILTER_NEW_ACCOUNT_AGE)
+	FilterNewAccountAge time.Duration
+	// Links a new account can put in a content before it is flagged (FORUM_FILTER_MAX_LINKS)
+	FilterMaxLinks int
+	// A new account repeating a text it wrote within this window is blocked (FORUM_FILTER_DUPLICATE_WINDOW)
+	FilterDuplicateWindow time.Duration
+
+	// Base64url encoded P-256 private key signing the Web Push messages (FORUM_VAPID_PRIVATE_KEY)
+	// A random key is generated when unset, browsers then have to subscribe again when the server restarts
+	VAPIDPrivateKey string
+	// Contact given to the push services, a mailto: or https: URL (FORUM_VAPID_SUBJECT)
+	VAPIDSubject string
+	// Accept push endpoints served over plain HTTP or on the local network,
+	// to test against a local push service (FORUM_PUSH_ALLOW_HTTP)
+	PushAllowHTTP bool
+
+	// How often the users due for their daily or weekly email digest are looked for,
+	// digests are disabled when it is 0 (FORUM_DIGEST_INTERVAL)
+	DigestInterval time.Duration
+
+	// Directory of the disk store keeping the uploaded files (FORUM_UPLOAD_DIR)
+	UploadDir string
+	// Largest file that can be uploaded, in bytes (FORUM_UPLOAD_MAX_SIZE)
+	UploadMaxSize int
+	// Types of the files that can be uploaded, as detected from their content,
+	// as a comma separated list of MIME types, common images, PDF and text when unset (FORUM_UPLOAD_TYPES)
+	UploadTypes []string
+
+	// Longest wait for a linked page when building its preview, previews are disabled when it is 0 (FORUM_PREVIEW_TIMEOUT)
+	PreviewTimeout time.Duration
+	// Bytes of a linked page read at most, its tags are in its head (FORUM_PREVIEW_MAX_SIZE)
+	PreviewMaxSize int
+	// Fetch the pages of the local network too, to test against a local server (FORUM_PREVIEW_ALLOW_PRIVATE)
+	PreviewAllowPrivate bool
+}
+
+// RateLimit allows Count events per Period, with bursts of up to Burst events
+type RateLimit struct {
+	Count  int
+	Period time.Duration
+	Burst  int
+}
+
+// config is loaded once from the environment when the server starts
+var config = loadConfig()
+
+// loadConfig reads the configuration from the environment, falling back to defaults
+func loadConfig() Config {
+	rateLimits := make(map[string]RateLimit, len(defaultRateLimits))
+	for action, fallback := range defaultRateLimits {
+		rateLimits[action] = envRateLimit("FORUM_RATE_LIMIT_"+strings.ToUpper(action), fallback)
+	}
+
+	return Config{
+		SecureCookies:           envBool("FORUM_SECURE_COOKIES", false),
+		AllowedOrigins:          envList("FORUM_ALLOWED_ORIGINS"),
+		RateLimits:              rateLimits,
+		LoginMaxFailures:        envInt("FORUM_LOGIN_MAX_FAILURES", 5),
+		LoginAccountMaxFailures: envInt("FORUM_LOGIN_ACCOUNT_MAX_FAILURES", 20),
+		LoginLockout:            envDuration("FORUM_LOGIN_LOCKOUT", 15*time.Minute),
+		WSMaxViolations:         envInt("FORUM_WS_MAX_VIOLATIONS", 20),
+		PublicURL:               strings.TrimSuffix(envString("FORUM_PUBLIC_URL", "http://localhost:8080"), "/"),
+		TokenSecret:             envSecret("FORUM_TOKEN_SECRET"),
+		MailDriver:              envString("FORUM_MAIL_DRIVER", "file"),
+		MailDir:                 os.Getenv("FORUM_MAIL_DIR"),
+		MailFrom:                envString("FORUM_MAIL_FROM", "no-reply@localhost"),
+		SMTPHost:                os.Getenv("FORUM_SMTP_HOST"),
+		SMTPPort:                envInt("FORUM_SMTP_PORT", 587),
+		SMTPUsername:            os.Getenv("FORUM_SMTP_USERNAME"),
+		SMTPPassword:            os.Getenv("FORUM_SMTP_PASSWORD"),
+		Admins:                  envList("FORUM_ADMINS"),
+
+		FilterBlockWords:      envList("FORUM_FILTER_BLOCK_WORDS"),
+		FilterMaskWords:       envList("FORUM_FILTER_MASK_WORDS"),
+		FilterFlagWords:       envList("FORUM_FILTER_FLAG_WORDS"),
+		FilterNewAccountAge:   envDuration("FORUM_FILTER_NEW_ACCOUNT_AGE", 24*time.Hour),
+		FilterMaxLinks:        envInt("FORUM_FILTER_MAX_LINKS", 2),
+		FilterDuplicateWindow: envDuration("FORUM_FILTER_DUPLICATE_WINDOW", 10*time.Minute),
+
+		VAPIDPrivateKey: os.Getenv("FORUM_VAPID_PRIVATE_KEY"),
+		VAPIDSubject:    envString("FORUM_VAPID_SUBJECT", "mailto:"+envString("FORUM_MAIL_FROM", "no-reply@localhost")),
+		PushAllowHTTP:   envBool("FORUM_PUSH_ALLOW_HTTP", false),
+
+		DigestInterval: envDuration("FORUM_DIGEST_INTERVAL", time.Hour),
+
+		UploadDir:     envString("FORUM_UPLOAD_DIR", "uploads"),
+		UploadMaxSize: envInt("FORUM_UPLOAD_MAX_SIZE", 5<<20),
+		UploadTypes:   envList("FORUM_UPLOAD_TYPES"),
+
+		PreviewTimeout:      envDuration("FORUM_PREVIEW_TIMEOUT", 5*time.Second),
+		PreviewMaxSize:      envInt("FORUM_PREVIEW_MAX_SIZE", 512<<10),
+		PreviewAllowPrivate: envBool("FORUM_PREVIEW_ALLOW_PRIVATE", false),
+	}
+}
+
+// envString reads a string environment variable
+func envString(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+// envSecret reads a secret key, or generates a random one when the variable is unset
+func envSecret(name string) []byte {
+	if value := os.Getenv(name); value != "" {
+		return []byte(value)
+	}
+	log.Printf("%s is not set, using a random key: links sent by email will not survive a restart", name)
+	return []byte(shared.RandomToken(32))
+}
+
+// envInt reads an integer environment variable
+func envInt(name string, fallback int) int {
+	value, err := strconv.Atoi(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envDuration reads a duration environment variable like "15m" or "1h30m"
+func envDuration(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envRateLimit reads a rate limit environment variable like "10/m" or "10/m:20"
+func envRateLimit(name string, fallback RateLimit) RateLimit {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+
+	limit, err := parseRateLimit(value)
+	if err != nil {
+		log.Printf("Ignoring %s: %v", name, err)
+		return fallback
+	}
+	return limit
+}
+
+// parseRateLimit parses the "<count>/<s|m|h>[:burst]" format
+func parseRateLimit(value string) (RateLimit, error) {
+	var limit RateLimit
+
+	rate, burst, hasBurst := strings.Cut(value, ":")
+	count, unit, ok := strings.Cut(rate, "/")
+	if !ok {
+		return limit, fmt.Errorf("invalid rate limit %q", value)
+	}
+
+	var err error
+	if limit.Count, err = strconv.Atoi(count); err != nil || limit.Count <= 0 {
+		return limit, fmt.Errorf("invalid count in rate limit %q", value)
+	}
+
+	switch unit {
+	case "s":
+		limit.Period = time.Second
+	case "m":
+		limit.Period = time.Minute
+	case "h":
+		limit.Period = time.Hour
+	default:
+		return limit, fmt.Errorf("invalid period in rate limit %q", value)
+	}
+
+	limit.Burst = limit.Count
+	if hasBurst {
+		if limit.Burst, err = strconv.Atoi(burst); err != nil || limit.Burst <= 0 {
+			return limit, fmt.Errorf("invalid burst in rate limit %q", value)
+		}
+	}
+	return limit, nil
+}
+
+// envBool reads a boolean environment variable
+func envBool(name string, fallback bool) bool {
+	value, err := strconv.ParseBool(os.Getenv(name))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// envList reads a comma separated environment variable
+func envList(name string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(name), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
@@ -0,0 +1,77 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"crypto/subtle"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Header carrying the CSRF token of the session, in responses and state-changing requests
+const csrfHeader = "X-CSRF-Token"
+
+// ErrCodeCSRF is returned when a request fails the CSRF or origin checks
+const ErrCodeCSRF = "csrf_failed"
+
+// verifyCSRF protects state-changing requests against cross-site forgery:
+// requests coming from a foreign origin are rejected, and requests made
+// with a session must carry the session's token in the X-CSRF-Token header
+func verifyCSRF(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			next(w, r)
+			return
+		}
+
+		if !isAllowedOrigin(r) {
+			writeErrorCode(w, r, http.StatusForbidden, ErrCodeCSRF, "Origin not allowed", nil)
+			return
+		}
+
+		if sessionID := currentSessionID(r); sessionID != "" {
+			session, err := database.GetSession(sessionID)
+			if err != nil {
+				writeError(w, r, http.StatusUnauthorized, "Invalid session")
+				return
+			}
+
+			token := r.Header.Get(csrfHeader)
+			if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				writeErrorCode(w, r, http.StatusForbidden, ErrCodeCSRF, "Missing or invalid CSRF token", nil)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// isSafeMethod reports whether a method is read-only and needs no CSRF check
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// isAllowedOrigin checks the Origin header against the server's own host and
+// the configured allowlist; requests without Origin (non-browser clients) are allowed
+func isAllowedOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range config.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,215 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/mailer"
+	"Real-Time-Forum/models"
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"slices"
+	texttemplate "text/template"
+	"time"
+)
+
+// Templates of the digest email, in plain text and HTML
+//
+//go:embed templates/digest.txt templates/digest.html
+var digestTemplateFiles embed.FS
+
+var (
+	digestTextTemplate = texttemplate.Must(texttemplate.ParseFS(digestTemplateFiles, "templates/digest.txt"))
+	digestHTMLTemplate = htmltemplate.Must(htmltemplate.ParseFS(digestTemplateFiles, "templates/digest.html"))
+)
+
+// Sections of the digest, in the order they are shown, with the notifications they list
+var digestSections = []struct {
+	title string
+	types []string
+}{
+	{"Private messages", []string{models.NotifyMessage}},
+	{"Replies", []string{models.NotifyPostReply, models.NotifyCommentReply}},
+	{"Mentions", []string{models.NotifyMention}},
+}
+
+// Most notifications listed in each section, the others are only counted
+const digestSectionLength = 10
+
+// digestItem is a notification as listed in the digest
+type digestItem struct {
+	Title   string
+	Excerpt string
+	Time    string
+}
+
+// digestSection is a titled list of notifications of the digest
+type digestSection struct {
+	Title string
+	Items []digestItem
+	More  int // notifications left out of the list
+}
+
+// digestData is what the digest templates render
+type digestData struct {
+	Username    string
+	Since       string
+	Frequency   string
+	Sections    []digestSection
+	URL         string
+	SettingsURL string
+}
+
+// DigestHandler returns (GET) or changes (POST) how often the current user gets the email digest
+func DigestHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodPost {
+		var req struct {
+			Frequency string `json:"frequency"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid request body")
+			return
+		}
+
+		var v validator
+		switch req.Frequency {
+		case "":
+			v.add("frequency", ErrRequired)
+		case models.DigestOff, models.DigestDaily, models.DigestWeekly:
+		default:
+			v.add("frequency", ErrInvalid)
+		}
+		if len(v.errors) > 0 {
+			writeValidationError(w, r, v.errors)
+			return
+		}
+
+		if err := database.SetDigestFrequency(userID, req.Frequency); err != nil {
+			log.Printf("Error saving digest frequency: %v", err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update digest settings")
+			return
+		}
+	}
+
+	settings, err := database.GetDigestSettings(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get digest settings")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(settings)
+}
+
+// StartDigestScheduler periodically emails their digest to the users whose period ended
+// It blocks forever and is meant to be run in its own goroutine, it returns at once when
+// digests are disabled
+func StartDigestScheduler() {
+	if config.DigestInterval <= 0 {
+		log.Printf("FORUM_DIGEST_INTERVAL is not positive, email digests are disabled")
+		return
+	}
+
+	ticker := time.NewTicker(config.DigestInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		sendDigests(now)
+	}
+}
+
+// sendDigests emails the users due for a digest the unread activity of their period
+// Users with nothing unread get no email, but their period still ends
+// Emails are sent one after the other, and a digest that could not be sent is tried again on the next tick
+func sendDigests(now time.Time) {
+	digests, err := database.GetDueDigests(now)
+	if err != nil {
+		log.Printf("Error getting due digests: %v", err)
+		return
+	}
+
+	sent := 0
+	for _, digest := range digests {
+		digest.Notifications, err = database.GetDigestNotifications(digest.UserId, digest.Since, now)
+		if err != nil {
+			log.Printf("Error getting digest of %s: %v", digest.UserId, err)
+			continue
+		}
+
+		if len(digest.Notifications) > 0 {
+			msg, err := renderDigest(digest)
+			if err != nil {
+				log.Printf("Error rendering digest of %s: %v", digest.UserId, err)
+				continue
+			}
+			if err := emailSender.Send(msg); err != nil {
+				log.Printf("Error sending digest to %s: %v", msg.To, err)
+				continue
+			}
+			sent++
+		}
+
+		if err := database.MarkDigestSent(digest.UserId, now); err != nil {
+			log.Printf("Error recording digest of %s: %v", digest.UserId, err)
+		}
+	}
+	if sent > 0 {
+		log.Printf("Sent %d email digests", sent)
+	}
+}
+
+// renderDigest builds the email of a digest in plain text and HTML
+func renderDigest(digest models.Digest) (mailer.Message, error) {
+	data := digestData{
+		Username:    digest.Username,
+		Since:       digest.Since.Format("January 2, 15:04"),
+		Frequency:   digest.Frequency,
+		URL:         config.PublicURL + "/",
+		SettingsURL: config.PublicURL + "/#settings",
+	}
+
+	for _, section := range digestSections {
+		s := digestSection{Title: section.title}
+		for _, n := range digest.Notifications {
+			if !slices.Contains(section.types, n.Type) {
+				continue
+			}
+			if len(s.Items) == digestSectionLength {
+				s.More++
+				continue
+			}
+			s.Items = append(s.Items, digestItem{
+				Title:   fmt.Sprintf(pushTitles[n.Type], n.Actor),
+				Excerpt: n.Excerpt,
+				Time:    n.CreatedAt.Format("Jan 2, 15:04"),
+			})
+		}
+		if len(s.Items) > 0 {
+			data.Sections = append(data.Sections, s)
+		}
+	}
+
+	var text, html bytes.Buffer
+	if err := digestTextTemplate.Execute(&text, data); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := digestHTMLTemplate.Execute(&html, data); err != nil {
+		return mailer.Message{}, err
+	}
+
+	subject := fmt.Sprintf("Your %s forum digest: %d unread notifications", digest.Frequency, len(digest.Notifications))
+	if len(digest.Notifications) == 1 {
+		subject = fmt.Sprintf("Your %s forum digest: 1 unread notification", digest.Frequency)
+	}
+
+	return mailer.Message{
+		To:      digest.Email,
+		Subject: subject,
+		Body:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
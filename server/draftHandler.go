@@ -0,0 +1,171 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// Drafts left untouched for this long are deleted
+const draftTTL = 30 * 24 * time.Hour
+
+// DraftsHandler lists the drafts of the current user, so that a page can fill its forms when it loads
+// Drafts are saved over the WebSocket with draft_save events
+func DraftsHandler(w http.ResponseWriter, r *http.Request) {
+	drafts, err := database.GetDrafts(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get drafts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drafts)
+}
+
+// handleDraftSave saves a draft sent by a client, or deletes it when it was emptied
+// The other connections of the user get the change, so that every open tab shows the same text
+func handleDraftSave(conn *websocket.Conn, userID string, rawMessage []byte) {
+	var draft models.Draft
+	if err := json.Unmarshal(rawMessage, &draft); err != nil {
+		log.Printf("Error decoding draft: %v", err)
+		return
+	}
+
+	errs, err := validateDraft(userID, &draft)
+	if err != nil {
+		log.Printf("Error checking draft: %v", err)
+		return
+	}
+	if len(errs) > 0 {
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeValidation,
+			"message_type": DraftSave,
+			"errors":       errs,
+		})
+		return
+	}
+
+	if strings.TrimSpace(draft.Title) == "" && strings.TrimSpace(draft.Content) == "" {
+		if _, err := database.DeleteDraft(userID, draft.TargetType, draft.TargetId); err != nil {
+			log.Printf("Error deleting draft: %v", err)
+			return
+		}
+		sendToOtherConns(userID, conn, draftDeletedEvent(draft.TargetType, draft.TargetId))
+		return
+	}
+
+	draft.UpdatedAt = time.Now()
+	if err := database.SaveDraft(userID, draft); err != nil {
+		log.Printf("Error saving draft: %v", err)
+		return
+	}
+	sendToOtherConns(userID, conn, map[string]interface{}{
+		"type":  DraftUpdated,
+		"draft": draft,
+	})
+}
+
+// validateDraft checks the target and the length of a draft
+// Titles are only kept for posts, and the post commented or the other user of the conversation must exist
+func validateDraft(userID string, draft *models.Draft) ([]FieldError, error) {
+	var v validator
+	maxLength := 0
+	switch draft.TargetType {
+	case "":
+		v.add("target_type", ErrRequired)
+	case database.DraftPost:
+		maxLength = postContentMaxLength
+		if draft.TargetId != "" {
+			v.add("target_id", ErrInvalid)
+		}
+	case database.DraftComment:
+		maxLength = commentMaxLength
+		if draft.TargetId == "" {
+			v.add("target_id", ErrRequired)
+		} else if _, err := database.GetPostByID(database.DB, draft.TargetId); err == sql.ErrNoRows {
+			v.add("target_id", ErrNotFound)
+		} else if err != nil {
+			return nil, err
+		}
+	case database.DraftMessage:
+		maxLength = messageMaxLength
+		if draft.TargetId == "" {
+			v.add("target_id", ErrRequired)
+		} else if draft.TargetId == userID {
+			v.add("target_id", ErrInvalid)
+		} else if user, err := database.GetUserByID(draft.TargetId); err != nil || user.Username == database.DeletedUsername {
+			v.add("target_id", ErrNotFound)
+		}
+	default:
+		v.add("target_type", ErrInvalid)
+	}
+
+	if draft.TargetType != database.DraftPost {
+		draft.Title = ""
+	}
+	if utf8.RuneCountInString(draft.Title) > postTitleMaxLength {
+		v.add("title", ErrTooLong)
+	}
+	if utf8.RuneCountInString(draft.Content) > maxLength && maxLength > 0 {
+		v.add("content", ErrTooLong)
+	}
+	return v.errors, nil
+}
+
+// clearDraft deletes the draft of a content that was just sent, and tells every connection of the user
+func clearDraft(userID, targetType, targetID string) {
+	removed, err := database.DeleteDraft(userID, targetType, targetID)
+	if err != nil {
+		log.Printf("Error deleting draft: %v", err)
+		return
+	}
+	if removed {
+		sendToUser(userID, draftDeletedEvent(targetType, targetID))
+	}
+}
+
+// draftDeletedEvent is the event telling that a draft was sent or emptied
+func draftDeletedEvent(targetType, targetID string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        DraftDeleted,
+		"target_type": targetType,
+		"target_id":   targetID,
+	}
+}
+
+// sendToOtherConns sends a JSON message to every connection of a user but one
+func sendToOtherConns(userID string, except *websocket.Conn, message interface{}) {
+	connectionsLock.Lock()
+	var recipients []*websocket.Conn
+	for _, c := range connections {
+		if c.UserID == userID && c.Conn != except {
+			recipients = append(recipients, c.Conn)
+		}
+	}
+	connectionsLock.Unlock()
+
+	for _, conn := range recipients {
+		sendToConn(conn, message)
+	}
+}
+
+// purgeDrafts deletes the drafts left untouched for too long and those of deleted posts
+func purgeDrafts() {
+	removed, err := database.DeleteOldDrafts(time.Now().Add(-draftTTL))
+	if err != nil {
+		log.Printf("Error purging drafts: %v", err)
+		return
+	}
+	if removed > 0 {
+		log.Printf("Purged %d drafts", removed)
+	}
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Error codes returned in the error envelope
+const (
+	ErrCodeBadRequest       = "bad_request"
+	ErrCodeUnauthorized     = "unauthorized"
+	ErrCodeForbidden        = "forbidden"
+	ErrCodeNotFound         = "not_found"
+	ErrCodeMethodNotAllowed = "method_not_allowed"
+	ErrCodeConflict         = "conflict"
+	ErrCodeInternal         = "internal_error"
+)
+
+// APIError is the body of every error response of the API:
+//
+//	{"error": {"code": "not_found", "message": "Post not found", "request_id": "..."}}
+type APIError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	Details   interface{} `json:"details,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// writeError sends an error response whose code is derived from the HTTP status
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	writeErrorCode(w, r, status, errorCodeForStatus(status), message, nil)
+}
+
+// writeErrorCode sends an error response with an explicit code and optional details
+func writeErrorCode(w http.ResponseWriter, r *http.Request, status int, code, message string, details interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]APIError{
+		"error": {
+			Code:      code,
+			Message:   message,
+			Details:   details,
+			RequestID: requestID(r),
+		},
+	})
+}
+
+// errorCodeForStatus returns the default error code of an HTTP status
+func errorCodeForStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrCodeBadRequest
+	case http.StatusUnauthorized:
+		return ErrCodeUnauthorized
+	case http.StatusForbidden:
+		return ErrCodeForbidden
+	case http.StatusNotFound:
+		return ErrCodeNotFound
+	case http.StatusMethodNotAllowed:
+		return ErrCodeMethodNotAllowed
+	case http.StatusConflict:
+		return ErrCodeConflict
+	default:
+		return ErrCodeInternal
+	}
+}
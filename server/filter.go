@@ -0,0 +1,123 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/filter"
+	"Real-Time-Forum/models"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrCodeContentRejected is the error code of a content refused by the content filter
+const ErrCodeContentRejected = "content_rejected"
+
+// Shortest text, in characters, that a new account is refused to repeat
+const minDuplicateLength = 20
+
+// contentFilter checks every post, comment and private message before it is saved
+var contentFilter = filter.Pipeline{
+	filter.NewWordFilter(config.FilterBlockWords, config.FilterMaskWords, config.FilterFlagWords),
+	filter.LinkFilter{MaxLinks: config.FilterMaxLinks, NewAccountAge: config.FilterNewAccountAge},
+	filter.DuplicateFilter{
+		Window:        config.FilterDuplicateWindow,
+		NewAccountAge: config.FilterNewAccountAge,
+		MinLength:     minDuplicateLength,
+		Recent:        database.GetRecentContent,
+	},
+}
+
+// filterMatch is a broken rule as shown to the author and the moderators
+type filterMatch struct {
+	Rule   string `json:"rule"`
+	Action string `json:"action"`
+	Detail string `json:"detail"`
+}
+
+// checkContent runs the content filter on the fields of a content a user is about to publish
+// The fields are rewritten in place when words are masked
+func checkContent(authorID, kind string, fields ...*string) (filter.Result, error) {
+	author, err := database.GetUserByID(authorID)
+	if err != nil {
+		return filter.Result{}, err
+	}
+
+	var result filter.Result
+	for _, field := range fields {
+		fieldResult := contentFilter.Run(filter.Content{
+			Kind:       kind,
+			AuthorID:   authorID,
+			AccountAge: time.Since(author.CreationDate),
+			Text:       *field,
+		})
+		*field = fieldResult.Text
+		result = result.Merge(fieldResult)
+	}
+	return result, nil
+}
+
+// recordFilteredContent keeps a content the filter did not allow as is, for the moderators to review
+// targetID is empty when the content was blocked. Flagged and blocked contents are pushed to the moderators online
+func recordFilteredContent(authorID, kind, targetID, original string, result filter.Result) {
+	if result.Action == filter.Allow {
+		return
+	}
+
+	entry, err := database.RecordFilteredContent(models.FilteredContent{
+		AuthorId: authorID,
+		Kind:     kind,
+		TargetId: targetID,
+		Action:   result.Action.String(),
+		Rules:    result.Rules(),
+		Original: original,
+	})
+	if err != nil {
+		log.Printf("Error recording filtered content: %v", err)
+		return
+	}
+
+	if result.Action >= filter.Flag {
+		sendToModerators(map[string]interface{}{
+			"type":  ContentFiltered,
+			"entry": entry,
+		})
+	}
+}
+
+// filterMatches converts the matches of a result for the JSON responses
+func filterMatches(result filter.Result) []filterMatch {
+	matches := make([]filterMatch, 0, len(result.Matches))
+	for _, m := range result.Matches {
+		matches = append(matches, filterMatch{Rule: m.Rule, Action: m.Action.String(), Detail: m.Detail})
+	}
+	return matches
+}
+
+// writeContentRejected answers a request whose content was blocked by the filter
+func writeContentRejected(w http.ResponseWriter, r *http.Request, result filter.Result) {
+	writeErrorCode(w, r, http.StatusUnprocessableEntity, ErrCodeContentRejected,
+		"Your content was rejected by the content filter", filterMatches(result))
+}
+
+// FilteredContentHandler lists the contents changed or stopped by the filter for moderators, most recent first
+// They can be filtered with the action query parameter ("mask", "flag" or "block")
+func FilteredContentHandler(w http.ResponseWriter, r *http.Request) {
+	action := r.URL.Query().Get("action")
+	switch action {
+	case "", filter.Mask.String(), filter.Flag.String(), filter.Block.String():
+	default:
+		writeValidationError(w, r, []FieldError{{Field: "action", Error: ErrInvalid}})
+		return
+	}
+
+	page, limit := pageParams(r, 20, 100)
+	entries, err := database.GetFilteredContent(action, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get filtered content")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
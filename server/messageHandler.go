@@ -1,123 +1,219 @@
-package server
-
-import (
-	"Real-Time-Forum/database"
-	"Real-Time-Forum/models"
-	"encoding/json"
-	"log"
-	"net/http"
-	"strconv"
-	"time"
-
-	"github.com/gorilla/websocket"
-)
-
-// Handle incoming private messages
-func handlePrivateMessage(conn *websocket.Conn, userID string, rawMessage []byte) {
-	var msg models.Message
-	if err := json.Unmarshal(rawMessage, &msg); err != nil {
-		log.Printf("Error unmarshaling private message: %v", err)
-		return
-	}
-
-	// Validate the message
-	if msg.ReceiverID == "" || msg.Content == "" {
-		log.Println("Invalid private message format")
-		return
-	}
-
-	// Save to database
-	err := database.SavePrivateMessage(userID, msg.ReceiverID, msg.Content)
-	if err != nil {
-		log.Printf("Error saving private message: %v", err)
-		return
-	}
-
-	// Broadcast to the recipient if online
-	connectionsLock.Lock()
-	defer connectionsLock.Unlock()
-
-	for _, c := range connections {
-		if c.UserID == msg.ReceiverID {
-			// Prepare the message to send
-			response := map[string]interface{}{
-				"type":      PrivateMessage,
-				"sender_id": userID,
-				"content":   msg.Content,
-				"sent_at":   time.Now().UnixNano() / int64(time.Millisecond),
-			}
-
-			responseJSON, _ := json.Marshal(response)
-			if err := c.Conn.WriteMessage(websocket.TextMessage, responseJSON); err != nil {
-				log.Printf("Error sending private message: %v", err)
-			}
-			break
-		}
-	}
-}
-
-func MessagesHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-
-	// Vérification de l'authentification
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
-		return
-	}
-
-	userID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid session"})
-		return
-	}
-
-	counterpartID := r.URL.Query().Get("user_id")
-	if counterpartID == "" {
-		w.WriteHeader(http.StatusBadRequest)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Missing user_id parameter"})
-		return
-	}
-
-	// Récupération des paramètres de pagination
-	page := 1
-	limit := 10
-
-	if p := r.URL.Query().Get("page"); p != "" {
-		if pInt, err := strconv.Atoi(p); err == nil && pInt > 0 {
-			page = pInt
-		}
-	}
-
-	if l := r.URL.Query().Get("limit"); l != "" {
-		if lInt, err := strconv.Atoi(l); err == nil && lInt > 0 {
-			limit = lInt
-			if limit > 100 {
-				limit = 100
-			}
-		}
-	}
-
-	// Appel à la fonction de base de données avec pagination
-	messages, err := database.GetPrivateMessages(userID, counterpartID, page, limit)
-	if err != nil {
-		log.Printf("Erreur DB: %v", err)
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Database error"})
-		return
-	}
-
-	if len(messages) == 0 {
-		log.Printf("Aucun message trouvé pour %s et %s", userID, counterpartID)
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode([]interface{}{}) // Retourne un tableau vide au lieu de null
-		return
-	}
-
-	if err := json.NewEncoder(w).Encode(messages); err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to encode response"})
-	}
-}
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/filter"
+	"Real-Time-Forum/models"
+	"encoding/json"
+	"log"
+	"maps"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Error codes of refused private messages
+const (
+	ErrCodeMessagesNotAllowed = "messages_not_allowed" // the receiver only accepts messages from the users they talked with
+	ErrCodeBlocked            = "blocked"              // one of the two users blocked the other
+)
+
+// Handle incoming private messages
+func handlePrivateMessage(conn *websocket.Conn, userID string, rawMessage []byte) {
+	var msg models.Message
+	if err := json.Unmarshal(rawMessage, &msg); err != nil {
+		log.Printf("Error unmarshaling private message: %v", err)
+		return
+	}
+
+	// Validate the message, the sender is told which fields were rejected
+	errs := validateMessage(userID, &msg)
+	attachmentErrs, err := validateAttachments(userID, msg.AttachmentIds)
+	if err != nil {
+		log.Printf("Error checking attachments: %v", err)
+		return
+	}
+	errs = append(errs, attachmentErrs...)
+	if len(errs) == 0 {
+		if _, err := database.GetUserByID(msg.ReceiverID); err != nil {
+			errs = append(errs, FieldError{Field: "receiver_id", Error: ErrNotFound})
+		}
+	}
+	if len(errs) > 0 {
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeValidation,
+			"message_type": PrivateMessage,
+			"errors":       errs,
+		})
+		return
+	}
+
+	blocked, err := database.IsBlockedBetween(userID, msg.ReceiverID)
+	if err != nil {
+		log.Printf("Error checking blocks: %v", err)
+		return
+	}
+	if blocked {
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeBlocked,
+			"message_type": PrivateMessage,
+			"receiver_id":  msg.ReceiverID,
+		})
+		return
+	}
+
+	allowed, err := acceptsMessagesFrom(msg.ReceiverID, userID)
+	if err != nil {
+		log.Printf("Error checking privacy settings: %v", err)
+		return
+	}
+	if !allowed {
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeMessagesNotAllowed,
+			"message_type": PrivateMessage,
+			"receiver_id":  msg.ReceiverID,
+		})
+		return
+	}
+
+	original := msg.Content
+	verdict, err := checkContent(userID, "message", &msg.Content)
+	if err != nil {
+		log.Printf("Error filtering private message: %v", err)
+		return
+	}
+	if verdict.Action == filter.Block {
+		recordFilteredContent(userID, "message", "", original, verdict)
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeContentRejected,
+			"message_type": PrivateMessage,
+			"receiver_id":  msg.ReceiverID,
+			"matches":      filterMatches(verdict),
+		})
+		return
+	}
+
+	// Save to database
+	messageID, mentions, err := database.SavePrivateMessage(userID, msg.ReceiverID, msg.Content)
+	if err != nil {
+		log.Printf("Error saving private message: %v", err)
+		return
+	}
+	recordFilteredContent(userID, "message", strconv.FormatInt(messageID, 10), original, verdict)
+	attachments := linkAttachments(userID, msg.AttachmentIds, "message", strconv.FormatInt(messageID, 10))
+	clearDraft(userID, database.DraftMessage, msg.ReceiverID)
+	previews := linkPreviews("message", strconv.FormatInt(messageID, 10), msg.Content,
+		sendMessagePreview(strconv.FormatInt(messageID, 10), userID, msg.ReceiverID))
+
+	// Receivers who are offline find the message in their notifications when they come back
+	// Only the receiver can read the message, the other users it mentions are not told
+	receiverMentioned := false
+	for _, m := range mentions {
+		receiverMentioned = receiverMentioned || m.UserId == msg.ReceiverID
+	}
+	if receiverMentioned || !isUserConnected(msg.ReceiverID) {
+		notificationType := models.NotifyMessage
+		if receiverMentioned {
+			notificationType = models.NotifyMention
+		}
+		notify(models.Notification{
+			UserId:   msg.ReceiverID,
+			ActorId:  userID,
+			Type:     notificationType,
+			TargetId: strconv.FormatInt(messageID, 10),
+			Excerpt:  msg.Content,
+		})
+	}
+
+	// Recipients in do-not-disturb mode still receive the message, but flagged
+	// as silent so that no notification is raised for it
+	silent := database.IsUserDoNotDisturb(msg.ReceiverID)
+
+	response := map[string]interface{}{
+		"type":         PrivateMessage,
+		"id":           messageID,
+		"sender_id":    userID,
+		"content":      msg.Content,
+		"content_html": database.RenderContent(msg.Content, mentions),
+		"mentions":     mentions,
+		"attachments":  attachments,
+		"previews":     previews,
+		"sent_at":      time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	// Every connection of the sender gets the message as saved: the page that sent it
+	// replaces its copy, found by client_id, and the other pages show it
+	echo := maps.Clone(response)
+	echo["receiver_id"] = msg.ReceiverID
+	echo["is_sent"] = true
+	echo["client_id"] = msg.ClientID
+	sendToUser(userID, echo)
+
+	response["silent"] = silent
+
+	// Broadcast to every connection of the recipient if online
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+
+	for _, c := range connections {
+		if c.UserID == msg.ReceiverID {
+			responseJSON, _ := json.Marshal(response)
+			if err := c.Conn.WriteMessage(websocket.TextMessage, responseJSON); err != nil {
+				log.Printf("Error sending private message: %v", err)
+			}
+		}
+	}
+}
+
+// acceptsMessagesFrom reports whether receiverID accepts private messages from senderID
+// Users who turned off messages from strangers can still be messaged by the people they talked with
+func acceptsMessagesFrom(receiverID, senderID string) (bool, error) {
+	privacy, err := database.GetPrivacySettings(receiverID)
+	if err != nil {
+		return false, err
+	}
+	if privacy.AllowStrangerMessages {
+		return true, nil
+	}
+	return database.HaveConversation(receiverID, senderID)
+}
+
+func MessagesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := currentUserID(r)
+
+	counterpartID := r.URL.Query().Get("user_id")
+	if counterpartID == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing user_id parameter")
+		return
+	}
+
+	// Récupération des paramètres de pagination
+	page, limit := pageParams(r, 10, 100)
+
+	// Appel à la fonction de base de données avec pagination
+	messages, err := database.GetPrivateMessages(userID, counterpartID, page, limit)
+	if err != nil {
+		log.Printf("Erreur DB: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if len(messages) == 0 {
+		log.Printf("Aucun message trouvé pour %s et %s", userID, counterpartID)
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode([]interface{}{}) // Retourne un tableau vide au lieu de null
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(messages); err != nil {
+		log.Printf("Error encoding messages: %v", err)
+	}
+}
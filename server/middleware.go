@@ -0,0 +1,129 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/shared"
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Keys of the values stored in the request context
+type contextKey string
+
+const (
+	userIDKey    contextKey = "user_id"
+	sessionIDKey contextKey = "session_id"
+	requestIDKey contextKey = "request_id"
+)
+
+// Access levels of a route
+type access int
+
+const (
+	public        access = iota // anyone can call the route, the user is resolved when logged in
+	authenticated               // the route requires a valid session
+)
+
+// route describes an endpoint: the methods it accepts and who can call it
+type route struct {
+	pattern string
+	methods []string
+	access  access
+	handler http.HandlerFunc
+}
+
+// wrap applies the middlewares matching the route declaration to its handler
+func (rt route) wrap() http.HandlerFunc {
+	handler := rt.handler
+	if rt.access == authenticated {
+		handler = requireAuth(handler)
+	}
+	return withRequestID(limitPerIP(allowMethods(rt.methods, withSession(verifyCSRF(handler)))))
+}
+
+// withRequestID tags every request with an ID, sent back in the X-Request-ID header
+// and in error responses so that a failing call can be found in the logs
+func withRequestID(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := shared.ParseUUID(shared.GenerateUUID())
+		w.Header().Set("X-Request-ID", id)
+		next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	}
+}
+
+// allowMethods rejects requests made with a method the route does not accept
+func allowMethods(methods []string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		for _, method := range methods {
+			if r.Method == method {
+				next(w, r)
+				return
+			}
+		}
+
+		w.Header().Set("Allow", strings.Join(methods, ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+// withSession resolves the session cookie, when there is a valid one,
+// and stores the session and its user in the request context
+func withSession(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie("session_id")
+		if err == nil && cookie.Value != "" {
+			if userID, err := database.GetUserIDFromSession(cookie.Value); err == nil {
+				ctx := context.WithValue(r.Context(), userIDKey, userID)
+				ctx = context.WithValue(ctx, sessionIDKey, cookie.Value)
+				r = r.WithContext(ctx)
+			}
+		}
+		next(w, r)
+	}
+}
+
+// requireAuth rejects requests that were not resolved to a user by withSession
+func requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if currentUserID(r) == "" {
+			writeError(w, r, http.StatusUnauthorized, "Not authenticated")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// requireVerified rejects logged in users who have not confirmed their email address yet
+func requireVerified(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		verified, err := database.IsEmailVerified(currentUserID(r))
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+			return
+		}
+		if !verified {
+			writeErrorCode(w, r, http.StatusForbidden, ErrCodeEmailNotVerified, "Confirm your email address before posting", nil)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// currentUserID returns the ID of the logged in user, or "" for anonymous requests
+func currentUserID(r *http.Request) string {
+	userID, _ := r.Context().Value(userIDKey).(string)
+	return userID
+}
+
+// currentSessionID returns the session of the logged in user, or "" for anonymous requests
+func currentSessionID(r *http.Request) string {
+	sessionID, _ := r.Context().Value(sessionIDKey).(string)
+	return sessionID
+}
+
+// requestID returns the ID given to the request by withRequestID
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
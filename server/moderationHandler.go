@@ -0,0 +1,341 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Error codes of the restrictions set by moderators
+const (
+	ErrCodeAccountSuspended = "account_suspended" // a suspended user tries to log in
+	ErrCodePostLocked       = "post_locked"       // a comment is added to a locked post
+)
+
+// Actions written to the moderation log
+const (
+	modDeletePost    = "delete_post"
+	modDeleteComment = "delete_comment"
+	modDeleteMessage = "delete_message"
+	modLockPost      = "lock_post"
+	modUnlockPost    = "unlock_post"
+	modPinPost       = "pin_post"
+	modUnpinPost     = "unpin_post"
+	modSuspendUser   = "suspend_user"
+	modUnsuspendUser = "unsuspend_user"
+	modSetRole       = "set_role"
+	modResolveReport = "resolve_report"
+)
+
+// Limits of the moderation requests
+const (
+	reasonMaxLength    = 500
+	maxSuspensionHours = 24 * 365
+)
+
+// moderationRequest holds the parameters of every moderation action,
+// each handler reads the ones it needs
+type moderationRequest struct {
+	PostID    string `json:"post_id"`
+	CommentID string `json:"comment_id"`
+	UserID    string `json:"user_id"`
+	Locked    bool   `json:"locked"`
+	Pinned    bool   `json:"pinned"`
+	Hours     int    `json:"hours"`
+	Role      string `json:"role"`
+	Reason    string `json:"reason"` // optional, kept in the log
+}
+
+// ModDeletePostHandler deletes any post along with its comments
+func ModDeletePostHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "post_id")
+	if !ok {
+		return
+	}
+
+	post, err := database.GetPostByID(database.DB, req.PostID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Post not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve post")
+		return
+	}
+
+	entry := moderationEntry(r, modDeletePost, "post", post.Id, post.Title, req.Reason)
+	err = database.Moderate(entry, func(tx *sql.Tx) error {
+		return database.DeletePost(tx, post.Id)
+	})
+	if err != nil {
+		log.Printf("Error deleting post: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to delete post")
+		return
+	}
+
+	writeModerationDone(w, "Post deleted")
+}
+
+// ModDeleteCommentHandler deletes any comment
+func ModDeleteCommentHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "comment_id")
+	if !ok {
+		return
+	}
+
+	postID, err := database.GetCommentPostID(req.CommentID)
+	if err == nil {
+		entry := moderationEntry(r, modDeleteComment, "comment", req.CommentID, "post "+postID, req.Reason)
+		err = database.Moderate(entry, func(tx *sql.Tx) error {
+			return database.DeleteComment(tx, req.CommentID)
+		})
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Comment not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting comment: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to delete comment")
+		return
+	}
+
+	writeModerationDone(w, "Comment deleted")
+}
+
+// ModLockPostHandler locks a post so that no comment can be added to it, or unlocks it
+func ModLockPostHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "post_id")
+	if !ok {
+		return
+	}
+
+	action, message := modUnlockPost, "Post unlocked"
+	if req.Locked {
+		action, message = modLockPost, "Post locked"
+	}
+	err := database.Moderate(moderationEntry(r, action, "post", req.PostID, "", req.Reason), func(tx *sql.Tx) error {
+		return database.SetPostLocked(tx, req.PostID, req.Locked)
+	})
+	if !checkPostUpdate(w, r, err) {
+		return
+	}
+
+	writeModerationDone(w, message)
+}
+
+// ModPinPostHandler pins a post at the top of its category, or unpins it
+func ModPinPostHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "post_id")
+	if !ok {
+		return
+	}
+
+	action, message := modUnpinPost, "Post unpinned"
+	if req.Pinned {
+		action, message = modPinPost, "Post pinned"
+	}
+	err := database.Moderate(moderationEntry(r, action, "post", req.PostID, "", req.Reason), func(tx *sql.Tx) error {
+		return database.SetPostPinned(tx, req.PostID, req.Pinned)
+	})
+	if !checkPostUpdate(w, r, err) {
+		return
+	}
+
+	writeModerationDone(w, message)
+}
+
+// SuspendUserHandler prevents a user from logging in for a number of hours
+// Their sessions are revoked and their WebSocket connections closed right away
+func SuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	if req.Hours < 1 || req.Hours > maxSuspensionHours {
+		writeValidationError(w, r, []FieldError{{Field: "hours", Error: ErrOutOfRange}})
+		return
+	}
+	if !checkCanModerateUser(w, r, req.UserID) {
+		return
+	}
+
+	until := time.Now().Add(time.Duration(req.Hours) * time.Hour)
+	entry := moderationEntry(r, modSuspendUser, "user", req.UserID, "until "+until.Format(time.RFC3339), req.Reason)
+	err := database.Moderate(entry, func(tx *sql.Tx) error {
+		return database.SetSuspension(tx, req.UserID, &until)
+	})
+	if err != nil {
+		log.Printf("Error suspending user: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to suspend user")
+		return
+	}
+
+	sessionIDs, err := database.DeleteUserSessions(req.UserID)
+	if err != nil {
+		log.Printf("Error deleting sessions: %v", err)
+	}
+	closeSessionConnections(sessionIDs...)
+
+	writeModerationDone(w, "User suspended")
+}
+
+// UnsuspendUserHandler lifts the suspension of a user
+func UnsuspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	if !checkCanModerateUser(w, r, req.UserID) {
+		return
+	}
+
+	err := database.Moderate(moderationEntry(r, modUnsuspendUser, "user", req.UserID, "", req.Reason), func(tx *sql.Tx) error {
+		return database.SetSuspension(tx, req.UserID, nil)
+	})
+	if err != nil {
+		log.Printf("Error lifting suspension: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to lift suspension")
+		return
+	}
+
+	writeModerationDone(w, "Suspension lifted")
+}
+
+// SetRoleHandler changes the role of a user, admins can't change their own
+func SetRoleHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeModerationRequest(w, r, "user_id")
+	if !ok {
+		return
+	}
+	if _, known := roleRanks[req.Role]; !known {
+		writeValidationError(w, r, []FieldError{{Field: "role", Error: ErrInvalid}})
+		return
+	}
+	if req.UserID == currentUserID(r) {
+		writeError(w, r, http.StatusForbidden, "You can't change your own role")
+		return
+	}
+
+	err := database.Moderate(moderationEntry(r, modSetRole, "user", req.UserID, req.Role, req.Reason), func(tx *sql.Tx) error {
+		return database.SetUserRole(tx, req.UserID, req.Role)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error setting role: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to change role")
+		return
+	}
+
+	writeModerationDone(w, "Role changed")
+}
+
+// ModerationLogHandler lists the actions of the moderators, most recent first
+func ModerationLogHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r, 50, 200)
+
+	entries, err := database.GetModerationLog(limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get moderation log")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(entries)
+}
+
+// decodeModerationRequest reads a moderation request whose target is given by idField,
+// answering with an error if it is missing or the reason is too long
+func decodeModerationRequest(w http.ResponseWriter, r *http.Request, idField string) (moderationRequest, bool) {
+	var req moderationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+
+	var v validator
+	target := map[string]string{
+		"post_id":    req.PostID,
+		"comment_id": req.CommentID,
+		"user_id":    req.UserID,
+	}[idField]
+	if target == "" {
+		v.add(idField, ErrRequired)
+	}
+	if req.Reason != "" {
+		v.length("reason", req.Reason, 0, reasonMaxLength)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return req, false
+	}
+	return req, true
+}
+
+// checkPostUpdate answers the request with an error if updating a post failed,
+// and reports whether the request can go on
+func checkPostUpdate(w http.ResponseWriter, r *http.Request, err error) bool {
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Post not found")
+		return false
+	}
+	if err != nil {
+		log.Printf("Error updating post: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to update post")
+		return false
+	}
+	return true
+}
+
+// checkCanModerateUser answers the request with an error unless the current user
+// outranks the target user, and reports whether the request can go on
+func checkCanModerateUser(w http.ResponseWriter, r *http.Request, userID string) bool {
+	target, err := database.GetUserRole(userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return false
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		return false
+	}
+
+	role, err := database.GetUserRole(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		return false
+	}
+	if !outranks(role, target) {
+		writeError(w, r, http.StatusForbidden, "You are not allowed to moderate this user")
+		return false
+	}
+	return true
+}
+
+// moderationEntry describes an action of the current user for the moderation log
+func moderationEntry(r *http.Request, action, targetType, targetID, details, reason string) models.ModerationEntry {
+	return models.ModerationEntry{
+		ModeratorId: currentUserID(r),
+		Action:      action,
+		TargetType:  targetType,
+		TargetId:    targetID,
+		Details:     details,
+		Reason:      reason,
+	}
+}
+
+// writeModerationDone confirms a moderation action
+func writeModerationDone(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": message,
+	})
+}
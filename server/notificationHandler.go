@@ -0,0 +1,187 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Longest excerpt of the content kept in a notification, in characters
+const notificationExcerptLength = 100
+
+// notify records a notification and pushes it to the live connections of the notified user,
+// or to their browsers through Web Push when they have none
+// Users in do-not-disturb mode only find it in their notification center
+// Nothing is recorded when users act on their own content, or when the notified user
+// blocked or muted the actor
+func notify(n models.Notification) {
+	if n.UserId == n.ActorId {
+		return
+	}
+
+	hiding, err := database.GetUsersHiding(n.ActorId)
+	if err != nil {
+		log.Printf("Error getting relations: %v", err)
+		return
+	}
+	if hiding[n.UserId] {
+		return
+	}
+
+	n.Excerpt = excerpt(n.Excerpt)
+	created, err := database.CreateNotification(n)
+	if err != nil {
+		log.Printf("Error creating notification: %v", err)
+		return
+	}
+
+	if database.IsUserDoNotDisturb(n.UserId) {
+		return
+	}
+
+	unread, err := database.CountUnreadNotifications(n.UserId)
+	if err != nil {
+		log.Printf("Error counting notifications: %v", err)
+	}
+	sendToUser(n.UserId, map[string]interface{}{
+		"type":         NotificationEvent,
+		"notification": created,
+		"unread":       unread,
+	})
+
+	// Users away from the forum are reached on their devices
+	if !isUserConnected(n.UserId) {
+		sendPush(created)
+	}
+}
+
+// notifyMentions tells the users mentioned in a post or a comment, and returns who was mentioned
+func notifyMentions(mentions []models.Mention, actorID, postID, targetID, content string) map[string]bool {
+	mentioned := make(map[string]bool, len(mentions))
+	for _, m := range mentions {
+		if mentioned[m.UserId] {
+			continue
+		}
+		mentioned[m.UserId] = true
+		notify(models.Notification{
+			UserId:   m.UserId,
+			ActorId:  actorID,
+			Type:     models.NotifyMention,
+			PostId:   postID,
+			TargetId: targetID,
+			Excerpt:  content,
+		})
+	}
+	return mentioned
+}
+
+// notifyNewComment tells the subscribers of a post about a new comment
+// The users in skip, already told they were mentioned in the comment, are left out
+func notifyNewComment(post *models.Post, comment *models.Comment, subscribers []string, skip map[string]bool) {
+	for _, userID := range subscribers {
+		if skip[userID] {
+			continue
+		}
+		notificationType := models.NotifyCommentReply
+		if userID == post.UserId {
+			notificationType = models.NotifyPostReply
+		}
+		notify(models.Notification{
+			UserId:   userID,
+			ActorId:  comment.UserId,
+			Type:     notificationType,
+			PostId:   post.Id,
+			TargetId: comment.Id,
+			Excerpt:  comment.Content,
+		})
+	}
+}
+
+// excerpt shortens a text to notificationExcerptLength characters
+func excerpt(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	runes := []rune(text)
+	if len(runes) <= notificationExcerptLength {
+		return text
+	}
+	return string(runes[:notificationExcerptLength-1]) + "…"
+}
+
+// NotificationsHandler lists the notifications of the current user, most recent first
+// Only the unread ones are listed with ?unread=true
+func NotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r, 20, 100)
+	unreadOnly := r.URL.Query().Get("unread") == "true"
+
+	notifications, err := database.GetNotifications(currentUserID(r), unreadOnly, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get notifications")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notifications)
+}
+
+// UnreadNotificationsHandler returns the number of unread notifications of the current user, for the badge
+func UnreadNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	writeUnreadCount(w, r, "")
+}
+
+// MarkNotificationReadHandler marks a notification of the current user as read
+func MarkNotificationReadHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		NotificationID string `json:"notification_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+	if req.NotificationID == "" {
+		writeValidationError(w, r, []FieldError{{Field: "notification_id", Error: ErrRequired}})
+		return
+	}
+
+	err := database.MarkNotificationRead(currentUserID(r), req.NotificationID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Notification not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to update notification")
+		return
+	}
+
+	writeUnreadCount(w, r, "Notification marked as read")
+}
+
+// MarkAllNotificationsReadHandler marks every notification of the current user as read
+func MarkAllNotificationsReadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := database.MarkAllNotificationsRead(currentUserID(r)); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to update notifications")
+		return
+	}
+
+	writeUnreadCount(w, r, "All notifications marked as read")
+}
+
+// writeUnreadCount answers with the number of unread notifications of the current user,
+// and the message of the action that was done if any
+func writeUnreadCount(w http.ResponseWriter, r *http.Request, message string) {
+	unread, err := database.CountUnreadNotifications(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to count notifications")
+		return
+	}
+
+	response := map[string]interface{}{"unread": unread}
+	if message != "" {
+		response["message"] = message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
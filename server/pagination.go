@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// pageParams reads the "page" and "limit" query parameters of a paginated list
+// Missing or invalid values fall back to the first page and defaultLimit, and limit is capped at maxLimit
+func pageParams(r *http.Request, defaultLimit, maxLimit int) (page, limit int) {
+	page, limit = 1, defaultLimit
+
+	if p, err := strconv.Atoi(r.URL.Query().Get("page")); err == nil && p > 0 {
+		page = p
+	}
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 {
+		limit = l
+		if limit > maxLimit {
+			limit = maxLimit
+		}
+	}
+	return page, limit
+}
@@ -1,198 +1,207 @@
-package server
-
-import (
-	"Real-Time-Forum/database"
-	"Real-Time-Forum/models"
-	"encoding/json"
-	"net/http"
-
-	"github.com/gorilla/websocket"
-)
-
-// CreatePostHandler handles the creation of new posts
-func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	userID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	var post models.Post
-	err = json.NewDecoder(r.Body).Decode(&post)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if post.Title == "" || post.Content == "" {
-		http.Error(w, "Title and content are required", http.StatusBadRequest)
-		return
-	}
-
-	post.UserId = userID
-
-	createdPost, err := database.CreatePost(post)
-	if err != nil {
-		http.Error(w, "Failed to create post in database", http.StatusInternalServerError)
-		return
-	}
-
-	broadcastNewPost(*createdPost)
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(createdPost)
-}
-
-// PostsHandler handles both GET and POST requests for posts
-func PostsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet && r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	if r.Method == http.MethodGet {
-		posts, err := database.GetAllPosts(database.DB)
-		if err != nil {
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode([]models.Post{})
-			return
-		}
-		if posts == nil {
-			posts = []models.Post{}
-		}
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(posts)
-	} else if r.Method == http.MethodPost {
-		cookie, err := r.Cookie("session_id")
-		if err != nil {
-			http.Error(w, "Not authenticated", http.StatusUnauthorized)
-			return
-		}
-
-		userID, err := database.GetUserIDFromSession(cookie.Value)
-		if err != nil {
-			http.Error(w, "Invalid session", http.StatusUnauthorized)
-			return
-		}
-
-		var post models.Post
-		err = json.NewDecoder(r.Body).Decode(&post)
-		if err != nil {
-			http.Error(w, "Invalid request body", http.StatusBadRequest)
-			return
-		}
-
-		if post.Title == "" || post.Content == "" {
-			http.Error(w, "Title and content are required", http.StatusBadRequest)
-			return
-		}
-
-		post.UserId = userID
-
-		createdPost, err := database.CreatePost(post)
-		if err != nil {
-			http.Error(w, "Failed to create post", http.StatusInternalServerError)
-			return
-		}
-
-		broadcastNewPost(*createdPost)
-
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusCreated)
-		json.NewEncoder(w).Encode(createdPost)
-	}
-}
-
-// GetPostWithCommentsHandler retrieves a post and all its comments
-func GetPostWithCommentsHandler(w http.ResponseWriter, r *http.Request) {
-	postID := r.URL.Path[len("/post/"):]
-	if postID == "" {
-		http.Error(w, "Post ID is required", http.StatusBadRequest)
-		return
-	}
-
-	postWithComments, err := database.GetPostWithComments(postID)
-	if err != nil {
-		http.Error(w, "Failed to retrieve post", http.StatusInternalServerError)
-		return
-	}
-
-	if postWithComments == nil {
-		http.Error(w, "Post not found", http.StatusNotFound)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(postWithComments)
-}
-
-// CreateCommentHandler handles creation of new comments
-func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	userID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	var comment models.Comment
-	err = json.NewDecoder(r.Body).Decode(&comment)
-	if err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
-		return
-	}
-
-	if comment.Content == "" || comment.PostId == "" {
-		http.Error(w, "Content and post ID are required", http.StatusBadRequest)
-		return
-	}
-
-	comment.UserId = userID
-
-	err = database.CreateComment(comment)
-	if err != nil {
-		http.Error(w, "Failed to create comment", http.StatusInternalServerError)
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(comment)
-}
-
-// broadcastNewPost broadcasts a new post to all connected WebSocket clients
-func broadcastNewPost(post models.Post) {
-	message := map[string]interface{}{
-		"type": NewPost,
-		"post": post,
-	}
-
-	messageJSON, err := json.Marshal(message)
-	if err != nil {
-		return
-	}
-
-	connectionsLock.Lock()
-	defer connectionsLock.Unlock()
-
-	for _, conn := range connections {
-		_ = conn.Conn.WriteMessage(websocket.TextMessage, messageJSON)
-	}
-}
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/filter"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// CreatePostHandler handles the creation of new posts
+func CreatePostHandler(w http.ResponseWriter, r *http.Request) {
+	var post models.Post
+	err := json.NewDecoder(r.Body).Decode(&post)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	post.UserId = currentUserID(r)
+
+	errs := validatePost(&post)
+	attachmentErrs, err := validateAttachments(post.UserId, post.AttachmentIds)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check attachments")
+		return
+	}
+	if errs = append(errs, attachmentErrs...); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	original := post.Title + "\n\n" + post.Content
+	verdict, err := checkContent(post.UserId, "post", &post.Title, &post.Content)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check post")
+		return
+	}
+	if verdict.Action == filter.Block {
+		recordFilteredContent(post.UserId, "post", "", original, verdict)
+		writeContentRejected(w, r, verdict)
+		return
+	}
+
+	createdPost, err := database.CreatePost(post)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to create post in database")
+		return
+	}
+	recordFilteredContent(post.UserId, "post", createdPost.Id, original, verdict)
+	createdPost.Attachments = linkAttachments(post.UserId, post.AttachmentIds, "post", createdPost.Id)
+	createdPost.Previews = linkPreviews("post", createdPost.Id, createdPost.Content,
+		broadcastPostPreview(createdPost.Id, post.UserId))
+	subscribeToPost(post.UserId, createdPost.Id)
+	clearDraft(post.UserId, database.DraftPost, "")
+	notifyMentions(createdPost.Mentions, post.UserId, createdPost.Id, createdPost.Id, createdPost.Content)
+
+	broadcastNewPost(*createdPost)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdPost)
+}
+
+// PostsHandler handles both GET and POST requests for posts
+// GET lists every post, or the posts of one category with ?category=, pinned ones first
+func PostsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		requireAuth(requireVerified(rateLimited(ActionPost, CreatePostHandler)))(w, r)
+		return
+	}
+
+	category := r.URL.Query().Get("category")
+	if category != "" && !isPostCategory(category) {
+		writeValidationError(w, r, []FieldError{{Field: "category", Error: ErrInvalid}})
+		return
+	}
+
+	posts, err := database.GetAllPosts(database.DB, currentUserID(r), category)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+	if posts == nil {
+		posts = []models.Post{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// GetPostWithCommentsHandler retrieves a post and all its comments
+func GetPostWithCommentsHandler(w http.ResponseWriter, r *http.Request) {
+	postID := r.URL.Path[len("/post/"):]
+	if postID == "" {
+		writeError(w, r, http.StatusBadRequest, "Post ID is required")
+		return
+	}
+
+	viewerID := currentUserID(r)
+	postWithComments, err := database.GetPostWithComments(postID, viewerID)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Post not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve post")
+		return
+	}
+	if viewerID != "" {
+		postWithComments.Subscribed, err = database.IsSubscribed(viewerID, database.SubscriptionPost, postID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to retrieve post")
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(postWithComments)
+}
+
+// CreateCommentHandler handles creation of new comments
+func CreateCommentHandler(w http.ResponseWriter, r *http.Request) {
+	var comment models.Comment
+	err := json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	comment.UserId = currentUserID(r)
+
+	errs := validateComment(&comment)
+	attachmentErrs, err := validateAttachments(comment.UserId, comment.AttachmentIds)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check attachments")
+		return
+	}
+	if errs = append(errs, attachmentErrs...); len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+
+	// Make sure the commented post exists and is still open
+	post, err := database.GetPostByID(database.DB, comment.PostId)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Post not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve post")
+		return
+	}
+	if post.Locked {
+		writeErrorCode(w, r, http.StatusForbidden, ErrCodePostLocked, "This post is locked, no more comments can be added", nil)
+		return
+	}
+
+	original := comment.Content
+	verdict, err := checkContent(comment.UserId, "comment", &comment.Content)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to check comment")
+		return
+	}
+	if verdict.Action == filter.Block {
+		recordFilteredContent(comment.UserId, "comment", "", original, verdict)
+		writeContentRejected(w, r, verdict)
+		return
+	}
+
+	createdComment, err := database.CreateComment(comment)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to create comment")
+		return
+	}
+	recordFilteredContent(comment.UserId, "comment", createdComment.Id, original, verdict)
+	createdComment.Attachments = linkAttachments(comment.UserId, comment.AttachmentIds, "comment", createdComment.Id)
+	subscribeToPost(comment.UserId, post.Id)
+	clearDraft(comment.UserId, database.DraftComment, post.Id)
+	mentioned := notifyMentions(createdComment.Mentions, comment.UserId, post.Id, createdComment.Id, createdComment.Content)
+	if subscribers, err := database.GetPostSubscribers(post.Id); err != nil {
+		log.Printf("Error getting subscribers: %v", err)
+	} else {
+		notifyNewComment(post, createdComment, subscribers, mentioned)
+		broadcastNewComment(*createdComment, subscribers)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(createdComment)
+}
+
+// broadcastNewPost pushes a new post to the users following its category or its author
+// The post is not pushed to the users who blocked or muted its author
+func broadcastNewPost(post models.Post) {
+	subscribers, err := database.GetNewPostSubscribers(post)
+	if err != nil {
+		log.Printf("Error getting subscribers: %v", err)
+		return
+	}
+
+	sendToSubscribers(subscribers, post.UserId, map[string]interface{}{
+		"type": NewPost,
+		"post": post,
+	})
+}
@@ -0,0 +1,250 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/storage"
+	"Real-Time-Forum/unfurl"
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Most links of a post or a message that get a preview
+	maxPreviews = 3
+	// How long the preview of a page is kept before it is fetched again
+	previewTTL = 24 * time.Hour
+	// How long a page that could not be previewed is left alone
+	failedPreviewTTL = time.Hour
+	// Largest image of a page downloaded, in bytes, and the size of the copy kept, in pixels
+	maxPreviewImageSize = 5 << 20
+	previewImageSize    = 400
+)
+
+// previewFetcher downloads the linked pages, with the timeouts and size limit of the configuration
+var previewFetcher = unfurl.NewFetcher(config.PreviewTimeout, int64(config.PreviewMaxSize), config.PreviewAllowPrivate)
+
+// pendingPreviews holds the callbacks waiting for the pages being fetched, by URL,
+// so that a page linked by several contents at once is only fetched once
+var pendingPreviews = struct {
+	sync.Mutex
+	waiting map[string][]func(models.LinkPreview)
+}{waiting: make(map[string][]func(models.LinkPreview))}
+
+// linkPreviews records the links of a content that was just saved and returns the previews already known
+// The pages never fetched, or not for a while, are fetched in the background and each preview found
+// is given to ready, which tells the readers of the content about it
+func linkPreviews(targetType, targetID, content string, ready func(models.LinkPreview)) []models.LinkPreview {
+	if config.PreviewTimeout <= 0 {
+		return nil
+	}
+	urls := unfurl.FindURLs(content, maxPreviews)
+	if len(urls) == 0 {
+		return nil
+	}
+	if err := database.SaveContentLinks(targetType, targetID, urls); err != nil {
+		log.Printf("Error saving links of %s %s: %v", targetType, targetID, err)
+		return nil
+	}
+
+	var previews []models.LinkPreview
+	for _, url := range urls {
+		cached, err := database.GetLinkPreview(url)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			log.Printf("Error getting link preview: %v", err)
+			continue
+		}
+		if cached != nil && !cached.Failed {
+			previews = append(previews, *cached)
+		}
+		if cached == nil || previewExpired(*cached) {
+			fetchPreview(url, ready)
+		}
+	}
+	return previews
+}
+
+// previewExpired reports whether a cached preview is old enough to be fetched again
+func previewExpired(p models.LinkPreview) bool {
+	ttl := previewTTL
+	if p.Failed {
+		ttl = failedPreviewTTL
+	}
+	return time.Since(p.FetchedAt) > ttl
+}
+
+// fetchPreview fetches a page in the background, unless it is already being fetched,
+// caches its preview and gives it to ready
+// Pages that can't be previewed are cached as failed, so that they are not fetched on every link
+func fetchPreview(url string, ready func(models.LinkPreview)) {
+	pendingPreviews.Lock()
+	waiting, fetching := pendingPreviews.waiting[url]
+	pendingPreviews.waiting[url] = append(waiting, ready)
+	pendingPreviews.Unlock()
+	if fetching {
+		return
+	}
+
+	go func() {
+		preview := models.LinkPreview{URL: url}
+		ctx, cancel := context.WithTimeout(context.Background(), config.PreviewTimeout)
+		page, err := previewFetcher.Fetch(ctx, url)
+		cancel()
+		if err != nil {
+			if !errors.Is(err, unfurl.ErrNoPreview) {
+				log.Printf("Error fetching preview of %s: %v", url, err)
+			}
+			preview.Failed = true
+		} else {
+			preview.Title = page.Title
+			preview.Description = page.Description
+			preview.SiteName = page.SiteName
+			if page.ImageURL != "" {
+				preview.ImageKey, preview.ImageType = copyPreviewImage(page.ImageURL)
+				preview.ImageURL = database.PreviewImageURL(preview.ImageKey)
+			}
+		}
+		unused, err := database.SaveLinkPreview(preview)
+		if err != nil {
+			log.Printf("Error saving link preview: %v", err)
+		}
+		deleteBlobs(unused)
+
+		pendingPreviews.Lock()
+		waiting := pendingPreviews.waiting[url]
+		delete(pendingPreviews.waiting, url)
+		pendingPreviews.Unlock()
+
+		if preview.Failed {
+			return
+		}
+		for _, ready := range waiting {
+			ready(preview)
+		}
+	}()
+}
+
+// copyPreviewImage downloads the image of a page and keeps a thumbnail of it in the blob store,
+// so that the readers of the preview load it from the forum and not from the site of the page
+// It returns the key and the MIME type of the copy, or empty strings when there is none
+func copyPreviewImage(imageURL string) (key, mimeType string) {
+	ctx, cancel := context.WithTimeout(context.Background(), config.PreviewTimeout)
+	data, err := previewFetcher.FetchImage(ctx, imageURL, maxPreviewImageSize)
+	cancel()
+	if err != nil {
+		if !errors.Is(err, unfurl.ErrNoPreview) {
+			log.Printf("Error fetching preview image %s: %v", imageURL, err)
+		}
+		return "", ""
+	}
+
+	thumbnail, _, _, err := storage.Thumbnail(data, previewImageSize)
+	if err != nil {
+		return "", ""
+	}
+	if key, err = blobStore.Put(bytes.NewReader(thumbnail)); err != nil {
+		log.Printf("Error storing preview image: %v", err)
+		return "", ""
+	}
+	// Thumbnails of photos are JPEG, the others PNG
+	return key, http.DetectContentType(thumbnail)
+}
+
+// PreviewImageHandler serves the copy of a preview image at /previews/{key}
+// Only the keys of preview images are served, the other files of the blob store have their own checks
+// A key names a single content, so the images can be cached for good
+func PreviewImageHandler(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/previews/")
+	if !storage.ValidKey(key) {
+		writeError(w, r, http.StatusNotFound, "Image not found")
+		return
+	}
+
+	mimeType, err := database.GetPreviewImageType(key)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Image not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve image")
+		return
+	}
+
+	file, err := blobStore.Open(key)
+	if err != nil {
+		log.Printf("Error opening blob %s: %v", key, err)
+		writeError(w, r, http.StatusNotFound, "Image not found")
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", mimeType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; sandbox")
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	w.Header().Set("ETag", `"`+key+`"`)
+	http.ServeContent(w, r, "", time.Time{}, file)
+}
+
+// previewReadyEvent is the event telling that a preview of a content was fetched
+func previewReadyEvent(targetType, targetID string, preview models.LinkPreview) map[string]interface{} {
+	return map[string]interface{}{
+		"type":        PreviewReady,
+		"target_type": targetType,
+		"target_id":   targetID,
+		"preview":     preview,
+	}
+}
+
+// broadcastPostPreview tells every connected user who can see the posts of the author,
+// the author included, about a preview of one of them
+func broadcastPostPreview(postID, authorID string) func(models.LinkPreview) {
+	return func(preview models.LinkPreview) {
+		hiding, err := database.GetUsersHiding(authorID)
+		if err != nil {
+			log.Printf("Error getting relations: %v", err)
+			return
+		}
+
+		connectionsLock.Lock()
+		recipients := make([]Connection, len(connections))
+		copy(recipients, connections)
+		connectionsLock.Unlock()
+
+		message := previewReadyEvent("post", postID, preview)
+		for _, c := range recipients {
+			if !hiding[c.UserID] {
+				sendToConn(c.Conn, message)
+			}
+		}
+	}
+}
+
+// sendMessagePreview tells both sides of a conversation about a preview of one of its messages
+func sendMessagePreview(messageID, senderID, receiverID string) func(models.LinkPreview) {
+	return func(preview models.LinkPreview) {
+		message := previewReadyEvent("message", messageID, preview)
+		sendToUser(senderID, message)
+		sendToUser(receiverID, message)
+	}
+}
+
+// purgePreviews forgets the links of deleted contents and the previews no content uses anymore, with their images
+func purgePreviews() {
+	removed, keys, err := database.DeleteOrphanLinks(time.Now().Add(-previewTTL))
+	if err != nil {
+		log.Printf("Error purging link previews: %v", err)
+		return
+	}
+	deleteBlobs(keys)
+	if removed > 0 {
+		log.Printf("Purged %d link previews", removed)
+	}
+}
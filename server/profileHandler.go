@@ -0,0 +1,139 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Number of posts and comments shown with a profile, and sizes of the activity pages
+const (
+	profileRecentItems  = 5
+	profileDefaultLimit = 10
+	profileMaxLimit     = 50
+)
+
+// UserProfileHandler serves the public profile of a user and its activity history:
+//
+//	/users/{username}           profile with the most recent posts and comments
+//	/users/{username}/posts     paginated posts
+//	/users/{username}/comments  paginated comments
+func UserProfileHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/users/"), "/")
+	username, section, _ := strings.Cut(path, "/")
+	if username == "" {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+
+	user, err := database.GetUserByUsername(username)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get user")
+		return
+	}
+
+	var response interface{}
+	page, limit := pageParams(r, profileDefaultLimit, profileMaxLimit)
+	offset := (page - 1) * limit
+
+	switch section {
+	case "":
+		response, err = publicProfile(user, currentUserID(r))
+	case "posts":
+		response, err = database.GetPostsByUser(database.DB, user.Id, limit, offset)
+	case "comments":
+		response, err = database.GetCommentsByUser(database.DB, user.Id, limit, offset)
+	default:
+		writeError(w, r, http.StatusNotFound, "Not found")
+		return
+	}
+	if err != nil {
+		log.Printf("Error building profile of %s: %v", user.Username, err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to get profile")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// publicProfile builds the profile of a user as seen by viewerID
+// The fields hidden by the privacy settings are only shown to the user themselves
+func publicProfile(user *models.User, viewerID string) (*models.PublicProfile, error) {
+	profile := &models.PublicProfile{
+		UserId:    user.Id,
+		Username:  user.Username,
+		AvatarURL: user.AvatarURL,
+		JoinedAt:  user.CreationDate,
+	}
+
+	privacy, err := database.GetPrivacySettings(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	isSelf := viewerID == user.Id
+
+	if isSelf || privacy.ShowFullName {
+		profile.FirstName = user.FirstName
+		profile.LastName = user.LastName
+	}
+	if isSelf || privacy.ShowAge {
+		profile.Age = user.Age
+	}
+	if isSelf || privacy.ShowGender {
+		profile.Gender = user.Gender
+	}
+
+	if profile.PostCount, profile.CommentCount, err = database.CountUserActivity(user.Id); err != nil {
+		return nil, err
+	}
+
+	// Invisible users look offline, and their last activity is not shown either
+	status, err := database.GetUserStatus(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	invisible := status.Availability == models.AvailabilityInvisible && !isSelf
+	if !invisible {
+		profile.Status = status
+	}
+
+	profile.Presence = "offline"
+	if isUserConnected(user.Id) && !invisible {
+		profile.Presence = "online"
+	} else if !invisible {
+		if profile.LastSeen, err = database.GetUserLastSeen(user.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if viewerID != "" && !isSelf {
+		if profile.Blocked, err = database.HasUserRelation(viewerID, user.Id, database.RelationBlock); err != nil {
+			return nil, err
+		}
+		if profile.Muted, err = database.HasUserRelation(viewerID, user.Id, database.RelationMute); err != nil {
+			return nil, err
+		}
+		if profile.Following, err = database.IsSubscribed(viewerID, database.SubscriptionUser, user.Id); err != nil {
+			return nil, err
+		}
+	}
+
+	if profile.RecentPosts, err = database.GetPostsByUser(database.DB, user.Id, profileRecentItems, 0); err != nil {
+		return nil, err
+	}
+	if profile.RecentComments, err = database.GetCommentsByUser(database.DB, user.Id, profileRecentItems, 0); err != nil {
+		return nil, err
+	}
+
+	return profile, nil
+}
@@ -0,0 +1,225 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"Real-Time-Forum/webpush"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+)
+
+const (
+	// Longest push endpoint accepted, the URLs of the push services are far shorter
+	maxPushEndpointLength = 2048
+	// Most browsers receiving the pushes of a user, registering another one drops the oldest
+	maxPushSubscriptions = 10
+)
+
+// pushSender delivers the Web Push messages, signed with the configured VAPID key
+// It is created by SetupRoutes
+var pushSender *webpush.Sender
+
+// openPushSender loads the VAPID key of the configuration, or generates one when it is unset
+func openPushSender() error {
+	if config.VAPIDPrivateKey != "" {
+		vapid, err := webpush.NewVAPID(config.VAPIDPrivateKey, config.VAPIDSubject)
+		if err != nil {
+			return fmt.Errorf("invalid FORUM_VAPID_PRIVATE_KEY: %w", err)
+		}
+		pushSender = webpush.NewSender(vapid, config.PushAllowHTTP)
+		return nil
+	}
+
+	log.Printf("FORUM_VAPID_PRIVATE_KEY is not set, using a random key: browsers will have to subscribe again after a restart")
+	privateKey, err := webpush.GenerateVAPIDKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate a VAPID key: %w", err)
+	}
+	vapid, err := webpush.NewVAPID(privateKey, config.VAPIDSubject)
+	if err != nil {
+		return fmt.Errorf("failed to generate a VAPID key: %w", err)
+	}
+	pushSender = webpush.NewSender(vapid, config.PushAllowHTTP)
+	return nil
+}
+
+// pushTitles are the titles of the pushed notifications, after the name of the actor
+var pushTitles = map[string]string{
+	models.NotifyPostReply:    "%s commented your post",
+	models.NotifyCommentReply: "%s commented a post you follow",
+	models.NotifyMention:      "%s mentioned you",
+	models.NotifyMessage:      "%s sent you a message",
+}
+
+// pushSubscriptionRequest is a browser subscription, in the format of PushSubscription.toJSON
+type pushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+// PushKeyHandler returns the public VAPID key browsers subscribe with
+func PushKeyHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"public_key": pushSender.VAPID.PublicKey,
+	})
+}
+
+// PushSubscriptionsHandler lists (GET) the browsers receiving the pushes of the current user,
+// or registers the current browser (POST)
+func PushSubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodGet {
+		subscriptions, err := database.GetPushSubscriptions(userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to get push subscriptions")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscriptions)
+		return
+	}
+
+	var req pushSubscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	if req.Endpoint == "" {
+		v.add("endpoint", ErrRequired)
+	} else if !isPushEndpoint(req.Endpoint) {
+		v.add("endpoint", ErrInvalid)
+	}
+	if req.Keys.P256dh == "" {
+		v.add("p256dh", ErrRequired)
+	} else if key, err := webpush.DecodeKey(req.Keys.P256dh); err != nil || len(key) != 65 {
+		v.add("p256dh", ErrInvalid)
+	}
+	if req.Keys.Auth == "" {
+		v.add("auth", ErrRequired)
+	} else if secret, err := webpush.DecodeKey(req.Keys.Auth); err != nil || len(secret) != 16 {
+		v.add("auth", ErrInvalid)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	err := database.SavePushSubscription(models.PushSubscription{
+		Endpoint:  req.Endpoint,
+		UserId:    userID,
+		P256dh:    req.Keys.P256dh,
+		Auth:      req.Keys.Auth,
+		UserAgent: r.UserAgent(),
+	}, maxPushSubscriptions)
+	if errors.Is(err, database.ErrPushEndpointTaken) {
+		writeError(w, r, http.StatusConflict, "This browser receives the pushes of another user")
+		return
+	}
+	if err != nil {
+		log.Printf("Error saving push subscription: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to save push subscription")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Push notifications enabled",
+	})
+}
+
+// RemovePushSubscriptionHandler stops the pushes to one of the browsers of the current user
+func RemovePushSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Endpoint string `json:"endpoint"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	err := database.DeletePushSubscription(currentUserID(r), req.Endpoint)
+	if err == sql.ErrNoRows {
+		writeError(w, r, http.StatusNotFound, "Push subscription not found")
+		return
+	} else if err != nil {
+		log.Printf("Error removing push subscription: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to remove push subscription")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Push notifications disabled",
+	})
+}
+
+// isPushEndpoint reports whether the server may post pushes to an endpoint
+// Push services are only reached over HTTPS, unless plain HTTP is allowed for testing
+// The address they resolve to is checked when connecting, by the dialer of the sender
+func isPushEndpoint(endpoint string) bool {
+	if len(endpoint) > maxPushEndpointLength {
+		return false
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" || u.User != nil {
+		return false
+	}
+	return u.Scheme == "https" || (u.Scheme == "http" && config.PushAllowHTTP)
+}
+
+// sendPush pushes a notification to the browsers of a user in the background
+// Subscriptions the push service no longer knows are forgotten
+func sendPush(n *models.Notification) {
+	title := fmt.Sprintf(pushTitles[n.Type], n.Actor)
+	// Browsers replace a shown notification with a new one of the same tag,
+	// one per post, or per sender for private messages
+	tag := "post:" + n.PostId
+	if n.PostId == "" {
+		tag = "user:" + n.ActorId
+	}
+	payload, err := json.Marshal(map[string]string{
+		"title":           title,
+		"body":            n.Excerpt,
+		"tag":             tag,
+		"url":             config.PublicURL + "/",
+		"notification_id": n.Id,
+	})
+	if err != nil {
+		log.Printf("Error encoding push: %v", err)
+		return
+	}
+
+	go func() {
+		subscriptions, err := database.GetPushSubscriptions(n.UserId)
+		if err != nil {
+			log.Printf("Error getting push subscriptions: %v", err)
+			return
+		}
+
+		subs := make([]webpush.Subscription, len(subscriptions))
+		for i, s := range subscriptions {
+			subs[i] = webpush.Subscription{Endpoint: s.Endpoint, P256dh: s.P256dh, Auth: s.Auth}
+		}
+		err = pushSender.SendAll(subs, payload, func(sub webpush.Subscription) {
+			if err := database.DeleteExpiredPushSubscription(sub.Endpoint); err != nil {
+				log.Printf("Error removing expired push subscription: %v", err)
+			}
+		})
+		if err != nil {
+			log.Printf("Error sending push: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"Real-Time-Forum/shared"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limited actions
+const (
+	ActionAPI       = "api"      // every API request, per IP
+	ActionLogin     = "login"    // login attempts, per IP
+	ActionRegister  = "register" // account creations, per IP
+	ActionPost      = "post"
+	ActionComment   = "comment"
+	ActionMessage   = "message" // private messages sent over the WebSocket
+	ActionTyping    = "typing"  // typing notifications sent over the WebSocket
+	ActionWebSocket = "ws"      // any frame sent over the WebSocket
+	ActionMail      = "mail"    // verification and password reset emails
+	ActionReport    = "report"  // content reported to the moderators
+	ActionUpload    = "upload"  // files uploaded as attachments
+	ActionDraft     = "draft"   // drafts saved over the WebSocket
+)
+
+// Limits used when no FORUM_RATE_LIMIT_<ACTION> variable is set
+var defaultRateLimits = map[string]RateLimit{
+	ActionAPI:       {Count: 300, Period: time.Minute, Burst: 100},
+	ActionLogin:     {Count: 10, Period: time.Minute, Burst: 10},
+	ActionRegister:  {Count: 5, Period: time.Hour, Burst: 5},
+	ActionPost:      {Count: 5, Period: time.Minute, Burst: 5},
+	ActionComment:   {Count: 20, Period: time.Minute, Burst: 10},
+	ActionMessage:   {Count: 60, Period: time.Minute, Burst: 20},
+	ActionTyping:    {Count: 120, Period: time.Minute, Burst: 30},
+	ActionWebSocket: {Count: 300, Period: time.Minute, Burst: 60},
+	ActionMail:      {Count: 5, Period: time.Hour, Burst: 3},
+	ActionReport:    {Count: 10, Period: time.Hour, Burst: 5},
+	ActionUpload:    {Count: 30, Period: time.Hour, Burst: 10},
+	ActionDraft:     {Count: 60, Period: time.Minute, Burst: 20},
+}
+
+// ErrCodeRateLimited is returned when a client goes over a rate limit
+const ErrCodeRateLimited = "rate_limited"
+
+// One limiter per action, created from the configuration on first use
+var (
+	limiters     = make(map[string]*shared.RateLimiter)
+	limitersLock sync.Mutex
+)
+
+// limiterFor returns the limiter of an action
+func limiterFor(action string) *shared.RateLimiter {
+	limitersLock.Lock()
+	defer limitersLock.Unlock()
+
+	limiter, ok := limiters[action]
+	if !ok {
+		limit := config.RateLimits[action]
+		limiter = shared.NewRateLimiter(float64(limit.Count)/limit.Period.Seconds(), limit.Burst)
+		limiters[action] = limiter
+	}
+	return limiter
+}
+
+// allowAction takes a token for the action from the bucket of key
+func allowAction(action, key string) (bool, time.Duration) {
+	return limiterFor(action).Allow(action + ":" + key)
+}
+
+// rateLimited limits how often an action can be performed, per user when
+// logged in and per IP address otherwise
+func rateLimited(action string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + clientIP(r)
+		if userID := currentUserID(r); userID != "" {
+			key = "user:" + userID
+		}
+
+		if ok, wait := allowAction(action, key); !ok {
+			writeRateLimited(w, r, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// limitPerIP applies the global API limit of each IP address
+func limitPerIP(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := allowAction(ActionAPI, clientIP(r)); !ok {
+			writeRateLimited(w, r, wait)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeRateLimited sends a 429 response telling the client when to retry
+func writeRateLimited(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(wait)))
+	writeErrorCode(w, r, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many requests, try again later", nil)
+}
+
+// retryAfterSeconds rounds a wait up to whole seconds, as used by the Retry-After header
+func retryAfterSeconds(wait time.Duration) int {
+	return int(math.Max(1, math.Ceil(wait.Seconds())))
+}
+
+// loginLockout counts failed logins per identifier and client IP, and locks the identifier
+// for that IP a while once there are too many of them
+// Someone guessing passwords from one address does not lock the owner out from theirs, but the
+// failures of an identifier from every address are counted too, with a higher limit locking it
+// everywhere, so that changing addresses does not allow guessing forever
+type loginLockout struct {
+	failures  map[string]*loginFailures
+	lastSweep time.Time
+	mu        sync.Mutex
+}
+
+type loginFailures struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+var failedLogins = &loginLockout{failures: make(map[string]*loginFailures)}
+
+// lockoutKey returns the key the failures of an identifier from an IP are counted under
+func lockoutKey(identifier, ip string) string {
+	return strings.ToLower(identifier) + " " + ip
+}
+
+// accountKey returns the key the failures of an identifier from every IP are counted under
+func accountKey(identifier string) string {
+	return strings.ToLower(identifier)
+}
+
+// lockedFor returns how long the identifier is still locked for the IP, or 0
+func (l *loginLockout) lockedFor(identifier, ip string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var wait time.Duration
+	for _, key := range []string{lockoutKey(identifier, ip), accountKey(identifier)} {
+		if f, ok := l.failures[key]; ok {
+			wait = max(wait, time.Until(f.lockedUntil))
+		}
+	}
+	return wait
+}
+
+// fail records a failed login, and locks the identifier for the IP, or for every IP,
+// when the limit is reached
+func (l *loginLockout) fail(identifier, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.count(lockoutKey(identifier, ip), config.LoginMaxFailures, now)
+	l.count(accountKey(identifier), config.LoginAccountMaxFailures, now)
+
+	// Forget the identifiers that have not failed for a while, once per lockout period
+	if now.Sub(l.lastSweep) < config.LoginLockout {
+		return
+	}
+	l.lastSweep = now
+	for key, f := range l.failures {
+		if l.expired(f, now) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// count adds a failure to the ones counted under key, and locks it once there are limit of them
+func (l *loginLockout) count(key string, limit int, now time.Time) {
+	f, ok := l.failures[key]
+	if !ok || l.expired(f, now) {
+		f = &loginFailures{}
+		l.failures[key] = f
+	}
+
+	f.count++
+	f.lastFailure = now
+	if f.count >= limit {
+		f.lockedUntil = now.Add(config.LoginLockout)
+	}
+}
+
+// expired reports whether failures are old enough to be forgotten:
+// the lock is over, or there was no failure during a whole lockout period
+func (l *loginLockout) expired(f *loginFailures, now time.Time) bool {
+	if !f.lockedUntil.IsZero() {
+		return now.After(f.lockedUntil)
+	}
+	return now.Sub(f.lastFailure) > config.LoginLockout
+}
+
+// reset forgets the failures of an identifier from an IP after a successful login
+// The failures from every IP are kept, the owner logging in must not let someone else guess again
+func (l *loginLockout) reset(identifier, ip string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, lockoutKey(identifier, ip))
+}
+
+// resetAll forgets the failures of an identifier from every IP
+func (l *loginLockout) resetAll(identifier string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, accountKey(identifier))
+	prefix := lockoutKey(identifier, "")
+	for key := range l.failures {
+		if strings.HasPrefix(key, prefix) {
+			delete(l.failures, key)
+		}
+	}
+}
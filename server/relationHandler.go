@@ -0,0 +1,113 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// relationRequest names the user to block, mute, unblock or unmute
+type relationRequest struct {
+	UserID string `json:"user_id"`
+}
+
+// BlocksHandler lists (GET) the users blocked by the current user, or blocks one more (POST)
+// Blocked users can't exchange private messages or typing notifications with the current
+// user, and their posts and comments are hidden from them
+var BlocksHandler = relationsHandler(database.RelationBlock, "User blocked")
+
+// MutesHandler lists (GET) the users muted by the current user, or mutes one more (POST)
+// The posts and comments of muted users are hidden, but they can still send messages
+var MutesHandler = relationsHandler(database.RelationMute, "User muted")
+
+// UnblockHandler removes a user from the blocked users of the current user
+var UnblockHandler = removeRelationHandler(database.RelationBlock, "User unblocked")
+
+// UnmuteHandler removes a user from the muted users of the current user
+var UnmuteHandler = removeRelationHandler(database.RelationMute, "User unmuted")
+
+// relationsHandler builds the handler listing and adding the relations of a kind
+func relationsHandler(kind, doneMessage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		userID := currentUserID(r)
+
+		if r.Method == http.MethodGet {
+			users, err := database.GetUserRelations(userID, kind)
+			if err != nil {
+				writeError(w, r, http.StatusInternalServerError, "Failed to get users")
+				return
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(users)
+			return
+		}
+
+		req, ok := decodeRelationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		var v validator
+		if req.UserID == userID {
+			v.add("user_id", ErrInvalid)
+		} else if _, err := database.GetUserByID(req.UserID); err != nil {
+			v.add("user_id", ErrNotFound)
+		}
+		if len(v.errors) > 0 {
+			writeValidationError(w, r, v.errors)
+			return
+		}
+
+		if err := database.AddUserRelation(userID, req.UserID, kind); err != nil {
+			log.Printf("Error saving %s: %v", kind, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": doneMessage,
+		})
+	}
+}
+
+// removeRelationHandler builds the handler removing a relation of a kind
+func removeRelationHandler(kind, doneMessage string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decodeRelationRequest(w, r)
+		if !ok {
+			return
+		}
+
+		removed, err := database.RemoveUserRelation(currentUserID(r), req.UserID, kind)
+		if err != nil {
+			log.Printf("Error removing %s: %v", kind, err)
+			writeError(w, r, http.StatusInternalServerError, "Failed to update user")
+			return
+		}
+		if !removed {
+			writeError(w, r, http.StatusNotFound, "User not found in the list")
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{
+			"message": doneMessage,
+		})
+	}
+}
+
+// decodeRelationRequest reads the user named by a request, answering with an error if there is none
+func decodeRelationRequest(w http.ResponseWriter, r *http.Request) (relationRequest, bool) {
+	var req relationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+	if req.UserID == "" {
+		writeValidationError(w, r, []FieldError{{Field: "user_id", Error: ErrRequired}})
+		return req, false
+	}
+	return req, true
+}
@@ -0,0 +1,224 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+)
+
+// Types of content that can be reported
+var reportTargetTypes = map[string]bool{
+	"post":    true,
+	"comment": true,
+	"message": true,
+}
+
+// Limits of the report reasons and resolution notes
+const (
+	reportReasonMinLength = 3
+	reportReasonMaxLength = 500
+)
+
+// ReportHandler lets a user flag a post, a comment or a private message they received
+func ReportHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		TargetType string `json:"target_type"`
+		TargetID   string `json:"target_id"`
+		Reason     string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	if req.TargetType == "" {
+		v.add("target_type", ErrRequired)
+	} else if !reportTargetTypes[req.TargetType] {
+		v.add("target_type", ErrInvalid)
+	}
+	if req.TargetID == "" {
+		v.add("target_id", ErrRequired)
+	}
+	v.length("reason", req.Reason, reportReasonMinLength, reportReasonMaxLength)
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	userID := currentUserID(r)
+	authorID, receiverID, content, err := database.GetReportTarget(req.TargetType, req.TargetID)
+
+	// Private messages can only be reported by the user who received them
+	if errors.Is(err, sql.ErrNoRows) || (req.TargetType == "message" && receiverID != userID) {
+		writeValidationError(w, r, []FieldError{{Field: "target_id", Error: ErrNotFound}})
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to create report")
+		return
+	}
+	if authorID == userID {
+		writeValidationError(w, r, []FieldError{{Field: "target_id", Error: ErrInvalid}})
+		return
+	}
+
+	report, err := database.CreateReport(models.Report{
+		ReporterId:     userID,
+		TargetType:     req.TargetType,
+		TargetId:       req.TargetID,
+		TargetAuthorId: authorID,
+		Content:        content,
+		Reason:         req.Reason,
+	})
+	if errors.Is(err, database.ErrDuplicateReport) {
+		writeError(w, r, http.StatusConflict, "You already reported this")
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating report: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to create report")
+		return
+	}
+
+	// Let the moderators online know right away
+	if full, err := database.GetReport(report.Id); err == nil {
+		sendToModerators(map[string]interface{}{
+			"type":   NewReport,
+			"report": full,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]string{
+		"report_id": report.Id,
+		"message":   "Thanks, the moderators will review it",
+	})
+}
+
+// ReportsQueueHandler lists the reports for moderators, oldest first
+// They can be filtered with the status (open by default, "all" for every report)
+// and target_type query parameters
+func ReportsQueueHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "":
+		status = models.ReportOpen
+	case "all":
+		status = ""
+	case models.ReportOpen, models.ReportActioned, models.ReportDismissed:
+	default:
+		writeValidationError(w, r, []FieldError{{Field: "status", Error: ErrInvalid}})
+		return
+	}
+
+	targetType := r.URL.Query().Get("target_type")
+	if targetType != "" && !reportTargetTypes[targetType] {
+		writeValidationError(w, r, []FieldError{{Field: "target_type", Error: ErrInvalid}})
+		return
+	}
+
+	page, limit := pageParams(r, 20, 100)
+	reports, err := database.GetReports(status, targetType, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get reports")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// ResolveReportHandler closes an open report as actioned or dismissed
+// With delete_content, an actioned report also deletes the reported content
+func ResolveReportHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ReportID      string `json:"report_id"`
+		Status        string `json:"status"`
+		Note          string `json:"note"`
+		DeleteContent bool   `json:"delete_content"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	var v validator
+	if req.ReportID == "" {
+		v.add("report_id", ErrRequired)
+	}
+	if req.Status != models.ReportActioned && req.Status != models.ReportDismissed {
+		v.add("status", ErrInvalid)
+	}
+	if req.DeleteContent && req.Status != models.ReportActioned {
+		v.add("delete_content", ErrInvalid)
+	}
+	if req.Note != "" {
+		v.length("note", req.Note, 0, reportReasonMaxLength)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	report, err := database.GetReport(req.ReportID)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusNotFound, "Report not found")
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get report")
+		return
+	}
+
+	err = database.Moderate(moderationEntry(r, modResolveReport, "report", report.Id, req.Status, req.Note), func(tx *sql.Tx) error {
+		return database.ResolveReport(tx, report.Id, currentUserID(r), req.Status, req.Note)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		writeError(w, r, http.StatusConflict, "Report already resolved")
+		return
+	}
+	if err != nil {
+		log.Printf("Error resolving report: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to resolve report")
+		return
+	}
+
+	if req.DeleteContent {
+		deleteReportedContent(r, report)
+	}
+
+	writeModerationDone(w, "Report resolved")
+}
+
+// deleteReportedContent deletes the content of a report, if it still exists
+func deleteReportedContent(r *http.Request, report *models.Report) {
+	var action string
+	var remove func(tx *sql.Tx, id string) error
+	switch report.TargetType {
+	case "post":
+		action, remove = modDeletePost, database.DeletePost
+	case "comment":
+		action, remove = modDeleteComment, database.DeleteComment
+	case "message":
+		action, remove = modDeleteMessage, database.DeleteMessage
+	default:
+		return
+	}
+
+	entry := moderationEntry(r, action, report.TargetType, report.TargetId, "report "+report.Id, "")
+	err := database.Moderate(entry, func(tx *sql.Tx) error {
+		return remove(tx, report.TargetId)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		return
+	}
+	if err != nil {
+		log.Printf("Error deleting reported %s: %v", report.TargetType, err)
+	}
+}
@@ -1,29 +1,122 @@
-package server
-
-import (
-	"net/http"
-)
-
-// SetupRoutes defines all the application routes
-func SetupRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/register", registerHandler)
-	mux.HandleFunc("/login", LoginHandler)
-	mux.HandleFunc("/logout", LogoutHandler)
-	mux.HandleFunc("/ws", HandleWebsocket)
-	mux.HandleFunc("/check-session", CheckSessionHandler)
-	mux.HandleFunc("/messages", MessagesHandler)
-	mux.HandleFunc("/users", AllUsersHandler)
-	mux.HandleFunc("/online-users", OnlineUsersHandler)
-	mux.HandleFunc("/users/ordered-by-last-message", UsersOrderedByLastMessageHandler)
-	// hub := shared.NewHub()
-
-	mux.HandleFunc("/posts", PostsHandler)
-	mux.HandleFunc("/create-post", CreatePostHandler)
-	mux.HandleFunc("/post/", GetPostWithCommentsHandler)
-	mux.HandleFunc("/comment", CreateCommentHandler)
-
-	// Adds a route to check if the server is running
-	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Server is online!"))
-	})
-}
+package server
+
+import (
+	"Real-Time-Forum/models"
+	"net/http"
+)
+
+// routes lists every endpoint of the application, with the methods it accepts
+// and whether it can be called without being logged in
+var routes = []route{
+	{"/register", []string{http.MethodPost}, public, rateLimited(ActionRegister, registerHandler)},
+	{"/login", []string{http.MethodPost}, public, rateLimited(ActionLogin, LoginHandler)},
+	{"/logout", []string{http.MethodPost}, authenticated, LogoutHandler},
+	{"/verify-email", []string{http.MethodPost}, public, VerifyEmailHandler},
+	{"/verify-email/resend", []string{http.MethodPost}, authenticated, rateLimited(ActionMail, ResendVerificationHandler)},
+	{"/password-reset/request", []string{http.MethodPost}, public, rateLimited(ActionMail, RequestPasswordResetHandler)},
+	{"/password-reset", []string{http.MethodPost}, public, rateLimited(ActionLogin, ResetPasswordHandler)},
+	{"/ws", []string{http.MethodGet}, authenticated, HandleWebsocket},
+	{"/check-session", []string{http.MethodGet}, authenticated, CheckSessionHandler},
+	{"/sessions", []string{http.MethodGet}, authenticated, SessionsHandler},
+	{"/sessions/refresh", []string{http.MethodPost}, authenticated, RefreshSessionHandler},
+	{"/sessions/revoke", []string{http.MethodPost}, authenticated, RevokeSessionHandler},
+	{"/sessions/revoke-all", []string{http.MethodPost}, authenticated, RevokeAllSessionsHandler},
+	{"/messages", []string{http.MethodGet}, authenticated, MessagesHandler},
+	{"/users", []string{http.MethodGet}, authenticated, AllUsersHandler},
+	{"/online-users", []string{http.MethodGet}, authenticated, OnlineUsersHandler},
+	{"/users/ordered-by-last-message", []string{http.MethodGet}, authenticated, UsersOrderedByLastMessageHandler},
+	{"/users/", []string{http.MethodGet}, public, UserProfileHandler},
+	{"/avatars/", []string{http.MethodGet}, public, AvatarHandler},
+	{"/previews/", []string{http.MethodGet}, public, PreviewImageHandler},
+	{"/status", []string{http.MethodGet, http.MethodPost}, authenticated, StatusHandler},
+	{"/account", []string{http.MethodGet, http.MethodPost}, authenticated, AccountHandler},
+	{"/account/privacy", []string{http.MethodGet, http.MethodPost}, authenticated, PrivacyHandler},
+	{"/account/digest", []string{http.MethodGet, http.MethodPost}, authenticated, DigestHandler},
+	{"/account/avatar", []string{http.MethodPost}, authenticated, rateLimited(ActionUpload, AvatarUploadHandler)},
+	{"/account/avatar/remove", []string{http.MethodPost}, authenticated, RemoveAvatarHandler},
+	{"/account/password", []string{http.MethodPost}, authenticated, rateLimited(ActionLogin, ChangePasswordHandler)},
+	{"/account/email", []string{http.MethodPost}, authenticated, rateLimited(ActionMail, ChangeEmailHandler)},
+	{"/account/delete", []string{http.MethodPost}, authenticated, rateLimited(ActionLogin, DeleteAccountHandler)},
+	{"/blocks", []string{http.MethodGet, http.MethodPost}, authenticated, BlocksHandler},
+	{"/blocks/remove", []string{http.MethodPost}, authenticated, UnblockHandler},
+	{"/mutes", []string{http.MethodGet, http.MethodPost}, authenticated, MutesHandler},
+	{"/mutes/remove", []string{http.MethodPost}, authenticated, UnmuteHandler},
+	{"/subscriptions", []string{http.MethodGet, http.MethodPost}, authenticated, SubscriptionsHandler},
+	{"/subscriptions/remove", []string{http.MethodPost}, authenticated, UnsubscribeHandler},
+	{"/notifications", []string{http.MethodGet}, authenticated, NotificationsHandler},
+	{"/notifications/unread", []string{http.MethodGet}, authenticated, UnreadNotificationsHandler},
+	{"/notifications/read", []string{http.MethodPost}, authenticated, MarkNotificationReadHandler},
+	{"/notifications/read-all", []string{http.MethodPost}, authenticated, MarkAllNotificationsReadHandler},
+	{"/push/key", []string{http.MethodGet}, authenticated, PushKeyHandler},
+	{"/push/subscriptions", []string{http.MethodGet, http.MethodPost}, authenticated, PushSubscriptionsHandler},
+	{"/push/subscriptions/remove", []string{http.MethodPost}, authenticated, RemovePushSubscriptionHandler},
+	{"/drafts", []string{http.MethodGet}, authenticated, DraftsHandler},
+
+	// Reading posts is public, creating them requires a session (checked by PostsHandler)
+	{"/posts", []string{http.MethodGet, http.MethodPost}, public, PostsHandler},
+	{"/create-post", []string{http.MethodPost}, authenticated, requireVerified(rateLimited(ActionPost, CreatePostHandler))},
+	{"/post/", []string{http.MethodGet}, public, GetPostWithCommentsHandler},
+	{"/feed/following", []string{http.MethodGet}, authenticated, FollowingFeedHandler},
+	{"/comment", []string{http.MethodPost}, authenticated, requireVerified(rateLimited(ActionComment, CreateCommentHandler))},
+	{"/reports", []string{http.MethodPost}, authenticated, rateLimited(ActionReport, ReportHandler)},
+	{"/attachments", []string{http.MethodPost}, authenticated, requireVerified(rateLimited(ActionUpload, UploadAttachmentHandler))},
+	{"/attachments/", []string{http.MethodGet}, authenticated, AttachmentHandler},
+
+	// Moderation, every action is written to the moderation log
+	{"/moderation/posts/delete", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, ModDeletePostHandler)},
+	{"/moderation/posts/lock", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, ModLockPostHandler)},
+	{"/moderation/posts/pin", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, ModPinPostHandler)},
+	{"/moderation/comments/delete", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, ModDeleteCommentHandler)},
+	{"/moderation/users/suspend", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, SuspendUserHandler)},
+	{"/moderation/users/unsuspend", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, UnsuspendUserHandler)},
+	{"/moderation/log", []string{http.MethodGet}, authenticated, requireRole(models.RoleModerator, ModerationLogHandler)},
+	{"/moderation/reports", []string{http.MethodGet}, authenticated, requireRole(models.RoleModerator, ReportsQueueHandler)},
+	{"/moderation/reports/resolve", []string{http.MethodPost}, authenticated, requireRole(models.RoleModerator, ResolveReportHandler)},
+	{"/moderation/filtered", []string{http.MethodGet}, authenticated, requireRole(models.RoleModerator, FilteredContentHandler)},
+	{"/admin/users/role", []string{http.MethodPost}, authenticated, requireRole(models.RoleAdmin, SetRoleHandler)},
+
+	// Adds a route to check if the server is running
+	{"/health", []string{http.MethodGet}, public, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("Server is online!"))
+	}},
+}
+
+// Prefix of the versioned REST API
+const apiPrefix = "/api/v1"
+
+// Date after which the unversioned legacy paths will be removed
+const legacySunset = "Sat, 01 May 2027 00:00:00 GMT"
+
+// SetupRoutes defines all the application routes, after opening the stores they need
+// Every route is served under the versioned prefix, and under its legacy
+// unversioned path for the duration of the deprecation period
+func SetupRoutes(mux *http.ServeMux) error {
+	if err := openBlobStore(); err != nil {
+		return err
+	}
+	if err := openPushSender(); err != nil {
+		return err
+	}
+
+	for _, rt := range routes {
+		handler := rt.wrap()
+		mux.Handle(apiPrefix+rt.pattern, http.StripPrefix(apiPrefix, handler))
+		mux.HandleFunc(rt.pattern, deprecated(handler))
+	}
+
+	// Unknown API paths get a JSON error instead of the static files 404 page
+	mux.HandleFunc(apiPrefix+"/", withRequestID(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, http.StatusNotFound, "Endpoint not found")
+	}))
+	return nil
+}
+
+// deprecated flags the responses of legacy paths and points to their versioned successor
+func deprecated(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", legacySunset)
+		w.Header().Set("Link", "<"+apiPrefix+r.URL.Path+">; rel=\"successor-version\"")
+		next(w, r)
+	}
+}
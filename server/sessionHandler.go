@@ -1,34 +1,165 @@
-package server
-
-import (
-	"Real-Time-Forum/database"
-	"encoding/json"
-	"net/http"
-)
-
-func CheckSessionHandler(w http.ResponseWriter, r *http.Request) {
-	// Fetch the session ID from the cookie
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "Not authenticated", http.StatusUnauthorized)
-		return
-	}
-
-	// Get the user ID from the database using the session ID
-	userID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	// Get user details from database
-	user, err := database.GetUserByID(userID)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusInternalServerError)
-		return
-	}
-
-	// Respond with the user ID
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(user)
-}
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+func CheckSessionHandler(w http.ResponseWriter, r *http.Request) {
+	// Get user details from database
+	user, err := database.GetUserByID(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "User not found")
+		return
+	}
+
+	// Extend the lifetime of "remember me" cookies on every visit
+	// and hand the CSRF token of the session back to the client
+	if session, err := database.GetSession(currentSessionID(r)); err == nil {
+		setSessionCookie(w, r, session.Id, session.RememberMe)
+		w.Header().Set(csrfHeader, session.CSRFToken)
+	}
+
+	// Respond with the user ID
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+// SessionsHandler lists the active sessions of the current user
+func SessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := currentUserID(r)
+
+	sessions, err := database.GetUserSessions(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get sessions")
+		return
+	}
+
+	// Flag the session making the request
+	for i := range sessions {
+		sessions[i].Current = sessions[i].Id == currentSessionID(r)
+	}
+	if sessions == nil {
+		sessions = []models.Session{}
+	}
+
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// RefreshSessionHandler extends the current session and re-issues its cookie
+func RefreshSessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	session, err := database.RefreshSession(currentSessionID(r))
+	if err != nil {
+		writeError(w, r, http.StatusUnauthorized, "Invalid session")
+		return
+	}
+
+	setSessionCookie(w, r, session.Id, session.RememberMe)
+	w.Header().Set(csrfHeader, session.CSRFToken)
+	session.Current = true
+	json.NewEncoder(w).Encode(session)
+}
+
+// RevokeSessionHandler logs out one of the current user's sessions
+func RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := currentUserID(r)
+
+	var req struct {
+		SessionID string `json:"session_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.SessionID == "" {
+		writeError(w, r, http.StatusBadRequest, "Missing session_id")
+		return
+	}
+
+	sessions, err := database.GetUserSessions(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get sessions")
+		return
+	}
+
+	// Sessions are identified by their public ID, and only the user's own can be revoked
+	for _, session := range sessions {
+		if session.PublicId != req.SessionID {
+			continue
+		}
+
+		if err := database.DeleteSession(session.Id); err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+		closeSessionConnections(session.Id)
+
+		if session.Id == currentSessionID(r) {
+			clearSessionCookie(w, r)
+		}
+		json.NewEncoder(w).Encode(map[string]string{"message": "Session revoked"})
+		return
+	}
+
+	writeError(w, r, http.StatusNotFound, "Session not found")
+}
+
+// RevokeAllSessionsHandler logs the current user out everywhere, including this session
+func RevokeAllSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := currentUserID(r)
+
+	sessionIDs, err := database.DeleteUserSessions(userID)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	closeSessionConnections(sessionIDs...)
+
+	clearSessionCookie(w, r)
+	json.NewEncoder(w).Encode(map[string]string{"message": "Logged out everywhere"})
+}
+
+// StartSessionSweeper periodically purges expired sessions and email tokens from the database,
+// and the files of deleted contents and abandoned uploads
+// It blocks forever and is meant to be run in its own goroutine
+func StartSessionSweeper(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if removed, err := database.DeleteExpiredSessions(); err != nil {
+			log.Printf("Error purging expired sessions: %v", err)
+		} else if removed > 0 {
+			log.Printf("Purged %d expired sessions", removed)
+		}
+
+		if removed, err := database.DeleteExpiredUserTokens(); err != nil {
+			log.Printf("Error purging expired tokens: %v", err)
+		} else if removed > 0 {
+			log.Printf("Purged %d expired tokens", removed)
+		}
+
+		purgeAttachments()
+		purgeAvatars()
+		purgePreviews()
+		purgeDrafts()
+	}
+}
+
+// clientIP returns the IP address of the client making the request
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
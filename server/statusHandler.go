@@ -0,0 +1,124 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"encoding/json"
+	"net/http"
+	"time"
+	"unicode/utf8"
+)
+
+// Limits for the custom status fields
+const (
+	maxStatusTextLength  = 100
+	maxStatusEmojiLength = 16
+)
+
+// statusRequest is the payload used to change a user's custom status,
+// both through the /status endpoint and the user_status WebSocket event
+type statusRequest struct {
+	StatusText   *string `json:"status_text"`
+	StatusEmoji  *string `json:"status_emoji"`
+	Availability string  `json:"availability"`
+	ExpiresIn    *int    `json:"expires_in"` // in seconds, 0 means no expiry and nil keeps the current one
+}
+
+// isEmpty reports whether the request carries no status change at all
+func (req statusRequest) isEmpty() bool {
+	return req.StatusText == nil && req.StatusEmoji == nil && req.Availability == "" && req.ExpiresIn == nil
+}
+
+// applyUserStatus validates a status request and saves it for the user
+// The rejected fields are returned, and nothing is saved, when the request is invalid
+func applyUserStatus(userID string, req statusRequest) (*models.UserStatus, []FieldError, error) {
+	current, err := database.GetUserStatus(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	status := *current
+	if req.StatusText != nil {
+		status.Text = *req.StatusText
+	}
+	if req.StatusEmoji != nil {
+		status.Emoji = *req.StatusEmoji
+	}
+	if req.Availability != "" {
+		status.Availability = req.Availability
+	}
+
+	var v validator
+	switch status.Availability {
+	case models.AvailabilityAvailable, models.AvailabilityBusy,
+		models.AvailabilityDoNotDisturb, models.AvailabilityInvisible:
+	default:
+		v.add("availability", ErrInvalid)
+	}
+	if utf8.RuneCountInString(status.Text) > maxStatusTextLength {
+		v.add("status_text", ErrTooLong)
+	}
+	if utf8.RuneCountInString(status.Emoji) > maxStatusEmojiLength {
+		v.add("status_emoji", ErrTooLong)
+	}
+	if req.ExpiresIn != nil && *req.ExpiresIn < 0 {
+		v.add("expires_in", ErrOutOfRange)
+	}
+	if len(v.errors) > 0 {
+		return nil, v.errors, nil
+	}
+
+	if req.ExpiresIn != nil {
+		status.ExpiresAt = nil
+		if *req.ExpiresIn > 0 {
+			expiresAt := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Second)
+			status.ExpiresAt = &expiresAt
+		}
+	}
+
+	if err := database.SetUserStatus(status); err != nil {
+		return nil, nil, err
+	}
+	saved, err := database.GetUserStatus(userID)
+	return saved, nil, err
+}
+
+// StatusHandler returns (GET) or changes (POST) the custom status of the current user
+func StatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodGet {
+		status, err := database.GetUserStatus(userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to get status")
+			return
+		}
+		json.NewEncoder(w).Encode(status)
+		return
+	}
+
+	var req statusRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	status, errs, err := applyUserStatus(userID, req)
+	if len(errs) > 0 {
+		writeValidationError(w, r, errs)
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to update status")
+		return
+	}
+
+	// Users without a live connection are offline, the others see their new status when they come back
+	if user, err := database.GetUserByID(userID); err == nil && isUserConnected(userID) {
+		broadcastUserStatus(userID, user.Username, "online")
+	}
+
+	json.NewEncoder(w).Encode(status)
+}
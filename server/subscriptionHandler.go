@@ -0,0 +1,170 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// subscriptionRequest names the post, category or user to follow or stop following
+type subscriptionRequest struct {
+	TargetType string `json:"target_type"`
+	TargetID   string `json:"target_id"`
+}
+
+// SubscriptionsHandler lists (GET) the subscriptions of the current user, or adds one (POST)
+// Users are subscribed to the posts they write or comment automatically
+func SubscriptionsHandler(w http.ResponseWriter, r *http.Request) {
+	userID := currentUserID(r)
+
+	if r.Method == http.MethodGet {
+		subscriptions, err := database.GetSubscriptions(userID)
+		if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to get subscriptions")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(subscriptions)
+		return
+	}
+
+	req, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	var v validator
+	switch req.TargetType {
+	case database.SubscriptionPost:
+		if _, err := database.GetPostByID(database.DB, req.TargetID); err == sql.ErrNoRows {
+			v.add("target_id", ErrNotFound)
+		} else if err != nil {
+			writeError(w, r, http.StatusInternalServerError, "Failed to subscribe")
+			return
+		}
+	case database.SubscriptionCategory:
+		if !isPostCategory(req.TargetID) {
+			v.add("target_id", ErrNotFound)
+		}
+	case database.SubscriptionUser:
+		if req.TargetID == userID {
+			v.add("target_id", ErrInvalid)
+		} else if user, err := database.GetUserByID(req.TargetID); err != nil || user.Username == database.DeletedUsername {
+			v.add("target_id", ErrNotFound)
+		}
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	if err := database.Subscribe(userID, req.TargetType, req.TargetID); err != nil {
+		log.Printf("Error subscribing: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to subscribe")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Subscribed",
+	})
+}
+
+// UnsubscribeHandler removes a subscription of the current user
+func UnsubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeSubscriptionRequest(w, r)
+	if !ok {
+		return
+	}
+
+	removed, err := database.Unsubscribe(currentUserID(r), req.TargetType, req.TargetID)
+	if err != nil {
+		log.Printf("Error unsubscribing: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to unsubscribe")
+		return
+	}
+	if !removed {
+		writeError(w, r, http.StatusNotFound, "Subscription not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Unsubscribed",
+	})
+}
+
+// FollowingFeedHandler lists the posts followed by the current user, newest first
+func FollowingFeedHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r, 20, 100)
+
+	posts, err := database.GetFollowingFeed(currentUserID(r), limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to retrieve posts")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(posts)
+}
+
+// decodeSubscriptionRequest reads the target of a subscription request, answering with an error if it is invalid
+func decodeSubscriptionRequest(w http.ResponseWriter, r *http.Request) (subscriptionRequest, bool) {
+	var req subscriptionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return req, false
+	}
+
+	var v validator
+	switch req.TargetType {
+	case "":
+		v.add("target_type", ErrRequired)
+	case database.SubscriptionPost, database.SubscriptionCategory, database.SubscriptionUser:
+	default:
+		v.add("target_type", ErrInvalid)
+	}
+	if req.TargetID == "" {
+		v.add("target_id", ErrRequired)
+	}
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return req, false
+	}
+	return req, true
+}
+
+// subscribeToPost subscribes a user to a post they wrote or commented
+func subscribeToPost(userID, postID string) {
+	if err := database.Subscribe(userID, database.SubscriptionPost, postID); err != nil {
+		log.Printf("Error subscribing to post: %v", err)
+	}
+}
+
+// sendToSubscribers sends a WebSocket event to the connections of subscribers, except the author
+// of the content and the users who blocked or muted them
+func sendToSubscribers(subscribers []string, authorID string, message interface{}) {
+	hiding, err := database.GetUsersHiding(authorID)
+	if err != nil {
+		log.Printf("Error getting relations: %v", err)
+		return
+	}
+
+	for _, userID := range subscribers {
+		if userID == authorID || hiding[userID] {
+			continue
+		}
+		sendToUser(userID, message)
+	}
+}
+
+// broadcastNewComment tells the subscribers of a post about a new comment
+func broadcastNewComment(comment models.Comment, subscribers []string) {
+	sendToSubscribers(subscribers, comment.UserId, map[string]interface{}{
+		"type":    NewComment,
+		"comment": comment,
+	})
+}
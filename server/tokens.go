@@ -0,0 +1,121 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/mailer"
+	"Real-Time-Forum/shared"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long the links sent by email stay valid
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = 1 * time.Hour
+)
+
+// emailSender delivers the emails of the forum, chosen by the configuration
+var emailSender = newMailer()
+
+// newMailer creates the mailer of the configured driver
+func newMailer() mailer.Mailer {
+	if config.MailDriver == "smtp" {
+		return mailer.NewSMTPMailer(config.SMTPHost, config.SMTPPort, config.SMTPUsername, config.SMTPPassword, config.MailFrom)
+	}
+	return mailer.NewFileMailer(config.MailDir, config.MailFrom)
+}
+
+// sendMail delivers an email in the background, failures are only logged
+func sendMail(msg mailer.Message) {
+	go func() {
+		if err := emailSender.Send(msg); err != nil {
+			log.Printf("Error sending email to %s: %v", msg.To, err)
+		}
+	}()
+}
+
+// issueToken creates a signed, expiring, single-use token for a user
+// The token carries its purpose, user and expiry, and only its hash is stored
+func issueToken(userID, purpose string, ttl time.Duration) (string, error) {
+	expiresAt := time.Now().Add(ttl)
+	data := strings.Join([]string{purpose, userID, strconv.FormatInt(expiresAt.Unix(), 10), shared.RandomToken(16)}, "|")
+	token := shared.SignToken(config.TokenSecret, data)
+
+	if err := database.SaveUserToken(shared.HashToken(token), userID, purpose, expiresAt); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// consumeToken checks the signature and expiry of a token, then uses it up
+// It returns the user the token was issued to
+func consumeToken(token, purpose string) (string, error) {
+	data, ok := shared.VerifyToken(config.TokenSecret, token)
+	if !ok {
+		return "", database.ErrInvalidToken
+	}
+
+	fields := strings.Split(data, "|")
+	if len(fields) != 4 || fields[0] != purpose {
+		return "", database.ErrInvalidToken
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || time.Now().Unix() >= expiresAt {
+		return "", database.ErrInvalidToken
+	}
+
+	userID, err := database.ConsumeUserToken(shared.HashToken(token), purpose)
+	if err != nil {
+		return "", err
+	}
+	if userID != fields[1] {
+		return "", errors.New("token issued to another user")
+	}
+	return userID, nil
+}
+
+// tokenLink returns the link of a page of the forum receiving a token
+func tokenLink(page, token string) string {
+	return fmt.Sprintf("%s/#%s?token=%s", config.PublicURL, page, url.QueryEscape(token))
+}
+
+// sendVerificationEmail sends the link confirming the email address of a user
+func sendVerificationEmail(userID, username, email string) error {
+	token, err := issueToken(userID, database.TokenEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	sendMail(mailer.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Please confirm your email address by opening this link:\n%s\n\n"+
+			"The link expires in 24 hours. You can't post on the forum until your address is confirmed.\n",
+			username, tokenLink("verify-email", token)),
+	})
+	return nil
+}
+
+// sendPasswordResetEmail sends the link choosing a new password
+func sendPasswordResetEmail(userID, username, email string) error {
+	token, err := issueToken(userID, database.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	sendMail(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hello %s,\n\n"+
+			"Someone asked to reset the password of your account. Open this link to choose a new one:\n%s\n\n"+
+			"The link expires in 1 hour. If you did not ask for it, you can ignore this email.\n",
+			username, tokenLink("reset-password", token)),
+	})
+	return nil
+}
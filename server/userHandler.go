@@ -1,83 +1,53 @@
-package server
-
-import (
-	"Real-Time-Forum/database"
-	"encoding/json"
-	"net/http"
-)
-
-func AllUsersHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	users, err := database.GetAllUsers()
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get users"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
-}
-
-func OnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Récupérer les sessions actives depuis la base de données
-	onlineUsers, err := database.GetOnlineUsers()
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get online users"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(onlineUsers)
-}
-
-func UsersOrderedByLastMessageHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != "GET" {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusMethodNotAllowed)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Method not allowed"})
-		return
-	}
-
-	// Vérification de l'authentification
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Unauthorized"})
-		return
-	}
-
-	currentUserID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Invalid session"})
-		return
-	}
-
-	users, err := database.GetUsersOrderedByLastMessage(currentUserID)
-	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		json.NewEncoder(w).Encode(map[string]string{"error": "Failed to get users"})
-		return
-	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(users)
-}
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// Sizes of the pages of the user directory
+const (
+	directoryDefaultLimit = 20
+	directoryMaxLimit     = 100
+)
+
+// AllUsersHandler lists the user directory as seen by the current user, one page at a time
+// The q query parameter keeps the usernames starting with it
+func AllUsersHandler(w http.ResponseWriter, r *http.Request) {
+	page, limit := pageParams(r, directoryDefaultLimit, directoryMaxLimit)
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	users, err := database.SearchUsers(currentUserID(r), query, limit, (page-1)*limit)
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func OnlineUsersHandler(w http.ResponseWriter, r *http.Request) {
+	// Récupérer les sessions actives depuis la base de données
+	onlineUsers, err := database.GetOnlineUsers(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get online users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(onlineUsers)
+}
+
+func UsersOrderedByLastMessageHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := database.GetUsersOrderedByLastMessage(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to get users")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"Real-Time-Forum/models"
+	"net/http"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ErrCodeValidation is returned with the list of rejected fields in the error details
+const ErrCodeValidation = "validation_failed"
+
+// Reasons a field can be rejected for
+const (
+	ErrRequired   = "required"
+	ErrTooShort   = "too_short"
+	ErrTooLong    = "too_long"
+	ErrInvalid    = "invalid"
+	ErrOutOfRange = "out_of_range"
+	ErrWeak       = "weak"
+	ErrNotFound   = "not_found"
+)
+
+// Field limits, the lengths are counted in characters
+const (
+	usernameMinLength    = 3
+	usernameMaxLength    = 30
+	emailMaxLength       = 100 // VARCHAR(100) column
+	nameMaxLength        = 50
+	passwordMinLength    = 8
+	passwordMaxBytes     = 72 // bcrypt ignores anything after 72 bytes
+	minAge               = 13
+	maxAge               = 120
+	postTitleMaxLength   = 50 // VARCHAR(50) column
+	postContentMaxLength = 10000
+	commentMaxLength     = 2000
+	messageMaxLength     = 2000
+)
+
+// Categories a post can be filed under, posts without a category go to the first one
+var postCategories = []string{"general", "technology", "question"}
+
+var usernamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// FieldError describes why a field of a request was rejected:
+//
+//	{"field": "email", "error": "invalid"}
+type FieldError struct {
+	Field string `json:"field"`
+	Error string `json:"error"`
+}
+
+// validator collects the errors of every field of a request
+type validator struct {
+	errors []FieldError
+}
+
+// add rejects a field
+func (v *validator) add(field, reason string) {
+	v.errors = append(v.errors, FieldError{Field: field, Error: reason})
+}
+
+// length checks that a required text field has between min and max characters
+// It returns false when the field was rejected
+func (v *validator) length(field, value string, min, max int) bool {
+	n := utf8.RuneCountInString(value)
+	switch {
+	case n == 0:
+		v.add(field, ErrRequired)
+	case n < min:
+		v.add(field, ErrTooShort)
+	case n > max:
+		v.add(field, ErrTooLong)
+	default:
+		return true
+	}
+	return false
+}
+
+// writeValidationError sends the rejected fields in a 400 response
+func writeValidationError(w http.ResponseWriter, r *http.Request, errs []FieldError) {
+	writeErrorCode(w, r, http.StatusBadRequest, ErrCodeValidation, "Some fields are invalid", errs)
+}
+
+// validateUser checks a registration and trims its text fields
+func validateUser(user *models.User) []FieldError {
+	var v validator
+
+	validateProfile(&v, user)
+	user.Email = strings.TrimSpace(user.Email)
+	validateEmail(&v, "email", user.Email)
+	validatePassword(&v, "password", user.Password)
+
+	return v.errors
+}
+
+// validateProfile checks the fields of a user that can be edited in the account settings
+func validateProfile(v *validator, user *models.User) {
+	user.Username = strings.TrimSpace(user.Username)
+	if v.length("username", user.Username, usernameMinLength, usernameMaxLength) && !usernamePattern.MatchString(user.Username) {
+		v.add("username", ErrInvalid)
+	}
+
+	user.FirstName = strings.TrimSpace(user.FirstName)
+	v.length("first_name", user.FirstName, 1, nameMaxLength)
+	user.LastName = strings.TrimSpace(user.LastName)
+	v.length("last_name", user.LastName, 1, nameMaxLength)
+
+	if user.Age == 0 {
+		v.add("age", ErrRequired)
+	} else if user.Age < minAge || user.Age > maxAge {
+		v.add("age", ErrOutOfRange)
+	}
+
+	if user.Gender == 0 {
+		v.add("gender", ErrRequired)
+	} else if user.Gender < 1 || user.Gender > 3 {
+		v.add("gender", ErrInvalid)
+	}
+}
+
+// validateEmail checks the length and format of an email address
+func validateEmail(v *validator, field, email string) {
+	if v.length(field, email, 1, emailMaxLength) && !isValidEmail(email) {
+		v.add(field, ErrInvalid)
+	}
+}
+
+// validatePassword checks the length of a password and that it mixes letters and digits
+func validatePassword(v *validator, field, password string) {
+	switch {
+	case password == "":
+		v.add(field, ErrRequired)
+	case utf8.RuneCountInString(password) < passwordMinLength:
+		v.add(field, ErrTooShort)
+	case len(password) > passwordMaxBytes:
+		v.add(field, ErrTooLong)
+	case !strings.ContainsFunc(password, unicode.IsLetter) || !strings.ContainsFunc(password, unicode.IsDigit):
+		v.add(field, ErrWeak)
+	}
+}
+
+// isValidEmail accepts a bare address like "name@example.com"
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return false
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	return strings.Contains(domain, ".")
+}
+
+// validatePost checks a new post, trims its title and fills in the default category
+func validatePost(post *models.Post) []FieldError {
+	var v validator
+
+	post.Title = strings.TrimSpace(post.Title)
+	v.length("title", post.Title, 1, postTitleMaxLength)
+	v.length("content", strings.TrimSpace(post.Content), 1, postContentMaxLength)
+
+	post.Category = strings.ToLower(strings.TrimSpace(post.Category))
+	if post.Category == "" {
+		post.Category = postCategories[0]
+	}
+	if !isPostCategory(post.Category) {
+		v.add("category", ErrInvalid)
+	}
+
+	return v.errors
+}
+
+// isPostCategory reports whether a category is one of postCategories
+func isPostCategory(category string) bool {
+	for _, c := range postCategories {
+		if c == category {
+			return true
+		}
+	}
+	return false
+}
+
+// validateComment checks a new comment
+func validateComment(comment *models.Comment) []FieldError {
+	var v validator
+
+	if comment.PostId == "" {
+		v.add("post_id", ErrRequired)
+	}
+	v.length("content", strings.TrimSpace(comment.Content), 1, commentMaxLength)
+
+	return v.errors
+}
+
+// validateMessage checks a private message sent by senderID
+func validateMessage(senderID string, msg *models.Message) []FieldError {
+	var v validator
+
+	if msg.ReceiverID == "" {
+		v.add("receiver_id", ErrRequired)
+	} else if msg.ReceiverID == senderID {
+		v.add("receiver_id", ErrInvalid)
+	}
+	v.length("content", strings.TrimSpace(msg.Content), 1, messageMaxLength)
+	if msg.ClientID != "" {
+		v.length("client_id", msg.ClientID, 0, 64)
+	}
+
+	return v.errors
+}
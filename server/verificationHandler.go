@@ -0,0 +1,167 @@
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// Error codes of the email verification and password reset flows
+const (
+	ErrCodeInvalidToken     = "invalid_token"
+	ErrCodeEmailNotVerified = "email_not_verified"
+)
+
+// VerifyEmailHandler confirms the email address of the user a verification token was sent to
+func VerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token string `json:"token"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Token == "" {
+		writeError(w, r, http.StatusBadRequest, "Token is required")
+		return
+	}
+
+	userID, err := consumeToken(req.Token, database.TokenEmailVerification)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, ErrCodeInvalidToken, "This link is invalid or has expired", nil)
+		return
+	}
+
+	if err := database.MarkEmailVerified(userID); err != nil {
+		writeError(w, r, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Email address verified",
+	})
+}
+
+// ResendVerificationHandler sends a new verification link to the current user
+func ResendVerificationHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := database.GetUserByID(currentUserID(r))
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, "User not found")
+		return
+	}
+	if user.EmailVerified {
+		writeError(w, r, http.StatusConflict, "Email address already verified")
+		return
+	}
+
+	if err := sendVerificationEmail(user.Id, user.Username, user.Email); err != nil {
+		log.Printf("Error issuing verification token: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Verification email sent",
+	})
+}
+
+// RequestPasswordResetHandler sends a password reset link to an email address
+// The response is the same whether an account uses the address or not
+func RequestPasswordResetHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || strings.TrimSpace(req.Email) == "" {
+		writeValidationError(w, r, []FieldError{{Field: "email", Error: ErrRequired}})
+		return
+	}
+
+	if err := sendPasswordReset(strings.TrimSpace(req.Email)); err != nil {
+		log.Printf("Error sending password reset: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account uses this address, a reset link was sent to it",
+	})
+}
+
+// sendPasswordReset sends a reset link to the owner of an email address, if any
+func sendPasswordReset(email string) error {
+	userID, err := database.GetUserIDByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		return err
+	}
+	return sendPasswordResetEmail(user.Id, user.Username, user.Email)
+}
+
+// ResetPasswordHandler sets a new password with a reset token
+// Every session of the user is closed, and the user has to log in again
+func ResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, r, http.StatusBadRequest, "Invalid request body")
+		return
+	}
+
+	// Check the password first so that a rejected one doesn't use up the token
+	var v validator
+	if req.Token == "" {
+		v.add("token", ErrRequired)
+	}
+	validatePassword(&v, "password", req.Password)
+	if len(v.errors) > 0 {
+		writeValidationError(w, r, v.errors)
+		return
+	}
+
+	userID, err := consumeToken(req.Token, database.TokenPasswordReset)
+	if err != nil {
+		writeErrorCode(w, r, http.StatusBadRequest, ErrCodeInvalidToken, "This link is invalid or has expired", nil)
+		return
+	}
+
+	if err := database.UpdatePassword(userID, req.Password); err != nil {
+		log.Printf("Error updating password: %v", err)
+		writeError(w, r, http.StatusInternalServerError, "Failed to reset password")
+		return
+	}
+
+	// Following the link proves the address belongs to the user
+	if err := database.MarkEmailVerified(userID); err != nil {
+		log.Printf("Error marking email as verified: %v", err)
+	}
+
+	// Log out every device, someone else may know the old password
+	sessionIDs, err := database.DeleteUserSessions(userID)
+	if err != nil {
+		log.Printf("Error deleting sessions: %v", err)
+	}
+	closeSessionConnections(sessionIDs...)
+
+	// Lift the lockout caused by failed attempts with the old password
+	if user, err := database.GetUserByID(userID); err == nil {
+		failedLogins.resetAll(user.Username)
+		failedLogins.resetAll(user.Email)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "Password updated, you can now log in",
+	})
+}
@@ -1,261 +1,489 @@
-package server
-
-import (
-	"Real-Time-Forum/database"
-	"encoding/json"
-	"log"
-	"net/http"
-	"sync"
-	"time"
-
-	"github.com/gorilla/websocket"
-)
-
-// Define message types
-const (
-	UserStatusUpdate = "user_status"
-	PrivateMessage   = "private_message"
-	Identify         = "identify"
-	GetOnlineUsers   = "get_online_users"
-	OnlineUsersList  = "online_users"
-	TypingStart      = "typing_start"
-	TypingStop       = "typing_stop"
-	NewPost          = "new_post"
-)
-
-// Upgrader to handle WebSocket connections
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Authorize all origins in development
-	},
-}
-
-// Define a Connection struct
-type Connection struct {
-	UserID string
-	Conn   *websocket.Conn
-}
-
-// Create a slice to store connections instead of a map
-var connections []Connection
-var connectionsLock sync.Mutex
-
-// HandleWebsocket handles WebSocket connections
-func HandleWebsocket(w http.ResponseWriter, r *http.Request) {
-	// Check authentification with cookie
-	cookie, err := r.Cookie("session_id")
-	if err != nil {
-		http.Error(w, "Not authentified", http.StatusUnauthorized)
-		return
-	}
-
-	// Get the user ID from the database using the session ID
-	userID, err := database.GetUserIDFromSession(cookie.Value)
-	if err != nil {
-		http.Error(w, "Invalid session", http.StatusUnauthorized)
-		return
-	}
-
-	// Set this user's status to online
-	database.UpdateSessionStatus(cookie.Value, "online")
-
-	// Get user details for broadcasting
-	user, err := database.GetUserByID(userID)
-	if err != nil {
-		log.Println("Error getting user details:", err)
-	}
-
-	// Upgrade the connection to WebSocket
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println("Upgrade error:", err)
-		return
-	}
-
-	log.Printf("New Websocket connexion from user %s", userID)
-
-	// Add connection to a slice (instead of map)
-	activeConn := Connection{
-		UserID: userID,
-		Conn:   conn,
-	}
-
-	// Add to connections slice with mutex
-	// Avant d'ajouter une nouvelle connexion
-	connectionsLock.Lock()
-	// Supprime les connexions existantes pour cet utilisateur
-	filtered := connections[:0]
-	for _, c := range connections {
-		if c.UserID != userID {
-			filtered = append(filtered, c)
-		}
-	}
-	connections = filtered
-	// Ajoute la nouvelle connexion
-	connections = append(connections, activeConn)
-	connectionsLock.Unlock()
-
-	// Broadcast to all clients that this user is online
-	broadcastUserStatus(userID, user.Username, "online")
-
-	// Send current online users list to the new client
-	sendOnlineUsersList(conn)
-
-	// Set up cleanup on disconnect
-	defer func() {
-		conn.Close()
-
-		// Remove from connections slice
-		connectionsLock.Lock()
-		for i, c := range connections {
-			if c.UserID == userID {
-				// Remove by replacing with last element and truncating
-				connections[i] = connections[len(connections)-1]
-				connections = connections[:len(connections)-1]
-				break
-			}
-		}
-		connectionsLock.Unlock()
-
-		// Update status in database
-		database.UpdateSessionStatus(cookie.Value, "offline")
-
-		// Broadcast offline status
-		broadcastUserStatus(userID, user.Username, "offline")
-
-		log.Printf("WebSocket connection closed for user %s", userID)
-	}()
-
-	// Main message loop
-	for {
-		_, message, err := conn.ReadMessage()
-		if err != nil {
-			log.Printf("Reading error: %v", err)
-			break
-		}
-
-		// Determine message type
-		var msgType struct {
-			Type string `json:"type"`
-		}
-		if err := json.Unmarshal(message, &msgType); err != nil {
-			log.Printf("Error decoding message type: %v", err)
-			continue
-		}
-
-		switch msgType.Type {
-		case PrivateMessage:
-			handlePrivateMessage(conn, userID, message)
-		case Identify:
-			// Just log for now, no action needed
-			log.Printf("User identified: %s", userID)
-		case UserStatusUpdate:
-			// Broadcast the new connection status
-			broadcastUserStatus(userID, user.Username, "online")
-		case GetOnlineUsers:
-			// Send online users list to requester
-			sendOnlineUsersList(conn)
-		case TypingStart:
-			// Handle typing start event
-			handleTypingNotification(userID, message, true)
-		case TypingStop:
-			// Handle typing stop event
-			handleTypingNotification(userID, message, false)
-
-		default:
-			log.Printf("Unknown message type: %s", msgType.Type)
-		}
-	}
-}
-
-// Send all online users to a specific client
-func sendOnlineUsersList(conn *websocket.Conn) {
-	onlineUsers, err := database.GetOnlineUsers()
-	if err != nil {
-		log.Println("Error fetching online users:", err)
-		return
-	}
-
-	// Create a message with all online users
-	message := map[string]interface{}{
-		"type":  OnlineUsersList,
-		"users": onlineUsers,
-	}
-
-	// Send the message
-	messageJSON, _ := json.Marshal(message)
-	conn.WriteMessage(websocket.TextMessage, messageJSON)
-}
-
-// Broadcast user status change to all connected clients
-func broadcastUserStatus(userID, username, status string) {
-	message := map[string]interface{}{
-		"type":      "user_status",
-		"user_id":   userID,
-		"username":  username,
-		"status":    status,
-		"timestamp": time.Now().UnixNano() / int64(time.Millisecond),
-	}
-
-	messageJSON, _ := json.Marshal(message)
-
-	// Send to all connections
-	connectionsLock.Lock()
-	for _, conn := range connections {
-		// Skip sending to the user who changed status
-		if conn.UserID != userID {
-			conn.Conn.WriteMessage(websocket.TextMessage, messageJSON)
-		}
-	}
-	connectionsLock.Unlock()
-}
-
-func handleTypingNotification(senderID string, rawMessage []byte, isTyping bool) {
-	var msg struct {
-		ReceiverID string `json:"receiver_id"`
-	}
-	if err := json.Unmarshal(rawMessage, &msg); err != nil {
-		log.Printf("Error decoding typing notification: %v", err)
-		return
-	}
-
-	// Retrieve sender info from the database
-	sender, err := database.GetUserByID(senderID)
-	if err != nil {
-		log.Printf("Error getting sender info: %v", err)
-		return
-	}
-
-	connectionsLock.Lock()
-	defer connectionsLock.Unlock()
-
-	// Find the connection for the receiver to send the typing notification
-	for _, c := range connections {
-		if c.UserID == msg.ReceiverID {
-			status := TypingStop
-			if isTyping {
-				status = TypingStart
-			}
-
-			notification := map[string]interface{}{
-				"type":            status,
-				"sender_id":       senderID,
-				"sender_username": sender.Username,
-			}
-
-			messageJSON, err := json.Marshal(notification)
-			if err != nil {
-				log.Printf("Error marshaling typing notification: %v", err)
-				return
-			}
-
-			err = c.Conn.WriteMessage(websocket.TextMessage, messageJSON)
-			if err != nil {
-				log.Printf("Error sending typing notification: %v", err)
-			}
-			break
-		}
-	}
-}
+package server
+
+import (
+	"Real-Time-Forum/database"
+	"Real-Time-Forum/models"
+	"encoding/json"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Define message types
+const (
+	UserStatusUpdate  = "user_status"
+	PrivateMessage    = "private_message"
+	Identify          = "identify"
+	GetOnlineUsers    = "get_online_users"
+	OnlineUsersList   = "online_users"
+	TypingStart       = "typing_start"
+	TypingStop        = "typing_stop"
+	NewPost           = "new_post"    // sent to the subscribers of the category or the author
+	NewComment        = "new_comment" // sent to the subscribers of the post
+	RateLimited       = "rate_limited"
+	WSError           = "error"
+	NewReport         = "new_report"       // sent to moderators only
+	ContentFiltered   = "content_filtered" // sent to moderators only
+	NotificationEvent = "notification"
+	PreviewReady      = "preview_ready" // sent to the readers of a post or a message
+	DraftSave         = "draft_save"
+	DraftUpdated      = "draft_updated" // sent to the other connections of the user
+	DraftDeleted      = "draft_deleted"
+)
+
+// A client that stays under the limits for this long has its violations forgiven
+const wsViolationWindow = time.Minute
+
+// Upgrader to handle WebSocket connections
+// Only pages served by the forum itself or an allowed origin can open a socket
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+	CheckOrigin:     isAllowedOrigin,
+}
+
+// Define a Connection struct
+type Connection struct {
+	UserID    string
+	SessionID string
+	Conn      *websocket.Conn
+}
+
+// Create a slice to store connections instead of a map
+var connections []Connection
+var connectionsLock sync.Mutex
+
+// HandleWebsocket handles WebSocket connections
+func HandleWebsocket(w http.ResponseWriter, r *http.Request) {
+	// The session was resolved by the authentication middleware
+	userID := currentUserID(r)
+	sessionID := currentSessionID(r)
+
+	// Set this user's status to online
+	database.UpdateSessionStatus(sessionID, "online")
+
+	// Get user details for broadcasting
+	user, err := database.GetUserByID(userID)
+	if err != nil {
+		log.Println("Error getting user details:", err)
+	}
+
+	// Upgrade the connection to WebSocket
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println("Upgrade error:", err)
+		return
+	}
+
+	log.Printf("New Websocket connexion from user %s", userID)
+
+	// Add connection to a slice (instead of map)
+	// A user can be connected from several devices or tabs at the same time
+	activeConn := Connection{
+		UserID:    userID,
+		SessionID: sessionID,
+		Conn:      conn,
+	}
+
+	// Add to connections slice with mutex
+	connectionsLock.Lock()
+	connections = append(connections, activeConn)
+	connectionsLock.Unlock()
+
+	// Broadcast to all clients that this user is online
+	broadcastUserStatus(userID, user.Username, "online")
+
+	// Send current online users list to the new client
+	sendOnlineUsersList(conn, userID)
+
+	// Set up cleanup on disconnect
+	defer func() {
+		conn.Close()
+
+		// Remove from connections slice
+		connectionsLock.Lock()
+		for i, c := range connections {
+			if c.Conn == conn {
+				// Remove by replacing with last element and truncating
+				connections[i] = connections[len(connections)-1]
+				connections = connections[:len(connections)-1]
+				break
+			}
+		}
+		connectionsLock.Unlock()
+
+		// Update status in database
+		database.UpdateSessionStatus(sessionID, "offline")
+		database.UpdateLastSeen(userID)
+
+		// Broadcast offline status once the user's last connection is gone
+		if !isUserConnected(userID) {
+			broadcastUserStatus(userID, user.Username, "offline")
+		}
+
+		log.Printf("WebSocket connection closed for user %s", userID)
+	}()
+
+	// Rate limited frames sent by this client, it is disconnected after too many of them
+	violations := 0
+	var lastViolation time.Time
+
+	// Main message loop
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			log.Printf("Reading error: %v", err)
+			break
+		}
+
+		// Activity on the socket keeps the session alive, and a revoked or
+		// expired session ends the connection
+		if _, err := database.GetUserIDFromSession(sessionID); err != nil {
+			log.Printf("Session of user %s is no longer valid", userID)
+			break
+		}
+
+		// Determine message type
+		var msgType struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(message, &msgType); err != nil {
+			log.Printf("Error decoding message type: %v", err)
+			continue
+		}
+
+		// Every frame counts against the WebSocket limit, messages and
+		// typing events also have their own limits
+		if action, wait := wsRateLimit(userID, msgType.Type); action != "" {
+			if time.Since(lastViolation) > wsViolationWindow {
+				violations = 0
+			}
+			violations++
+			lastViolation = time.Now()
+
+			if violations > config.WSMaxViolations {
+				log.Printf("Closing WebSocket of user %s after too many rate limited frames", userID)
+				closeMessage := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "rate limit exceeded")
+				conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+				break
+			}
+
+			sendToConn(conn, map[string]interface{}{
+				"type":           RateLimited,
+				"action":         action,
+				"message_type":   msgType.Type,
+				"retry_after_ms": wait.Milliseconds(),
+			})
+			continue
+		}
+
+		switch msgType.Type {
+		case PrivateMessage:
+			handlePrivateMessage(conn, userID, message)
+		case Identify:
+			// Just log for now, no action needed
+			log.Printf("User identified: %s", userID)
+		case UserStatusUpdate:
+			// Save the custom status if one was sent, then broadcast it
+			handleUserStatusUpdate(conn, userID, message)
+			broadcastUserStatus(userID, user.Username, "online")
+		case GetOnlineUsers:
+			// Send online users list to requester
+			sendOnlineUsersList(conn, userID)
+		case TypingStart:
+			// Handle typing start event
+			handleTypingNotification(userID, message, true)
+		case TypingStop:
+			// Handle typing stop event
+			handleTypingNotification(userID, message, false)
+		case DraftSave:
+			// Save the text being written and share it with the other tabs of the user
+			handleDraftSave(conn, userID, message)
+
+		default:
+			log.Printf("Unknown message type: %s", msgType.Type)
+		}
+	}
+}
+
+// wsRateLimit checks the limits of a frame sent by a user
+// It returns the action whose limit was reached and how long to wait, or "" when the frame is allowed
+func wsRateLimit(userID, msgType string) (string, time.Duration) {
+	if ok, wait := allowAction(ActionWebSocket, userID); !ok {
+		return ActionWebSocket, wait
+	}
+
+	var action string
+	switch msgType {
+	case PrivateMessage:
+		action = ActionMessage
+	case TypingStart, TypingStop:
+		action = ActionTyping
+	case DraftSave:
+		action = ActionDraft
+	default:
+		return "", 0
+	}
+
+	if ok, wait := allowAction(action, userID); !ok {
+		return action, wait
+	}
+	return "", 0
+}
+
+// sendToConn sends a JSON message to a single connection
+// Writes are serialized with the connections lock, as gorilla/websocket allows one writer at a time
+func sendToConn(conn *websocket.Conn, message interface{}) {
+	messageJSON, err := json.Marshal(message)
+	if err != nil {
+		log.Printf("Error marshaling message: %v", err)
+		return
+	}
+
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+	if err := conn.WriteMessage(websocket.TextMessage, messageJSON); err != nil {
+		log.Printf("Error sending message: %v", err)
+	}
+}
+
+// sendToModerators sends a JSON message to every connection of a moderator or admin
+func sendToModerators(message interface{}) {
+	connectionsLock.Lock()
+	recipients := make([]Connection, len(connections))
+	copy(recipients, connections)
+	connectionsLock.Unlock()
+
+	isModerator := make(map[string]bool)
+	for _, c := range recipients {
+		moderator, checked := isModerator[c.UserID]
+		if !checked {
+			role, err := database.GetUserRole(c.UserID)
+			moderator = err == nil && hasRole(role, models.RoleModerator)
+			isModerator[c.UserID] = moderator
+		}
+		if moderator {
+			sendToConn(c.Conn, message)
+		}
+	}
+}
+
+// sendToUser sends a JSON message to every connection of a user
+func sendToUser(userID string, message interface{}) {
+	connectionsLock.Lock()
+	var recipients []*websocket.Conn
+	for _, c := range connections {
+		if c.UserID == userID {
+			recipients = append(recipients, c.Conn)
+		}
+	}
+	connectionsLock.Unlock()
+
+	for _, conn := range recipients {
+		sendToConn(conn, message)
+	}
+}
+
+// isUserConnected reports whether a user has at least one live connection
+func isUserConnected(userID string) bool {
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+
+	for _, c := range connections {
+		if c.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// closeSessionConnections closes the connections opened with any of the given sessions
+func closeSessionConnections(sessionIDs ...string) {
+	revoked := make(map[string]bool, len(sessionIDs))
+	for _, sessionID := range sessionIDs {
+		revoked[sessionID] = true
+	}
+
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+
+	// The read loop of each connection notices the close and cleans up after itself
+	closeMessage := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "session revoked")
+	for _, c := range connections {
+		if revoked[c.SessionID] {
+			c.Conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(time.Second))
+			c.Conn.Close()
+		}
+	}
+}
+
+// Send the online users that userID can see to a specific client
+func sendOnlineUsersList(conn *websocket.Conn, userID string) {
+	onlineUsers, err := database.GetOnlineUsers(userID)
+	if err != nil {
+		log.Println("Error fetching online users:", err)
+		return
+	}
+
+	// Create a message with all online users
+	message := map[string]interface{}{
+		"type":  OnlineUsersList,
+		"users": onlineUsers,
+	}
+
+	// Send the message
+	sendToConn(conn, message)
+}
+
+// Save the custom status sent with a user_status event
+// An event without any status field only re-broadcasts the current status
+// The sender is told which fields were rejected, the current status is then kept
+func handleUserStatusUpdate(conn *websocket.Conn, userID string, rawMessage []byte) {
+	var req statusRequest
+	if err := json.Unmarshal(rawMessage, &req); err != nil {
+		log.Printf("Error decoding user status: %v", err)
+		return
+	}
+	if req.isEmpty() {
+		return
+	}
+
+	_, errs, err := applyUserStatus(userID, req)
+	if err != nil {
+		log.Printf("Error updating user status: %v", err)
+		return
+	}
+	if len(errs) > 0 {
+		sendToConn(conn, map[string]interface{}{
+			"type":         WSError,
+			"code":         ErrCodeValidation,
+			"message_type": UserStatusUpdate,
+			"errors":       errs,
+		})
+	}
+}
+
+// Broadcast user status change to all connected clients
+// Invisible users are always announced as offline, and users hidden from the
+// directory are only announced to the people they talk with
+func broadcastUserStatus(userID, username, status string) {
+	message := map[string]interface{}{
+		"type":      "user_status",
+		"user_id":   userID,
+		"username":  username,
+		"status":    status,
+		"timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+	}
+
+	if avatarURL, err := database.GetAvatarURL(userID); err != nil {
+		log.Printf("Error getting avatar: %v", err)
+	} else {
+		message["avatar_url"] = avatarURL
+	}
+
+	customStatus, err := database.GetUserStatus(userID)
+	if err != nil {
+		log.Printf("Error getting user status: %v", err)
+	} else if customStatus.Availability == models.AvailabilityInvisible {
+		message["status"] = "offline"
+	} else {
+		message["availability"] = customStatus.Availability
+		message["status_text"] = customStatus.Text
+		message["status_emoji"] = customStatus.Emoji
+		message["status_expires_at"] = customStatus.ExpiresAt
+	}
+
+	privacy, err := database.GetPrivacySettings(userID)
+	if err != nil {
+		log.Printf("Error getting privacy settings: %v", err)
+		return
+	}
+
+	// Collect the recipients first, the database is not queried while holding the lock
+	connectionsLock.Lock()
+	recipients := make([]Connection, 0, len(connections))
+	for _, conn := range connections {
+		// Skip sending to the user who changed status
+		if conn.UserID != userID {
+			recipients = append(recipients, conn)
+		}
+	}
+	connectionsLock.Unlock()
+
+	canSee := make(map[string]bool)
+	for _, conn := range recipients {
+		visible, checked := canSee[conn.UserID]
+		if !checked {
+			visible = privacy.ShowInDirectory
+			if !visible {
+				if visible, err = database.HaveConversation(userID, conn.UserID); err != nil {
+					log.Printf("Error checking conversation: %v", err)
+				}
+			}
+			canSee[conn.UserID] = visible
+		}
+		if visible {
+			sendToConn(conn.Conn, message)
+		}
+	}
+}
+
+func handleTypingNotification(senderID string, rawMessage []byte, isTyping bool) {
+	var msg struct {
+		ReceiverID string `json:"receiver_id"`
+	}
+	if err := json.Unmarshal(rawMessage, &msg); err != nil {
+		log.Printf("Error decoding typing notification: %v", err)
+		return
+	}
+
+	// Typing notifications are not sent to users in do-not-disturb mode
+	if database.IsUserDoNotDisturb(msg.ReceiverID) {
+		return
+	}
+
+	// Nor between users when one blocked the other
+	if blocked, err := database.IsBlockedBetween(senderID, msg.ReceiverID); err != nil || blocked {
+		return
+	}
+
+	// Retrieve sender info from the database
+	sender, err := database.GetUserByID(senderID)
+	if err != nil {
+		log.Printf("Error getting sender info: %v", err)
+		return
+	}
+
+	connectionsLock.Lock()
+	defer connectionsLock.Unlock()
+
+	// Find the connections of the receiver to send the typing notification
+	for _, c := range connections {
+		if c.UserID == msg.ReceiverID {
+			status := TypingStop
+			if isTyping {
+				status = TypingStart
+			}
+
+			notification := map[string]interface{}{
+				"type":            status,
+				"sender_id":       senderID,
+				"sender_username": sender.Username,
+			}
+
+			messageJSON, err := json.Marshal(notification)
+			if err != nil {
+				log.Printf("Error marshaling typing notification: %v", err)
+				return
+			}
+
+			err = c.Conn.WriteMessage(websocket.TextMessage, messageJSON)
+			if err != nil {
+				log.Printf("Error sending typing notification: %v", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package shared
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress is returned when connecting to an address of the local network,
+// which the server must not be made to reach on behalf of a user
+var ErrPrivateAddress = errors.New("address is not public")
+
+// Ranges not covered by the netip helpers that are not reachable on the internet either
+var reservedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("240.0.0.0/4"),
+	netip.MustParsePrefix("64:ff9b::/96"), // NAT64, which can lead to any IPv4 address
+}
+
+// PublicDialer creates a dialer that only connects to public addresses
+// The address is checked once resolved, right before connecting, so that a name
+// resolving to a private address is refused as well, even after a redirect
+func PublicDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			return CheckPublicAddress(address)
+		},
+	}
+}
+
+// CheckPublicAddress refuses the loopback, private, link-local and other non public addresses,
+// given as host:port with the host already resolved
+func CheckPublicAddress(address string) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return ErrPrivateAddress
+	}
+	ip = ip.Unmap()
+
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified() {
+		return ErrPrivateAddress
+	}
+	for _, prefix := range reservedPrefixes {
+		if prefix.Contains(ip) {
+			return ErrPrivateAddress
+		}
+	}
+	return nil
+}
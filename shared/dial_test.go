@@ -0,0 +1,41 @@
+package shared
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckPublicAddress(t *testing.T) {
+	private := []string{
+		"127.0.0.1:80",
+		"127.1.2.3:443",
+		"[::1]:80",
+		"10.0.0.1:80",
+		"10.255.255.255:8080",
+		"172.16.0.1:80",
+		"192.168.1.1:80",
+		"169.254.169.254:80", // cloud metadata
+		"[fe80::1]:80",
+		"[fc00::1]:80",
+		"[::ffff:127.0.0.1]:80",
+		"[::ffff:10.0.0.1]:80",
+		"[64:ff9b::7f00:1]:80",
+		"0.0.0.0:80",
+		"[::]:80",
+		"100.64.0.1:80",
+		"224.0.0.1:80",
+		"localhost:80", // names are only checked once resolved
+	}
+	for _, address := range private {
+		if err := CheckPublicAddress(address); !errors.Is(err, ErrPrivateAddress) {
+			t.Errorf("CheckPublicAddress(%q) = %v, want ErrPrivateAddress", address, err)
+		}
+	}
+
+	public := []string{"93.184.215.14:443", "8.8.8.8:53", "[2606:4700::1111]:443", "[::ffff:8.8.8.8]:80"}
+	for _, address := range public {
+		if err := CheckPublicAddress(address); err != nil {
+			t.Errorf("CheckPublicAddress(%q) = %v, want nil", address, err)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Number of calls to Allow between two sweeps of the idle buckets
+const sweepEvery = 1000
+
+// RateLimiter is a set of token buckets, one per key (a user, an IP address...)
+// Each bucket holds up to burst tokens and refills at rate tokens per second
+type RateLimiter struct {
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	calls   int
+	mu      sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter creates a limiter allowing rate events per second, with bursts of up to burst events
+func NewRateLimiter(rate float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+// Allow takes a token from the bucket of key
+// When the bucket is empty it returns false and how long to wait for the next token
+func (l *RateLimiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.calls++
+	if l.calls%sweepEvery == 0 {
+		l.sweep(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+
+	// Refill the tokens earned since the last call
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	return false, wait
+}
+
+// sweep forgets the buckets that had time to refill completely, they are equivalent to new ones
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package shared
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"log"
+	"strings"
+)
+
+// RandomToken returns a random hex string made of n bytes from crypto/rand
+func RandomToken(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		log.Println("ERROR: can't generate random token:", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// SignToken returns data and its HMAC-SHA256 signature, both base64url encoded and
+// separated by a dot, so that the data can be trusted when it comes back
+func SignToken(secret []byte, data string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(data))
+	return base64.RawURLEncoding.EncodeToString([]byte(data)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyToken checks the signature of a token made by SignToken and returns its data
+func VerifyToken(secret []byte, token string) (string, bool) {
+	encodedData, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", false
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(encodedData)
+	if err != nil {
+		return "", false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", false
+	}
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(data)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", false
+	}
+	return string(data), true
+}
+
+// HashToken returns the SHA-256 of a token, tokens are only stored hashed
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,56 @@
+// Base path of the versioned REST API
+export const API_BASE = "/api/v1";
+
+// Escapes text before inserting it in HTML
+export function escapeHTML(text) {
+  const div = document.createElement("div");
+  div.textContent = text ?? "";
+  return div.innerHTML;
+}
+
+// Returns the readable message of an API error response, or the fallback
+// Errors are sent as { error: { code, message, details, request_id } }
+// Validation errors list the rejected fields, they are appended to the message
+export function apiErrorMessage(data, fallback) {
+  const error = data?.error;
+  if (!error) return fallback;
+  if (error.code === "validation_failed" && Array.isArray(error.details)) {
+    return describeFieldErrors(error.details);
+  }
+  if (error.code === "content_rejected" && Array.isArray(error.details)) {
+    return `${error.message}: ${error.details.map((m) => m.detail).join(", ")}`;
+  }
+  return error.message || fallback;
+}
+
+// Readable labels of the reasons a field can be rejected for
+const fieldErrorLabels = {
+  required: "is required",
+  too_short: "is too short",
+  too_long: "is too long",
+  invalid: "is invalid",
+  out_of_range: "is out of range",
+  weak: "must contain letters and digits",
+  not_found: "does not exist",
+};
+
+// Turns [{ field, error }] into a sentence like "email is invalid, age is out of range"
+export function describeFieldErrors(errors) {
+  return errors
+    .map(({ field, error }) => `${field.replace("_", " ")} ${fieldErrorLabels[error] || error}`)
+    .join(", ");
+}
+
+// CSRF token of the current session, required on state-changing requests
+let csrfToken = null;
+
+// Keeps the CSRF token sent by the server on login and session checks
+export function storeCSRFToken(response) {
+  const token = response.headers.get("X-CSRF-Token");
+  if (token) csrfToken = token;
+}
+
+// Adds the CSRF token to the headers of a state-changing request
+export function csrfHeaders(headers = {}) {
+  return csrfToken ? { ...headers, "X-CSRF-Token": csrfToken } : headers;
+}
@@ -0,0 +1,53 @@
+import { API_BASE, apiErrorMessage, csrfHeaders, escapeHTML } from "./api.js";
+
+// Uploads the files picked in an input and returns the created attachments,
+// whose IDs are then sent as the attachment_ids of the content
+// The files are sent one by one, the first refused file stops the upload
+export async function uploadFiles(files) {
+  const uploads = [];
+  for (const file of files || []) {
+    const form = new FormData();
+    form.append("file", file);
+
+    const response = await fetch(`${API_BASE}/attachments`, {
+      method: "POST",
+      credentials: "include",
+      headers: csrfHeaders(),
+      body: form,
+    });
+    const data = await response.json().catch(() => null);
+    if (!response.ok) {
+      throw new Error(`${file.name}: ${apiErrorMessage(data, "Failed to upload the file")}`);
+    }
+    uploads.push(data);
+  }
+  return uploads;
+}
+
+// Returns a size like "12 KB" or "3.4 MB"
+function formatSize(bytes) {
+  if (bytes < 1024) return `${bytes} B`;
+  if (bytes < 1024 * 1024) return `${Math.round(bytes / 1024)} KB`;
+  return `${(bytes / (1024 * 1024)).toFixed(1)} MB`;
+}
+
+// Returns the HTML of the attachments of a post, a comment or a message
+// Images are shown as thumbnails opening the full file, other files as download links
+export function renderAttachments(attachments) {
+  if (!attachments || attachments.length === 0) return "";
+
+  const items = attachments.map((a) => {
+    const name = escapeHTML(a.filename);
+    if (a.thumbnail_url) {
+      return `
+        <a class="attachment attachment-image" href="${a.url}" target="_blank" rel="noopener">
+          <img src="${a.thumbnail_url}" alt="${name}" loading="lazy">
+        </a>`;
+    }
+    return `
+      <a class="attachment attachment-file" href="${a.url}" download="${name}">
+        📎 ${name} <span class="attachment-size">${formatSize(a.size)}</span>
+      </a>`;
+  });
+  return `<div class="attachments">${items.join("")}</div>`;
+}
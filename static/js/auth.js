@@ -1,135 +1,239 @@
-import { navigateTo, initializeWebSocket } from "./main.js";
-import { setCurrentUser } from "./users.js";
-
-// Function to attach the event to the form
-export function attachRegisterEventListener() {
-  const form = document.getElementById("registerForm");
-
-  form.addEventListener("submit", async function (event) {
-    event.preventDefault(); // Prevent default behaviour to let the JS handle the form submission
-
-    // Collect form data
-    const formData = {
-      username: document.getElementById("username").value,
-      age: parseInt(document.getElementById("age").value),
-      gender: parseInt(document.getElementById("gender").value),
-      first_name: document.getElementById("first_name").value,
-      last_name: document.getElementById("last_name").value,
-      email: document.getElementById("email").value,
-      password: document.getElementById("password").value,
-    };
-
-
-    try {
-      // HTTP request to create a new user
-      const response = await fetch("/register", {
-        method: "POST",
-        body: JSON.stringify(formData), // converts the form data to JSON format
-        headers: { "Content-Type": "application/json" },
-      });
-
-      // Wait for the response and converts it to JS object
-      const data = await response.json();
-      if (!response.ok) {
-        throw new Error(data.error || "Registration failed");
-      }
-
-      console.log("Success:", data);
-      navigateTo("login");
-    } catch (error) {
-      console.error("Error:", error);
-      displayError(error.message, form);
-    }
-  });
-}
-
-// Function to display an error message in the form
-function displayError(message, form) {
-  const errorContainer = document.getElementById("error-message");
-
-  // If the error container does not exist, create it
-  if (!errorContainer) {
-    const container = document.createElement("div");
-    container.id = "error-message";
-    container.className = "error-message";
-    form.insertBefore(container, form.firstChild);
-  }
-
-  // Set the error message and display it
-  const errorElement = document.getElementById("error-message");
-  errorElement.textContent = message;
-  errorElement.style.display = "block";
-}
-
-// Function to attach the event to the login form
-export function attachLoginEventListener() {
-  const form = document.getElementById("loginForm");
-
-  form.addEventListener("submit", async function (event) {
-    event.preventDefault();
-
-    const identifier = document.getElementById("usernameOrMail").value;
-    const password = document.getElementById("password").value;
-
-    try {
-      const response = await fetch("/login", {
-        method: "POST",
-        headers: {
-          "Content-Type": "application/json",
-        },
-        body: JSON.stringify({
-          identifier: identifier,
-          password: password,
-        }),
-        credentials: "include", // Include cookies in the request
-      });
-
-      const userData = await response.json();
-      if (!response.ok) {
-        throw new Error(userData.error || "Login failed");
-      }
-
-      // Close the old WebSocket connection if it exists
-      if (window.websocket) {
-        window.websocket.close();
-        window.websocket = null;
-      }
-
-      console.log("User data received:", userData);
-      // Update the global currentUser variable
-      window.currentUser = userData;
-      // Update the current user state in the application
-      setCurrentUser(userData);
-      
-      console.log("User data updated, calling navigation");
-      // AWait a short timeout to ensure the DOM is updated
-      setTimeout(() => {
-        document.getElementById("logout-button");
-        initializeWebSocket();
-        navigateTo("home");
-      }, 50);
-    } catch (error) {
-      console.error("Login error:", error);
-      errorMessage.textContent = error.message;
-    }
-  });
-}
-
-export function logout() {
-  // Close the WebSocket connection if it exists
-  if (window.websocket) {
-    window.websocket.close();
-    window.websocket = null;
-  }
-
-  fetch("/logout", { method: "POST", credentials: "include" })
-    .then((response) => response.json())
-    .then(() => {
-      // Update the current user state
-      window.currentUser = null;
-      setCurrentUser(null);
-      // redirect to the login page
-      navigateTo("login");
-    })
-    .catch((error) => console.error("Logout error:", error));
-}
\ No newline at end of file
+import { navigateTo, initializeWebSocket } from "./main.js";
+import { setCurrentUser } from "./users.js";
+import { API_BASE, apiErrorMessage, storeCSRFToken, csrfHeaders } from "./api.js";
+import { disablePush } from "./push.js";
+
+// Function to attach the event to the form
+export function attachRegisterEventListener() {
+  const form = document.getElementById("registerForm");
+
+  form.addEventListener("submit", async function (event) {
+    event.preventDefault(); // Prevent default behaviour to let the JS handle the form submission
+
+    // Collect form data
+    const formData = {
+      username: document.getElementById("username").value,
+      age: parseInt(document.getElementById("age").value),
+      gender: parseInt(document.getElementById("gender").value),
+      first_name: document.getElementById("first_name").value,
+      last_name: document.getElementById("last_name").value,
+      email: document.getElementById("email").value,
+      password: document.getElementById("password").value,
+    };
+
+
+    try {
+      // HTTP request to create a new user
+      const response = await fetch(`${API_BASE}/register`, {
+        method: "POST",
+        body: JSON.stringify(formData), // converts the form data to JSON format
+        headers: { "Content-Type": "application/json" },
+      });
+
+      // Wait for the response and converts it to JS object
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(apiErrorMessage(data, "Registration failed"));
+      }
+
+      console.log("Success:", data);
+      navigateTo("login");
+    } catch (error) {
+      console.error("Error:", error);
+      displayError(error.message, form);
+    }
+  });
+}
+
+// Function to display an error message in the form
+function displayError(message, form) {
+  const errorContainer = document.getElementById("error-message");
+
+  // If the error container does not exist, create it
+  if (!errorContainer) {
+    const container = document.createElement("div");
+    container.id = "error-message";
+    container.className = "error-message";
+    form.insertBefore(container, form.firstChild);
+  }
+
+  // Set the error message and display it
+  const errorElement = document.getElementById("error-message");
+  errorElement.textContent = message;
+  errorElement.style.display = "block";
+}
+
+// Function to attach the event to the login form
+export function attachLoginEventListener() {
+  const form = document.getElementById("loginForm");
+
+  form.addEventListener("submit", async function (event) {
+    event.preventDefault();
+
+    const identifier = document.getElementById("usernameOrMail").value;
+    const password = document.getElementById("password").value;
+    const rememberMe = document.getElementById("remember-me").checked;
+
+    try {
+      const response = await fetch(`${API_BASE}/login`, {
+        method: "POST",
+        headers: {
+          "Content-Type": "application/json",
+        },
+        body: JSON.stringify({
+          identifier: identifier,
+          password: password,
+          remember_me: rememberMe,
+        }),
+        credentials: "include", // Include cookies in the request
+      });
+
+      const userData = await response.json();
+      storeCSRFToken(response);
+      if (!response.ok) {
+        throw new Error(apiErrorMessage(userData, "Login failed"));
+      }
+
+      // Close the old WebSocket connection if it exists
+      if (window.websocket) {
+        window.websocket.close();
+        window.websocket = null;
+      }
+
+      console.log("User data received:", userData);
+      // Update the global currentUser variable
+      window.currentUser = userData;
+      // Update the current user state in the application
+      setCurrentUser(userData);
+      
+      console.log("User data updated, calling navigation");
+      // AWait a short timeout to ensure the DOM is updated
+      setTimeout(() => {
+        document.getElementById("logout-button");
+        initializeWebSocket();
+        navigateTo("home");
+      }, 50);
+    } catch (error) {
+      console.error("Login error:", error);
+      errorMessage.textContent = error.message;
+    }
+  });
+}
+
+export async function logout() {
+  // Close the WebSocket connection if it exists
+  if (window.websocket) {
+    window.websocket.close();
+    window.websocket = null;
+  }
+
+  // This browser stops receiving the pushes of the user leaving
+  await disablePush().catch((error) => console.error("Error disabling push notifications:", error));
+
+  fetch(`${API_BASE}/logout`, {
+    method: "POST",
+    headers: csrfHeaders(),
+    credentials: "include",
+  })
+    .then((response) => response.json())
+    .then(() => {
+      // Update the current user state
+      window.currentUser = null;
+      setCurrentUser(null);
+      // redirect to the login page
+      navigateTo("login");
+    })
+    .catch((error) => console.error("Logout error:", error));
+}
+
+// Function to attach the event to the forgot password form
+export function attachForgotPasswordEventListener() {
+  const form = document.getElementById("forgotPasswordForm");
+
+  form.addEventListener("submit", async function (event) {
+    event.preventDefault();
+
+    try {
+      const response = await fetch(`${API_BASE}/password-reset/request`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({ email: document.getElementById("email").value }),
+      });
+
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(apiErrorMessage(data, "Request failed"));
+      }
+
+      document.getElementById("form-info").textContent = data.message;
+      form.reset();
+    } catch (error) {
+      displayError(error.message, form);
+    }
+  });
+}
+
+// Function to attach the event to the reset password form, token comes from the emailed link
+export function attachResetPasswordEventListener(token) {
+  const form = document.getElementById("resetPasswordForm");
+
+  form.addEventListener("submit", async function (event) {
+    event.preventDefault();
+
+    try {
+      const response = await fetch(`${API_BASE}/password-reset`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        body: JSON.stringify({
+          token: token,
+          password: document.getElementById("password").value,
+        }),
+      });
+
+      const data = await response.json();
+      if (!response.ok) {
+        throw new Error(apiErrorMessage(data, "Password reset failed"));
+      }
+
+      alert(data.message);
+      navigateTo("login");
+    } catch (error) {
+      displayError(error.message, form);
+    }
+  });
+}
+
+// Confirms the email address with the token of the emailed link
+export async function verifyEmail(token) {
+  const info = document.getElementById("form-info");
+
+  try {
+    const response = await fetch(`${API_BASE}/verify-email`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      body: JSON.stringify({ token: token }),
+    });
+
+    const data = await response.json();
+    if (!response.ok) {
+      throw new Error(apiErrorMessage(data, "Verification failed"));
+    }
+    info.textContent = data.message;
+  } catch (error) {
+    info.textContent = error.message;
+  }
+}
+
+// Sends a new verification email to the current user
+export function resendVerificationEmail() {
+  fetch(`${API_BASE}/verify-email/resend`, {
+    method: "POST",
+    headers: csrfHeaders(),
+    credentials: "include",
+  })
+    .then(async (response) => {
+      const data = await response.json();
+      alert(response.ok ? data.message : apiErrorMessage(data, "Failed to send the email"));
+    })
+    .catch((error) => console.error("Resend verification error:", error));
+}
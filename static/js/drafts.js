@@ -0,0 +1,117 @@
+import { API_BASE } from "./api.js";
+
+// Drafts of the current user, by "target_type:target_id"
+const drafts = new Map();
+// Saves waiting for the user to stop typing, by draft key
+const pendingSaves = new Map();
+// Forms whose fields are kept in drafts: { fields: { title, content }, target }
+let boundForms = [];
+
+// How long the user has to stop typing before a draft is saved
+const SAVE_DELAY = 1000;
+
+function draftKey(type, id = "") {
+  return `${type}:${id}`;
+}
+
+// Loads the drafts of the user and fills the empty forms with them
+export async function loadDrafts() {
+  try {
+    const response = await fetch(`${API_BASE}/drafts`, { credentials: "include" });
+    if (!response.ok) return;
+    drafts.clear();
+    for (const draft of await response.json()) {
+      drafts.set(draftKey(draft.target_type, draft.target_id), draft);
+    }
+  } catch (error) {
+    console.error("Error loading drafts:", error);
+    return;
+  }
+  liveForms().forEach((form) => fillDraft(form.fields, form.target(), true));
+}
+
+// Keeps the text of a form in a draft as it is typed, and fills the form with the saved one
+// target returns { type, id } of the draft, or null when there is nothing to save
+export function bindDraft(fields, target) {
+  boundForms.push({ fields, target });
+  fillDraft(fields, target(), true);
+
+  Object.values(fields).forEach((field) => {
+    field?.addEventListener("input", () => {
+      const t = target();
+      if (t) scheduleSave(t, fields);
+    });
+  });
+}
+
+// Shows the draft of a target in a form, or empties the form when there is none
+// With onlyEmpty, the text the user already typed is left alone
+export function fillDraft(fields, target, onlyEmpty = false) {
+  if (!target) return;
+  const draft = drafts.get(draftKey(target.type, target.id));
+  for (const [name, field] of Object.entries(fields)) {
+    if (!field || (onlyEmpty && field.value)) continue;
+    field.value = draft?.[name] || "";
+  }
+}
+
+// Forgets the draft of a content being sent, the server deletes it once the content is saved
+export function discardDraft(type, id = "") {
+  const key = draftKey(type, id);
+  clearTimeout(pendingSaves.get(key));
+  pendingSaves.delete(key);
+  drafts.delete(key);
+}
+
+// Saves the text of a form once the user stopped typing for SAVE_DELAY
+function scheduleSave(target, fields) {
+  const key = draftKey(target.type, target.id);
+  const draft = {
+    target_type: target.type,
+    target_id: target.id || "",
+    title: fields.title?.value || "",
+    content: fields.content?.value || "",
+  };
+
+  clearTimeout(pendingSaves.get(key));
+  pendingSaves.set(
+    key,
+    setTimeout(() => {
+      pendingSaves.delete(key);
+      if (draft.title.trim() || draft.content.trim()) {
+        drafts.set(key, draft);
+      } else {
+        drafts.delete(key);
+      }
+      if (window.websocket?.readyState === WebSocket.OPEN) {
+        window.websocket.send(JSON.stringify({ type: "draft_save", ...draft }));
+      }
+    }, SAVE_DELAY)
+  );
+}
+
+// Applies a draft_updated or draft_deleted event sent after a change in another tab,
+// or after the content was sent
+export function receiveDraft(event) {
+  const draft = event.draft || { target_type: event.target_type, target_id: event.target_id };
+  const key = draftKey(draft.target_type, draft.target_id);
+  if (event.type === "draft_updated") {
+    drafts.set(key, draft);
+  } else {
+    drafts.delete(key);
+  }
+
+  // The form being typed in is left alone, its own draft is on its way
+  liveForms().forEach((form) => {
+    const t = form.target();
+    if (!t || draftKey(t.type, t.id) !== key || pendingSaves.has(key)) return;
+    if (document.hasFocus() && Object.values(form.fields).includes(document.activeElement)) return;
+    fillDraft(form.fields, t);
+  });
+}
+
+// Returns the bound forms still on the page, forgetting the others
+function liveForms() {
+  boundForms = boundForms.filter((form) => Object.values(form.fields).some((field) => field?.isConnected));
+  return boundForms;
+}
@@ -1,269 +1,383 @@
-import { routes } from "./routes.js";
-
-window.navigateTo = navigateTo;
-
-import {
-  attachLoginEventListener,
-  attachRegisterEventListener,
-  logout,
-} from "./auth.js";
-
-import { loadPosts, setupPostForm, viewPost } from "./posts.js";
-
-import {
-  addNotification,
-  markAsRead,
-  initNotifications,
-} from "./notifications.js";
-
-import {
-  updateUsersList,
-  getCachedUsers,
-  updateCachedUsers,
-  loadAllUsers,
-  handleUserStatusChange,
-  getCurrentUser,
-  setCurrentUser,
-} from "./users.js";
-
-import {
-  displayMessage,
-  openChat,
-  closeChat,
-  initChat,
-  currentChatPartner,
-  showTypingIndicator,
-} from "./chat.js";
-
-// Page initialization, check if user is logged in
-window.onload = function () {
-  checkSession();
-};
-
-// Function to navigate to a specific page
-export function navigateTo(page) {
-  if (routes[page]) {
-    const content =
-      typeof routes[page] === "function" ? routes[page]() : routes[page];
-    document.getElementById("app").innerHTML = content;
-    history.pushState({}, page, `#${page}`); // Update URL without reloading, allow SPA behavior
-  }
-
-  if (page === "register") {
-    attachRegisterEventListener();
-  } else if (page === "login") {
-    attachLoginEventListener();
-  } else if (page === "home") {
-    setupPostForm();
-    loadPosts();
-    initChat();
-    loadAllUsers();
-    setTimeout(() => initNotifications(), 100);
-  }
-}
-
-function checkSession() {
-  fetch("/check-session", { method: "GET", credentials: "include" })
-    .then((response) => {
-      if (response.ok) {
-        return response.json();
-      } else {
-        // Login as default if not authenticated
-        navigateTo("login");
-        return Promise.reject("Not authenticated");
-      }
-    })
-    .then((userData) => {
-      if (window.websocket) {
-        window.websocket.close();
-        window.websocket = null;
-      }
-
-      // Update the current user in the global state
-      setCurrentUser(userData);
-      window.currentUser = userData;
-      document.getElementById("logout-button");
-
-      // Initializa a new WebSocket connection and navigate to home
-      return initializeWebSocket().then(() => {
-        navigateTo("home");
-      });
-    })
-    .catch((error) => {
-      console.error("Session check error:", error);
-    });
-}
-
-function goToHome() {
-  navigateTo("home");
-}
-
-window.logout = logout;
-window.checkSession = checkSession;
-window.goToHome = goToHome;
-window.navigateTo = navigateTo;
-window.viewPost = viewPost;
-
-export function initializeWebSocket() {
-  // if already a websocket connection, close it
-  if (window.websocket) {
-    window.websocket.close();
-    window.websocket = null;
-  }
-
-  return new Promise((resolve, reject) => {
-    // Create a new WebSocket connection
-    const socket = new WebSocket("ws://" + window.location.host + "/ws");
-
-    // Create websocket event handlers
-    // When connection is opened, send identify user message & user status
-    socket.onopen = function () {
-      console.log("WebSocket connection established");
-      window.websocket = socket;
-
-      if (getCurrentUser()?.user_id) {
-        socket.send(
-          JSON.stringify({
-            type: "identify",
-            user_id: getCurrentUser().user_id,
-          })
-        );
-
-        socket.send(
-          JSON.stringify({
-            type: "user_status",
-            user_id: getCurrentUser().user_id,
-            username: getCurrentUser().username,
-          })
-        );
-      }
-      resolve(socket); // resolve promise to indicate websocket conn is ready
-    };
-
-    // Handle errors, if error occurs, log it and reject the promise
-    socket.onerror = function (error) {
-      console.error("WebSocket error:", error);
-      reject(error);
-    };
-
-    // Handle incoming messages from the server
-    socket.onmessage = function (event) {
-
-      const message = JSON.parse(event.data);
-
-      switch (message.type) {
-        case "online_users":
-          // Update the list of online users
-          loadAllUsers(); // Reload all users to ensure the list is up-to-date
-          break;
-
-        case "all_users":
-          // Update the complete list of users
-          updateUsersList(message.users);
-          break;
-
-        case "user_status":
-          handleUserStatusChange(message);
-          // After handling user status change, reload all users
-          loadAllUsers();
-          break;
-
-        case "typing_start":
-          if (message.sender_id !== getCurrentUser()?.user_id) {
-            const sender = getCachedUsers().find(
-              (u) => u.user_id === message.sender_id
-            );
-            console.log("Sender found:", sender);
-            showTypingIndicator(true, sender?.username || "Someone");
-
-            // Reset existing timeout if any
-            if (window.typingStopTimeout)
-              clearTimeout(window.typingStopTimeout);
-
-            // Set timeout to hide after 1,5 seconds
-            window.typingStopTimeout = setTimeout(() => {
-              showTypingIndicator(false);
-            }, 1500);
-          }
-          break;
-
-        case "typing_stop":
-          showTypingIndicator(false);
-          if (window.typingStopTimeout) clearTimeout(window.typingStopTimeout);
-          break;
-
-        case "private_message":
-           // If no receiver id & sender is not current user, then i'm the receiver
-          if (
-            !message.receiver_id && message.sender_id !== getCurrentUser()?.user_id
-          ) {
-            message.receiver_id = getCurrentUser().user_id;
-          }
-
-          if (message.receiver_id === getCurrentUser()?.user_id) {
-
-            // Get the sender in the cached users list
-            const sender = getCachedUsers().find(
-              (u) => u.user_id === message.sender_id
-            );
-            const senderName = sender ? sender.username : "Unknown user";
-
-            // Check if the current chat partner is the sender of the message
-            const isCorrectConversation =
-              currentChatPartner &&
-              String(message.sender_id) === String(currentChatPartner.id);
-
-            // Display if the message is for the current chat partner & chat open
-            if (isCorrectConversation) {
-              displayMessage({
-                sender_id: message.sender_id,
-                content: message.content,
-                timestamp: message.timestamp || Date.now(),
-              });
-            } else {
-              // Create notification if chat is not open
-              addNotification(
-                message.sender_id,
-                senderName,
-                message.content,
-                message.timestamp || Date.now()
-              );
-            }
-          }
-
-          const updatedUsers = [...getCachedUsers()]; // Copy cached users list without touching the original
-          // get the partner id based on whether the message is sent or received
-          const partnerId = message.is_sent
-            ? message.receiver_id
-            : message.sender_id;
-
-          // Search for the partner index
-          const partnerIndex = updatedUsers.findIndex(
-            (u) => u.user_id === partnerId
-          );
-
-          if (partnerIndex > -1) {
-            const [partner] = updatedUsers.splice(partnerIndex, 1); // Remove the partner from the list
-            // update the partner's last message content and timestamp
-            partner.last_message_content = message.content;
-            partner.last_message_timestamp = message.timestamp || Date.now();
-            // Add the updated partner to the beginning of the list
-            updatedUsers.unshift(partner);
-            updateCachedUsers(updatedUsers);
-            updateUsersList(updatedUsers);
-          }
-          // Refresh the user list with a small delay to ensure UI updates
-          setTimeout(() => loadAllUsers(), 100);
-          break;
-      }
-    };
-
-    // When connection is closed, set websocket to null
-    socket.onclose = function () {
-      console.log("WebSocket connection closed");
-      window.websocket = null;
-    };
-
-    window.websocket = socket;
-  });
-}
+import { routes } from "./routes.js";
+import { API_BASE, storeCSRFToken, describeFieldErrors } from "./api.js";
+
+window.navigateTo = navigateTo;
+
+import {
+  attachLoginEventListener,
+  attachRegisterEventListener,
+  attachForgotPasswordEventListener,
+  attachResetPasswordEventListener,
+  verifyEmail,
+  resendVerificationEmail,
+  logout,
+} from "./auth.js";
+
+import { loadPosts, loadPostDetails, setupFeedTabs, setupPostForm, viewPost } from "./posts.js";
+import { setupSettingsPage } from "./settings.js";
+import { setupModerationPage, notifyNewReport, notifyFilteredContent } from "./moderation.js";
+import { loadProfile } from "./profile.js";
+import { receivePreview } from "./previews.js";
+import { loadDrafts, receiveDraft } from "./drafts.js";
+
+import {
+  addNotification,
+  markAsRead,
+  initNotifications,
+  receiveNotification,
+} from "./notifications.js";
+
+import {
+  updateUsersList,
+  getCachedUsers,
+  updateCachedUsers,
+  loadAllUsers,
+  setupUserSearch,
+  handleUserStatusChange,
+  getCurrentUser,
+  setCurrentUser,
+} from "./users.js";
+
+import {
+  displayMessage,
+  receiveSentMessage,
+  openChat,
+  closeChat,
+  initChat,
+  currentChatPartner,
+  showTypingIndicator,
+} from "./chat.js";
+
+// Page initialization, check if user is logged in
+// Links sent by email open #verify-email?token=..., #reset-password?token=... and #settings
+window.onload = function () {
+  const [page, query] = location.hash.slice(1).split("?");
+  const token = new URLSearchParams(query).get("token");
+
+  if (token && page === "verify-email") {
+    navigateTo("verify-email");
+    verifyEmail(token);
+  } else if (token && page === "reset-password") {
+    navigateTo("reset-password", token);
+  } else {
+    checkSession(page === "settings" ? "settings" : "home");
+  }
+};
+
+// Function to navigate to a specific page
+// param is the token of the reset password page, or the username of the profile page
+export function navigateTo(page, param) {
+  if (routes[page]) {
+    const content =
+      typeof routes[page] === "function" ? routes[page]() : routes[page];
+    document.getElementById("app").innerHTML = content;
+    history.pushState({}, page, `#${page}`); // Update URL without reloading, allow SPA behavior
+  }
+
+  if (page === "register") {
+    attachRegisterEventListener();
+  } else if (page === "login") {
+    attachLoginEventListener();
+  } else if (page === "forgot-password") {
+    attachForgotPasswordEventListener();
+  } else if (page === "reset-password") {
+    attachResetPasswordEventListener(param);
+  } else if (page === "profile") {
+    loadProfile(param);
+  } else if (page === "settings") {
+    setupSettingsPage();
+  } else if (page === "moderation") {
+    setupModerationPage();
+  } else if (page === "home") {
+    setupPostForm();
+    setupFeedTabs();
+    loadPosts();
+    initChat();
+    loadAllUsers();
+    setupUserSearch();
+    setTimeout(() => initNotifications(), 100);
+  }
+}
+
+// Logged in users are taken to startPage, the home page by default
+function checkSession(startPage = "home") {
+  fetch(`${API_BASE}/check-session`, { method: "GET", credentials: "include" })
+    .then((response) => {
+      if (response.ok) {
+        storeCSRFToken(response);
+        return response.json();
+      } else {
+        // Login as default if not authenticated
+        navigateTo("login");
+        return Promise.reject("Not authenticated");
+      }
+    })
+    .then((userData) => {
+      if (window.websocket) {
+        window.websocket.close();
+        window.websocket = null;
+      }
+
+      // Update the current user in the global state
+      setCurrentUser(userData);
+      window.currentUser = userData;
+      document.getElementById("logout-button");
+
+      // Initializa a new WebSocket connection and navigate to the start page
+      return initializeWebSocket().then(() => {
+        navigateTo(startPage);
+      });
+    })
+    .catch((error) => {
+      console.error("Session check error:", error);
+    });
+}
+
+function goToHome() {
+  navigateTo("home");
+}
+
+window.logout = logout;
+window.resendVerificationEmail = resendVerificationEmail;
+window.checkSession = checkSession;
+window.goToHome = goToHome;
+window.navigateTo = navigateTo;
+window.viewPost = viewPost;
+
+export function initializeWebSocket() {
+  // if already a websocket connection, close it
+  if (window.websocket) {
+    window.websocket.close();
+    window.websocket = null;
+  }
+
+  return new Promise((resolve, reject) => {
+    // Create a new WebSocket connection
+    const socket = new WebSocket("ws://" + window.location.host + API_BASE + "/ws");
+
+    // Create websocket event handlers
+    // When connection is opened, send identify user message & user status
+    socket.onopen = function () {
+      console.log("WebSocket connection established");
+      window.websocket = socket;
+
+      if (getCurrentUser()?.user_id) {
+        socket.send(
+          JSON.stringify({
+            type: "identify",
+            user_id: getCurrentUser().user_id,
+          })
+        );
+
+        socket.send(
+          JSON.stringify({
+            type: "user_status",
+            user_id: getCurrentUser().user_id,
+            username: getCurrentUser().username,
+          })
+        );
+
+        // Drafts changed while the page was not connected are loaded again
+        loadDrafts();
+      }
+      resolve(socket); // resolve promise to indicate websocket conn is ready
+    };
+
+    // Handle errors, if error occurs, log it and reject the promise
+    socket.onerror = function (error) {
+      console.error("WebSocket error:", error);
+      reject(error);
+    };
+
+    // Handle incoming messages from the server
+    socket.onmessage = function (event) {
+
+      const message = JSON.parse(event.data);
+
+      switch (message.type) {
+        case "online_users":
+          // Update the list of online users
+          loadAllUsers(); // Reload all users to ensure the list is up-to-date
+          break;
+
+        case "all_users":
+          // Update the complete list of users
+          updateUsersList(message.users);
+          break;
+
+        case "user_status":
+          handleUserStatusChange(message);
+          // After handling user status change, reload all users
+          loadAllUsers();
+          break;
+
+        case "typing_start":
+          if (message.sender_id !== getCurrentUser()?.user_id) {
+            const sender = getCachedUsers().find(
+              (u) => u.user_id === message.sender_id
+            );
+            console.log("Sender found:", sender);
+            showTypingIndicator(true, sender?.username || "Someone");
+
+            // Reset existing timeout if any
+            if (window.typingStopTimeout)
+              clearTimeout(window.typingStopTimeout);
+
+            // Set timeout to hide after 1,5 seconds
+            window.typingStopTimeout = setTimeout(() => {
+              showTypingIndicator(false);
+            }, 1500);
+          }
+          break;
+
+        case "typing_stop":
+          showTypingIndicator(false);
+          if (window.typingStopTimeout) clearTimeout(window.typingStopTimeout);
+          break;
+
+        case "error":
+          // A message refused by the server, e.g. too long
+          if (message.code === "validation_failed" && message.message_type === "user_status") {
+            alert(`Status not saved: ${describeFieldErrors(message.errors)}`);
+          } else if (message.code === "validation_failed") {
+            alert(`Message not sent: ${describeFieldErrors(message.errors)}`);
+          } else if (message.code === "blocked") {
+            alert("Message not sent: you can't exchange messages with this user");
+          } else if (message.code === "messages_not_allowed") {
+            alert("Message not sent: this user only accepts messages from people they talked with");
+          } else if (message.code === "content_rejected") {
+            alert(`Message not sent: ${message.matches.map((m) => m.detail).join(", ")}`);
+          }
+          break;
+
+        case "new_report":
+          // Only moderators receive the reports
+          notifyNewReport(message.report);
+          break;
+
+        case "new_post":
+          // A post in a category or from a user followed, shown if the home page is open
+          if (document.querySelector(".posts")) loadPosts();
+          break;
+
+        case "new_comment":
+          // A comment on a followed post, shown if the post is open
+          if (location.hash === `#post/${message.comment.post_id}`) {
+            loadPostDetails(message.comment.post_id);
+          }
+          break;
+
+        case "notification":
+          // A reply, mention or missed message recorded by the server
+          receiveNotification(message.notification, message.unread);
+          break;
+
+        case "draft_updated":
+        case "draft_deleted":
+          // A draft was changed in another tab, or its content was sent
+          receiveDraft(message);
+          break;
+
+        case "preview_ready":
+          // The preview of a link was fetched after its post or message was sent
+          receivePreview(message);
+          break;
+
+        case "content_filtered":
+          // Only moderators are told about flagged and blocked contents
+          notifyFilteredContent(message.entry);
+          break;
+
+        case "rate_limited":
+          // Typing events are dropped silently, only a refused message is worth telling
+          if (message.message_type === "private_message") {
+            const seconds = Math.ceil(message.retry_after_ms / 1000);
+            alert(`You are sending messages too fast, try again in ${seconds}s`);
+          }
+          break;
+
+        case "private_message":
+           // If no receiver id & sender is not current user, then i'm the receiver
+          if (
+            !message.receiver_id && message.sender_id !== getCurrentUser()?.user_id
+          ) {
+            message.receiver_id = getCurrentUser().user_id;
+          }
+
+          if (message.receiver_id === getCurrentUser()?.user_id) {
+
+            // Get the sender in the cached users list
+            const sender = getCachedUsers().find(
+              (u) => u.user_id === message.sender_id
+            );
+            const senderName = sender ? sender.username : "Unknown user";
+
+            // Check if the current chat partner is the sender of the message
+            const isCorrectConversation =
+              currentChatPartner &&
+              String(message.sender_id) === String(currentChatPartner.id);
+
+            // Display if the message is for the current chat partner & chat open
+            if (isCorrectConversation) {
+              displayMessage({
+                id: message.id,
+                sender_id: message.sender_id,
+                content: message.content,
+                content_html: message.content_html,
+                mentions: message.mentions,
+                attachments: message.attachments,
+                previews: message.previews,
+                timestamp: message.timestamp || Date.now(),
+              });
+            } else if (!message.silent) {
+              // Create notification if chat is not open (unless in do-not-disturb)
+              addNotification(
+                message.sender_id,
+                senderName,
+                message.content,
+                message.timestamp || Date.now()
+              );
+            }
+          }
+
+          // Messages sent by the user, from this page or another one, come back as saved
+          if (message.is_sent && message.sender_id === getCurrentUser()?.user_id) {
+            receiveSentMessage(message);
+          }
+
+          const updatedUsers = [...getCachedUsers()]; // Copy cached users list without touching the original
+          // get the partner id based on whether the message is sent or received
+          const partnerId = message.is_sent
+            ? message.receiver_id
+            : message.sender_id;
+
+          // Search for the partner index
+          const partnerIndex = updatedUsers.findIndex(
+            (u) => u.user_id === partnerId
+          );
+
+          if (partnerIndex > -1) {
+            const [partner] = updatedUsers.splice(partnerIndex, 1); // Remove the partner from the list
+            // update the partner's last message content and timestamp
+            partner.last_message_content = message.content;
+            partner.last_message_timestamp = message.timestamp || Date.now();
+            // Add the updated partner to the beginning of the list
+            updatedUsers.unshift(partner);
+            updateCachedUsers(updatedUsers);
+            updateUsersList(updatedUsers);
+          }
+          // Refresh the user list with a small delay to ensure UI updates
+          setTimeout(() => loadAllUsers(), 100);
+          break;
+      }
+    };
+
+    // When connection is closed, set websocket to null
+    socket.onclose = function () {
+      console.log("WebSocket connection closed");
+      window.websocket = null;
+    };
+
+    window.websocket = socket;
+  });
+}
@@ -0,0 +1,210 @@
+import { API_BASE, apiErrorMessage, csrfHeaders, escapeHTML } from "./api.js";
+import { getCurrentUser } from "./users.js";
+
+// Whether the current user can delete, lock and pin content and suspend users
+export function isModerator() {
+  const role = getCurrentUser()?.role;
+  return role === "moderator" || role === "admin";
+}
+
+// Sends a moderation action after asking for its optional reason, kept in the moderation log
+// Returns false when the moderator cancelled, and throws the readable error when it fails
+export async function moderate(path, body, question) {
+  const reason = prompt(`${question}\nReason (optional):`);
+  if (reason === null) return false;
+
+  const response = await fetch(`${API_BASE}/moderation${path}`, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify({ ...body, reason: reason.trim() }),
+    credentials: "include",
+  });
+
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(apiErrorMessage(data, "Moderation failed"));
+  }
+  return true;
+}
+
+// Adds a button running a moderation action to a container
+export function addModerationButton(container, label, action) {
+  const button = document.createElement("button");
+  button.className = "moderation-button";
+  button.textContent = label;
+  button.addEventListener("click", async () => {
+    try {
+      await action();
+    } catch (error) {
+      alert(error.message);
+    }
+  });
+  container.appendChild(button);
+}
+
+// Asks for a reason and reports a post, a comment or a received private message to the moderators
+export async function reportContent(targetType, targetId) {
+  const reason = prompt("Why are you reporting this?");
+  if (reason === null) return;
+
+  try {
+    const response = await fetch(`${API_BASE}/reports`, {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify({ target_type: targetType, target_id: String(targetId), reason: reason.trim() }),
+      credentials: "include",
+    });
+    const data = await response.json();
+    if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to report"));
+    alert(data.message);
+  } catch (error) {
+    alert(error.message);
+  }
+}
+
+// Report buttons can be anywhere in the page, a single listener handles them all
+document.addEventListener("click", (event) => {
+  const button = event.target.closest("[data-report-type]");
+  if (!button) return;
+  event.preventDefault();
+  reportContent(button.dataset.reportType, button.dataset.reportId);
+});
+
+// Returns a button reporting content, for logged in users other than its author
+export function reportButton(targetType, targetId, authorId) {
+  const user = getCurrentUser();
+  if (!user || user.user_id === authorId) return "";
+  return `<button class="report-button" data-report-type="${targetType}" data-report-id="${escapeHTML(String(targetId))}">Report</button>`;
+}
+
+// Counts the reports and filtered contents received while the moderation page is not open
+let unseenItems = 0;
+
+// Shows the number of unseen items on the moderation button
+function bumpModerationBadge() {
+  unseenItems++;
+  const badge = document.querySelector(".report-badge");
+  if (badge) badge.textContent = unseenItems;
+}
+
+// Tells a moderator about a new report, sent over the WebSocket
+export function notifyNewReport() {
+  if (document.getElementById("reports-list")) {
+    loadReportsQueue();
+    return;
+  }
+  bumpModerationBadge();
+}
+
+// Tells a moderator about a content flagged or blocked by the content filter, sent over the WebSocket
+export function notifyFilteredContent() {
+  if (document.getElementById("filtered-list")) {
+    loadFilteredContent();
+    return;
+  }
+  bumpModerationBadge();
+}
+
+// Sets up the moderation queue page
+export function setupModerationPage() {
+  unseenItems = 0;
+  document.getElementById("report-status").addEventListener("change", loadReportsQueue);
+  document.getElementById("report-type").addEventListener("change", loadReportsQueue);
+  document.getElementById("filtered-action").addEventListener("change", loadFilteredContent);
+  loadReportsQueue();
+  loadFilteredContent();
+}
+
+// Loads the contents changed or stopped by the content filter
+function loadFilteredContent() {
+  const list = document.getElementById("filtered-list");
+  if (!list) return;
+
+  const params = new URLSearchParams();
+  const action = document.getElementById("filtered-action").value;
+  if (action) params.set("action", action);
+
+  fetch(`${API_BASE}/moderation/filtered?${params}`, { credentials: "include" })
+    .then(async (response) => {
+      const data = await response.json();
+      if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to load filtered content"));
+      return data;
+    })
+    .then((entries) => {
+      list.innerHTML = entries.length ? "" : "<p>Nothing was filtered.</p>";
+      entries.forEach((entry) => {
+        const item = document.createElement("div");
+        item.className = "report";
+        item.innerHTML = `
+          <p><strong>${escapeHTML(entry.kind)}</strong> by ${escapeHTML(entry.author)}
+            on ${new Date(entry.created_at).toLocaleString()}: ${escapeHTML(entry.action)}</p>
+          <blockquote>${escapeHTML(entry.original)}</blockquote>
+          <p>Rules: ${escapeHTML(entry.rules)}</p>
+        `;
+        list.appendChild(item);
+      });
+    })
+    .catch((error) => {
+      list.innerHTML = `<p>${escapeHTML(error.message)}</p>`;
+    });
+}
+
+// Loads the reports matching the filters of the moderation queue
+function loadReportsQueue() {
+  const list = document.getElementById("reports-list");
+  if (!list) return;
+
+  const params = new URLSearchParams({ status: document.getElementById("report-status").value });
+  const type = document.getElementById("report-type").value;
+  if (type) params.set("target_type", type);
+
+  fetch(`${API_BASE}/moderation/reports?${params}`, { credentials: "include" })
+    .then(async (response) => {
+      const data = await response.json();
+      if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to load reports"));
+      return data;
+    })
+    .then((reports) => {
+      list.innerHTML = reports.length ? "" : "<p>No reports.</p>";
+      reports.forEach((report) => list.appendChild(renderReport(report)));
+    })
+    .catch((error) => {
+      list.innerHTML = `<p>${escapeHTML(error.message)}</p>`;
+    });
+}
+
+// Builds the element of a report, with the resolution buttons while it is open
+function renderReport(report) {
+  const item = document.createElement("div");
+  item.className = "report";
+  item.innerHTML = `
+    <p><strong>${escapeHTML(report.target_type)}</strong> by ${escapeHTML(report.target_author)},
+      reported by ${escapeHTML(report.reporter)} on ${new Date(report.created_at).toLocaleString()}</p>
+    <blockquote>${escapeHTML(report.content)}</blockquote>
+    <p>Reason: ${escapeHTML(report.reason)}</p>
+    <p>Status: ${escapeHTML(report.status)}${report.resolution_note ? ` (${escapeHTML(report.resolution_note)})` : ""}</p>
+    <div class="moderation-tools"></div>
+  `;
+  if (report.status !== "open") return item;
+
+  const tools = item.querySelector(".moderation-tools");
+  const resolve = (status, deleteContent) => async () => {
+    const note = prompt("Note for the other moderators (optional):");
+    if (note === null) return;
+
+    const response = await fetch(`${API_BASE}/moderation/reports/resolve`, {
+      method: "POST",
+      headers: csrfHeaders({ "Content-Type": "application/json" }),
+      body: JSON.stringify({ report_id: report.report_id, status, note: note.trim(), delete_content: deleteContent }),
+      credentials: "include",
+    });
+    const data = await response.json();
+    if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to resolve report"));
+    loadReportsQueue();
+  };
+
+  addModerationButton(tools, "Delete content", resolve("actioned", true));
+  addModerationButton(tools, "Mark as actioned", resolve("actioned", false));
+  addModerationButton(tools, "Dismiss", resolve("dismissed", false));
+  return item;
+}
@@ -1,170 +1,293 @@
-import { openChat } from "./chat.js";
-
-// Stores all unread notifications
-export let unreadNotifications = [];
-
-// Allows adding a notification
-export function addNotification(senderId, senderName, message, timestamp) {
-  // Avoid duplicates
-  const existingIndex = unreadNotifications.findIndex(
-    (n) => n.senderId === senderId
-  );
-
-  if (existingIndex !== -1) {
-    // Update an existing notification from the same sender
-    unreadNotifications[existingIndex] = {
-      senderId,
-      senderName,
-      message,
-      timestamp,
-      read: false,
-    };
-  } else {
-    // New notification
-    unreadNotifications.push({
-      senderId,
-      senderName,
-      message,
-      timestamp,
-      read: false,
-    });
-  }
-
-  updateNotificationBadge();
-}
-
-// Marks a notification as read
-export function markAsRead(senderId) {
-  unreadNotifications = unreadNotifications.filter(
-    (n) => n.senderId !== senderId
-  );
-  updateNotificationBadge();
-}
-
-// Gets all unread notifications
-export function getUnreadNotifications() {
-  return unreadNotifications;
-}
-
-// Updates the notification badge
-export function updateNotificationBadge() {
-  const badge = document.querySelector(".notification-badge");
-  const count = unreadNotifications.length;
-
-  if (badge) {
-    if (count > 0) {
-      badge.textContent = count;
-      // Use inline style instead of className
-      badge.style.display = "flex";
-    } else {
-      badge.style.display = "none";
-    }
-  }
-}
-
-// Initializes the notification system
-export function initNotifications() {
-  // Initialize the badge
-  updateNotificationBadge();
-
-  // Click handler for the notification button
-  const btn = document.getElementById("notification-button");
-  if (btn) {
-    btn.addEventListener("click", function (event) {
-      event.preventDefault();
-      toggleNotificationPanel();
-    });
-  } else {
-    console.log("Notification button not found in DOM"); // (Je le laisse au cas où :))
-  }
-
-  // Close the notification panel when clicking elsewhere
-  document.addEventListener("click", function (event) {
-    const panel = document.querySelector(".notification-panel");
-    const btn = document.getElementById("notification-button");
-
-    if (
-      panel &&
-      panel.style.display === "block" &&
-      !panel.contains(event.target) &&
-      event.target !== btn
-    ) {
-      panel.style.display = "none";
-    }
-  });
-}
-
-// Shows or hides the notification panel
-export function toggleNotificationPanel() {
-  const panel = document.querySelector(".notification-panel");
-
-  if (panel) {
-    if (panel.style.display === "block") {
-      panel.style.display = "none";
-    } else {
-      // Update the panel content before displaying it
-      updateNotificationPanel();
-      panel.style.display = "block";
-    }
-  }
-}
-
-// Updates the content of the notification panel
-function updateNotificationPanel() {
-  const panel = document.querySelector(".notification-panel");
-
-  if (panel) {
-    if (unreadNotifications.length === 0) {
-      panel.innerHTML =
-        '<div class="empty-notification">No messages received</div>';
-    } else {
-      // Sort by date (most recent first)
-      const sorted = [...unreadNotifications].sort(
-        (a, b) => b.timestamp - a.timestamp
-      );
-
-      let html = "";
-      sorted.forEach((notification) => {
-        html += `
-          <div class="notification-item" data-user-id="${
-            notification.senderId
-          }">
-            <div class="notification-content">
-              Message received from <strong>${notification.senderName}</strong>
-            </div>
-            <div class="notification-time">
-              ${new Date(notification.timestamp).toLocaleTimeString()}
-            </div>
-          </div>
-        `;
-      });
-
-      panel.innerHTML = html;
-
-      // Add event listeners
-      panel.querySelectorAll(".notification-item").forEach((item) => {
-        item.addEventListener("click", function () {
-          const userId = this.dataset.userId;
-          const username = this.querySelector("strong").textContent;
-
-          // Mark as read
-          markAsRead(userId);
-
-          // Open chat with this user
-          if (typeof openChat === "function") {
-            openChat(userId, username);
-          }
-
-          // Hide the panel
-          panel.style.display = "none";
-        });
-      });
-    }
-  }
-}
-
-window.notificationFunctions = {
-  addNotification,
-  markAsRead,
-  initNotifications,
-};
+import { openChat } from "./chat.js";
+import { API_BASE, csrfHeaders, escapeHTML } from "./api.js";
+
+// Stores all unread notifications
+export let unreadNotifications = [];
+
+// Allows adding a notification
+export function addNotification(senderId, senderName, message, timestamp) {
+  // Avoid duplicates
+  const existingIndex = unreadNotifications.findIndex(
+    (n) => n.senderId === senderId
+  );
+
+  if (existingIndex !== -1) {
+    // Update an existing notification from the same sender
+    unreadNotifications[existingIndex] = {
+      senderId,
+      senderName,
+      message,
+      timestamp,
+      read: false,
+    };
+  } else {
+    // New notification
+    unreadNotifications.push({
+      senderId,
+      senderName,
+      message,
+      timestamp,
+      read: false,
+    });
+  }
+
+  updateNotificationBadge();
+}
+
+// Marks a notification as read
+export function markAsRead(senderId) {
+  unreadNotifications = unreadNotifications.filter(
+    (n) => n.senderId !== senderId
+  );
+  updateNotificationBadge();
+}
+
+// Gets all unread notifications
+export function getUnreadNotifications() {
+  return unreadNotifications;
+}
+
+// Updates the notification badge
+export function updateNotificationBadge() {
+  const badge = document.querySelector(".notification-badge");
+  const count = unreadNotifications.length + serverUnread;
+
+  if (badge) {
+    if (count > 0) {
+      badge.textContent = count;
+      // Use inline style instead of className
+      badge.style.display = "flex";
+    } else {
+      badge.style.display = "none";
+    }
+  }
+}
+
+// Initializes the notification system
+export function initNotifications() {
+  // Initialize the badge
+  updateNotificationBadge();
+  loadNotifications();
+
+  // Click handler for the notification button
+  const btn = document.getElementById("notification-button");
+  if (btn) {
+    btn.addEventListener("click", function (event) {
+      event.preventDefault();
+      toggleNotificationPanel();
+    });
+  } else {
+    console.log("Notification button not found in DOM"); // (Je le laisse au cas où :))
+  }
+
+  // Close the notification panel when clicking elsewhere
+  document.addEventListener("click", function (event) {
+    const panel = document.querySelector(".notification-panel");
+    const btn = document.getElementById("notification-button");
+
+    if (
+      panel &&
+      panel.style.display === "block" &&
+      !panel.contains(event.target) &&
+      event.target !== btn
+    ) {
+      panel.style.display = "none";
+    }
+  });
+}
+
+// Shows or hides the notification panel
+export function toggleNotificationPanel() {
+  const panel = document.querySelector(".notification-panel");
+
+  if (panel) {
+    if (panel.style.display === "block") {
+      panel.style.display = "none";
+    } else {
+      // Update the panel content before displaying it
+      updateNotificationPanel();
+      panel.style.display = "block";
+    }
+  }
+}
+
+// Updates the content of the notification panel
+function updateNotificationPanel() {
+  const panel = document.querySelector(".notification-panel");
+
+  if (panel) {
+    if (unreadNotifications.length === 0 && serverNotifications.length === 0) {
+      panel.innerHTML =
+        '<div class="empty-notification">No notifications</div>';
+    } else {
+      // Sort by date (most recent first)
+      const sorted = [...unreadNotifications].sort(
+        (a, b) => b.timestamp - a.timestamp
+      );
+
+      let html = "";
+      sorted.forEach((notification) => {
+        html += `
+          <div class="notification-item" data-user-id="${
+            notification.senderId
+          }">
+            <div class="notification-content">
+              Message received from <strong>${notification.senderName}</strong>
+            </div>
+            <div class="notification-time">
+              ${new Date(notification.timestamp).toLocaleTimeString()}
+            </div>
+          </div>
+        `;
+      });
+
+      if (serverUnread > 0) {
+        html += '<button class="mark-all-read">Mark all as read</button>';
+      }
+      serverNotifications.forEach((notification) => {
+        html += `
+          <div class="notification-item${notification.read ? " read" : ""}" data-notification-id="${
+            notification.notification_id
+          }">
+            <div class="notification-content">
+              <strong>${escapeHTML(notification.actor)}</strong> ${notificationLabels[notification.type] || ""}
+              <div class="notification-excerpt">${escapeHTML(notification.excerpt)}</div>
+            </div>
+            <div class="notification-time">
+              ${new Date(notification.created_at).toLocaleString()}
+            </div>
+          </div>
+        `;
+      });
+
+      panel.innerHTML = html;
+
+      // Add event listeners
+      panel.querySelectorAll(".notification-item[data-user-id]").forEach((item) => {
+        item.addEventListener("click", function () {
+          const userId = this.dataset.userId;
+          const username = this.querySelector("strong").textContent;
+
+          // Mark as read
+          markAsRead(userId);
+
+          // Open chat with this user
+          if (typeof openChat === "function") {
+            openChat(userId, username);
+          }
+
+          // Hide the panel
+          panel.style.display = "none";
+        });
+      });
+
+      panel.querySelectorAll(".notification-item[data-notification-id]").forEach((item) => {
+        item.addEventListener("click", function () {
+          const notification = serverNotifications.find(
+            (n) => n.notification_id === this.dataset.notificationId
+          );
+          panel.style.display = "none";
+          openNotification(notification);
+        });
+      });
+
+      const markAll = panel.querySelector(".mark-all-read");
+      if (markAll) {
+        markAll.addEventListener("click", function (event) {
+          event.stopPropagation();
+          markAllNotificationsRead();
+        });
+      }
+    }
+  }
+}
+
+// Texts shown after the name of the user who caused a notification kept by the server
+const notificationLabels = {
+  post_reply: "commented your post",
+  comment_reply: "commented a post you follow",
+  mention: "mentioned you",
+  message: "sent you a message while you were away",
+};
+
+// Notifications kept by the server: replies, mentions, messages received while offline
+let serverNotifications = [];
+// Number of unread notifications on the server, some may not be loaded
+let serverUnread = 0;
+
+// Loads the most recent notifications and the unread count from the server
+export function loadNotifications() {
+  Promise.all([
+    fetch(`${API_BASE}/notifications?limit=20`, { credentials: "include" }).then((r) => r.json()),
+    fetch(`${API_BASE}/notifications/unread`, { credentials: "include" }).then((r) => r.json()),
+  ])
+    .then(([notifications, count]) => {
+      serverNotifications = Array.isArray(notifications) ? notifications : [];
+      serverUnread = count.unread || 0;
+      updateNotificationBadge();
+    })
+    .catch((error) => console.error("Error loading notifications:", error));
+}
+
+// Adds a notification pushed over the WebSocket
+export function receiveNotification(notification, unread) {
+  serverNotifications.unshift(notification);
+  serverUnread = unread;
+  updateNotificationBadge();
+
+  const panel = document.querySelector(".notification-panel");
+  if (panel && panel.style.display === "block") {
+    updateNotificationPanel();
+  }
+}
+
+// Marks a notification as read and opens the post or conversation it is about
+function openNotification(notification) {
+  if (!notification) return;
+
+  if (!notification.read) {
+    notificationRequest("/notifications/read", { notification_id: notification.notification_id })
+      .then(() => {
+        notification.read = true;
+      });
+  }
+
+  // Notifications without a post are about private messages
+  if (notification.post_id) {
+    window.viewPost(notification.post_id);
+  } else {
+    openChat(notification.actor_id, notification.actor);
+  }
+}
+
+// Marks every notification as read
+function markAllNotificationsRead() {
+  notificationRequest("/notifications/read-all", {}).then(() => {
+    serverNotifications.forEach((n) => (n.read = true));
+    updateNotificationPanel();
+  });
+}
+
+// Sends a request updating notifications, and refreshes the badge with the unread count it returns
+function notificationRequest(path, body) {
+  return fetch(`${API_BASE}${path}`, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify(body),
+    credentials: "include",
+  })
+    .then((response) => response.json())
+    .then((data) => {
+      if (typeof data.unread === "number") {
+        serverUnread = data.unread;
+        updateNotificationBadge();
+      }
+    })
+    .catch((error) => console.error("Error updating notifications:", error));
+}
+
+window.notificationFunctions = {
+  addNotification,
+  markAsRead,
+  initNotifications,
+};
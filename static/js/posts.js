@@ -1,281 +1,434 @@
-import { routes } from "./routes.js";
-
-// Get the posts list from the server and display them
-export function loadPosts() {
-    fetch("/posts", { method: "GET", credentials: "include" })
-        .then((response) => {
-            return response.json();
-        })
-        .then((posts) => {
-            const postsContainer = document.querySelector(".posts");
-
-            if (!postsContainer) {
-                console.error("Posts container not found!");
-                return;
-            }
-
-            // Clear existing posts
-            postsContainer.innerHTML = "";
-
-            // If no posts are found, display a message
-            if (posts.length === 0) {
-                postsContainer.innerHTML = "<p>No posts yet. Be the first to post!</p>";
-                return;
-            }
-
-            // Add each post to the container
-            posts.forEach((post) => {
-                // Convert ISO string to Date properly
-                let dateDisplay = "Unknown date";
-                try {
-                    // Check if creation_date exists and isn't null
-                    if (post.creation_date) {
-                        const date = new Date(post.creation_date);
-                        // Verify it's a valid date
-                        if (!isNaN(date.getTime())) {
-                            dateDisplay = date.toLocaleString();
-                        }
-                    }
-                } catch (e) {
-                    console.error("Date parsing error:", e);
-                }
-
-                // Create a new post element
-                const postElement = document.createElement("div");
-                postElement.className = "post";
-
-                postElement.innerHTML = `
-                    <h4>${post.username || "Anonymous"}</h4>
-                    <h3>${post.title || ""}</h3>
-                        <p>${post.content}</p>
-                    <div class="post-meta">
-                        <span>Category: ${post.category || "General"}</span>
-                        <br>
-                        <span>Posted: ${new Date(
-                    post.creation_date
-                ).toLocaleString()}</span>
-                        <button class="view-comments-btn">View Post Details</button>
-                    </div>
-                `;
-
-                // Add specific click handler for the comments button
-                const commentsBtn = postElement.querySelector(".view-comments-btn");
-                if (commentsBtn) {
-                    commentsBtn.addEventListener("click", function (e) {
-                        viewPost(post.post_id);
-                    });
-                }
-
-                // Append the post element to the posts container
-                postsContainer.appendChild(postElement);
-            });
-        })
-        .catch((error) => console.error("Error loading posts:", error));
-}
-
-// Function to handle post creation
-export function setupPostForm() {
-    // Get the elements from the creation form
-    const titleInput = document.getElementById("post-title");
-    const categorySelect = document.getElementById("post-category");
-    const textarea = document.querySelector(".create-post textarea");
-    const postButton = document.querySelector(".create-post button");
-
-    if (!textarea || !postButton || !titleInput) {
-        console.error("Form elements not found!");
-        return;
-    }
-
-    // Add event listener to the button
-    postButton.addEventListener("click", function () {
-        const title = titleInput.value.trim();
-        const content = textarea.value.trim();
-        const category = categorySelect ? categorySelect.value : "general";
-
-        if (!content || !title) {
-            alert("Please enter both title and content for your post");
-            return;
-        }
-
-        // Create post with content
-        const postData = {
-            title: title,
-            content: content,
-            category: category,
-        };
-
-        // Send to server
-        fetch("/posts", {
-            method: "POST",
-            headers: { "Content-Type": "application/json" },
-            body: JSON.stringify(postData),
-            credentials: "include",
-        })
-            .then((response) => {
-                if (!response.ok) throw new Error("Failed to create post");
-                return response.json();
-            })
-            .then((newPost) => {
-                // Clear the form inputs
-                titleInput.value = "";
-                textarea.value = "";
-                if (categorySelect) categorySelect.value = "general";
-
-                // Add the new post to the DOM immediately
-                const postsContainer = document.querySelector(".posts");
-                if (postsContainer) {
-                    const postElement = document.createElement("div");
-                    postElement.className = "post";
-                    const currentUser = window.currentUser;
-
-                    postElement.innerHTML = `
-                        <h4>${currentUser?.username}</h4>
-                        <h3>${newPost.title}</h3>
-                        <p>${newPost.content}</p>
-                        <div class="post-meta">
-                            <span>Category: ${newPost.category}</span>
-                            <br>
-                            <span>Posted: ${new Date(
-                        newPost.creation_date
-                    ).toLocaleString()}</span>
-                            <button class="view-comments-btn">View Post Details</button>
-                        </div>
-                    `;
-
-                    const DetailsBtn = postElement.querySelector(".view-comments-btn");
-                    if (DetailsBtn) {
-                        DetailsBtn.addEventListener("click", function (e) {
-                            viewPost(newPost.post_id);
-                        });
-                    }
-
-                    // Add to the beginning of the posts container
-                    postsContainer.insertBefore(postElement, postsContainer.firstChild);
-                }
-            })
-            .catch((error) => console.error("Error:", error));
-    });
-}
-
-// Function to navigate to a post detail page
-export function viewPost(postId) {
-    // Update URL to include post ID
-    history.pushState({}, `Post ${postId}`, `#post/${postId}`);
-
-    // Render the post detail template
-    document.getElementById("app").innerHTML = routes["post-detail"](postId);
-
-    // Load post details
-    loadPostDetails(postId);
-
-    // Setup comment form
-    setupCommentForm(postId);
-}
-
-// Function to load post details and comments
-export function loadPostDetails(postId) {
-    fetch(`/post/${postId}`, {
-        method: "GET",
-        credentials: "include",
-    })
-        .then((response) => response.json())
-        .then((data) => {
-            displayPostDetails(data.post);
-            displayComments(data.comments);
-        })
-        .catch((error) => {
-            console.error("Error loading post details:", error);
-        });
-}
-
-// Function to display post details
-export function displayPostDetails(post) {
-    const container = document.getElementById("post-content");
-    if (!container) return; // If the container is not found, exit
-
-    container.innerHTML = `
-      <h2>${post.title}</h2>
-      <div class="post-meta">
-        <span>By: ${post.username || "Anonymous"}</span>
-        <br>
-        <span>Category: ${post.category || "General"}</span>
-        <br>
-        <span>Posted: ${new Date(post.creation_date).toLocaleString()}</span>
-      </div>
-      <div class="post-body">
-        <p>${post.content}</p>
-      </div>
-    `;
-}
-
-// Function to display comments
-export function displayComments(comments) {
-    const container = document.getElementById("comments-list");
-    if (!container) return;
-
-    if (!comments || comments.length === 0) {
-        container.innerHTML = "<p>No comments yet. Be the first to comment!</p>";
-        return;
-    }
-
-    // For each comment, create a new HTML structure
-    let html = "";
-    comments.forEach((comment) => {
-        html += `
-        <div class="comment">
-          <div class="comment-header">
-            <span class="comment-author">${comment.username || "Anonymous"}</span>
-            <span class="comment-date">${new Date(
-            comment.creation_date
-        ).toLocaleString()}</span>
-          </div>
-          <div class="comment-body">
-            <p>${comment.content}</p>
-          </div>
-        </div>
-      `;
-    });
-
-    // Set the inner HTML of the comments container
-    container.innerHTML = html;
-}
-
-// Function to set up the comment form
-export function setupCommentForm(postId) {
-    const submitButton = document.getElementById("submit-comment");
-    const contentInput = document.getElementById("comment-content");
-
-    if (!submitButton || !contentInput) return;
-
-    submitButton.addEventListener("click", () => {
-        const content = contentInput.value.trim(); // Get the content from the input field, trim whitespace to ensure it's not empty
-        if (!content) {
-            alert("Comment cannot be empty");
-            return;
-        }
-
-        // Send comment to server
-        fetch("/comment", {
-            method: "POST",
-            headers: { "Content-Type": "application/json" },
-            body: JSON.stringify({
-                post_id: postId,
-                content: content,
-            }),
-            credentials: "include",
-        })
-            .then((response) => {
-                if (!response.ok) throw new Error("Failed to post comment");
-                return response.json();
-            })
-            .then(() => {
-                // Clear the input field
-                contentInput.value = "";
-                // Reload comments to show the new one
-                loadPostDetails(postId);
-            })
-            .catch((error) => {
-                console.error("Error posting comment:", error);
-            });
-    });
+import { routes } from "./routes.js";
+import { API_BASE, apiErrorMessage, csrfHeaders } from "./api.js";
+import { profileLink, contentHTML } from "./profile.js";
+import { isModerator, moderate, addModerationButton, reportButton } from "./moderation.js";
+import { navigateTo } from "./main.js";
+import { accountRequest } from "./settings.js";
+import { uploadFiles, renderAttachments } from "./attachments.js";
+import { renderPreviews } from "./previews.js";
+import { bindDraft, discardDraft } from "./drafts.js";
+
+// Feed shown on the home page: "all" posts, the posts the user is "following", or the posts of a category
+let currentFeed = "all";
+
+// Marks shown before the title of pinned and locked posts
+function postBadges(post) {
+    return `${post.pinned ? "📌 " : ""}${post.locked ? "🔒 " : ""}`;
+}
+
+// Switches the feed shown on the home page when its tabs are clicked
+export function setupFeedTabs() {
+    document.querySelectorAll(".feed-tabs button").forEach((tab) => {
+        tab.classList.toggle("active", tab.dataset.feed === currentFeed);
+        tab.addEventListener("click", () => {
+            currentFeed = tab.dataset.feed;
+            document.querySelectorAll(".feed-tabs button").forEach((t) => t.classList.toggle("active", t === tab));
+            loadPosts();
+        });
+    });
+}
+
+// Get the posts of the current feed from the server and display them
+export function loadPosts() {
+    // Pinned posts are only listed first in their category
+    let url = `${API_BASE}/posts`;
+    if (currentFeed === "following") {
+        url = `${API_BASE}/feed/following`;
+    } else if (currentFeed !== "all") {
+        url = `${API_BASE}/posts?category=${encodeURIComponent(currentFeed)}`;
+    }
+    fetch(url, { method: "GET", credentials: "include" })
+        .then((response) => {
+            return response.json();
+        })
+        .then((posts) => {
+            const postsContainer = document.querySelector(".posts");
+
+            if (!postsContainer) {
+                console.error("Posts container not found!");
+                return;
+            }
+
+            // Clear existing posts
+            postsContainer.innerHTML = "";
+
+            // If no posts are found, display a message
+            if (posts.length === 0) {
+                if (currentFeed === "following") {
+                    postsContainer.innerHTML = "<p>No posts from what you follow yet. Follow categories and users from the settings and profiles.</p>";
+                } else if (currentFeed !== "all") {
+                    postsContainer.innerHTML = "<p>No posts in this category yet.</p>";
+                } else {
+                    postsContainer.innerHTML = "<p>No posts yet. Be the first to post!</p>";
+                }
+                return;
+            }
+
+            // Add each post to the container
+            posts.forEach((post) => {
+                // Convert ISO string to Date properly
+                let dateDisplay = "Unknown date";
+                try {
+                    // Check if creation_date exists and isn't null
+                    if (post.creation_date) {
+                        const date = new Date(post.creation_date);
+                        // Verify it's a valid date
+                        if (!isNaN(date.getTime())) {
+                            dateDisplay = date.toLocaleString();
+                        }
+                    }
+                } catch (e) {
+                    console.error("Date parsing error:", e);
+                }
+
+                // Create a new post element
+                const postElement = document.createElement("div");
+                postElement.className = "post";
+                postElement.dataset.postId = post.post_id;
+
+                postElement.innerHTML = `
+                    <h4>${profileLink(post.username)}</h4>
+                    <h3>${postBadges(post)}${post.title || ""}</h3>
+                        <div class="content">${contentHTML(post)}</div>
+                        ${renderAttachments(post.attachments)}
+                        ${renderPreviews(post.previews)}
+                    <div class="post-meta">
+                        <span>Category: ${post.category || "General"}</span>
+                        <br>
+                        <span>Posted: ${new Date(
+                    post.creation_date
+                ).toLocaleString()}</span>
+                        <button class="view-comments-btn">View Post Details</button>
+                    </div>
+                `;
+
+                // Add specific click handler for the comments button
+                const commentsBtn = postElement.querySelector(".view-comments-btn");
+                if (commentsBtn) {
+                    commentsBtn.addEventListener("click", function (e) {
+                        viewPost(post.post_id);
+                    });
+                }
+
+                // Append the post element to the posts container
+                postsContainer.appendChild(postElement);
+            });
+        })
+        .catch((error) => console.error("Error loading posts:", error));
+}
+
+// Function to handle post creation
+export function setupPostForm() {
+    // Get the elements from the creation form
+    const titleInput = document.getElementById("post-title");
+    const categorySelect = document.getElementById("post-category");
+    const textarea = document.querySelector(".create-post textarea");
+    const postButton = document.querySelector(".create-post button");
+    const fileInput = document.getElementById("post-files");
+
+    if (!textarea || !postButton || !titleInput) {
+        console.error("Form elements not found!");
+        return;
+    }
+
+    // The title and content are kept as a draft until the post is sent
+    bindDraft({ title: titleInput, content: textarea }, () => ({ type: "post", id: "" }));
+
+    // Add event listener to the button
+    postButton.addEventListener("click", async function () {
+        const title = titleInput.value.trim();
+        const content = textarea.value.trim();
+        const category = categorySelect ? categorySelect.value : "general";
+
+        if (!content || !title) {
+            alert("Please enter both title and content for your post");
+            return;
+        }
+        discardDraft("post");
+
+        // The files are uploaded first, the post then refers to them by ID
+        let attachmentIds;
+        try {
+            attachmentIds = (await uploadFiles(fileInput?.files)).map((a) => a.attachment_id);
+        } catch (error) {
+            alert(error.message);
+            return;
+        }
+
+        // Create post with content
+        const postData = {
+            title: title,
+            content: content,
+            category: category,
+            attachment_ids: attachmentIds,
+        };
+
+        // Send to server
+        fetch(`${API_BASE}/posts`, {
+            method: "POST",
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify(postData),
+            credentials: "include",
+        })
+            .then(async (response) => {
+                const data = await response.json();
+                if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to create post"));
+                return data;
+            })
+            .then((newPost) => {
+                // Clear the form inputs
+                titleInput.value = "";
+                textarea.value = "";
+                if (fileInput) fileInput.value = "";
+                if (categorySelect) categorySelect.value = "general";
+
+                // Add the new post to the DOM immediately
+                const postsContainer = document.querySelector(".posts");
+                if (postsContainer) {
+                    const postElement = document.createElement("div");
+                    postElement.className = "post";
+                    postElement.dataset.postId = newPost.post_id;
+                    const currentUser = window.currentUser;
+
+                    postElement.innerHTML = `
+                        <h4>${profileLink(currentUser?.username)}</h4>
+                        <h3>${newPost.title}</h3>
+                        <div class="content">${contentHTML(newPost)}</div>
+                        ${renderAttachments(newPost.attachments)}
+                        ${renderPreviews(newPost.previews)}
+                        <div class="post-meta">
+                            <span>Category: ${newPost.category}</span>
+                            <br>
+                            <span>Posted: ${new Date(
+                        newPost.creation_date
+                    ).toLocaleString()}</span>
+                            <button class="view-comments-btn">View Post Details</button>
+                        </div>
+                    `;
+
+                    const DetailsBtn = postElement.querySelector(".view-comments-btn");
+                    if (DetailsBtn) {
+                        DetailsBtn.addEventListener("click", function (e) {
+                            viewPost(newPost.post_id);
+                        });
+                    }
+
+                    // Add to the beginning of the posts container
+                    postsContainer.insertBefore(postElement, postsContainer.firstChild);
+                }
+            })
+            .catch((error) => {
+                console.error("Error:", error);
+                alert(error.message);
+            });
+    });
+}
+
+// Function to navigate to a post detail page
+export function viewPost(postId) {
+    // Update URL to include post ID
+    history.pushState({}, `Post ${postId}`, `#post/${postId}`);
+
+    // Render the post detail template
+    document.getElementById("app").innerHTML = routes["post-detail"](postId);
+
+    // Load post details
+    loadPostDetails(postId);
+
+    // Setup comment form
+    setupCommentForm(postId);
+}
+
+// Function to load post details and comments
+export function loadPostDetails(postId) {
+    fetch(`${API_BASE}/post/${postId}`, {
+        method: "GET",
+        credentials: "include",
+    })
+        .then((response) => response.json())
+        .then((data) => {
+            displayPostDetails(data.post, data.subscribed);
+            displayComments(data.comments, data.post.post_id);
+        })
+        .catch((error) => {
+            console.error("Error loading post details:", error);
+        });
+}
+
+// Function to display post details
+// subscribed tells whether the current user follows the new comments of the post
+export function displayPostDetails(post, subscribed) {
+    const container = document.getElementById("post-content");
+    if (!container) return; // If the container is not found, exit
+
+    container.innerHTML = `
+      <h2>${postBadges(post)}${post.title}</h2>
+      <div class="post-meta">
+        <span>By: ${profileLink(post.username)}</span>
+        <br>
+        <span>Category: ${post.category || "General"}</span>
+        <br>
+        <span>Posted: ${new Date(post.creation_date).toLocaleString()}</span>
+      </div>
+      <div class="post-body" data-post-id="${post.post_id}">
+        <div class="content">${contentHTML(post)}</div>
+        ${renderAttachments(post.attachments)}
+        ${renderPreviews(post.previews)}
+      </div>
+      ${reportButton("post", post.post_id, post.user_id)}
+      ${window.currentUser ? `<button class="follow-post-btn">${subscribed ? "Unfollow" : "Follow"}</button>` : ""}
+      <div class="moderation-tools"></div>
+    `;
+
+    // Following a post tells about its new comments
+    const followButton = container.querySelector(".follow-post-btn");
+    if (followButton) {
+        followButton.addEventListener("click", async () => {
+            const path = subscribed ? "/subscriptions/remove" : "/subscriptions";
+            try {
+                await accountRequest(path, { target_type: "post", target_id: post.post_id });
+                subscribed = !subscribed;
+                followButton.textContent = subscribed ? "Unfollow" : "Follow";
+            } catch (error) {
+                alert(error.message);
+            }
+        });
+    }
+
+    // No comment can be added to a locked post
+    const commentForm = document.querySelector(".comment-form");
+    if (commentForm) {
+        commentForm.style.display = post.locked ? "none" : "";
+    }
+
+    if (isModerator()) {
+        setupPostModeration(container.querySelector(".moderation-tools"), post);
+    }
+}
+
+// Adds the buttons deleting, locking and pinning a post for moderators
+function setupPostModeration(tools, post) {
+    const postId = post.post_id;
+
+    addModerationButton(tools, "Delete post", async () => {
+        if (await moderate("/posts/delete", { post_id: postId }, "Delete this post and its comments?")) {
+            navigateTo("home");
+        }
+    });
+    addModerationButton(tools, post.locked ? "Unlock" : "Lock", async () => {
+        const question = post.locked ? "Allow comments again?" : "Prevent new comments?";
+        if (await moderate("/posts/lock", { post_id: postId, locked: !post.locked }, question)) {
+            loadPostDetails(postId);
+        }
+    });
+    addModerationButton(tools, post.pinned ? "Unpin" : "Pin", async () => {
+        const question = post.pinned ? "Unpin this post?" : "Pin this post at the top of its category?";
+        if (await moderate("/posts/pin", { post_id: postId, pinned: !post.pinned }, question)) {
+            loadPostDetails(postId);
+        }
+    });
+}
+
+// Function to display comments
+export function displayComments(comments, postId) {
+    const container = document.getElementById("comments-list");
+    if (!container) return;
+
+    if (!comments || comments.length === 0) {
+        container.innerHTML = "<p>No comments yet. Be the first to comment!</p>";
+        return;
+    }
+
+    // For each comment, create a new HTML structure
+    let html = "";
+    comments.forEach((comment) => {
+        html += `
+        <div class="comment">
+          <div class="comment-header">
+            <span class="comment-author">${profileLink(comment.username)}</span>
+            <span class="comment-date">${new Date(
+            comment.creation_date
+        ).toLocaleString()}</span>
+          </div>
+          <div class="comment-body">
+            <div class="content">${contentHTML(comment)}</div>
+            ${renderAttachments(comment.attachments)}
+          </div>
+          ${reportButton("comment", comment.comment_id, comment.user_id)}
+          ${isModerator() ? `<button class="moderation-button" data-comment-id="${comment.comment_id}">Delete</button>` : ""}
+        </div>
+      `;
+    });
+
+    // Set the inner HTML of the comments container
+    container.innerHTML = html;
+
+    // Moderators can delete any comment
+    container.onclick = async (event) => {
+        const button = event.target.closest("[data-comment-id]");
+        if (!button) return;
+        try {
+            if (await moderate("/comments/delete", { comment_id: button.dataset.commentId }, "Delete this comment?")) {
+                loadPostDetails(postId);
+            }
+        } catch (error) {
+            alert(error.message);
+        }
+    };
+}
+
+// Function to set up the comment form
+export function setupCommentForm(postId) {
+    const submitButton = document.getElementById("submit-comment");
+    const contentInput = document.getElementById("comment-content");
+    const fileInput = document.getElementById("comment-files");
+
+    if (!submitButton || !contentInput) return;
+
+    bindDraft({ content: contentInput }, () => ({ type: "comment", id: postId }));
+
+    submitButton.addEventListener("click", async () => {
+        const content = contentInput.value.trim(); // Get the content from the input field, trim whitespace to ensure it's not empty
+        if (!content) {
+            alert("Comment cannot be empty");
+            return;
+        }
+        discardDraft("comment", postId);
+
+        let attachmentIds;
+        try {
+            attachmentIds = (await uploadFiles(fileInput?.files)).map((a) => a.attachment_id);
+        } catch (error) {
+            alert(error.message);
+            return;
+        }
+
+        // Send comment to server
+        fetch(`${API_BASE}/comment`, {
+            method: "POST",
+            headers: csrfHeaders({ "Content-Type": "application/json" }),
+            body: JSON.stringify({
+                post_id: postId,
+                content: content,
+                attachment_ids: attachmentIds,
+            }),
+            credentials: "include",
+        })
+            .then(async (response) => {
+                const data = await response.json();
+                if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to post comment"));
+                return data;
+            })
+            .then(() => {
+                // Clear the input field
+                contentInput.value = "";
+                if (fileInput) fileInput.value = "";
+                // Reload comments to show the new one
+                loadPostDetails(postId);
+            })
+            .catch((error) => {
+                console.error("Error posting comment:", error);
+                alert(error.message);
+            });
+    });
 }
\ No newline at end of file
@@ -0,0 +1,62 @@
+import { escapeHTML } from "./api.js";
+
+// Returns the HTML of a single link preview card
+function previewCard(preview) {
+  const image = preview.image_url
+    ? `<img src="${escapeHTML(preview.image_url)}" alt="" loading="lazy">`
+    : "";
+  const site = preview.site_name || new URL(preview.url).hostname;
+  return `
+    <a class="link-preview" href="${escapeHTML(preview.url)}" data-url="${escapeHTML(preview.url)}"
+       target="_blank" rel="nofollow noopener noreferrer">
+      ${image}
+      <span class="link-preview-text">
+        <span class="link-preview-site">${escapeHTML(site)}</span>
+        <strong class="link-preview-title">${escapeHTML(preview.title)}</strong>
+        ${preview.description ? `<span class="link-preview-description">${escapeHTML(preview.description)}</span>` : ""}
+      </span>
+    </a>`;
+}
+
+// Returns the HTML of the previews of the links of a post or a message
+export function renderPreviews(previews) {
+  if (!previews || previews.length === 0) return "";
+  return `<div class="link-previews">${previews.map(previewCard).join("")}</div>`;
+}
+
+// Adds a preview fetched after the content was shown, or replaces the older one of the same page
+export function showPreview(container, preview) {
+  let list = container.querySelector(".link-previews");
+  if (!list) {
+    container.insertAdjacentHTML("beforeend", renderPreviews([preview]));
+    return;
+  }
+  const shown = [...list.querySelectorAll(".link-preview")].find((el) => el.dataset.url === preview.url);
+  if (shown) {
+    shown.outerHTML = previewCard(preview);
+  } else {
+    list.insertAdjacentHTML("beforeend", previewCard(preview));
+  }
+}
+
+// Shows a preview_ready event on the post or message it belongs to, if it is on the page
+// The messages just sent have no ID yet, the last one linking the page gets it
+export function receivePreview(event) {
+  if (event.target_type === "post") {
+    document
+      .querySelectorAll(`[data-post-id="${CSS.escape(event.target_id)}"]`)
+      .forEach((el) => showPreview(el, event.preview));
+    return;
+  }
+
+  let message = document.querySelector(`.message[data-message-id="${CSS.escape(event.target_id)}"]`);
+  if (!message) {
+    const pending = [...document.querySelectorAll(".message.sent:not([data-message-id])")].filter((el) =>
+      el.querySelector(".message-content")?.textContent.includes(event.preview.url)
+    );
+    message = pending.pop();
+    if (!message) return;
+    message.dataset.messageId = event.target_id;
+  }
+  showPreview(message.querySelector(".message-content"), event.preview);
+}
@@ -0,0 +1,232 @@
+import { navigateTo } from "./main.js";
+import { API_BASE, apiErrorMessage, escapeHTML } from "./api.js";
+import { getCurrentUser } from "./users.js";
+import { accountRequest } from "./settings.js";
+import { isModerator, moderate, addModerationButton } from "./moderation.js";
+import { renderAttachments } from "./attachments.js";
+import { renderPreviews } from "./previews.js";
+
+// Username shown for the content of deleted accounts, which have no profile
+const DELETED_USERNAME = "[deleted]";
+
+// Number of posts or comments loaded by each "Load more" click
+const PAGE_SIZE = 10;
+
+const GENDERS = { 1: "Male", 2: "Female", 3: "Other" };
+
+// Returns a link opening the profile of a user, or the plain name for deleted accounts
+export function profileLink(username) {
+  if (!username || username === DELETED_USERNAME) {
+    return escapeHTML(username || "Anonymous");
+  }
+  return `<a href="#" class="profile-link" data-username="${escapeHTML(username)}">${escapeHTML(username)}</a>`;
+}
+
+// Returns an image showing the avatar of a user at one of the sizes of the server:
+// "small" (32px), "medium" (64px) or "large" (256px)
+export function avatarImage(avatarURL, size = "small") {
+  if (!avatarURL) return "";
+  const separator = avatarURL.includes("?") ? "&" : "?";
+  return `<img class="avatar avatar-${size}" src="${escapeHTML(avatarURL)}${separator}size=${size}" alt="" loading="lazy">`;
+}
+
+// Returns the HTML of a content with its @mentions linked to the profiles of the mentioned users
+// The offsets of the mentions count characters, the way Array.from splits a string
+export function linkMentions(content, mentions) {
+  const chars = Array.from(content || "");
+  let html = "";
+  let position = 0;
+  (mentions || []).forEach((mention) => {
+    html += escapeHTML(chars.slice(position, mention.start).join(""));
+    html += `<a href="#" class="profile-link mention" data-username="${escapeHTML(mention.username)}">${escapeHTML(
+      chars.slice(mention.start, mention.end).join("")
+    )}</a>`;
+    position = mention.end;
+  });
+  return html + escapeHTML(chars.slice(position).join(""));
+}
+
+// Returns the HTML of a post, a comment or a message: the sanitized HTML rendered by the server,
+// or the plain text with its mentions linked when there is none, as for a message just sent
+export function contentHTML(item) {
+  return item.content_html ?? linkMentions(item.content, item.mentions);
+}
+
+// Profile links can be anywhere in the page, a single listener handles them all
+document.addEventListener("click", (event) => {
+  const link = event.target.closest(".profile-link");
+  if (!link) return;
+  event.preventDefault();
+  navigateTo("profile", link.dataset.username);
+});
+
+// Loads and displays the profile of a user
+export function loadProfile(username) {
+  const container = document.getElementById("profile-content");
+  if (!container) return;
+
+  fetch(`${API_BASE}/users/${encodeURIComponent(username)}`, { credentials: "include" })
+    .then(async (response) => {
+      const data = await response.json();
+      if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to load profile"));
+      return data;
+    })
+    .then((profile) => displayProfile(container, profile))
+    .catch((error) => {
+      container.innerHTML = `<p>${escapeHTML(error.message)}</p>`;
+    });
+}
+
+function displayProfile(container, profile) {
+  const details = [];
+  if (profile.first_name || profile.last_name) {
+    details.push(`${escapeHTML(profile.first_name)} ${escapeHTML(profile.last_name)}`);
+  }
+  if (profile.age) details.push(`${profile.age} years old`);
+  if (profile.gender) details.push(GENDERS[profile.gender] || "");
+
+  let presence = "Offline";
+  if (profile.presence === "online") {
+    presence = "Online";
+  } else if (profile.last_seen) {
+    presence = `Last seen ${new Date(profile.last_seen).toLocaleString()}`;
+  }
+
+  const status = profile.status?.status_text
+    ? `<p class="profile-status">${escapeHTML(profile.status.status_emoji)} ${escapeHTML(profile.status.status_text)}</p>`
+    : "";
+
+  container.innerHTML = `
+    ${avatarImage(profile.avatar_url, "large")}
+    <h2>${escapeHTML(profile.username)}</h2>
+    ${status}
+    <p>${details.join(" · ")}</p>
+    <p>${presence}</p>
+    <p>Joined ${new Date(profile.joined_at).toLocaleDateString()} ·
+      ${profile.post_count} posts · ${profile.comment_count} comments</p>
+    <div id="profile-actions"></div>
+
+    <h3>Posts</h3>
+    <div id="profile-posts"></div>
+    <button id="more-posts">Load more posts</button>
+
+    <h3>Comments</h3>
+    <div id="profile-comments"></div>
+    <button id="more-comments">Load more comments</button>
+  `;
+
+  setupRelationButtons(profile);
+  setupUserModeration(profile);
+  setupActivityList(profile, "posts", profile.recent_posts, profile.post_count, renderPost);
+  setupActivityList(profile, "comments", profile.recent_comments, profile.comment_count, renderComment);
+}
+
+// Adds the buttons to follow, block or mute the user, for logged in visitors other than the user
+function setupRelationButtons(profile) {
+  const actions = document.getElementById("profile-actions");
+  const viewer = getCurrentUser();
+  if (!viewer || viewer.user_id === profile.user_id) return;
+
+  const relations = [
+    { kind: "subscriptions", active: profile.following, labels: ["Follow", "Unfollow"] },
+    { kind: "blocks", active: profile.blocked, labels: ["Block", "Unblock"] },
+    { kind: "mutes", active: profile.muted, labels: ["Mute", "Unmute"] },
+  ];
+  relations.forEach((relation) => {
+    const button = document.createElement("button");
+    button.textContent = relation.labels[relation.active ? 1 : 0];
+    button.addEventListener("click", async () => {
+      const path = relation.active ? `/${relation.kind}/remove` : `/${relation.kind}`;
+      const body =
+        relation.kind === "subscriptions"
+          ? { target_type: "user", target_id: profile.user_id }
+          : { user_id: profile.user_id };
+      try {
+        await accountRequest(path, body);
+        relation.active = !relation.active;
+        button.textContent = relation.labels[relation.active ? 1 : 0];
+      } catch (error) {
+        alert(error.message);
+      }
+    });
+    actions.appendChild(button);
+  });
+}
+
+// Adds the buttons suspending the user for moderators
+function setupUserModeration(profile) {
+  if (!isModerator() || getCurrentUser()?.user_id === profile.user_id) return;
+  const actions = document.getElementById("profile-actions");
+
+  addModerationButton(actions, "Suspend", async () => {
+    const hours = parseInt(prompt("Suspend for how many hours?", "24"), 10);
+    if (!hours) return;
+    if (await moderate("/users/suspend", { user_id: profile.user_id, hours }, `Suspend ${profile.username} for ${hours} hours?`)) {
+      alert(`${profile.username} is suspended`);
+    }
+  });
+  addModerationButton(actions, "Lift suspension", async () => {
+    if (await moderate("/users/unsuspend", { user_id: profile.user_id }, `Lift the suspension of ${profile.username}?`)) {
+      alert(`${profile.username} can log in again`);
+    }
+  });
+}
+
+// Displays the first items of a list and loads the next pages on demand
+function setupActivityList(profile, kind, firstItems, total, render) {
+  const list = document.getElementById(`profile-${kind}`);
+  const button = document.getElementById(`more-${kind}`);
+  let loaded = 0;
+  let page = 0;
+
+  const append = (items) => {
+    items.forEach((item) => list.insertAdjacentHTML("beforeend", render(item)));
+    loaded = list.children.length;
+    button.style.display = loaded < total ? "block" : "none";
+  };
+
+  if (firstItems.length === 0) {
+    list.innerHTML = `<p>No ${kind} yet.</p>`;
+  }
+  append(firstItems);
+
+  button.addEventListener("click", () => {
+    // Pages are fetched from the start, skipping what the profile already included
+    page++;
+    fetch(`${API_BASE}/users/${encodeURIComponent(profile.username)}/${kind}?page=${page}&limit=${PAGE_SIZE}`, {
+      credentials: "include",
+    })
+      .then((response) => response.json())
+      .then((items) => {
+        const shown = new Set(
+          [...list.querySelectorAll("[data-id]")].map((el) => el.dataset.id)
+        );
+        append(items.filter((item) => !shown.has(item.post_id + (item.comment_id || ""))));
+      })
+      .catch((error) => console.error(`Error loading ${kind}:`, error));
+  });
+}
+
+function renderPost(post) {
+  return `
+    <div class="post" data-id="${escapeHTML(post.post_id)}" data-post-id="${escapeHTML(post.post_id)}">
+      <h4><a href="#" onclick="viewPost('${escapeHTML(post.post_id)}'); return false;">${escapeHTML(post.title)}</a></h4>
+      <div class="content">${contentHTML(post)}</div>
+      ${renderAttachments(post.attachments)}
+      ${renderPreviews(post.previews)}
+      <span class="post-meta">${new Date(post.creation_date).toLocaleString()}</span>
+    </div>
+  `;
+}
+
+function renderComment(comment) {
+  return `
+    <div class="comment" data-id="${escapeHTML(comment.post_id + comment.comment_id)}">
+      <div class="content">${contentHTML(comment)}</div>
+      ${renderAttachments(comment.attachments)}
+      <span class="post-meta">On
+        <a href="#" onclick="viewPost('${escapeHTML(comment.post_id)}'); return false;">${escapeHTML(comment.post_title)}</a>,
+        ${new Date(comment.creation_date).toLocaleString()}</span>
+    </div>
+  `;
+}
@@ -0,0 +1,74 @@
+import { API_BASE, apiErrorMessage, csrfHeaders } from "./api.js";
+
+// Whether the browser can receive Web Push notifications at all
+export function isPushSupported() {
+  return "serviceWorker" in navigator && "PushManager" in window && "Notification" in window;
+}
+
+// Returns the push subscription of this browser, or null
+async function currentSubscription() {
+  const registration = await navigator.serviceWorker.getRegistration("/");
+  return registration ? registration.pushManager.getSubscription() : null;
+}
+
+// Reports whether this browser receives the pushes of the forum
+export async function isPushEnabled() {
+  if (!isPushSupported() || Notification.permission !== "granted") return false;
+  return Boolean(await currentSubscription());
+}
+
+// Converts the base64url VAPID key of the server to the bytes expected by the browser
+function decodeKey(key) {
+  const base64 = (key + "=".repeat((4 - (key.length % 4)) % 4)).replace(/-/g, "+").replace(/_/g, "/");
+  return Uint8Array.from(atob(base64), (c) => c.charCodeAt(0));
+}
+
+// Asks for the permission to show notifications, subscribes this browser
+// and registers the subscription with the server
+export async function enablePush() {
+  if (!isPushSupported()) throw new Error("Push notifications are not supported by this browser");
+  if ((await Notification.requestPermission()) !== "granted") {
+    throw new Error("Notifications are blocked for this site");
+  }
+
+  const registration = await navigator.serviceWorker.register("/sw.js", { scope: "/" });
+  const keyResponse = await fetch(`${API_BASE}/push/key`, { credentials: "include" });
+  const { public_key } = await keyResponse.json();
+
+  let subscription = await registration.pushManager.getSubscription();
+  if (subscription) {
+    // A subscription made with an older key of the server would be refused by the push service
+    await subscription.unsubscribe();
+  }
+  subscription = await registration.pushManager.subscribe({
+    userVisibleOnly: true,
+    applicationServerKey: decodeKey(public_key),
+  });
+
+  const response = await fetch(`${API_BASE}/push/subscriptions`, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify(subscription.toJSON()),
+    credentials: "include",
+  });
+  if (!response.ok) {
+    await subscription.unsubscribe();
+    throw new Error(apiErrorMessage(await response.json(), "Failed to enable push notifications"));
+  }
+}
+
+// Stops the pushes to this browser, on the server and in the browser
+// Called on logout so that the next user of the browser does not get them
+export async function disablePush() {
+  if (!isPushSupported()) return;
+  const subscription = await currentSubscription();
+  if (!subscription) return;
+
+  await fetch(`${API_BASE}/push/subscriptions/remove`, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify({ endpoint: subscription.endpoint }),
+    credentials: "include",
+  }).catch((error) => console.error("Error disabling push notifications:", error));
+  await subscription.unsubscribe();
+}
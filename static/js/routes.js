@@ -1,148 +1,384 @@
-import { getCurrentUser } from "./users.js";
-
-// Defines the HTML templates for the main routes of the Real-Time Forum web application.
-// Each route is represented as a function that returns a string of HTML markup for rendering
-// the corresponding page (register, login, home, post detail)
-
-export const routes = {
-  register: function () {
-    return `
-        <h1>Register</h1>
-        <form id="registerForm">
-            <input type="text" id="username" placeholder="Username..." required>
-            <input type="number" id="age" placeholder="Age..." required min="13" max="120">
-            <select id="gender" required>
-                <option value="" disabled selected>Select Gender</option>
-                <option value="1">Male</option>
-                <option value="2">Female</option>
-                <option value="3">Other</option>
-            </select>
-            <input type="text" id="first_name" placeholder="Firstname..." required>
-            <input type="text" id="last_name" placeholder="Lastname..." required>
-            <input type="email" id="email" placeholder="Email..." required>
-            <input type="password" id="password" placeholder="Password..." required>
-            <button type="submit">Register</button>
-
-            <!-- Error container -->
-            <div id="error-message" class="error-message"></div>
-        </form>
-        <p>Already have an account ?<a href="#" onclick="navigateTo('login')"> Login</a></p>
-    `;
-  },
-
-  login: function () {
-    return `
-        <h1>Login</h1>
-        <form id="loginForm">
-            <input type="text" id="usernameOrMail" placeholder="Username or Email..." required>
-            <input type="password" id="password" placeholder="Password..." required>
-            <button type="submit">Login</button>
-        </form>
-        <div id="errorMessage" style="color: red;"></div>
-        <p>Don't have an account ?<a href="#" onclick="navigateTo('register')"> Register</a></p>
-    `;
-  },
-
-  home: function () {
-    return `
-      <header>
-          <h1>Holy Chicken Order</h1>
-            <div class="user-info">
-          <p id="welcome">Welcome, ${
-            getCurrentUser() ? getCurrentUser().username : "Guest"
-          }</p>
-              
-              <!-- Ajouter le bouton de notification ici -->
-              <div class="notification-container">
-                <button id="notification-button">🔔
-                  <span class="notification-badge"></span>
-                </button>
-                <div class="notification-panel"></div>
-              </div>
-              
-              <button id="logout-button" onclick="console.log('Button clicked'); logout();">Logout</button>
-            </div>
-      </header>
-   
-      <div class="main-content">
-       <div class="top-row">
-        <div class="users-container">
-              <div class="user-list-toggle">
-                <h3 id="show-all-users">All Users</h3>
-              </div>
-            <ul class="users-list">
-              <!-- List dynamically -->
-            </ul>
-        </div>
-
-        
-          <div class="create-post">
-            <h3>Create a new post</h3>
-            <input type="text" id="post-title" placeholder="Enter title..." required>
-            <select id="post-category">
-              <option value="general">General</option>
-              <option value="technology">Technology</option>
-              <option value="question">Question</option>
-            </select>
-            <textarea placeholder="What's on your mind?" rows="3"></textarea>
-            <button type="button">Post</button>
-          </div>
-          </div>
-            
-          <div class="posts-container">
-            <h2>Recent Posts</h2>
-            <div class="posts">
-                <!-- Posts will be loaded here -->
-            </div>
-          </div>
-      </div>
-
-    <!-- Hidden chat by default -->
-         <div id="chat-modal">
-          <div class="chat-header">
-              <h3>Chat with <span id="chat-partner-name"></span></h3>
-              <button class="close-chat">×</button>
-          </div>
-          <div class="chat-messages" id="chat-messages"></div>
-          <div id="typing-indicator"></div>
-          <div class="chat-input">
-              <input type="text" id="message-input" placeholder="Type your message...">
-              <button id="send-message-btn">Send</button>
-          </div>
-      </div>
-    `;
-  },
-  "post-detail": function (postId) {
-    return `
-      <header>
-        <h1>Holy Chicken Order</h1>
-        <div class="user-info">
-          <p id="welcome">Welcome, ${
-            getCurrentUser() ? getCurrentUser().username : "Guest"
-          }</p>
-          <button onclick="navigateTo('home')">Back to Forum</button>
-          <button id="logout-button" onclick="console.log('Button clicked'); logout();">Logout</button>
-        </div>
-      </header>
-      
-      <div class="post-detail-container">
-        <div id="post-content">
-          <h2>Loading post...</h2>
-        </div>
-        
-        <div class="comments-section">
-          <h3>Comments</h3>
-          <div id="comments-list">
-            <!-- Comments will be loaded here -->
-          </div>
-          
-          <div class="comment-form">
-            <h4>Add a Comment</h4>
-            <textarea id="comment-content" placeholder="Write your comment..." rows="3"></textarea>
-            <button id="submit-comment">Post Comment</button>
-          </div>
-        </div>
-      </div>
-    `;
-  },
-};
+import { getCurrentUser } from "./users.js";
+
+// Defines the HTML templates for the main routes of the Real-Time Forum web application.
+// Each route is represented as a function that returns a string of HTML markup for rendering
+// the corresponding page (register, login, home, post detail)
+
+export const routes = {
+  register: function () {
+    return `
+        <h1>Register</h1>
+        <form id="registerForm">
+            <input type="text" id="username" placeholder="Username..." required minlength="3" maxlength="30">
+            <input type="number" id="age" placeholder="Age..." required min="13" max="120">
+            <select id="gender" required>
+                <option value="" disabled selected>Select Gender</option>
+                <option value="1">Male</option>
+                <option value="2">Female</option>
+                <option value="3">Other</option>
+            </select>
+            <input type="text" id="first_name" placeholder="Firstname..." required maxlength="50">
+            <input type="text" id="last_name" placeholder="Lastname..." required maxlength="50">
+            <input type="email" id="email" placeholder="Email..." required maxlength="100">
+            <input type="password" id="password" placeholder="Password (8+ characters, letters and digits)..." required minlength="8">
+            <button type="submit">Register</button>
+
+            <!-- Error container -->
+            <div id="error-message" class="error-message"></div>
+        </form>
+        <p>Already have an account ?<a href="#" onclick="navigateTo('login')"> Login</a></p>
+    `;
+  },
+
+  login: function () {
+    return `
+        <h1>Login</h1>
+        <form id="loginForm">
+            <input type="text" id="usernameOrMail" placeholder="Username or Email..." required>
+            <input type="password" id="password" placeholder="Password..." required>
+            <label class="remember-me"><input type="checkbox" id="remember-me"> Remember me</label>
+            <button type="submit">Login</button>
+        </form>
+        <div id="errorMessage" style="color: red;"></div>
+        <p><a href="#" onclick="navigateTo('forgot-password')">Forgot your password ?</a></p>
+        <p>Don't have an account ?<a href="#" onclick="navigateTo('register')"> Register</a></p>
+    `;
+  },
+
+  "forgot-password": function () {
+    return `
+        <h1>Forgot password</h1>
+        <form id="forgotPasswordForm">
+            <input type="email" id="email" placeholder="Email..." required>
+            <button type="submit">Send reset link</button>
+            <div id="error-message" class="error-message"></div>
+        </form>
+        <p id="form-info"></p>
+        <p><a href="#" onclick="navigateTo('login')">Back to login</a></p>
+    `;
+  },
+
+  "reset-password": function () {
+    return `
+        <h1>Choose a new password</h1>
+        <form id="resetPasswordForm">
+            <input type="password" id="password" placeholder="New password (8+ characters, letters and digits)..." required minlength="8">
+            <button type="submit">Reset password</button>
+            <div id="error-message" class="error-message"></div>
+        </form>
+        <p><a href="#" onclick="navigateTo('login')">Back to login</a></p>
+    `;
+  },
+
+  "verify-email": function () {
+    return `
+        <h1>Email verification</h1>
+        <p id="form-info">Verifying your email address...</p>
+        <p><a href="#" onclick="checkSession()">Continue to the forum</a></p>
+    `;
+  },
+
+  home: function () {
+    const user = getCurrentUser();
+    return `
+      ${
+        user && !user.email_verified
+          ? `<div class="verify-banner">
+              Confirm your email address to start posting.
+              <a href="#" onclick="resendVerificationEmail(); return false;">Resend the email</a>
+            </div>`
+          : ""
+      }
+      <header>
+          <h1>Holy Chicken Order</h1>
+            <div class="user-info">
+          <p id="welcome">Welcome, ${
+            getCurrentUser() ? getCurrentUser().username : "Guest"
+          }</p>
+              
+              <!-- Ajouter le bouton de notification ici -->
+              <div class="notification-container">
+                <button id="notification-button">🔔
+                  <span class="notification-badge"></span>
+                </button>
+                <div class="notification-panel"></div>
+              </div>
+              
+              ${
+                ["moderator", "admin"].includes(getCurrentUser()?.role)
+                  ? `<button id="moderation-button" onclick="navigateTo('moderation')">Moderation <span class="report-badge"></span></button>`
+                  : ""
+              }
+              <button onclick="navigateTo('settings')">Settings</button>
+              <button id="logout-button" onclick="console.log('Button clicked'); logout();">Logout</button>
+            </div>
+      </header>
+   
+      <div class="main-content">
+       <div class="top-row">
+        <div class="users-container">
+              <div class="user-list-toggle">
+                <h3 id="show-all-users">All Users</h3>
+              </div>
+            <input type="search" id="user-search" placeholder="Search users..." maxlength="20">
+            <ul class="user-search-results"></ul>
+            <ul class="users-list">
+              <!-- List dynamically -->
+            </ul>
+        </div>
+
+        
+          <div class="create-post">
+            <h3>Create a new post</h3>
+            <input type="text" id="post-title" placeholder="Enter title..." required maxlength="50">
+            <select id="post-category">
+              <option value="general">General</option>
+              <option value="technology">Technology</option>
+              <option value="question">Question</option>
+            </select>
+            <textarea placeholder="What's on your mind?" rows="3"></textarea>
+            <input type="file" id="post-files" multiple>
+            <button type="button">Post</button>
+          </div>
+          </div>
+            
+          <div class="posts-container">
+            <h2>Recent Posts</h2>
+            <div class="feed-tabs">
+              <button data-feed="all" class="active">All posts</button>
+              <button data-feed="following">Following</button>
+              <button data-feed="general">General</button>
+              <button data-feed="technology">Technology</button>
+              <button data-feed="question">Question</button>
+            </div>
+            <div class="posts">
+                <!-- Posts will be loaded here -->
+            </div>
+          </div>
+      </div>
+
+    <!-- Hidden chat by default -->
+         <div id="chat-modal">
+          <div class="chat-header">
+              <h3>Chat with <span id="chat-partner-name"></span></h3>
+              <button class="close-chat">×</button>
+          </div>
+          <div class="chat-messages" id="chat-messages"></div>
+          <div id="typing-indicator"></div>
+          <div class="chat-input">
+              <input type="text" id="message-input" placeholder="Type your message..." maxlength="2000">
+              <label class="attach-button" title="Attach files">📎<input type="file" id="message-files" multiple hidden></label>
+              <button id="send-message-btn">Send</button>
+          </div>
+      </div>
+    `;
+  },
+  settings: function () {
+    const user = getCurrentUser() || {};
+    return `
+      <header>
+        <h1>Account settings</h1>
+        <div class="user-info">
+          <button onclick="navigateTo('home')">Back to Forum</button>
+          <button id="logout-button" onclick="logout();">Logout</button>
+        </div>
+      </header>
+
+      <div class="settings-container">
+        <form id="avatarForm">
+          <h3>Avatar</h3>
+          <div id="avatar-preview"></div>
+          <p>A JPEG, PNG or GIF image, cropped to a square</p>
+          <input type="file" id="avatar-file" accept="image/jpeg,image/png,image/gif" required>
+          <button type="submit">Upload avatar</button>
+          <button type="button" id="remove-avatar">Use the default avatar</button>
+          <div class="error-message"></div>
+        </form>
+
+        <form id="profileForm">
+          <h3>Profile</h3>
+          <input type="text" id="username" placeholder="Username..." required minlength="3" maxlength="30">
+          <input type="text" id="first_name" placeholder="Firstname..." required maxlength="50">
+          <input type="text" id="last_name" placeholder="Lastname..." required maxlength="50">
+          <input type="number" id="age" placeholder="Age..." required min="13" max="120">
+          <select id="gender" required>
+            <option value="1">Male</option>
+            <option value="2">Female</option>
+            <option value="3">Other</option>
+          </select>
+          <button type="submit">Save profile</button>
+          <div class="error-message"></div>
+        </form>
+
+        <form id="emailForm">
+          <h3>Email</h3>
+          <p>Current address: <span id="current-email">${user.email || ""}</span>${
+            user.email_verified ? "" : " (not verified)"
+          }</p>
+          <input type="email" id="new-email" placeholder="New email..." required maxlength="100">
+          <input type="password" id="email-password" placeholder="Current password..." required>
+          <button type="submit">Change email</button>
+          <div class="error-message"></div>
+        </form>
+
+        <form id="privacyForm">
+          <h3>Privacy</h3>
+          <p>Choose what other users see on your profile</p>
+          <label><input type="checkbox" id="show-full-name"> Show my full name</label>
+          <label><input type="checkbox" id="show-age"> Show my age</label>
+          <label><input type="checkbox" id="show-gender"> Show my gender</label>
+          <label><input type="checkbox" id="show-in-directory"> List me in the user directory</label>
+          <label><input type="checkbox" id="allow-stranger-messages"> Allow messages from users I never talked with</label>
+          <button type="submit">Save privacy settings</button>
+          <div class="error-message"></div>
+        </form>
+
+        <div id="relationsSection">
+          <h3>Blocked users</h3>
+          <p>They can't send you messages, and you don't see their posts and comments</p>
+          <ul id="blocked-users"></ul>
+          <h3>Muted users</h3>
+          <p>You don't see their posts and comments</p>
+          <ul id="muted-users"></ul>
+        </div>
+
+        <div id="subscriptionsSection">
+          <h3>Following</h3>
+          <p>You are told about new posts in the categories and from the users you follow, and about new comments on the posts you follow</p>
+          <div id="follow-categories"></div>
+          <ul id="subscriptions"></ul>
+        </div>
+
+        <form id="digestForm">
+          <h3>Email digest</h3>
+          <p>Get your unread messages, replies and mentions by email while you are away</p>
+          <select id="digest-frequency">
+            <option value="daily">Daily</option>
+            <option value="weekly">Weekly</option>
+            <option value="off">Never</option>
+          </select>
+          <button type="submit">Save digest settings</button>
+          <div class="error-message"></div>
+        </form>
+
+        <div id="pushSection">
+          <h3>Push notifications</h3>
+          <p>Get replies, mentions and messages on this device while the forum is closed</p>
+          <button id="push-toggle">Enable push notifications</button>
+          <div class="error-message"></div>
+        </div>
+
+        <form id="passwordForm">
+          <h3>Password</h3>
+          <input type="password" id="current-password" placeholder="Current password..." required>
+          <input type="password" id="new-password" placeholder="New password (8+ characters, letters and digits)..." required minlength="8">
+          <button type="submit">Change password</button>
+          <div class="error-message"></div>
+        </form>
+
+        <form id="deleteAccountForm">
+          <h3>Delete account</h3>
+          <label><input type="radio" name="delete-mode" value="anonymize" checked> Keep my posts, comments and messages, anonymized</label>
+          <label><input type="radio" name="delete-mode" value="remove"> Remove my posts, comments and messages</label>
+          <input type="password" id="delete-password" placeholder="Current password..." required>
+          <button type="submit" class="danger-button">Delete my account</button>
+          <div class="error-message"></div>
+        </form>
+      </div>
+    `;
+  },
+
+  moderation: function () {
+    return `
+      <header>
+        <h1>Moderation</h1>
+        <div class="user-info">
+          <button onclick="navigateTo('home')">Back to Forum</button>
+          <button id="logout-button" onclick="logout();">Logout</button>
+        </div>
+      </header>
+
+      <div class="moderation-container">
+        <select id="report-status">
+          <option value="open">Open reports</option>
+          <option value="actioned">Actioned</option>
+          <option value="dismissed">Dismissed</option>
+          <option value="all">All</option>
+        </select>
+        <select id="report-type">
+          <option value="">Everything</option>
+          <option value="post">Posts</option>
+          <option value="comment">Comments</option>
+          <option value="message">Messages</option>
+        </select>
+        <div id="reports-list">
+          <p>Loading reports...</p>
+        </div>
+
+        <h3>Filtered content</h3>
+        <select id="filtered-action">
+          <option value="">Everything</option>
+          <option value="flag">Flagged</option>
+          <option value="block">Blocked</option>
+          <option value="mask">Masked</option>
+        </select>
+        <div id="filtered-list">
+          <p>Loading filtered content...</p>
+        </div>
+      </div>
+    `;
+  },
+
+  profile: function () {
+    return `
+      <header>
+        <h1>Holy Chicken Order</h1>
+        <div class="user-info">
+          <button onclick="navigateTo('home')">Back to Forum</button>
+          <button id="logout-button" onclick="logout();">Logout</button>
+        </div>
+      </header>
+
+      <div class="profile-container">
+        <div id="profile-content">
+          <h2>Loading profile...</h2>
+        </div>
+      </div>
+    `;
+  },
+
+  "post-detail": function (postId) {
+    return `
+      <header>
+        <h1>Holy Chicken Order</h1>
+        <div class="user-info">
+          <p id="welcome">Welcome, ${
+            getCurrentUser() ? getCurrentUser().username : "Guest"
+          }</p>
+          <button onclick="navigateTo('home')">Back to Forum</button>
+          <button id="logout-button" onclick="console.log('Button clicked'); logout();">Logout</button>
+        </div>
+      </header>
+      
+      <div class="post-detail-container">
+        <div id="post-content">
+          <h2>Loading post...</h2>
+        </div>
+        
+        <div class="comments-section">
+          <h3>Comments</h3>
+          <div id="comments-list">
+            <!-- Comments will be loaded here -->
+          </div>
+          
+          <div class="comment-form">
+            <h4>Add a Comment</h4>
+            <textarea id="comment-content" placeholder="Write your comment..." rows="3"></textarea>
+            <input type="file" id="comment-files" multiple>
+            <button id="submit-comment">Post Comment</button>
+          </div>
+        </div>
+      </div>
+    `;
+  },
+};
@@ -0,0 +1,326 @@
+import { navigateTo } from "./main.js";
+import { getCurrentUser, setCurrentUser } from "./users.js";
+import { API_BASE, apiErrorMessage, csrfHeaders, escapeHTML } from "./api.js";
+import { profileLink, avatarImage } from "./profile.js";
+import { isPushSupported, isPushEnabled, enablePush, disablePush } from "./push.js";
+
+// Sends a JSON request to the account API and returns the decoded response,
+// throwing the readable error message when it fails
+export async function accountRequest(path, body) {
+  const response = await fetch(`${API_BASE}${path}`, {
+    method: "POST",
+    headers: csrfHeaders({ "Content-Type": "application/json" }),
+    body: JSON.stringify(body),
+    credentials: "include",
+  });
+
+  const data = await response.json();
+  if (!response.ok) {
+    throw new Error(apiErrorMessage(data, "Request failed"));
+  }
+  return data;
+}
+
+// Shows the result of a form below it
+function showFormMessage(form, message, isError) {
+  const container = form.querySelector(".error-message");
+  container.textContent = message;
+  container.style.color = isError ? "" : "green";
+  container.style.display = "block";
+}
+
+// Fills the forms of the settings page and attaches their events
+export function setupSettingsPage() {
+  const user = getCurrentUser();
+  if (!user) return;
+
+  // Avatar
+  const avatarForm = document.getElementById("avatarForm");
+  const avatarPreview = document.getElementById("avatar-preview");
+  const showAvatar = (avatarURL) => {
+    setCurrentUser({ ...getCurrentUser(), avatar_url: avatarURL });
+    avatarPreview.innerHTML = avatarImage(avatarURL, "large");
+  };
+  showAvatar(user.avatar_url);
+
+  avatarForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    const form = new FormData();
+    form.append("file", document.getElementById("avatar-file").files[0]);
+    try {
+      const response = await fetch(`${API_BASE}/account/avatar`, {
+        method: "POST",
+        headers: csrfHeaders(),
+        body: form,
+        credentials: "include",
+      });
+      const data = await response.json();
+      if (!response.ok) throw new Error(apiErrorMessage(data, "Failed to upload avatar"));
+      showAvatar(data.avatar_url);
+      avatarForm.reset();
+      showFormMessage(avatarForm, "Avatar updated", false);
+    } catch (error) {
+      showFormMessage(avatarForm, error.message, true);
+    }
+  });
+
+  document.getElementById("remove-avatar").addEventListener("click", async () => {
+    try {
+      const data = await accountRequest("/account/avatar/remove", {});
+      showAvatar(data.avatar_url);
+      showFormMessage(avatarForm, "The default avatar is used again", false);
+    } catch (error) {
+      showFormMessage(avatarForm, error.message, true);
+    }
+  });
+
+  // Profile
+  const profileForm = document.getElementById("profileForm");
+  fetch(`${API_BASE}/account`, { credentials: "include" })
+    .then((response) => response.json())
+    .then((account) => {
+      document.getElementById("username").value = account.username || "";
+      document.getElementById("first_name").value = account.first_name || "";
+      document.getElementById("last_name").value = account.last_name || "";
+      document.getElementById("age").value = account.age || "";
+      document.getElementById("gender").value = account.gender || 3;
+    })
+    .catch((error) => console.error("Error loading account:", error));
+
+  profileForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      const account = await accountRequest("/account", {
+        username: document.getElementById("username").value,
+        first_name: document.getElementById("first_name").value,
+        last_name: document.getElementById("last_name").value,
+        age: parseInt(document.getElementById("age").value),
+        gender: parseInt(document.getElementById("gender").value),
+      });
+      setCurrentUser({ ...getCurrentUser(), ...account });
+      window.currentUser = getCurrentUser();
+      showFormMessage(profileForm, "Profile saved", false);
+    } catch (error) {
+      showFormMessage(profileForm, error.message, true);
+    }
+  });
+
+  // Email
+  const emailForm = document.getElementById("emailForm");
+  emailForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      const account = await accountRequest("/account/email", {
+        email: document.getElementById("new-email").value,
+        password: document.getElementById("email-password").value,
+      });
+      setCurrentUser({ ...getCurrentUser(), ...account });
+      document.getElementById("current-email").textContent = account.email;
+      emailForm.reset();
+      showFormMessage(emailForm, "Email changed, check your inbox to confirm the new address", false);
+    } catch (error) {
+      showFormMessage(emailForm, error.message, true);
+    }
+  });
+
+  // Privacy
+  const privacyForm = document.getElementById("privacyForm");
+  const privacyFields = {
+    show_full_name: document.getElementById("show-full-name"),
+    show_age: document.getElementById("show-age"),
+    show_gender: document.getElementById("show-gender"),
+    show_in_directory: document.getElementById("show-in-directory"),
+    allow_stranger_messages: document.getElementById("allow-stranger-messages"),
+  };
+  fetch(`${API_BASE}/account/privacy`, { credentials: "include" })
+    .then((response) => response.json())
+    .then((settings) => {
+      Object.entries(privacyFields).forEach(([name, input]) => {
+        input.checked = Boolean(settings[name]);
+      });
+    })
+    .catch((error) => console.error("Error loading privacy settings:", error));
+
+  privacyForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      const settings = {};
+      Object.entries(privacyFields).forEach(([name, input]) => {
+        settings[name] = input.checked;
+      });
+      await accountRequest("/account/privacy", settings);
+      showFormMessage(privacyForm, "Privacy settings saved", false);
+    } catch (error) {
+      showFormMessage(privacyForm, error.message, true);
+    }
+  });
+
+  // Email digest
+  const digestForm = document.getElementById("digestForm");
+  const digestFrequency = document.getElementById("digest-frequency");
+  fetch(`${API_BASE}/account/digest`, { credentials: "include" })
+    .then((response) => response.json())
+    .then((settings) => {
+      digestFrequency.value = settings.frequency;
+    })
+    .catch((error) => console.error("Error loading digest settings:", error));
+
+  digestForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      await accountRequest("/account/digest", { frequency: digestFrequency.value });
+      showFormMessage(digestForm, "Digest settings saved", false);
+    } catch (error) {
+      showFormMessage(digestForm, error.message, true);
+    }
+  });
+
+  // Blocked and muted users
+  loadRelations("blocks", "blocked-users", "Unblock");
+  loadRelations("mutes", "muted-users", "Unmute");
+  loadSubscriptions();
+  setupPushToggle();
+
+  // Password
+  const passwordForm = document.getElementById("passwordForm");
+  passwordForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    try {
+      const data = await accountRequest("/account/password", {
+        current_password: document.getElementById("current-password").value,
+        new_password: document.getElementById("new-password").value,
+      });
+      passwordForm.reset();
+      showFormMessage(passwordForm, `${data.message}, your other devices were logged out`, false);
+    } catch (error) {
+      showFormMessage(passwordForm, error.message, true);
+    }
+  });
+
+  // Account deletion
+  const deleteForm = document.getElementById("deleteAccountForm");
+  deleteForm.addEventListener("submit", async (event) => {
+    event.preventDefault();
+    if (!confirm("Delete your account? This can't be undone.")) return;
+
+    try {
+      await accountRequest("/account/delete", {
+        password: document.getElementById("delete-password").value,
+        mode: deleteForm.querySelector('input[name="delete-mode"]:checked').value,
+      });
+
+      if (window.websocket) {
+        window.websocket.close();
+        window.websocket = null;
+      }
+      window.currentUser = null;
+      setCurrentUser(null);
+      navigateTo("login");
+    } catch (error) {
+      showFormMessage(deleteForm, error.message, true);
+    }
+  });
+}
+
+// Shows whether this browser receives push notifications, and lets the user switch them
+async function setupPushToggle() {
+  const section = document.getElementById("pushSection");
+  const button = document.getElementById("push-toggle");
+  if (!isPushSupported()) {
+    button.disabled = true;
+    button.textContent = "Not supported by this browser";
+    return;
+  }
+
+  let enabled = await isPushEnabled();
+  button.textContent = enabled ? "Disable push notifications" : "Enable push notifications";
+  button.addEventListener("click", async () => {
+    try {
+      if (enabled) {
+        await disablePush();
+      } else {
+        await enablePush();
+      }
+      enabled = !enabled;
+      button.textContent = enabled ? "Disable push notifications" : "Enable push notifications";
+      showFormMessage(section, enabled ? "Push notifications enabled" : "Push notifications disabled", false);
+    } catch (error) {
+      showFormMessage(section, error.message, true);
+    }
+  });
+}
+
+// Categories a post can be filed under
+const CATEGORIES = ["general", "technology", "question"];
+
+// Lists the posts, categories and users followed by the current user, each with a button to stop,
+// and a button to follow each category not followed yet
+function loadSubscriptions() {
+  const list = document.getElementById("subscriptions");
+  const categories = document.getElementById("follow-categories");
+  fetch(`${API_BASE}/subscriptions`, { credentials: "include" })
+    .then((response) => response.json())
+    .then((subscriptions) => {
+      const update = (path, body) => async () => {
+        try {
+          await accountRequest(path, body);
+          loadSubscriptions();
+  setupPushToggle();
+        } catch (error) {
+          alert(error.message);
+        }
+      };
+
+      categories.innerHTML = "";
+      CATEGORIES.filter(
+        (category) => !subscriptions.some((s) => s.target_type === "category" && s.target_id === category)
+      ).forEach((category) => {
+        const button = document.createElement("button");
+        button.textContent = `Follow ${category}`;
+        button.addEventListener("click", update("/subscriptions", { target_type: "category", target_id: category }));
+        categories.appendChild(button);
+      });
+
+      list.innerHTML = subscriptions.length === 0 ? "<li>Nothing</li>" : "";
+      subscriptions.forEach((subscription) => {
+        const item = document.createElement("li");
+        const label =
+          subscription.target_type === "user" ? profileLink(subscription.label) : escapeHTML(subscription.label);
+        item.innerHTML = `${escapeHTML(subscription.target_type)}: ${label} <button>Unfollow</button>`;
+        item.querySelector("button").addEventListener(
+          "click",
+          update("/subscriptions/remove", { target_type: subscription.target_type, target_id: subscription.target_id })
+        );
+        list.appendChild(item);
+      });
+    })
+    .catch((error) => console.error("Error loading subscriptions:", error));
+}
+
+// Lists the users blocked or muted by the current user, each with a button to undo it
+function loadRelations(kind, listId, buttonLabel) {
+  const list = document.getElementById(listId);
+  fetch(`${API_BASE}/${kind}`, { credentials: "include" })
+    .then((response) => response.json())
+    .then((users) => {
+      list.innerHTML = "";
+      if (users.length === 0) {
+        list.innerHTML = "<li>Nobody</li>";
+        return;
+      }
+      users.forEach((user) => {
+        const item = document.createElement("li");
+        item.innerHTML = `${profileLink(user.username)} <button>${buttonLabel}</button>`;
+        item.querySelector("button").addEventListener("click", async () => {
+          try {
+            await accountRequest(`/${kind}/remove`, { user_id: user.user_id });
+            loadRelations(kind, listId, buttonLabel);
+          } catch (error) {
+            alert(error.message);
+          }
+        });
+        list.appendChild(item);
+      });
+    })
+    .catch((error) => console.error(`Error loading ${kind}:`, error));
+}
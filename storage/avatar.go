@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"slices"
+)
+
+// Avatar crops the largest centered square of a JPEG, PNG or GIF image and resizes it
+// to each of the sizes, in pixels, returning one encoded image per size with their MIME type
+// Photos are encoded as JPEG and the other images as PNG, to keep their transparency
+// Only a few images are worked on at once, the others wait for their turn
+func Avatar(data []byte, sizes []int) (images [][]byte, mimeType string, err error) {
+	imageJobs <- struct{}{}
+	defer func() { <-imageJobs }()
+
+	src, _, format, err := decodeImage(data, maxAvatarPixels)
+	if err != nil {
+		return nil, "", err
+	}
+
+	bounds := src.Bounds()
+	side := min(bounds.Dx(), bounds.Dy())
+	x0 := bounds.Min.X + (bounds.Dx()-side)/2
+	y0 := bounds.Min.Y + (bounds.Dy()-side)/2
+	square := image.Rect(x0, y0, x0+side, y0+side)
+
+	// The source is only read once, when it is larger than the largest size,
+	// the smaller sizes are then made from that first copy
+	largest := slices.Max(sizes)
+	if side > largest {
+		src, square = resize(src, square, largest, largest), image.Rect(0, 0, largest, largest)
+	}
+
+	for _, size := range sizes {
+		encoded, err := encodeImage(resize(src, square, size, size), format)
+		if err != nil {
+			return nil, "", err
+		}
+		images = append(images, encoded)
+	}
+
+	mimeType = "image/png"
+	if format == "jpeg" {
+		mimeType = "image/jpeg"
+	}
+	return images, mimeType, nil
+}
+
+// Identicon draws the default avatar of a seed as a size x size PNG image:
+// a 5 x 5 grid of cells, symmetric like a face, whose pattern and color come from the SHA-256 of the seed
+// The same seed always gives the same image
+func Identicon(seed string, size int) ([]byte, error) {
+	hash := sha256.Sum256([]byte(seed))
+	fill := hueColor(int(hash[0])<<8 | int(hash[1]))
+	background := color.RGBA{R: 240, G: 240, B: 240, A: 255}
+
+	// The grid takes 5 of 6 cells, the half cell around it is left as a margin
+	const cells = 5
+	cell := float64(size) / (cells + 1)
+	cellAt := func(p int) int {
+		return int(math.Floor((float64(p) + 0.5 - cell/2) / cell))
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		cy := cellAt(y)
+		for x := 0; x < size; x++ {
+			cx := cellAt(x)
+			img.SetRGBA(x, y, background)
+			if cx < 0 || cy < 0 || cx >= cells || cy >= cells {
+				continue
+			}
+			// The right columns mirror the left ones, a cell of the left half is filled when its bit is set
+			bit := cy*3 + min(cx, cells-1-cx)
+			if hash[2+bit/8]>>(bit%8)&1 == 1 {
+				img.SetRGBA(x, y, fill)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// hueColor returns a saturated color of medium lightness whose hue is picked by n
+func hueColor(n int) color.RGBA {
+	hue := float64(n%360) / 60
+	const chroma, light = 0.55, 0.65
+	x := chroma * (1 - math.Abs(math.Mod(hue, 2)-1))
+	var r, g, b float64
+	switch int(hue) {
+	case 0:
+		r, g = chroma, x
+	case 1:
+		r, g = x, chroma
+	case 2:
+		g, b = chroma, x
+	case 3:
+		g, b = x, chroma
+	case 4:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := light - chroma/2
+	return color.RGBA{R: uint8((r + m) * 255), G: uint8((g + m) * 255), B: uint8((b + m) * 255), A: 255}
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// DiskStore keeps the blobs in a local directory, as <dir>/<first 2 characters of the key>/<key>
+type DiskStore struct {
+	Dir string
+}
+
+// NewDiskStore creates a store writing to dir, which is created when missing
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	return &DiskStore{Dir: dir}, nil
+}
+
+// path returns where the blob of a key is stored
+func (s *DiskStore) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+// Put writes the content to a temporary file while hashing it, then moves it to its key
+func (s *DiskStore) Put(r io.Reader) (string, error) {
+	tmp, err := os.CreateTemp(s.Dir, "upload-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create blob: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if _, err := io.Copy(tmp, io.TeeReader(r, hash)); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		return key, nil // same content already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to store blob: %w", err)
+	}
+	return key, nil
+}
+
+// Open opens the file of a blob
+func (s *DiskStore) Open(key string) (io.ReadSeekCloser, error) {
+	if !ValidKey(key) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes the file of a blob
+func (s *DiskStore) Delete(key string) error {
+	if !ValidKey(key) {
+		return ErrNotFound
+	}
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"regexp"
+)
+
+// ErrNotFound is returned when no blob has the given key
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps the uploaded files, addressed by the SHA-256 of their content
+// Storing the same content twice gives the same key and keeps a single copy
+// The disk store is used for now, other backends only have to implement this interface
+type BlobStore interface {
+	// Put stores a content and returns its key
+	Put(r io.Reader) (string, error)
+	// Open returns a content for reading, seeking is needed to serve byte ranges
+	Open(key string) (io.ReadSeekCloser, error)
+	// Delete removes a content, deleting a missing content is not an error
+	Delete(key string) error
+}
+
+// keyPattern matches the keys given by Put, other keys are refused so that they can't escape the store
+var keyPattern = regexp.MustCompile(`^[0-9a-f]{64}$`)
+
+// ValidKey reports whether key could have been returned by Put
+func ValidKey(key string) bool {
+	return keyPattern.MatchString(key)
+}
@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+)
+
+// Largest images, in pixels, decoded to make a thumbnail or an avatar, to keep memory use bounded
+const (
+	maxThumbnailPixels = 24_000_000 // photos of most phones
+	maxAvatarPixels    = 4096 * 4096
+)
+
+// Images decoded and resized at the same time, each one can take tens of megabytes
+const maxImageJobs = 2
+
+// imageJobs holds a token for each image being worked on
+var imageJobs = make(chan struct{}, maxImageJobs)
+
+// ErrNotImage is returned when a content is not an image a thumbnail can be made of
+var ErrNotImage = errors.New("not a supported image")
+
+// ErrImageTooLarge is returned when an image has too many pixels to be decoded
+var ErrImageTooLarge = errors.New("image too large")
+
+// Thumbnail returns a copy of a JPEG, PNG or GIF image scaled down to fit in a size x size square,
+// encoded as JPEG for photos and PNG otherwise, with the width and height of the original
+// Images already small enough are copied at their size
+// Only a few images are worked on at once, the others wait for their turn
+func Thumbnail(data []byte, size int) (thumbnail []byte, width, height int, err error) {
+	imageJobs <- struct{}{}
+	defer func() { <-imageJobs }()
+
+	src, config, format, err := decodeImage(data, maxThumbnailPixels)
+	if err != nil {
+		return nil, config.Width, config.Height, err
+	}
+
+	thumbnail, err = encodeImage(scaleDown(src, size), format)
+	return thumbnail, config.Width, config.Height, err
+}
+
+// decodeImage decodes a JPEG, PNG or GIF image of at most maxPixels pixels and returns it with its size and format
+// The size is read from the header first, so it is known even when the image is too large to be decoded
+func decodeImage(data []byte, maxPixels int) (image.Image, image.Config, string, error) {
+	config, format, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, config, "", ErrNotImage
+	}
+	if config.Width <= 0 || config.Height <= 0 || config.Width > maxPixels/config.Height {
+		return nil, config, format, ErrImageTooLarge
+	}
+
+	var src image.Image
+	switch format {
+	case "jpeg":
+		src, err = jpeg.Decode(bytes.NewReader(data))
+	case "png":
+		src, err = png.Decode(bytes.NewReader(data))
+	case "gif":
+		src, err = gif.Decode(bytes.NewReader(data)) // first frame only
+	default:
+		return nil, config, format, ErrNotImage
+	}
+	return src, config, format, err
+}
+
+// encodeImage encodes a resized image as JPEG when its source was a photo, as PNG otherwise
+func encodeImage(img image.Image, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	if format == "jpeg" {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 80})
+	} else {
+		err = png.Encode(&buf, img)
+	}
+	return buf.Bytes(), err
+}
+
+// scaleDown resizes an image to fit in a size x size square, keeping its ratio
+func scaleDown(src image.Image, size int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if w > size || h > size {
+		if w >= h {
+			dw, dh = size, max(1, h*size/w)
+		} else {
+			dw, dh = max(1, w*size/h), size
+		}
+	}
+	return resize(src, bounds, dw, dh)
+}
+
+// resize scales the part of src inside bounds to a dw x dh image
+// Each pixel of the result is the average of the pixels it covers in the source,
+// with premultiplied colors so that transparent pixels do not darken their neighbours
+// Enlarged images repeat the pixels of the source
+func resize(src image.Image, bounds image.Rectangle, dw, dh int) *image.RGBA {
+	w, h := bounds.Dx(), bounds.Dy()
+	pixel := pixelReader(src)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		y0, y1 := bounds.Min.Y+y*h/dh, bounds.Min.Y+max((y+1)*h/dh, y*h/dh+1)
+		for x := 0; x < dw; x++ {
+			x0, x1 := bounds.Min.X+x*w/dw, bounds.Min.X+max((x+1)*w/dw, x*w/dw+1)
+
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := pixel(sx, sy)
+					r, g, b, a = r+uint64(pr), g+uint64(pg), b+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8), G: uint8(g / n >> 8), B: uint8(b / n >> 8), A: uint8(a / n >> 8),
+			})
+		}
+	}
+	return dst
+}
+
+// pixelReader returns a function reading the premultiplied 16 bit colors of the pixels of an image
+// The pixels of the images made by the decoders are read straight from their buffers,
+// going through At for each of them would allocate a color per pixel
+func pixelReader(src image.Image) func(x, y int) (r, g, b, a uint32) {
+	switch img := src.(type) {
+	case *image.RGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := img.Pix[img.PixOffset(x, y):]
+			return uint32(p[0]) * 0x101, uint32(p[1]) * 0x101, uint32(p[2]) * 0x101, uint32(p[3]) * 0x101
+		}
+	case *image.NRGBA:
+		return func(x, y int) (r, g, b, a uint32) {
+			p := img.Pix[img.PixOffset(x, y):]
+			a = uint32(p[3]) * 0x101
+			return uint32(p[0]) * a / 0xff, uint32(p[1]) * a / 0xff, uint32(p[2]) * a / 0xff, a
+		}
+	case *image.YCbCr:
+		return func(x, y int) (r, g, b, a uint32) {
+			yi, ci := img.YOffset(x, y), img.COffset(x, y)
+			r8, g8, b8 := color.YCbCrToRGB(img.Y[yi], img.Cb[ci], img.Cr[ci])
+			return uint32(r8) * 0x101, uint32(g8) * 0x101, uint32(b8) * 0x101, 0xffff
+		}
+	case *image.Gray:
+		return func(x, y int) (r, g, b, a uint32) {
+			v := uint32(img.Pix[img.PixOffset(x, y)]) * 0x101
+			return v, v, v, 0xffff
+		}
+	}
+	return func(x, y int) (r, g, b, a uint32) {
+		return src.At(x, y).RGBA()
+	}
+}
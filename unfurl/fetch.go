@@ -0,0 +1,151 @@
+package unfurl
+
+import (
+	"Real-Time-Forum/shared"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var (
+	// ErrPrivateAddress is returned for the URLs leading to the local network,
+	// which must not be reachable by asking for their preview
+	ErrPrivateAddress = shared.ErrPrivateAddress
+	// ErrNoPreview is returned when a page is not HTML or has no title to show,
+	// or when the image of a preview is not a JPEG, PNG or GIF image
+	ErrNoPreview = errors.New("page has no preview")
+	// ErrImageTooLarge is returned when the image of a preview is larger than allowed
+	ErrImageTooLarge = errors.New("image too large")
+)
+
+// Most redirects followed before giving up on a page
+const maxRedirects = 3
+
+// Fetcher downloads web pages to read their previews
+type Fetcher struct {
+	Client  *http.Client
+	MaxSize int64 // bytes of a page read at most, the tags are in its head anyway
+}
+
+// NewFetcher creates a fetcher giving up on a page after timeout and reading at most maxSize bytes of it
+// Addresses of the local network are refused unless allowPrivate is set, which is only meant for tests
+func NewFetcher(timeout time.Duration, maxSize int64, allowPrivate bool) *Fetcher {
+	dialer := shared.PublicDialer(timeout)
+	if allowPrivate {
+		dialer.Control = nil
+	}
+
+	transport := &http.Transport{
+		Proxy:                  nil, // a proxy would connect to the addresses checked here
+		DialContext:            dialer.DialContext,
+		TLSHandshakeTimeout:    timeout,
+		ResponseHeaderTimeout:  timeout,
+		MaxResponseHeaderBytes: 64 << 10,
+		MaxIdleConns:           10,
+		IdleConnTimeout:        30 * time.Second,
+	}
+
+	return &Fetcher{
+		Client: &http.Client{
+			Transport: transport,
+			Timeout:   timeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return errors.New("too many redirects")
+				}
+				if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+					return errors.New("redirect to an unsupported scheme")
+				}
+				return nil
+			},
+		},
+		MaxSize: maxSize,
+	}
+}
+
+// Fetch downloads a page and returns the preview described by its tags
+func (f *Fetcher) Fetch(ctx context.Context, pageURL string) (*Preview, error) {
+	u, err := url.Parse(pageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", pageURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "Real-Time-Forum link preview")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page answered %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, ErrNoPreview
+	}
+
+	page, err := io.ReadAll(io.LimitReader(resp.Body, f.MaxSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page: %w", err)
+	}
+
+	// Relative images are resolved against the page the redirects led to
+	preview := parsePage(string(page), resp.Request.URL)
+	if preview.Title == "" {
+		return nil, ErrNoPreview
+	}
+	preview.URL = pageURL
+	return preview, nil
+}
+
+// FetchImage downloads the image of a preview, at most maxSize bytes of it
+// Only JPEG, PNG and GIF images are kept, the ones a thumbnail can be made of
+func (f *Fetcher) FetchImage(ctx context.Context, imageURL string, maxSize int64) ([]byte, error) {
+	u, err := url.Parse(imageURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q", imageURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "image/jpeg,image/png,image/gif")
+	req.Header.Set("User-Agent", "Real-Time-Forum link preview")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image answered %s", resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "image/jpeg" && mediaType != "image/png" && mediaType != "image/gif" {
+		return nil, ErrNoPreview
+	}
+
+	// One byte more than allowed tells a larger image from one of exactly maxSize bytes
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image: %w", err)
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,137 @@
+package unfurl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testPage = `<html><head><meta property="og:title" content="Test page">
+<meta property="og:image" content="/cover.png"></head><body></body></html>`
+
+// newTestServer serves a page at /page, a page whose title comes after padding bytes at /padded/{padding},
+// a chain of redirects to the page at /redirect/{count}, its image at /cover.png and a non HTML file at /file
+func newTestServer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, testPage)
+	})
+	mux.HandleFunc("/padded/", func(w http.ResponseWriter, r *http.Request) {
+		padding, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/padded/"))
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><head><!-- %s --><title>Padded</title></head></html>", strings.Repeat("x", padding))
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		count, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/redirect/"))
+		if count == 0 {
+			http.Redirect(w, r, "/page", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/redirect/"+strconv.Itoa(count-1), http.StatusFound)
+	})
+	mux.HandleFunc("/cover.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, "\x89PNG\r\n\x1a\n"+strings.Repeat("\x00", 2000))
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/pdf")
+		fmt.Fprint(w, "%PDF-1.4")
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestFetch(t *testing.T) {
+	server := newTestServer(t)
+	fetcher := NewFetcher(5*time.Second, 64<<10, true)
+
+	preview, err := fetcher.Fetch(context.Background(), server.URL+"/page")
+	if err != nil {
+		t.Fatalf("Fetch() error = %v", err)
+	}
+	if preview.URL != server.URL+"/page" || preview.Title != "Test page" || preview.ImageURL != server.URL+"/cover.png" {
+		t.Errorf("Fetch() = %+v", *preview)
+	}
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/file"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("Fetch() of a PDF error = %v, want ErrNoPreview", err)
+	}
+	if _, err := fetcher.Fetch(context.Background(), "file:///etc/passwd"); err == nil {
+		t.Error("Fetch() of a file URL succeeded")
+	}
+}
+
+func TestFetchImage(t *testing.T) {
+	server := newTestServer(t)
+	fetcher := NewFetcher(5*time.Second, 64<<10, true)
+
+	data, err := fetcher.FetchImage(context.Background(), server.URL+"/cover.png", 4096)
+	if err != nil || len(data) != 2008 {
+		t.Errorf("FetchImage() = %d bytes, %v, want 2008 bytes", len(data), err)
+	}
+	if _, err := fetcher.FetchImage(context.Background(), server.URL+"/cover.png", 1024); !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("FetchImage() of an image past the limit error = %v, want ErrImageTooLarge", err)
+	}
+	if _, err := fetcher.FetchImage(context.Background(), server.URL+"/page", 4096); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("FetchImage() of a page error = %v, want ErrNoPreview", err)
+	}
+}
+
+func TestFetchMaxSize(t *testing.T) {
+	server := newTestServer(t)
+	fetcher := NewFetcher(5*time.Second, 1024, true)
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/padded/900"); err != nil {
+		t.Errorf("Fetch() of a title within the limit error = %v", err)
+	}
+	// The title is past the bytes read, the page is as good as having none
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/padded/2000"); !errors.Is(err, ErrNoPreview) {
+		t.Errorf("Fetch() of a title past the limit error = %v, want ErrNoPreview", err)
+	}
+}
+
+func TestFetchRedirects(t *testing.T) {
+	server := newTestServer(t)
+	fetcher := NewFetcher(5*time.Second, 64<<10, true)
+
+	// /redirect/2 goes through /redirect/1 and /redirect/0 to /page
+	preview, err := fetcher.Fetch(context.Background(), server.URL+"/redirect/2")
+	if err != nil {
+		t.Fatalf("Fetch() after %d redirects error = %v", maxRedirects, err)
+	}
+	if preview.URL != server.URL+"/redirect/2" || preview.ImageURL != server.URL+"/cover.png" {
+		t.Errorf("Fetch() after redirects = %+v", *preview)
+	}
+
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/redirect/3"); err == nil {
+		t.Errorf("Fetch() after %d redirects succeeded", maxRedirects+1)
+	}
+}
+
+func TestFetchRefusesPrivateAddresses(t *testing.T) {
+	server := newTestServer(t)
+	fetcher := NewFetcher(5*time.Second, 64<<10, false)
+
+	// The test server listens on the loopback interface
+	if _, err := fetcher.Fetch(context.Background(), server.URL+"/page"); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Fetch() of %s error = %v, want ErrPrivateAddress", server.URL, err)
+	}
+	if _, err := fetcher.FetchImage(context.Background(), server.URL+"/cover.png", 1<<20); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("FetchImage() of %s error = %v, want ErrPrivateAddress", server.URL, err)
+	}
+
+	port := server.Listener.Addr().(*net.TCPAddr).Port
+	mapped := fmt.Sprintf("http://[::ffff:127.0.0.1]:%d/page", port)
+	if _, err := fetcher.Fetch(context.Background(), mapped); !errors.Is(err, ErrPrivateAddress) {
+		t.Errorf("Fetch() of %s error = %v, want ErrPrivateAddress", mapped, err)
+	}
+}
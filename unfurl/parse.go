@@ -0,0 +1,122 @@
+package unfurl
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Preview is what a page tells about itself in its OpenGraph and Twitter card tags
+type Preview struct {
+	URL         string
+	Title       string
+	Description string
+	ImageURL    string
+	SiteName    string
+}
+
+// Longest texts kept from a page, in characters
+const (
+	maxTitleLength       = 200
+	maxDescriptionLength = 300
+)
+
+var (
+	metaPattern      = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attributePattern = regexp.MustCompile(`(?s)([a-zA-Z:_-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	titlePattern     = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	urlPattern       = regexp.MustCompile(`https?://[^\s<>()\[\]"']+(?:\([^\s<>()]*\)[^\s<>()\[\]"']*)*`)
+)
+
+// Tags read for each field, the first one present wins
+var (
+	titleTags       = []string{"og:title", "twitter:title"}
+	descriptionTags = []string{"og:description", "twitter:description", "description"}
+	imageTags       = []string{"og:image", "og:image:url", "og:image:secure_url", "twitter:image", "twitter:image:src"}
+	siteNameTags    = []string{"og:site_name"}
+)
+
+// parsePage reads the preview of a page from the meta tags of its head, with its <title> as fallback
+func parsePage(page string, pageURL *url.URL) *Preview {
+	page = strings.ToValidUTF8(page, "")
+	if end := strings.Index(strings.ToLower(page), "</head>"); end >= 0 {
+		page = page[:end]
+	}
+
+	tags := make(map[string]string)
+	for _, meta := range metaPattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, match := range attributePattern.FindAllStringSubmatch(meta, -1) {
+			attrs[strings.ToLower(match[1])] = match[2] + match[3] + match[4]
+		}
+		name := attrs["property"]
+		if name == "" {
+			name = attrs["name"]
+		}
+		name = strings.ToLower(name)
+		if _, seen := tags[name]; name != "" && !seen {
+			tags[name] = cleanText(attrs["content"])
+		}
+	}
+
+	preview := &Preview{
+		Title:       truncate(firstTag(tags, titleTags), maxTitleLength),
+		Description: truncate(firstTag(tags, descriptionTags), maxDescriptionLength),
+		SiteName:    truncate(firstTag(tags, siteNameTags), maxTitleLength),
+	}
+	if preview.Title == "" {
+		if match := titlePattern.FindStringSubmatch(page); match != nil {
+			preview.Title = truncate(cleanText(match[1]), maxTitleLength)
+		}
+	}
+	if image := firstTag(tags, imageTags); image != "" {
+		if u, err := pageURL.Parse(image); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			preview.ImageURL = u.String()
+		}
+	}
+	return preview
+}
+
+// firstTag returns the value of the first of the names present in the tags
+func firstTag(tags map[string]string, names []string) string {
+	for _, name := range names {
+		if value := tags[name]; value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// cleanText decodes the entities of a text and collapses its spaces
+func cleanText(s string) string {
+	return strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+}
+
+// truncate shortens a text to max characters, ending it with an ellipsis
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	return string([]rune(s)[:max-1]) + "…"
+}
+
+// FindURLs returns the distinct http and https URLs of a text, at most max of them
+// The punctuation following a URL, like the dot ending a sentence, is not part of it
+func FindURLs(text string, max int) []string {
+	var urls []string
+	seen := make(map[string]bool)
+	for _, match := range urlPattern.FindAllString(text, -1) {
+		match = strings.TrimRight(match, ".,;:!?*_~")
+		if u, err := url.Parse(match); err != nil || u.Host == "" || seen[match] {
+			continue
+		}
+		seen[match] = true
+		urls = append(urls, match)
+		if len(urls) == max {
+			break
+		}
+	}
+	return urls
+}
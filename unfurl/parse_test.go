@@ -0,0 +1,98 @@
+package unfurl
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/articles/1")
+
+	tests := []struct {
+		name string
+		page string
+		want Preview
+	}{
+		{
+			name: "OpenGraph tags",
+			page: `<html><head><title>Ignored</title>
+                <meta property="og:title" content="Hello &amp; welcome">
+                <meta property="og:description" content="  A   page  ">
+                <meta property="og:image" content="/images/cover.png">
+                <meta property="og:site_name" content="Example">
+                </head><body></body></html>`,
+			want: Preview{
+				Title:       "Hello & welcome",
+				Description: "A page",
+				ImageURL:    "https://example.com/images/cover.png",
+				SiteName:    "Example",
+			},
+		},
+		{
+			name: "Twitter card tags",
+			page: `<head><meta name="twitter:title" content='Tweeted'>
+                <meta name="twitter:description" content=Short>
+                <meta name="twitter:image" content="https://cdn.example.com/t.jpg"></head>`,
+			want: Preview{
+				Title:       "Tweeted",
+				Description: "Short",
+				ImageURL:    "https://cdn.example.com/t.jpg",
+			},
+		},
+		{
+			name: "OpenGraph wins over Twitter",
+			page: `<head><meta name="twitter:title" content="Twitter"><meta property="og:title" content="OpenGraph"></head>`,
+			want: Preview{Title: "OpenGraph"},
+		},
+		{
+			name: "title element as fallback",
+			page: `<html><head><TITLE lang="en">  Plain
+                title </TITLE><meta name="description" content="Described"></head></html>`,
+			want: Preview{Title: "Plain title", Description: "Described"},
+		},
+		{
+			name: "tags of the body are ignored",
+			page: `<head><title>Head</title></head><body><meta property="og:title" content="Body"></body>`,
+			want: Preview{Title: "Head"},
+		},
+		{
+			name: "images with another scheme are dropped",
+			page: `<head><meta property="og:title" content="T"><meta property="og:image" content="javascript:alert(1)"></head>`,
+			want: Preview{Title: "T"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parsePage(tt.page, pageURL)
+			if *got != tt.want {
+				t.Errorf("parsePage() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParsePageTruncates(t *testing.T) {
+	pageURL, _ := url.Parse("https://example.com/")
+	page := `<head><meta property="og:title" content="` + strings.Repeat("é", 500) + `"></head>`
+
+	got := parsePage(page, pageURL)
+	if n := len([]rune(got.Title)); n != maxTitleLength {
+		t.Errorf("title has %d characters, want %d", n, maxTitleLength)
+	}
+	if !strings.HasSuffix(got.Title, "…") {
+		t.Errorf("truncated title %q does not end with an ellipsis", got.Title)
+	}
+}
+
+func TestFindURLs(t *testing.T) {
+	text := "See https://example.com/a. Also (http://example.org/b), https://example.com/a again " +
+		"and https://en.wikipedia.org/wiki/Go_(language)! ftp://example.net https://example.com/c"
+
+	got := FindURLs(text, 3)
+	want := []string{"https://example.com/a", "http://example.org/b", "https://en.wikipedia.org/wiki/Go_(language)"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("FindURLs() = %q, want %q", got, want)
+	}
+}
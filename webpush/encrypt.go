@@ -0,0 +1,92 @@
+package webpush
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Size of the single record holding the payload, which also bounds the payload
+const recordSize = 4096
+
+// MaxPayloadSize is the longest payload that fits in a record, after the
+// 86 byte header, the padding delimiter and the 16 byte authentication tag
+const MaxPayloadSize = recordSize - 86 - 1 - 16
+
+// Encrypt encrypts a payload for a subscription with the aes128gcm content encoding (RFC 8188, RFC 8291)
+// A new key pair and salt are used for every message, only the browser holding the
+// private key of the subscription can read it
+func Encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	if len(payload) > MaxPayloadSize {
+		return nil, errors.New("payload too large")
+	}
+
+	userAgentKey, err := DecodeKey(sub.P256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	userAgentPublic, err := ecdh.P256().NewPublicKey(userAgentKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := DecodeKey(sub.Auth)
+	if err != nil || len(authSecret) != 16 {
+		return nil, errors.New("invalid auth secret")
+	}
+
+	serverKey, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serverPublic := serverKey.PublicKey().Bytes()
+	sharedSecret, err := serverKey.ECDH(userAgentPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// The input keying material mixes the shared secret with the auth secret and both public keys
+	keyInfo := "WebPush: info\x00" + string(userAgentKey) + string(serverPublic)
+	ikm, err := hkdf.Key(sha256.New, sharedSecret, authSecret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	contentKey, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header: salt, record size, then the server public key as key ID
+	body := make([]byte, 0, 86+len(payload)+1+gcm.Overhead())
+	body = append(body, salt...)
+	body = binary.BigEndian.AppendUint32(body, recordSize)
+	body = append(body, byte(len(serverPublic)))
+	body = append(body, serverPublic...)
+
+	// 0x02 marks the last (and only) record, no padding follows it
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	return gcm.Seal(body, nonce, plaintext, nil), nil
+}
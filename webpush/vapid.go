@@ -0,0 +1,107 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/url"
+	"time"
+)
+
+// How long the signed VAPID claims stay valid, push services refuse more than 24 hours
+const vapidTTL = 12 * time.Hour
+
+// VAPID identifies the forum to the push services (RFC 8292)
+// Browsers only accept pushes signed with the key the subscription was created with
+type VAPID struct {
+	key       *ecdsa.PrivateKey
+	PublicKey string // uncompressed public key, base64url encoded, given to the browsers
+	Subject   string // contact of the forum for the push services, a mailto: or https: URL
+}
+
+// GenerateVAPIDKey creates a new private key, base64url encoded
+func GenerateVAPIDKey() (string, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate VAPID key: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(key.Bytes()), nil
+}
+
+// NewVAPID loads a base64url encoded P-256 private key, the format used by most Web Push libraries
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := DecodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID key: %w", err)
+	}
+
+	public := key.PublicKey().Bytes()
+	return &VAPID{
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		PublicKey: base64.RawURLEncoding.EncodeToString(public),
+		Subject:   subject,
+	}, nil
+}
+
+// authorization returns the Authorization header of a push sent to endpoint
+// It holds a JWT signed with ES256 whose audience is the origin of the push service
+func (v *VAPID) authorization(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid endpoint: %w", err)
+	}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"typ":"JWT","alg":"ES256"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(vapidTTL).Unix(),
+		"sub": v.Subject,
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+
+	hash := sha256.Sum256([]byte(unsigned))
+	r, s, err := ecdsa.Sign(rand.Reader, v.key, hash[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign VAPID claims: %w", err)
+	}
+	// JWS signatures are the two 32 byte integers side by side, not the ASN.1 encoding
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	token := unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+	return fmt.Sprintf("vapid t=%s, k=%s", token, v.PublicKey), nil
+}
+
+// DecodeKey decodes a key sent by a browser or read from the configuration
+// Browsers use base64url without padding, but some libraries add it or use the standard alphabet
+func DecodeKey(key string) ([]byte, error) {
+	for _, encoding := range []*base64.Encoding{
+		base64.RawURLEncoding, base64.URLEncoding, base64.RawStdEncoding, base64.StdEncoding,
+	} {
+		if raw, err := encoding.DecodeString(key); err == nil {
+			return raw, nil
+		}
+	}
+	return nil, fmt.Errorf("not a base64 key")
+}
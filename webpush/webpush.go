@@ -0,0 +1,115 @@
+package webpush
+
+import (
+	"Real-Time-Forum/shared"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ErrGone is returned when the push service no longer knows the subscription,
+// which then has to be forgotten
+var ErrGone = errors.New("push subscription expired")
+
+// Subscription is where a browser receives pushes, as given by PushSubscription.toJSON
+type Subscription struct {
+	Endpoint string // URL of the push service for this browser
+	P256dh   string // public key of the browser, base64url encoded
+	Auth     string // authentication secret, base64url encoded
+}
+
+// Sender delivers encrypted payloads to push services
+type Sender struct {
+	VAPID  *VAPID
+	Client *http.Client
+	TTL    time.Duration // how long the push service keeps a message for an unreachable browser
+}
+
+// Longest wait for a push service to answer
+const sendTimeout = 10 * time.Second
+
+// NewSender creates a sender signing its pushes with the given VAPID keys
+// Endpoints are given by the users, so push services on the local network are refused
+// unless allowPrivate is set, which is only meant for tests, and redirects are never followed
+func NewSender(vapid *VAPID, allowPrivate bool) *Sender {
+	dialer := shared.PublicDialer(sendTimeout)
+	if allowPrivate {
+		dialer.Control = nil
+	}
+
+	return &Sender{
+		VAPID: vapid,
+		Client: &http.Client{
+			Transport: &http.Transport{
+				Proxy:                 nil, // a proxy would connect to the addresses checked here
+				DialContext:           dialer.DialContext,
+				TLSHandshakeTimeout:   sendTimeout,
+				ResponseHeaderTimeout: sendTimeout,
+				MaxIdleConns:          10,
+				IdleConnTimeout:       time.Minute,
+			},
+			Timeout: sendTimeout,
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		TTL: 24 * time.Hour,
+	}
+}
+
+// Send encrypts the payload for the subscription and posts it to its push service
+// It returns ErrGone when the subscription expired or was revoked
+func (s *Sender) Send(sub Subscription, payload []byte) error {
+	body, err := Encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+	authorization, err := s.VAPID.authorization(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %w", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("TTL", strconv.Itoa(int(s.TTL.Seconds())))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach push service: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return ErrGone
+	case resp.StatusCode >= 300:
+		return fmt.Errorf("push service answered %s", resp.Status)
+	}
+	return nil
+}
+
+// SendAll sends the payload to each subscription, calling gone with the ones the push service
+// no longer knows so that they can be forgotten
+// The other failures are returned together, a failure does not stop the other pushes
+func (s *Sender) SendAll(subs []Subscription, payload []byte, gone func(Subscription)) error {
+	var errs []error
+	for _, sub := range subs {
+		err := s.Send(sub, payload)
+		if errors.Is(err, ErrGone) {
+			gone(sub)
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", sub.Endpoint, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,301 @@
+package webpush
+
+import (
+	"Real-Time-Forum/shared"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// browser holds the keys a browser creates along with a push subscription
+type browser struct {
+	key  *ecdh.PrivateKey
+	auth []byte
+}
+
+func newBrowser(t *testing.T) *browser {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &browser{key: key, auth: auth}
+}
+
+func (b *browser) subscription(endpoint string) Subscription {
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   base64.RawURLEncoding.EncodeToString(b.key.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(b.auth),
+	}
+}
+
+// decrypt reads an aes128gcm body the way the browser does (RFC 8188, RFC 8291)
+func (b *browser) decrypt(t *testing.T, body []byte) []byte {
+	t.Helper()
+	if len(body) < 21 {
+		t.Fatalf("body of %d bytes is shorter than its header", len(body))
+	}
+	salt, size, idLength := body[:16], binary.BigEndian.Uint32(body[16:20]), int(body[20])
+	if size != recordSize || idLength != 65 || len(body) < 21+idLength {
+		t.Fatalf("invalid header: record size %d, key ID of %d bytes", size, idLength)
+	}
+	serverKey, ciphertext := body[21:21+idLength], body[21+idLength:]
+
+	serverPublic, err := ecdh.P256().NewPublicKey(serverKey)
+	if err != nil {
+		t.Fatalf("invalid server key: %v", err)
+	}
+	sharedSecret, err := b.key.ECDH(serverPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyInfo := "WebPush: info\x00" + string(b.key.PublicKey().Bytes()) + string(serverKey)
+	ikm, _ := hkdf.Key(sha256.New, sharedSecret, b.auth, keyInfo, 32)
+	contentKey, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(contentKey)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("failed to decrypt body: %v", err)
+	}
+
+	// The last record ends with 0x02, followed by optional zero padding
+	end := len(plaintext) - 1
+	for end >= 0 && plaintext[end] == 0 {
+		end--
+	}
+	if end < 0 || plaintext[end] != 0x02 {
+		t.Fatal("missing last record delimiter")
+	}
+	return plaintext[:end]
+}
+
+// pushService records the pushes it gets and answers them with status
+type pushService struct {
+	*httptest.Server
+	status   int
+	requests []*http.Request
+	bodies   [][]byte
+}
+
+func newPushService(t *testing.T, status int) *pushService {
+	s := &pushService{status: status}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		s.requests = append(s.requests, r)
+		s.bodies = append(s.bodies, body)
+		if s.status == http.StatusFound {
+			http.Redirect(w, r, "/elsewhere", http.StatusFound)
+			return
+		}
+		w.WriteHeader(s.status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func newTestSender(t *testing.T) *Sender {
+	key, err := GenerateVAPIDKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vapid, err := NewVAPID(key, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The test push services listen on the loopback interface
+	return NewSender(vapid, true)
+}
+
+func TestSendEncryptsPayload(t *testing.T) {
+	service := newPushService(t, http.StatusCreated)
+	sender := newTestSender(t)
+	b := newBrowser(t)
+
+	payload := []byte(`{"title":"New reply","body":"Hello"}`)
+	if err := sender.Send(b.subscription(service.URL+"/push/abc"), payload); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if len(service.requests) != 1 {
+		t.Fatalf("push service got %d requests, want 1", len(service.requests))
+	}
+
+	req := service.requests[0]
+	if req.Method != http.MethodPost || req.URL.Path != "/push/abc" {
+		t.Errorf("request = %s %s, want POST /push/abc", req.Method, req.URL.Path)
+	}
+	if got := req.Header.Get("Content-Encoding"); got != "aes128gcm" {
+		t.Errorf("Content-Encoding = %q, want aes128gcm", got)
+	}
+	if got := req.Header.Get("TTL"); got != "86400" {
+		t.Errorf("TTL = %q, want 86400", got)
+	}
+	if got := b.decrypt(t, service.bodies[0]); string(got) != string(payload) {
+		t.Errorf("decrypted payload = %q, want %q", got, payload)
+	}
+}
+
+func TestSendSignsVAPIDClaims(t *testing.T) {
+	service := newPushService(t, http.StatusCreated)
+	sender := newTestSender(t)
+
+	if err := sender.Send(newBrowser(t).subscription(service.URL+"/push/abc"), []byte("hi")); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	authorization := service.requests[0].Header.Get("Authorization")
+	token, publicKey, ok := strings.Cut(strings.TrimPrefix(authorization, "vapid t="), ", k=")
+	if !ok || !strings.HasPrefix(authorization, "vapid t=") {
+		t.Fatalf("Authorization = %q, want vapid t=..., k=...", authorization)
+	}
+	if publicKey != sender.VAPID.PublicKey {
+		t.Errorf("k = %q, want the VAPID public key %q", publicKey, sender.VAPID.PublicKey)
+	}
+
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT has %d parts, want 3", len(parts))
+	}
+	var header struct{ Typ, Alg string }
+	var claims struct {
+		Aud string
+		Exp int64
+		Sub string
+	}
+	decodePart(t, parts[0], &header)
+	decodePart(t, parts[1], &claims)
+	if header.Alg != "ES256" {
+		t.Errorf("alg = %q, want ES256", header.Alg)
+	}
+	if claims.Aud != service.URL || claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("claims = %+v, want aud %s", claims, service.URL)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp = %v, want within the next 24 hours", exp)
+	}
+
+	raw, err := DecodeKey(publicKey)
+	if err != nil || len(raw) != 65 {
+		t.Fatalf("invalid public key: %v", err)
+	}
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[1:33]),
+		Y:     new(big.Int).SetBytes(raw[33:]),
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(signature) != 64 {
+		t.Fatalf("signature of %d bytes, want 64: %v", len(signature), err)
+	}
+	hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, hash[:], r, s) {
+		t.Error("JWT signature does not verify with the VAPID public key")
+	}
+}
+
+func decodePart(t *testing.T, part string, v interface{}) {
+	t.Helper()
+	raw, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		t.Fatalf("invalid JWT part %q: %v", part, err)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("invalid JWT part %s: %v", raw, err)
+	}
+}
+
+func TestSendStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		gone   bool
+		ok     bool
+	}{
+		{status: http.StatusCreated, ok: true},
+		{status: http.StatusNotFound, gone: true},
+		{status: http.StatusGone, gone: true},
+		{status: http.StatusFound},
+		{status: http.StatusTooManyRequests},
+		{status: http.StatusInternalServerError},
+	}
+
+	sender := newTestSender(t)
+	for _, tt := range tests {
+		service := newPushService(t, tt.status)
+		err := sender.Send(newBrowser(t).subscription(service.URL+"/push/abc"), []byte("hi"))
+		switch {
+		case tt.ok && err != nil:
+			t.Errorf("status %d: Send() error = %v, want nil", tt.status, err)
+		case tt.gone && !errors.Is(err, ErrGone):
+			t.Errorf("status %d: Send() error = %v, want ErrGone", tt.status, err)
+		case !tt.ok && !tt.gone && (err == nil || errors.Is(err, ErrGone)):
+			t.Errorf("status %d: Send() error = %v, want another error", tt.status, err)
+		}
+		// Redirects are not followed
+		if len(service.requests) != 1 {
+			t.Errorf("status %d: push service got %d requests, want 1", tt.status, len(service.requests))
+		}
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	service := newPushService(t, http.StatusCreated)
+	sender := NewSender(newTestSender(t).VAPID, false)
+
+	err := sender.Send(newBrowser(t).subscription(service.URL+"/push/abc"), []byte("hi"))
+	if !errors.Is(err, shared.ErrPrivateAddress) || len(service.requests) != 0 {
+		t.Errorf("Send() to %s error = %v, want ErrPrivateAddress", service.URL, err)
+	}
+}
+
+func TestSendAllForgetsExpiredSubscriptions(t *testing.T) {
+	sender := newTestSender(t)
+	delivered := newPushService(t, http.StatusCreated)
+	notFound := newPushService(t, http.StatusNotFound)
+	gone := newPushService(t, http.StatusGone)
+	failing := newPushService(t, http.StatusInternalServerError)
+
+	subs := []Subscription{
+		newBrowser(t).subscription(delivered.URL + "/a"),
+		newBrowser(t).subscription(notFound.URL + "/b"),
+		newBrowser(t).subscription(gone.URL + "/c"),
+		newBrowser(t).subscription(failing.URL + "/d"),
+	}
+	var forgotten []string
+	err := sender.SendAll(subs, []byte("hi"), func(sub Subscription) {
+		forgotten = append(forgotten, sub.Endpoint)
+	})
+
+	if want := []string{notFound.URL + "/b", gone.URL + "/c"}; strings.Join(forgotten, " ") != strings.Join(want, " ") {
+		t.Errorf("forgotten subscriptions = %q, want %q", forgotten, want)
+	}
+	// The failure of a push service is reported, but the subscription is kept
+	if err == nil || !strings.Contains(err.Error(), failing.URL+"/d") {
+		t.Errorf("SendAll() error = %v, want the failure of %s/d", err, failing.URL)
+	}
+	for _, service := range []*pushService{delivered, notFound, gone, failing} {
+		if len(service.requests) != 1 {
+			t.Errorf("%s got %d requests, want 1", service.URL, len(service.requests))
+		}
+	}
+}